@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/config"
+	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/images"
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// photoExtensions are the photo files a dataset may hold, and the extension
+// exported photos get for their content type
+var photoExtensions = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".webp": "image/webp",
+}
+
+// sample is a photo of a label and the values printed on it
+type sample struct {
+	name  string
+	image []byte
+	truth *models.ModelOutput
+}
+
+// loadDataset reads the labelled photos of a directory, sorted by name.
+// Photos without values are skipped with a warning.
+func loadDataset(dir string) ([]*sample, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading dataset: %w", err)
+	}
+
+	var samples []*sample
+	for _, f := range files {
+		ext := strings.ToLower(filepath.Ext(f.Name()))
+		if f.IsDir() || photoExtensions[ext] == "" {
+			continue
+		}
+
+		base := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+		truthFile := filepath.Join(dir, base+".json")
+		data, err := os.ReadFile(truthFile)
+		if os.IsNotExist(err) {
+			log.Printf("Skipping %s: no %s", f.Name(), filepath.Base(truthFile))
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", truthFile, err)
+		}
+		var truth models.ModelOutput
+		if err := json.Unmarshal(data, &truth); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", truthFile, err)
+		}
+
+		image, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", f.Name(), err)
+		}
+		samples = append(samples, &sample{name: f.Name(), image: image, truth: &truth})
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i].name < samples[j].name })
+	return samples, nil
+}
+
+// export writes the scans confirmed in [from, to] as a dataset in dir, named
+// after the scans. Scans whose photo is not in the image store are skipped.
+func export(ctx context.Context, dbConfig config.DatabaseConfig, cfg config.ImagesConfig, dir, from, to string) error {
+	start, err := parseDate(from, time.Time{})
+	if err != nil {
+		return fmt.Errorf("from: %w", err)
+	}
+	end, err := parseDate(to, time.Now())
+	if err != nil {
+		return fmt.Errorf("to: %w", err)
+	}
+	end = end.AddDate(0, 0, 1)
+
+	store, err := images.New(cfg)
+	if err != nil {
+		return err
+	}
+	db, err := database.New(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	scans, err := db.ListConfirmedScans(ctx, "", start, end)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("error creating dataset directory: %w", err)
+	}
+
+	exported := 0
+	for _, scan := range scans {
+		if !images.ValidKey(scan.ImagePath) {
+			continue
+		}
+		image, err := store.Get(ctx, scan.ImagePath)
+		if errors.Is(err, images.ErrNotFound) {
+			log.Printf("Skipping scan %s: its photo is not in the image store", scan.ID)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		truth, err := json.MarshalIndent(scan.ConfirmedOutput, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, scan.ID+photoExtension(image)), image, 0o644); err != nil {
+			return fmt.Errorf("error writing photo of scan %s: %w", scan.ID, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, scan.ID+".json"), append(truth, '\n'), 0o644); err != nil {
+			return fmt.Errorf("error writing values of scan %s: %w", scan.ID, err)
+		}
+		exported++
+	}
+
+	fmt.Printf("Exported %d of %d confirmed scans to %s\n", exported, len(scans), dir)
+	return nil
+}
+
+// photoExtension picks the file extension of a photo from its content
+func photoExtension(image []byte) string {
+	contentType := http.DetectContentType(image)
+	for _, ext := range []string{".jpg", ".png", ".webp"} {
+		if photoExtensions[ext] == contentType {
+			return ext
+		}
+	}
+	return ".jpg"
+}
@@ -0,0 +1,134 @@
+// Command eval measures how well a model reads nutrition labels, offline,
+// against a dataset of label photos whose values are known.
+//
+// Usage:
+//
+//	eval [-config file] [-model type] [-timeout d] [-json file] run dir
+//	eval [-config file] [-db file] [-from date] [-to date] export dir
+//
+// A dataset is a directory of photos (.jpg, .jpeg, .png or .webp), each next
+// to a JSON file of the same name holding the values per 100g printed on the
+// label:
+//
+//	{"calories": 379, "protein": 8, "carbs": 75, "fat": 3.5, "fiber": 7, "sugar": 21}
+//
+// run reads every photo with the model and reports, for each nutrient, the
+// mean absolute error and how often the model got it exactly right, how many
+// answers could not be parsed, latency percentiles and the differences photo
+// by photo. -json also writes the full report to a file so runs can be
+// compared.
+//
+// export turns the scans users confirmed into a dataset, taking the values
+// they confirmed as the truth and the photos from the image store.
+//
+// The model defaults to the one in the configuration; "fake" and "local"
+// need no network. As for the server, each model reads its own settings from
+// config/<model>.json or the environment.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/config"
+	"github.com/franckalain/nutritionalvalue/internal/ml"
+)
+
+func main() {
+	configPath := flag.String("config", config.GetConfigPath(), "path to configuration file")
+	modelType := flag.String("model", "", "model to evaluate: google, local or fake; defaults to the one in the configuration")
+	timeout := flag.Duration("timeout", 60*time.Second, "time allowed to read each photo")
+	jsonPath := flag.String("json", "", "also write the report of run as JSON to this file")
+	dbPath := flag.String("db", "", "SQLite database to export from; defaults to the database in the configuration")
+	from := flag.String("from", "", "export scans from this date, YYYY-MM-DD")
+	to := flag.String("to", "", "export scans up to and including this date, YYYY-MM-DD")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal("Failed to load configuration:", err)
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "run":
+		if *modelType == "" {
+			*modelType = cfg.ML.Type
+		}
+		err = run(ctx, *modelType, args[1], *timeout, *jsonPath)
+	case "export":
+		dbConfig := cfg.Database
+		if *dbPath != "" {
+			dbConfig = config.DatabaseConfig{Type: "sqlite", Path: *dbPath}
+		}
+		err = export(ctx, dbConfig, cfg.Images, args[1], *from, *to)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: eval [flags] command dir
+
+Commands:
+  run dir      read the photos of a dataset with a model and report its accuracy
+  export dir   write the scans users confirmed as a dataset
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+// run evaluates a model over the dataset in dir
+func run(ctx context.Context, modelType, dir string, timeout time.Duration, jsonPath string) error {
+	samples, err := loadDataset(dir)
+	if err != nil {
+		return err
+	}
+	if len(samples) == 0 {
+		return fmt.Errorf("no labelled photos in %s", dir)
+	}
+
+	model, err := ml.NewModel(modelType)
+	if err != nil {
+		return fmt.Errorf("failed to create ML model: %w", err)
+	}
+	if err := model.Load(ctx); err != nil {
+		return fmt.Errorf("failed to load ML model: %w", err)
+	}
+
+	report := evaluate(ctx, model, samples, timeout)
+	report.print(os.Stdout)
+	if jsonPath != "" {
+		return report.writeJSON(jsonPath)
+	}
+	return nil
+}
+
+// parseDate reads a YYYY-MM-DD date as local midnight, or returns def when
+// the date is empty
+func parseDate(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected YYYY-MM-DD, got %q", value)
+	}
+	return t, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/ml"
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// report is the outcome of evaluating a model over a dataset
+type report struct {
+	Model            string  `json:"model"`
+	Photos           int     `json:"photos"`
+	Failures         int     `json:"failures"`       // photos the model gave no values for
+	ParseFailures    int     `json:"parse_failures"` // of which the answer could not be parsed
+	ParseFailureRate float64 `json:"parse_failure_rate"`
+
+	// Accuracy of the photos the model gave values for
+	Accuracy *models.AccuracyStats `json:"accuracy"`
+	Latency  latency               `json:"latency"`
+
+	// Photos the model failed on or got any value wrong for
+	Diffs []photoDiff `json:"diffs"`
+}
+
+// latency percentiles in milliseconds over every photo, failed ones included
+type latency struct {
+	P50 float64 `json:"p50_ms"`
+	P90 float64 `json:"p90_ms"`
+	P99 float64 `json:"p99_ms"`
+	Max float64 `json:"max_ms"`
+}
+
+type photoDiff struct {
+	Photo       string              `json:"photo"`
+	Error       string              `json:"error,omitempty"`
+	Differences []models.Correction `json:"differences,omitempty"` // model is what was read, confirmed what the label says
+}
+
+// evaluate reads every photo with the model, one at a time so latencies are
+// not skewed by concurrency
+func evaluate(ctx context.Context, model ml.Model, samples []*sample, timeout time.Duration) *report {
+	r := &report{
+		Model:  model.Name(),
+		Photos: len(samples),
+	}
+	started := time.Now()
+
+	// The period of the stats is when the evaluation ran
+	r.Accuracy = models.NewAccuracyStats(started, started)
+	durations := make([]time.Duration, 0, len(samples))
+	for _, s := range samples {
+		photoCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		info, err := model.ProcessImage(photoCtx, s.image)
+		durations = append(durations, time.Since(start))
+		cancel()
+
+		if err != nil {
+			r.Failures++
+			if errors.Is(err, ml.ErrParse) {
+				r.ParseFailures++
+			}
+			r.Diffs = append(r.Diffs, photoDiff{Photo: s.name, Error: err.Error()})
+			continue
+		}
+
+		output := models.OutputOf(info)
+		r.Accuracy.Add(output, s.truth)
+		if diff := output.Diff(s.truth); len(diff) > 0 {
+			r.Diffs = append(r.Diffs, photoDiff{Photo: s.name, Differences: diff})
+		}
+	}
+
+	r.Accuracy.PeriodEnd = time.Now()
+	r.ParseFailureRate = float64(r.ParseFailures) / float64(r.Photos)
+	r.Latency = percentiles(durations)
+	return r
+}
+
+// percentiles computes nearest-rank latency percentiles
+func percentiles(durations []time.Duration) latency {
+	if len(durations) == 0 {
+		return latency{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(durations)))) - 1
+		return ms(durations[max(i, 0)])
+	}
+	return latency{
+		P50: rank(0.50),
+		P90: rank(0.90),
+		P99: rank(0.99),
+		Max: ms(durations[len(durations)-1]),
+	}
+}
+
+// print writes the report for a person to read
+func (r *report) print(out io.Writer) {
+	fmt.Fprintf(out, "Model %s: %d photos, %d failed, %d could not be parsed (%.1f%%)\n",
+		r.Model, r.Photos, r.Failures, r.ParseFailures, r.ParseFailureRate*100)
+	fmt.Fprintf(out, "Latency: p50 %.0fms, p90 %.0fms, p99 %.0fms, max %.0fms\n\n",
+		r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.Max)
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "FIELD\tMAE\tEXACT\n")
+	for _, field := range []string{"calories", "protein", "carbs", "fat", "fiber", "sugar"} {
+		if f := r.Accuracy.Fields[field]; f != nil {
+			fmt.Fprintf(w, "%s\t%.2f\t%.1f%%\n", field, f.MAE, f.ExactMatchRate*100)
+		}
+	}
+	w.Flush()
+
+	if len(r.Diffs) == 0 {
+		return
+	}
+	fmt.Fprintln(out, "\nDifferences (read / expected):")
+	w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, d := range r.Diffs {
+		if d.Error != "" {
+			fmt.Fprintf(w, "%s\terror\t%s\n", d.Photo, d.Error)
+			continue
+		}
+		for _, c := range d.Differences {
+			fmt.Fprintf(w, "%s\t%s\t%g / %g\n", d.Photo, c.Field, c.Model, c.Confirmed)
+		}
+	}
+	w.Flush()
+}
+
+// writeJSON saves the report to compare it with later runs
+func (r *report) writeJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,406 @@
+// Command nvctl administers the Nutritional Value database. Apart from
+// backups, which can be taken at any time, the server must be stopped.
+//
+// Usage:
+//
+//	nvctl [-config file] [-db file] migrate status
+//	nvctl [-config file] [-db file] migrate up [version]
+//	nvctl [-config file] [-db file] migrate down [version]
+//	nvctl [-config file] [-db file] images move
+//	nvctl [-config file] [-db file] backup
+//	nvctl [-config file] [-db file] snapshot file
+//	nvctl [-config file] [-db file] restore file
+//	nvctl [-config file] [-db file] export file [household]
+//	nvctl [-config file] [-db file] import file [household]
+//
+// migrate up applies every pending migration, or those up to version.
+// migrate down rolls back the latest migration, or every migration above
+// version; "migrate down 0" empties the database.
+//
+// images move moves the photos older releases kept in the database to the
+// configured image store and compacts the database file. The server does the
+// same on start, without compacting.
+//
+// backup takes a backup of a SQLite database as the server does on schedule:
+// into the backup directory of the configuration, as an encrypted archive
+// with the photos if configured so, removing the oldest beyond the number to
+// keep. snapshot copies the database to file. Both work while the server
+// runs. restore checks a backup or archive is intact and replaces the
+// database with it, keeping the replaced one with a ".pre-restore" suffix.
+//
+// export writes the data of every household, or of one, to file: as a ZIP of
+// CSV files when file ends in ".zip", as the JSON archive documented by
+// database.Export otherwise. import merges such a JSON archive into a
+// household of the database, that of the default user unless one is given,
+// adding the rows it does not have yet. Photos are not part of exports;
+// import lists those missing from the configured image store.
+//
+// -db names a SQLite file; without it, the database of the configuration is
+// used, which may be PostgreSQL.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/franckalain/nutritionalvalue/internal/backup"
+	"github.com/franckalain/nutritionalvalue/internal/config"
+	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/images"
+)
+
+func main() {
+	configPath := flag.String("config", config.GetConfigPath(), "path to configuration file")
+	dbPath := flag.String("db", "", "SQLite database file; defaults to the database in the configuration")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	// The configuration is only needed for what the flags do not give
+	var cfg *config.Config
+	dbConfig := config.DatabaseConfig{Type: "sqlite", Path: *dbPath}
+	if *dbPath == "" || args[0] == "images" || args[0] == "backup" || args[0] == "restore" {
+		var err error
+		if cfg, err = config.LoadConfig(*configPath); err != nil {
+			log.Fatal("Failed to load configuration:", err)
+		}
+		if *dbPath == "" {
+			dbConfig = cfg.Database
+		}
+	}
+
+	var err error
+	switch args[0] {
+	case "migrate":
+		err = migrate(context.Background(), dbConfig, args[1:])
+	case "images":
+		err = moveImages(context.Background(), dbConfig, cfg.Images, args[1:])
+	case "backup":
+		err = takeBackup(context.Background(), dbConfig, cfg, args[1:])
+	case "snapshot":
+		err = takeSnapshot(context.Background(), dbConfig, args[1:])
+	case "restore":
+		err = restore(context.Background(), dbConfig, cfg, args[1:])
+	case "export":
+		err = exportData(context.Background(), dbConfig, args[1:])
+	case "import":
+		err = importData(context.Background(), dbConfig, cfg.Images, args[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: nvctl [flags] command
+
+Commands:
+  migrate status           list migrations and whether they are applied
+  migrate up [version]     apply pending migrations, up to version if given
+  migrate down [version]   roll back the latest migration, or down to version
+  images move              move photos kept in the database to the image store
+  backup                   back up the database to the backup directory
+  snapshot file            copy the database to file
+  restore file             replace the database with a verified backup
+  export file [household]  export data as JSON, or as CSV files if file ends in .zip
+  import file [household]  merge a JSON export into a household, the default one if not given
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+// migrate runs a migrate subcommand against a database
+func migrate(ctx context.Context, dbConfig config.DatabaseConfig, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: nvctl migrate status|up|down [version]")
+	}
+
+	db, err := database.Open(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := database.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	current, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	target := -1
+	if len(args) == 2 {
+		if target, err = strconv.Atoi(args[1]); err != nil || target < 0 || target > migrator.Latest() {
+			return fmt.Errorf("version must be between 0 and %d", migrator.Latest())
+		}
+	}
+
+	switch args[0] {
+	case "status":
+		return printStatus(ctx, migrator, current)
+	case "up":
+		if target < 0 {
+			target = migrator.Latest()
+		}
+		applied, err := migrator.Up(ctx, target)
+		fmt.Printf("Applied %d migration(s)\n", len(applied))
+		return err
+	case "down":
+		if target < 0 {
+			target = current - 1
+		}
+		if target < 0 {
+			fmt.Println("Nothing to roll back")
+			return nil
+		}
+		rolledBack, err := migrator.Down(ctx, target)
+		fmt.Printf("Rolled back %d migration(s)\n", len(rolledBack))
+		return err
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
+
+// printStatus lists the known migrations and when they were applied
+func printStatus(ctx context.Context, migrator *database.Migrator, current int) error {
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Schema version %d of %d\n\n", current, migrator.Latest())
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, s := range status {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	return w.Flush()
+}
+
+// moveImages moves photos out of a database, migrating its schema first, and
+// compacts it to give the space back
+func moveImages(ctx context.Context, dbConfig config.DatabaseConfig, cfg config.ImagesConfig, args []string) error {
+	if len(args) != 1 || args[0] != "move" {
+		return fmt.Errorf("usage: nvctl images move")
+	}
+
+	store, err := images.New(cfg)
+	if err != nil {
+		return err
+	}
+	db, err := database.New(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	moved, err := db.MoveScanImages(ctx, store)
+	fmt.Printf("Moved %d image(s) to the %s image store\n", moved, cfg.Type)
+	if err != nil {
+		return err
+	}
+	if moved == 0 {
+		return nil
+	}
+	return db.Compact(ctx)
+}
+
+// takeBackup backs up a SQLite database the way the server does on schedule
+func takeBackup(ctx context.Context, dbConfig config.DatabaseConfig, cfg *config.Config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: nvctl backup")
+	}
+
+	store, err := images.New(cfg.Images)
+	if err != nil {
+		return err
+	}
+	db, err := database.Open(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	path, err := backup.Create(ctx, db, store, cfg.Backup)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backed up the database to %s\n", path)
+	return nil
+}
+
+// takeSnapshot copies a SQLite database to a new file
+func takeSnapshot(ctx context.Context, dbConfig config.DatabaseConfig, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: nvctl snapshot file")
+	}
+
+	db, err := database.Open(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.Snapshot(ctx, args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("Copied the database to %s\n", args[0])
+	return nil
+}
+
+// restore replaces a SQLite database with a backup or archive
+func restore(ctx context.Context, dbConfig config.DatabaseConfig, cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: nvctl restore file")
+	}
+	if dbConfig.Type != "sqlite" {
+		return fmt.Errorf("only SQLite databases can be restored; use pg_restore for PostgreSQL")
+	}
+
+	store, err := images.New(cfg.Images)
+	if err != nil {
+		return err
+	}
+	version, photos, err := backup.Restore(ctx, args[0], dbConfig.Path, store, cfg.Backup.Passphrase)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s with schema version %d and %d photo(s)\n", dbConfig.Path, version, photos)
+	return nil
+}
+
+// exportData writes the rows of a database to a JSON or CSV export
+func exportData(ctx context.Context, dbConfig config.DatabaseConfig, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: nvctl export file [household]")
+	}
+	householdID := ""
+	if len(args) == 2 {
+		householdID = args[1]
+	}
+
+	db, err := database.Open(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	export, err := db.Export(ctx, householdID)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(args[0], os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(strings.ToLower(args[0]), ".zip") {
+		err = export.WriteCSV(f)
+	} else {
+		err = export.WriteJSON(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(args[0])
+		return err
+	}
+
+	rows := 0
+	for _, table := range export.Tables {
+		rows += len(table.Rows)
+	}
+	fmt.Printf("Exported %d row(s) to %s\n", rows, args[0])
+	return nil
+}
+
+// importData merges a JSON export into a household of a database, migrating
+// its schema first, and reports the photos of the export the image store
+// does not have
+func importData(ctx context.Context, dbConfig config.DatabaseConfig, imagesConfig config.ImagesConfig, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: nvctl import file [household]")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	export, err := database.ReadExport(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	store, err := images.New(imagesConfig)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.New(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var householdID string
+	if len(args) == 2 {
+		householdID = args[1]
+	} else {
+		user, err := db.EnsureDefaultUser(ctx)
+		if err != nil {
+			return err
+		}
+		householdID = user.HouseholdID
+	}
+
+	imported, err := db.Import(ctx, export, householdID)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tADDED\tSKIPPED")
+	for _, table := range imported {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", table.Name, table.Added, table.Skipped)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	keys := export.ImageKeys()
+	missing := 0
+	for _, key := range keys {
+		if _, err := store.Get(ctx, key); errors.Is(err, images.ErrNotFound) {
+			missing++
+		} else if err != nil {
+			return fmt.Errorf("error checking photo %s: %w", key, err)
+		}
+	}
+	if missing > 0 {
+		fmt.Printf("%d of the %d photo(s) the export refers to are not in the %s image store and will not show;\n"+
+			"copy them from the image store of the exporting instance\n", missing, len(keys), imagesConfig.Type)
+	}
+	return nil
+}
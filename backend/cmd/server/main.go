@@ -4,9 +4,12 @@ import (
 	"context"
 	"flag"
 	"log"
+	_ "time/tzdata" // users may pick any time zone, whatever the system knows
 
+	"github.com/franckalain/nutritionalvalue/internal/backup"
 	"github.com/franckalain/nutritionalvalue/internal/config"
 	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/images"
 	"github.com/franckalain/nutritionalvalue/internal/ml"
 	"github.com/franckalain/nutritionalvalue/internal/server"
 )
@@ -22,12 +25,21 @@ func main() {
 	}
 
 	// Initialize database
-	db, err := database.NewSQLiteDB(cfg.Database.Path)
+	db, err := database.New(cfg.Database)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	defer db.Close()
 
+	// Initialize image store and move photos older releases kept in the database
+	imageStore, err := images.New(cfg.Images)
+	if err != nil {
+		log.Fatal("Failed to create image store:", err)
+	}
+	if _, err := db.MoveScanImages(context.Background(), imageStore); err != nil {
+		log.Fatal("Failed to move scan images to the image store:", err)
+	}
+
 	// Initialize ML service
 	model, err := ml.NewModel(cfg.ML.Type)
 	if err != nil {
@@ -38,9 +50,30 @@ func main() {
 		log.Fatal("Failed to load ML model:", err)
 	}
 
+	// Back up the database while the server runs
+	backupCtx, stopBackups := context.WithCancel(context.Background())
+	backupsDone := make(chan struct{})
+	go func() {
+		defer close(backupsDone)
+		if cfg.Database.Type != "sqlite" {
+			if cfg.Backup.Interval.Duration > 0 {
+				log.Println("Scheduled backups only cover SQLite; back up PostgreSQL with pg_dump")
+			}
+			return
+		}
+		backup.Schedule(backupCtx, db, imageStore, cfg.Backup)
+	}()
+
 	// Initialize and start server
-	srv := server.New(db, model, true)
-	if err := srv.Start(cfg.Server.Port, cfg.Server.StaticDir); err != nil {
+	srv := server.New(db, model, imageStore, true)
+	if err := srv.Start(cfg.Server); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
+
+	// A backup being taken is abandoned
+	stopBackups()
+	<-backupsDone
+
+	// Running jobs have finished or been abandoned; the deferred Close runs next
+	log.Println("Closing database")
 }
@@ -6,8 +6,11 @@ require (
 	cloud.google.com/go/vertexai v0.13.3
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/websocket v1.5.1
+	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.31.0
 	google.golang.org/api v0.211.0
 	modernc.org/sqlite v1.29.2
+	rsc.io/qr v0.2.0
 )
 
 require (
@@ -34,7 +37,6 @@ require (
 	go.opentelemetry.io/otel v1.29.0 // indirect
 	go.opentelemetry.io/otel/metric v1.29.0 // indirect
 	go.opentelemetry.io/otel/trace v1.29.0 // indirect
-	golang.org/x/crypto v0.31.0 // indirect
 	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/oauth2 v0.24.0 // indirect
 	golang.org/x/sync v0.10.0 // indirect
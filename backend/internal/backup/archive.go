@@ -0,0 +1,340 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/images"
+	"golang.org/x/crypto/scrypt"
+)
+
+// An archive is a tar stream holding the database as databaseEntry and every
+// photo as "images/<key>", encrypted with AES-256-GCM. It starts with a
+// header:
+//
+//	magic    "NVBACKUP"
+//	version  1 byte, archiveVersion
+//	salt     16 bytes, for deriving the key from the passphrase with scrypt
+//
+// followed by chunks of at most chunkSize bytes of the tar stream, each
+// written as a final flag byte, the big-endian uint32 length of the sealed
+// chunk and the sealed chunk. Chunks are numbered from 0 in their nonce and
+// authenticated together with the header and their flag, so that chunks
+// cannot be reordered, dropped or cut off at the end unnoticed.
+const (
+	archiveMagic   = "NVBACKUP"
+	archiveVersion = 1
+	saltSize       = 16
+	chunkSize      = 64 * 1024
+	databaseEntry  = "nutritional.db"
+	imagesDir      = "images/"
+)
+
+// scrypt parameters recommended for interactive logins in 2017, which keep
+// guessing passphrases expensive while deriving a key in well under a second
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// writeArchive writes an encrypted archive of the database snapshot at
+// snapshot and the photos with the given keys to w. Photos missing from the
+// store are logged and left out.
+func writeArchive(ctx context.Context, w io.Writer, snapshot string, keys []string, store images.Store, passphrase string) (int, error) {
+	header := make([]byte, len(archiveMagic)+1+saltSize)
+	copy(header, archiveMagic)
+	header[len(archiveMagic)] = archiveVersion
+	if _, err := rand.Read(header[len(archiveMagic)+1:]); err != nil {
+		return 0, fmt.Errorf("error generating salt: %w", err)
+	}
+	aead, err := newAEAD(passphrase, header[len(archiveMagic)+1:])
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return 0, fmt.Errorf("error writing archive: %w", err)
+	}
+
+	enc := &encryptWriter{w: w, aead: aead, header: header}
+	tw := tar.NewWriter(enc)
+
+	db, err := os.Open(snapshot)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	info, err := db.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := tw.WriteHeader(fileHeader(databaseEntry, info.Size(), info.ModTime())); err != nil {
+		return 0, fmt.Errorf("error writing archive: %w", err)
+	}
+	if _, err := io.Copy(tw, db); err != nil {
+		return 0, fmt.Errorf("error writing archive: %w", err)
+	}
+
+	photos := 0
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return photos, err
+		}
+		data, err := store.Get(ctx, key)
+		if errors.Is(err, images.ErrNotFound) {
+			log.Printf("Photo %s is missing from the image store, leaving it out of the backup", key)
+			continue
+		}
+		if err != nil {
+			return photos, fmt.Errorf("error reading photo %s: %w", key, err)
+		}
+		if err := tw.WriteHeader(fileHeader(imagesDir+key, int64(len(data)), time.Now())); err != nil {
+			return photos, fmt.Errorf("error writing archive: %w", err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return photos, fmt.Errorf("error writing archive: %w", err)
+		}
+		photos++
+	}
+
+	if err := tw.Close(); err != nil {
+		return photos, fmt.Errorf("error writing archive: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return photos, fmt.Errorf("error writing archive: %w", err)
+	}
+	return photos, nil
+}
+
+// readArchive decrypts an archive from r, writes its database to dbPath and
+// puts its photos into store, checking each against its key. It returns the
+// number of photos restored.
+func readArchive(ctx context.Context, r io.Reader, passphrase, dbPath string, store images.Store) (int, error) {
+	header := make([]byte, len(archiveMagic)+1+saltSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, fmt.Errorf("error reading archive: %w", err)
+	}
+	if string(header[:len(archiveMagic)]) != archiveMagic {
+		return 0, fmt.Errorf("not a backup archive")
+	}
+	if v := header[len(archiveMagic)]; v != archiveVersion {
+		return 0, fmt.Errorf("unsupported archive version %d", v)
+	}
+	aead, err := newAEAD(passphrase, header[len(archiveMagic)+1:])
+	if err != nil {
+		return 0, err
+	}
+
+	tr := tar.NewReader(&decryptReader{r: r, aead: aead, header: header})
+	hasDatabase := false
+	photos := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return photos, err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return photos, fmt.Errorf("error reading archive: %w", err)
+		}
+
+		switch {
+		case hdr.Name == databaseEntry:
+			if err := writeFile(dbPath, tr); err != nil {
+				return photos, err
+			}
+			hasDatabase = true
+		case path.Dir(hdr.Name)+"/" == imagesDir:
+			key := path.Base(hdr.Name)
+			if !images.ValidKey(key) {
+				return photos, fmt.Errorf("archive holds a photo with invalid key %q", key)
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return photos, fmt.Errorf("error reading archive: %w", err)
+			}
+			if images.Key(data) != key {
+				return photos, fmt.Errorf("photo %s in the archive is damaged", key)
+			}
+			if _, err := store.Put(ctx, data); err != nil {
+				return photos, fmt.Errorf("error restoring photo %s: %w", key, err)
+			}
+			photos++
+		default:
+			return photos, fmt.Errorf("archive holds unexpected file %q", hdr.Name)
+		}
+	}
+	if !hasDatabase {
+		return photos, fmt.Errorf("archive holds no database")
+	}
+	return photos, nil
+}
+
+// isArchive reports whether the file at path starts like an archive rather
+// than a SQLite database
+func isArchive(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(archiveMagic))
+	if _, err := io.ReadFull(f, magic); err != nil && err != io.ErrUnexpectedEOF {
+		return false, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return string(magic) == archiveMagic, nil
+}
+
+func fileHeader(name string, size int64, modTime time.Time) *tar.Header {
+	return &tar.Header{Name: name, Mode: 0o600, Size: size, ModTime: modTime, Typeflag: tar.TypeReg}
+}
+
+// newAEAD derives the key of an archive from the passphrase and its salt
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("no backup passphrase given")
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce of the nth chunk. Every archive has its own
+// salt and so its own key, which makes a counter a safe nonce.
+func chunkNonce(aead cipher.AEAD, n uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], n)
+	return nonce
+}
+
+// chunkData returns the additional data a chunk is authenticated with
+func chunkData(header []byte, final bool) []byte {
+	flag := byte(0)
+	if final {
+		flag = 1
+	}
+	return append(bytes.Clone(header), flag)
+}
+
+// encryptWriter seals what is written to it chunk by chunk. Close seals the
+// final chunk, which may be empty, and must be called.
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	n      uint64
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		take := min(chunkSize-len(e.buf), len(p))
+		e.buf = append(e.buf, p[:take]...)
+		p = p[take:]
+		written += take
+		if len(e.buf) == chunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(final bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.aead, e.n), e.buf, chunkData(e.header, final))
+	e.n++
+	e.buf = e.buf[:0]
+
+	var frame [5]byte
+	if final {
+		frame[0] = 1
+	}
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(sealed)))
+	if _, err := e.w.Write(frame[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// decryptReader opens the chunks written by encryptWriter
+type decryptReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	n      uint64
+	final  bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.final {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	var frame [5]byte
+	if _, err := io.ReadFull(d.r, frame[:]); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("archive is truncated")
+		}
+		return err
+	}
+	size := binary.BigEndian.Uint32(frame[1:])
+	if size > chunkSize+uint32(d.aead.Overhead()) {
+		return fmt.Errorf("archive is damaged")
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return fmt.Errorf("archive is truncated")
+	}
+
+	final := frame[0] == 1
+	plain, err := d.aead.Open(nil, chunkNonce(d.aead, d.n), sealed, chunkData(d.header, final))
+	if err != nil {
+		if d.n == 0 {
+			return fmt.Errorf("wrong passphrase or damaged archive")
+		}
+		return fmt.Errorf("archive is damaged")
+	}
+	d.n++
+	d.buf = plain
+	d.final = final
+	return nil
+}
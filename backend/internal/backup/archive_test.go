@@ -0,0 +1,163 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/franckalain/nutritionalvalue/internal/images"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+// testArchive writes an archive of a database file of a few chunks and two
+// photos, one of which is missing from the store. It returns the archive,
+// the database and the photo that was archived.
+func testArchive(t *testing.T) ([]byte, []byte, []byte) {
+	t.Helper()
+	ctx := context.Background()
+	database := make([]byte, 2*chunkSize+1234)
+	if _, err := rand.Read(database); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := os.WriteFile(snapshot, database, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := images.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo := []byte("label photo")
+	key, err := store.Put(ctx, photo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := images.Key([]byte("photo that was deleted"))
+
+	var archive bytes.Buffer
+	photos, err := writeArchive(ctx, &archive, snapshot, []string{key, missing}, store, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if photos != 1 {
+		t.Errorf("archived %d photos, want 1", photos)
+	}
+	return archive.Bytes(), database, photo
+}
+
+// openArchive reads an archive into a new database file and image store
+func openArchive(t *testing.T, archive []byte, passphrase string) ([]byte, images.Store, int, error) {
+	t.Helper()
+	store, err := images.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(t.TempDir(), "restored.db")
+	photos, err := readArchive(context.Background(), bytes.NewReader(archive), passphrase, dbPath, store)
+	if err != nil {
+		return nil, store, photos, err
+	}
+	database, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return database, store, photos, nil
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	archive, database, photo := testArchive(t)
+	if !bytes.HasPrefix(archive, []byte(archiveMagic)) {
+		t.Fatal("archive does not start with its magic")
+	}
+	if bytes.Contains(archive, photo) {
+		t.Fatal("archive holds the photo in the clear")
+	}
+
+	got, store, photos, err := openArchive(t, archive, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, database) {
+		t.Error("database changed through the archive")
+	}
+	if photos != 1 {
+		t.Errorf("restored %d photos, want 1", photos)
+	}
+	if data, err := store.Get(context.Background(), images.Key(photo)); err != nil || !bytes.Equal(data, photo) {
+		t.Errorf("restored photo = %q, %v", data, err)
+	}
+}
+
+func TestArchiveWrongPassphrase(t *testing.T) {
+	archive, _, _ := testArchive(t)
+	_, _, _, err := openArchive(t, archive, "wrong")
+	if err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Errorf("opening with the wrong passphrase: %v", err)
+	}
+	if _, _, _, err := openArchive(t, archive, ""); err == nil {
+		t.Error("opened an archive without a passphrase")
+	}
+}
+
+// archiveChunk is the frame of a chunk within an archive
+type archiveChunk struct {
+	start, end int
+}
+
+// archiveChunks splits an archive into its header and chunk frames
+func archiveChunks(t *testing.T, archive []byte) ([]byte, []archiveChunk) {
+	t.Helper()
+	headerSize := len(archiveMagic) + 1 + saltSize
+	var chunks []archiveChunk
+	for at := headerSize; at < len(archive); {
+		end := at + 5 + int(binary.BigEndian.Uint32(archive[at+1:at+5]))
+		chunks = append(chunks, archiveChunk{at, end})
+		at = end
+	}
+	return archive[:headerSize], chunks
+}
+
+func TestArchiveTampering(t *testing.T) {
+	archive, _, _ := testArchive(t)
+	header, chunks := archiveChunks(t, archive)
+	if len(chunks) < 3 {
+		t.Fatalf("archive has %d chunks, want at least 3", len(chunks))
+	}
+	frame := func(i int) []byte { return archive[chunks[i].start:chunks[i].end] }
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	rest := func(from int) []byte { return archive[chunks[from].start:] }
+
+	flipped := bytes.Clone(archive)
+	flipped[chunks[1].end-1] ^= 1
+	final := bytes.Clone(frame(0))
+	final[0] = 1
+
+	for _, tt := range []struct {
+		name    string
+		archive []byte
+	}{
+		{"header only", header},
+		{"cut within a chunk", archive[:chunks[1].start+100]},
+		{"cut between chunks", archive[:chunks[1].start]},
+		{"final chunk dropped", archive[:chunks[len(chunks)-1].start]},
+		{"chunk dropped", join(header, frame(0), rest(2))},
+		{"chunks reordered", join(header, frame(1), frame(0), rest(2))},
+		{"chunk repeated", join(header, frame(0), frame(0), rest(1))},
+		{"chunk marked final", join(header, final, rest(1))},
+		{"byte flipped", flipped},
+		{"other salt", join(append(bytes.Clone(header[:len(header)-1]), header[len(header)-1]^1), rest(0))},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, err := openArchive(t, tt.archive, testPassphrase); err == nil {
+				t.Error("tampered archive was read")
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+// Package backup takes consistent copies of a SQLite database while the server
+// uses it, keeps the latest few and restores them.
+//
+// A backup is either a plain copy of the database file, which SQLite opens as
+// is, or an encrypted archive that also holds the photos of the image store.
+// Backups are named after the time they were taken, such as
+// "nutritional-20261018T123000Z.db" or "nutritional-20261018T123000Z.nvbak",
+// so that their names sort oldest first.
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/config"
+	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/images"
+)
+
+const (
+	namePrefix  = "nutritional-"
+	nameLayout  = "20060102T150405Z"
+	snapshotExt = ".db"
+	archiveExt  = ".nvbak"
+)
+
+// Create takes a backup of db into cfg.Dir and removes the backups beyond the
+// latest cfg.Keep. With cfg.Archive the backup is an encrypted archive that
+// includes the photos of store. It returns the path of the backup.
+func Create(ctx context.Context, db *database.SQLDB, store images.Store, cfg config.BackupConfig) (string, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return "", fmt.Errorf("error creating backup directory: %w", err)
+	}
+	name := filepath.Join(cfg.Dir, namePrefix+time.Now().UTC().Format(nameLayout))
+
+	var backup string
+	var err error
+	if cfg.Archive {
+		backup, err = createArchive(ctx, db, store, name+archiveExt, cfg.Passphrase)
+	} else {
+		backup = name + snapshotExt
+		err = snapshot(ctx, db, backup)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := Rotate(cfg.Dir, cfg.Keep); err != nil {
+		return backup, err
+	}
+	return backup, nil
+}
+
+// snapshot copies the database to path, which only appears once complete
+func snapshot(ctx context.Context, db *database.SQLDB, path string) error {
+	tmp := path + ".tmp"
+	if err := db.Snapshot(ctx, tmp); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error saving snapshot: %w", err)
+	}
+	return nil
+}
+
+// createArchive writes an encrypted archive of a snapshot of db and every
+// photo it refers to
+func createArchive(ctx context.Context, db *database.SQLDB, store images.Store, path, passphrase string) (string, error) {
+	snapshotPath := path + ".db.tmp"
+	if err := db.Snapshot(ctx, snapshotPath); err != nil {
+		return "", err
+	}
+	defer os.Remove(snapshotPath)
+
+	// Photos are listed after the snapshot is taken so that none it refers
+	// to is missed; a few newer ones may come along
+	keys, err := db.ImageKeys(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("error creating archive: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	photos, err := writeArchive(ctx, f, snapshotPath, keys, store, passphrase)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("error saving archive: %w", err)
+	}
+	log.Printf("Archived the database with %d photo(s)", photos)
+	return path, nil
+}
+
+// List returns the backups in dir, oldest first
+func List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing backups: %w", err)
+	}
+
+	var backups []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if _, ok := takenAt(e.Name()); ok {
+			backups = append(backups, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// Rotate removes all but the latest keep backups in dir and returns the
+// removed ones
+func Rotate(dir string, keep int) ([]string, error) {
+	backups, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(backups) <= keep {
+		return nil, nil
+	}
+
+	old := backups[:len(backups)-keep]
+	for _, backup := range old {
+		if err := os.Remove(backup); err != nil {
+			return nil, fmt.Errorf("error removing old backup: %w", err)
+		}
+	}
+	return old, nil
+}
+
+// takenAt parses the time a backup was taken from its file name
+func takenAt(name string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, namePrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	if s, ok := strings.CutSuffix(stamp, snapshotExt); ok {
+		stamp = s
+	} else if s, ok := strings.CutSuffix(stamp, archiveExt); ok {
+		stamp = s
+	} else {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(nameLayout, stamp)
+	return t, err == nil
+}
+
+// Restore replaces the SQLite database at dbPath with a backup, after checking
+// the backup is intact. The photos of an archive are put into store first.
+// The database being replaced is kept next to it with a ".pre-restore"
+// suffix, and stays in place until the backup has taken its place. The server
+// must be stopped; it migrates an older backup to the current schema on
+// start. Restore returns the schema version of the backup and the number of
+// photos restored.
+func Restore(ctx context.Context, backup, dbPath string, store images.Store, passphrase string) (int, int, error) {
+	tmp := dbPath + ".restore"
+	removeDatabase(tmp)
+	version, photos, err := unpack(ctx, backup, tmp, store, passphrase)
+	if err == nil {
+		err = keepDatabase(dbPath, dbPath+".pre-restore")
+	}
+	if err == nil {
+		if err = os.Rename(tmp, dbPath); err != nil {
+			err = fmt.Errorf("error replacing database: %w", err)
+		}
+	}
+	if err != nil {
+		removeDatabase(tmp)
+		return 0, photos, err
+	}
+
+	// The write-ahead log of the replaced database was kept with it
+	for _, suffix := range sqliteFiles[1:] {
+		os.Remove(dbPath + suffix)
+	}
+	return version, photos, nil
+}
+
+// unpack writes the database of a backup to path and verifies it. It returns
+// the schema version of the backup and the number of photos of an archive
+// put into store.
+func unpack(ctx context.Context, backup, path string, store images.Store, passphrase string) (int, int, error) {
+	archive, err := isArchive(backup)
+	if err != nil {
+		return 0, 0, err
+	}
+	f, err := os.Open(backup)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	photos := 0
+	if archive {
+		photos, err = readArchive(ctx, f, passphrase, path, store)
+	} else {
+		err = writeFile(path, f)
+	}
+	if err != nil {
+		return 0, photos, err
+	}
+
+	version, err := database.VerifySQLite(ctx, path)
+	if err != nil {
+		return 0, photos, fmt.Errorf("backup %s failed verification: %w", backup, err)
+	}
+	return version, photos, nil
+}
+
+// sqliteFiles are the suffixes of the files a SQLite database in WAL mode is
+// made of
+var sqliteFiles = []string{"", "-wal", "-shm"}
+
+// keepDatabase makes a copy of a SQLite database and its write-ahead log at
+// to, replacing whatever was there, and leaves the database in place. The
+// copy is a hard link where the file system allows.
+func keepDatabase(from, to string) error {
+	removeDatabase(to)
+	for _, suffix := range sqliteFiles {
+		if err := os.Link(from+suffix, to+suffix); err == nil || os.IsNotExist(err) {
+			continue
+		}
+		if err := copyFile(from+suffix, to+suffix); err != nil {
+			removeDatabase(to)
+			return fmt.Errorf("error keeping the replaced database: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyFile copies the file at from to a new file at to
+func copyFile(from, to string) error {
+	f, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeFile(to, f)
+}
+
+func removeDatabase(path string) {
+	for _, suffix := range sqliteFiles {
+		os.Remove(path + suffix)
+	}
+}
+
+// writeFile copies r into a new file at path and syncs it
+func writeFile(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", path, err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// Schedule takes a backup every cfg.Interval until ctx is done, starting
+// once the latest backup in cfg.Dir is an interval old. Failed backups are
+// logged and tried again at the next interval.
+func Schedule(ctx context.Context, db *database.SQLDB, store images.Store, cfg config.BackupConfig) {
+	if cfg.Interval.Duration <= 0 {
+		return
+	}
+
+	wait := time.Duration(0)
+	if backups, err := List(cfg.Dir); err == nil && len(backups) > 0 {
+		latest, _ := takenAt(filepath.Base(backups[len(backups)-1]))
+		wait = max(time.Until(latest.Add(cfg.Interval.Duration)), 0)
+	}
+	log.Printf("Backing up the database to %s every %s", cfg.Dir, cfg.Interval.Duration)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if backup, err := Create(ctx, db, store, cfg); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error backing up database: %v", err)
+			}
+		} else {
+			log.Printf("Backed up database to %s", backup)
+		}
+		timer.Reset(cfg.Interval.Duration)
+	}
+}
@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/config"
+	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/images"
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+func openDatabase(t *testing.T, path string) *database.SQLDB {
+	t.Helper()
+	db, err := database.NewSQLiteDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func saveEntry(t *testing.T, db *database.SQLDB, id, photo string) {
+	t.Helper()
+	entry := &models.NutritionalInfo{ID: id, Calories: 120, ImagePath: photo, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if err := db.SaveNutritionalInfo(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// hasEntry reports whether the database at path holds an entry
+func hasEntry(t *testing.T, path, id string) bool {
+	t.Helper()
+	db := openDatabase(t, path)
+	defer db.Close()
+	entry, err := db.GetNutritionalInfo(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry != nil
+}
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"nutritional-20240101T000000Z.db",
+		"nutritional-20240102T000000Z.nvbak",
+		"nutritional-20240103T000000Z.db",
+		"nutritional-20240104T000000Z.nvbak",
+		"nutritional.db",
+		"nutritional-latest.db",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := Rotate(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, names[0]), filepath.Join(dir, names[1])}; !slices.Equal(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+	backups, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, names[2]), filepath.Join(dir, names[3])}; !slices.Equal(backups, want) {
+		t.Errorf("kept %v, want %v", backups, want)
+	}
+	for _, name := range names[4:] {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("file that is not a backup: %v", err)
+		}
+	}
+
+	if removed, err := Rotate(dir, 5); err != nil || len(removed) != 0 {
+		t.Errorf("rotating fewer backups than kept removed %v, %v", removed, err)
+	}
+	if backups, err := List(filepath.Join(dir, "missing")); err != nil || len(backups) != 0 {
+		t.Errorf("listing a missing directory = %v, %v", backups, err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	for _, archive := range []bool{false, true} {
+		name := "snapshot"
+		if archive {
+			name = "archive"
+		}
+		t.Run(name, func(t *testing.T) {
+			dbPath := filepath.Join(t.TempDir(), "nutritional.db")
+			db := openDatabase(t, dbPath)
+			store, err := images.NewLocalStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			photo, err := store.Put(ctx, []byte("label photo"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			saveEntry(t, db, "backed-up", photo)
+
+			cfg := config.BackupConfig{Dir: t.TempDir(), Keep: 1, Archive: archive, Passphrase: testPassphrase}
+			backup, err := Create(ctx, db, store, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			saveEntry(t, db, "after-backup", "")
+			db.Close()
+
+			restored, err := images.NewLocalStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			version, photos, err := Restore(ctx, backup, dbPath, restored, testPassphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version == 0 {
+				t.Error("restored schema version is 0")
+			}
+			if want := map[bool]int{false: 0, true: 1}[archive]; photos != want {
+				t.Errorf("restored %d photos, want %d", photos, want)
+			}
+			if data, err := restored.Get(ctx, photo); archive && (err != nil || string(data) != "label photo") {
+				t.Errorf("restored photo = %q, %v", data, err)
+			}
+
+			if !hasEntry(t, dbPath, "backed-up") || hasEntry(t, dbPath, "after-backup") {
+				t.Error("database was not replaced by the backup")
+			}
+			if !hasEntry(t, dbPath+".pre-restore", "after-backup") {
+				t.Error("replaced database was not kept")
+			}
+			if _, err := os.Stat(dbPath + ".restore"); !os.IsNotExist(err) {
+				t.Errorf("restore left its temporary file: %v", err)
+			}
+		})
+	}
+}
+
+func TestRestoreFailure(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "nutritional.db")
+	db := openDatabase(t, dbPath)
+	saveEntry(t, db, "current", "")
+	store, err := images.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := Create(ctx, db, store, config.BackupConfig{Dir: t.TempDir(), Keep: 1, Archive: true, Passphrase: testPassphrase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	current, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(t.TempDir(), "nutritional-20240101T000000Z.nvbak")
+	if err := os.WriteFile(truncated, data[:len(data)-100], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	garbage := filepath.Join(t.TempDir(), "nutritional-20240101T000000Z.db")
+	if err := os.WriteFile(garbage, bytes.Repeat([]byte("not a database "), 1000), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name, backup, passphrase string
+	}{
+		{"missing", filepath.Join(dir, "missing.db"), ""},
+		{"not a database", garbage, ""},
+		{"wrong passphrase", archive, "wrong"},
+		{"truncated", truncated, testPassphrase},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Restore(ctx, tt.backup, dbPath, store, tt.passphrase); err == nil {
+				t.Fatal("restore succeeded")
+			}
+			if got, err := os.ReadFile(dbPath); err != nil || !bytes.Equal(got, current) {
+				t.Errorf("failed restore changed the database: %v", err)
+			}
+			for _, leftover := range []string{".restore", ".restore-wal", ".pre-restore"} {
+				if _, err := os.Stat(dbPath + leftover); !os.IsNotExist(err) {
+					t.Errorf("failed restore left %s: %v", leftover, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+// Package certs creates the certificates needed to serve HTTPS on a home
+// network: a self-signed certificate authority, installed once on each phone,
+// and a server certificate it signs for the machine's names and addresses.
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// File names inside the certificate directory
+const (
+	caCertFile     = "ca.pem"
+	caKeyFile      = "ca-key.pem"
+	serverCertFile = "server.pem"
+	serverKeyFile  = "server-key.pem"
+)
+
+const (
+	caValidity = 10 * 365 * 24 * time.Hour
+	// Apple devices reject server certificates valid for more than 825 days
+	serverValidity = 825 * 24 * time.Hour
+	// Server certificates are renewed when they expire within this time
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// Files locates a certificate authority and the server certificate it signed
+type Files struct {
+	CACert string
+	Cert   string
+	Key    string
+}
+
+// EnsureSelfSigned returns a server certificate for hosts signed by the
+// certificate authority in dir. The authority is created on first use and kept
+// so that phones only have to trust it once; the server certificate is
+// reissued when it nears expiry or does not cover every host.
+func EnsureSelfSigned(dir string, hosts []string) (*Files, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating certificate directory: %w", err)
+	}
+
+	files := &Files{
+		CACert: filepath.Join(dir, caCertFile),
+		Cert:   filepath.Join(dir, serverCertFile),
+		Key:    filepath.Join(dir, serverKeyFile),
+	}
+
+	ca, caKey, err := loadOrCreateCA(files.CACert, filepath.Join(dir, caKeyFile), hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hosts added after the authority was created are left out rather than
+	// making a certificate no device accepts
+	hosts, uncovered := coveredHosts(ca, hosts)
+	if len(uncovered) > 0 {
+		log.Printf("Certificate authority %s does not cover %v; remove it and %s to create one that does, then install it on devices again",
+			files.CACert, uncovered, caKeyFile)
+	}
+
+	current, err := readCertificate(files.Cert)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Replacing unreadable server certificate: %v", err)
+	}
+	if current != nil && usable(current, ca, hosts, time.Now()) {
+		return files, nil
+	}
+
+	if err := createServerCertificate(files.Cert, files.Key, ca, caKey, hosts); err != nil {
+		return nil, err
+	}
+	log.Printf("Created server certificate for %v", hosts)
+	return files, nil
+}
+
+// LocalHosts lists the names and addresses this machine can be reached at
+func LocalHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		hosts = append(hosts, name, name+".local")
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return hosts
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		hosts = append(hosts, ipNet.IP.String())
+	}
+	return hosts
+}
+
+// localDomains are the DNS names a home network uses besides the hosts the
+// authority is created for: mDNS names and the special-use domain for homes
+var localDomains = []string{"localhost", "local", "home.arpa"}
+
+// localRanges are the addresses a home network hands out, so that the
+// authority still covers the server after its address changes
+var localRanges = []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"}
+
+// nameConstraints limits what an authority may sign to the local names and
+// addresses and to hosts. Phones trust the authority for every site, so a
+// leaked key must not be usable for anything outside the home network.
+func nameConstraints(template *x509.Certificate, hosts []string) {
+	template.PermittedDNSDomainsCritical = true
+	template.PermittedDNSDomains = append([]string{}, localDomains...)
+	for _, r := range localRanges {
+		_, ipNet, _ := net.ParseCIDR(r)
+		template.PermittedIPRanges = append(template.PermittedIPRanges, ipNet)
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			if !slices.ContainsFunc(template.PermittedIPRanges, func(r *net.IPNet) bool { return r.Contains(ip) }) {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				template.PermittedIPRanges = append(template.PermittedIPRanges, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			}
+			continue
+		}
+		if !slices.ContainsFunc(template.PermittedDNSDomains, func(domain string) bool { return withinDomain(host, domain) }) {
+			template.PermittedDNSDomains = append(template.PermittedDNSDomains, host)
+		}
+	}
+}
+
+// coveredHosts splits hosts into those the name constraints of a CA let it
+// sign for and the others. Authorities created before they had constraints
+// cover every host.
+func coveredHosts(ca *x509.Certificate, hosts []string) ([]string, []string) {
+	var covered, uncovered []string
+	for _, host := range hosts {
+		ok := true
+		if ip := net.ParseIP(host); ip != nil {
+			if len(ca.PermittedIPRanges) > 0 {
+				ok = slices.ContainsFunc(ca.PermittedIPRanges, func(r *net.IPNet) bool { return r.Contains(ip) })
+			}
+		} else if len(ca.PermittedDNSDomains) > 0 {
+			ok = slices.ContainsFunc(ca.PermittedDNSDomains, func(domain string) bool { return withinDomain(host, domain) })
+		}
+		if ok {
+			covered = append(covered, host)
+		} else {
+			uncovered = append(uncovered, host)
+		}
+	}
+	return covered, uncovered
+}
+
+// withinDomain reports whether a DNS name is domain or one of its subdomains
+func withinDomain(name, domain string) bool {
+	name, domain = strings.ToLower(name), strings.ToLower(domain)
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// loadOrCreateCA reads the certificate authority, creating it for hosts if
+// there is none
+func loadOrCreateCA(certPath, keyPath string, hosts []string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	cert, err := readCertificate(certPath)
+	if err == nil {
+		key, err := readKey(keyPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading CA key: %w", err)
+		}
+		return cert, key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("error reading CA certificate: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating CA key: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject: pkix.Name{
+			Organization: []string{"Nutritional Value"},
+			CommonName:   "Nutritional Value CA " + hostname,
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	nameConstraints(template, hosts)
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating CA certificate: %w", err)
+	}
+	if err := writeKey(keyPath, key); err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(certPath, "CERTIFICATE", der, 0o644); err != nil {
+		return nil, nil, err
+	}
+
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("Created certificate authority %s", certPath)
+	return cert, key, nil
+}
+
+// createServerCertificate issues a certificate for hosts signed by the CA
+func createServerCertificate(certPath, keyPath string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("error generating server key: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject: pkix.Name{
+			Organization: []string{"Nutritional Value"},
+			CommonName:   hosts[0],
+		},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(serverValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("error creating server certificate: %w", err)
+	}
+	if err := writeKey(keyPath, key); err != nil {
+		return err
+	}
+	return writePEM(certPath, "CERTIFICATE", der, 0o644)
+}
+
+// usable reports whether a server certificate is accepted by devices that
+// trust the CA, is valid for a while longer and covers every host
+func usable(cert, ca *x509.Certificate, hosts []string, now time.Time) bool {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	opts := x509.VerifyOptions{Roots: roots, CurrentTime: now, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+	if _, err := cert.Verify(opts); err != nil {
+		return false
+	}
+	if now.Add(renewBefore).After(cert.NotAfter) {
+		return false
+	}
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func randomSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return serial
+}
+
+func readCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate in %s", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func readKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("no private key in %s", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s does not hold an ECDSA key", path)
+	}
+	return key, nil
+}
+
+func writeKey(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("error encoding key: %w", err)
+	}
+	return writePEM(path, "PRIVATE KEY", der, 0o600)
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return nil
+}
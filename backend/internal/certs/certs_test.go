@@ -0,0 +1,104 @@
+package certs
+
+import (
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+)
+
+// verifyHost checks a certificate for host the way a device trusting the CA does
+func verifyHost(cert, ca *x509.Certificate, host string) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	_, err := cert.Verify(x509.VerifyOptions{
+		DNSName:   host,
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err
+}
+
+func TestEnsureSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	hosts := []string{"localhost", "127.0.0.1", "::1", "kitchen", "kitchen.local", "192.168.1.20", "nutrition.example.com", "203.0.113.7"}
+	files, err := EnsureSelfSigned(dir, hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := readCertificate(files.CACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := readCertificate(files.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ca.PermittedDNSDomainsCritical || len(ca.PermittedDNSDomains) == 0 || len(ca.PermittedIPRanges) == 0 {
+		t.Errorf("CA has no name constraints: %v %v", ca.PermittedDNSDomains, ca.PermittedIPRanges)
+	}
+	for _, host := range hosts {
+		if err := verifyHost(cert, ca, host); err != nil {
+			t.Errorf("server certificate for %s: %v", host, err)
+		}
+	}
+
+	// Kept while it covers every host
+	if again, err := EnsureSelfSigned(dir, hosts[:3]); err != nil {
+		t.Fatal(err)
+	} else if kept, _ := readCertificate(again.Cert); !kept.Equal(cert) {
+		t.Error("usable server certificate was reissued")
+	}
+
+	// The address the network hands out next is still covered
+	moved := append(hosts, "10.0.0.5")
+	if _, err := EnsureSelfSigned(dir, moved); err != nil {
+		t.Fatal(err)
+	}
+	if cert, _ = readCertificate(files.Cert); verifyHost(cert, ca, "10.0.0.5") != nil {
+		t.Error("server certificate does not cover a new local address")
+	}
+
+	// Hosts outside the constraints are left out of the certificate
+	if _, err := EnsureSelfSigned(dir, append(moved, "bank.example.org", "8.8.8.8")); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = readCertificate(files.Cert)
+	for _, host := range moved {
+		if err := verifyHost(cert, ca, host); err != nil {
+			t.Errorf("server certificate for %s: %v", host, err)
+		}
+	}
+	for _, host := range []string{"bank.example.org", "8.8.8.8"} {
+		if cert.VerifyHostname(host) == nil {
+			t.Errorf("server certificate covers %s outside the constraints", host)
+		}
+	}
+}
+
+func TestCAConstraints(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, err := loadOrCreateCA(filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile), []string{"localhost", "kitchen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A leaked key cannot sign for sites on the internet
+	for _, host := range []string{"bank.example.org", "8.8.8.8", "kitchen.example.org"} {
+		certPath, keyPath := filepath.Join(dir, host+".pem"), filepath.Join(dir, host+"-key.pem")
+		if err := createServerCertificate(certPath, keyPath, ca, caKey, []string{host}); err != nil {
+			t.Fatal(err)
+		}
+		cert, err := readCertificate(certPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifyHost(cert, ca, host); err == nil {
+			t.Errorf("certificate for %s verified", host)
+		}
+	}
+
+	if covered, uncovered := coveredHosts(ca, []string{"kitchen", "printer.local", "172.20.0.1", "example.com"}); len(covered) != 3 || len(uncovered) != 1 {
+		t.Errorf("covered %v, not %v", covered, uncovered)
+	}
+}
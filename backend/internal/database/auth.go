@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// ErrLoginTaken is returned by SetCredentials when another user has the login
+var ErrLoginTaken = errors.New("login already taken")
+
+// SetCredentials sets the login and password hash a user signs in with
+func (s *SQLDB) SetCredentials(ctx context.Context, userID, login, passwordHash string) error {
+	var owner string
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM users WHERE login = ?`, login).Scan(&owner)
+	if err == nil && owner != userID {
+		return ErrLoginTaken
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error checking login: %w", err)
+	}
+
+	return expectOneRow(s.db.ExecContext(ctx, `
+		UPDATE users SET login = ?, password_hash = ? WHERE id = ?
+	`, login, passwordHash, userID))
+}
+
+// GetCredentials returns the user with a login and their password hash, or nil
+// if no user has that login
+func (s *SQLDB) GetCredentials(ctx context.Context, login string) (*models.User, string, error) {
+	query := `
+		SELECT ` + userColumns + `, password_hash
+		FROM users WHERE login = ?
+	`
+
+	var passwordHash sql.NullString
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, login), &passwordHash)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return user, passwordHash.String, nil
+}
+
+// authTokenColumns is the column list read by scanAuthToken
+const authTokenColumns = `id, user_id, kind, name, token_hash, created_at, expires_at, last_used_at`
+
+// SaveToken stores a new session or API key
+func (s *SQLDB) SaveToken(ctx context.Context, token *models.AuthToken) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO auth_tokens (`+authTokenColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, token.ID, token.UserID, token.Kind, token.Name, token.Hash,
+		token.CreatedAt, nullTime(token.ExpiresAt), nullTime(token.LastUsedAt))
+	if err != nil {
+		return fmt.Errorf("error saving token: %w", err)
+	}
+	return nil
+}
+
+// GetTokenByHash looks up a token by the hash of its secret and records that
+// it was used. It returns nil if there is no such token.
+func (s *SQLDB) GetTokenByHash(ctx context.Context, hash string) (*models.AuthToken, error) {
+	query := `SELECT ` + authTokenColumns + ` FROM auth_tokens WHERE token_hash = ?`
+
+	token, err := scanAuthToken(s.db.QueryRowContext(ctx, query, hash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE auth_tokens SET last_used_at = ? WHERE id = ?
+	`, now, token.ID); err != nil {
+		return nil, fmt.Errorf("error updating token: %w", err)
+	}
+	token.LastUsedAt = &now
+	return token, nil
+}
+
+// ListTokens returns the sessions and API keys of a user, newest first
+func (s *SQLDB) ListTokens(ctx context.Context, userID string) ([]*models.AuthToken, error) {
+	query := `
+		SELECT ` + authTokenColumns + `
+		FROM auth_tokens WHERE user_id = ?
+		ORDER BY created_at DESC, id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*models.AuthToken
+	for rows.Next() {
+		token, err := scanAuthToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, token)
+	}
+
+	return results, rows.Err()
+}
+
+// DeleteToken revokes one of a user's tokens
+func (s *SQLDB) DeleteToken(ctx context.Context, userID, id string) error {
+	return expectOneRow(s.db.ExecContext(ctx, `
+		DELETE FROM auth_tokens WHERE id = ? AND user_id = ?
+	`, id, userID))
+}
+
+// scanAuthToken reads a row selected with authTokenColumns
+func scanAuthToken(row rowScanner) (*models.AuthToken, error) {
+	var token models.AuthToken
+	var createdAt string
+	var expiresAt, lastUsedAt sql.NullString
+	if err := row.Scan(&token.ID, &token.UserID, &token.Kind, &token.Name, &token.Hash,
+		&createdAt, &expiresAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if token.CreatedAt, err = parseTimestamp(createdAt); err != nil {
+		return nil, err
+	}
+	if token.ExpiresAt, err = parseNullTimestamp(expiresAt); err != nil {
+		return nil, err
+	}
+	if token.LastUsedAt, err = parseNullTimestamp(lastUsedAt); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Snapshot copies the database into a new SQLite file at path while it stays
+// in use. VACUUM INTO reads the database in one transaction, so the copy is
+// consistent and includes what is still in the write-ahead log; it is also
+// compacted. PostgreSQL databases are backed up with pg_dump instead.
+func (s *SQLDB) Snapshot(ctx context.Context, path string) error {
+	if s.db.dialect != sqlite {
+		return fmt.Errorf("snapshots are only supported for SQLite; use pg_dump for PostgreSQL")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("snapshot %s already exists", path)
+	}
+	if _, err := s.db.ExecContext(ctx, `VACUUM INTO ?`, path); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("error taking snapshot: %w", err)
+	}
+	return nil
+}
+
+// ImageKeys returns the keys of every photo entries and scans refer to,
+// including those of deleted entries, which may still be restored
+func (s *SQLDB) ImageKeys(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT image_path FROM nutritional_info WHERE image_path IS NOT NULL AND image_path <> ''
+		UNION
+		SELECT image_path FROM nutrition_scans WHERE image_path IS NOT NULL AND image_path <> ''
+		ORDER BY 1
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing images: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// VerifySQLite checks that the SQLite file at path is intact and holds a
+// schema this build knows, and returns the schema version. Backups are
+// checked this way before they replace a database.
+func VerifySQLite(ctx context.Context, path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, err
+	}
+	s, err := openSQLite(path)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+
+	rows, err := s.db.QueryContext(ctx, `PRAGMA integrity_check`)
+	if err != nil {
+		return 0, fmt.Errorf("error checking integrity: %w", err)
+	}
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error checking integrity: %w", err)
+	}
+	if len(problems) > 0 {
+		return 0, fmt.Errorf("database is corrupt: %s", problems[0])
+	}
+
+	rows, err = s.db.QueryContext(ctx, `PRAGMA foreign_key_check`)
+	if err != nil {
+		return 0, fmt.Errorf("error checking foreign keys: %w", err)
+	}
+	broken := rows.Next()
+	rows.Close()
+	if broken {
+		return 0, fmt.Errorf("database has rows referring to missing rows")
+	}
+
+	migrator, err := NewMigrator(s)
+	if err != nil {
+		return 0, err
+	}
+	version, err := migrator.Version(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if version == 0 {
+		return 0, fmt.Errorf("database has no schema")
+	}
+	if version > migrator.Latest() {
+		return 0, fmt.Errorf("database schema version %d is newer than this release supports (%d)", version, migrator.Latest())
+	}
+	return version, nil
+}
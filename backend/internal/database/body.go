@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// dateLayout is how calendar dates without a time are stored
+const dateLayout = "2006-01-02"
+
+// GetBodyProfile retrieves a user's body profile, or nil if they have none
+func (s *SQLDB) GetBodyProfile(ctx context.Context, userID string) (*models.BodyProfile, error) {
+	query := `
+		SELECT user_id, sex, birth_date, height_cm, weight_kg,
+			activity_level, plan, formula, auto_goals, updated_at
+		FROM body_profiles WHERE user_id = ?
+	`
+
+	var p models.BodyProfile
+	var birthDate, updatedAt string
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&p.UserID, &p.Sex, &birthDate,
+		&p.HeightCm, &p.WeightKg, &p.ActivityLevel, &p.Plan, &p.Formula, &p.AutoGoals, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p.BirthDate, err = time.Parse(dateLayout, birthDate); err != nil {
+		return nil, fmt.Errorf("invalid birth date %q: %w", birthDate, err)
+	}
+	if p.UpdatedAt, err = parseTimestamp(updatedAt); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// SaveBodyProfile creates or replaces a user's body profile
+func (s *SQLDB) SaveBodyProfile(ctx context.Context, p *models.BodyProfile) error {
+	if p.UpdatedAt.IsZero() {
+		p.UpdatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO body_profiles (
+			user_id, sex, birth_date, height_cm, weight_kg,
+			activity_level, plan, formula, auto_goals, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			sex = excluded.sex,
+			birth_date = excluded.birth_date,
+			height_cm = excluded.height_cm,
+			weight_kg = excluded.weight_kg,
+			activity_level = excluded.activity_level,
+			plan = excluded.plan,
+			formula = excluded.formula,
+			auto_goals = excluded.auto_goals,
+			updated_at = excluded.updated_at
+	`
+
+	_, err := s.db.ExecContext(ctx, query, p.UserID, p.Sex, p.BirthDate.Format(dateLayout),
+		p.HeightCm, p.WeightKg, p.ActivityLevel, p.Plan, p.Formula, p.AutoGoals, p.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error saving body profile: %w", err)
+	}
+	return nil
+}
+
+// bodyMetricColumns lists the columns read by scanBodyMetric
+const bodyMetricColumns = `id, user_id, measured_at, weight_kg, waist_cm, body_fat_percent, created_at`
+
+// AddBodyMetric records a set of body measurements
+func (s *SQLDB) AddBodyMetric(ctx context.Context, m *models.BodyMetric) error {
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now()
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO body_metrics (`+bodyMetricColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, m.ID, m.UserID, m.MeasuredAt, nullFloat(m.WeightKg), nullFloat(m.WaistCm),
+		nullFloat(m.BodyFatPercent), m.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error saving body metric: %w", err)
+	}
+	return nil
+}
+
+// ListBodyMetrics returns a user's measurements taken in [from, to), oldest first
+func (s *SQLDB) ListBodyMetrics(ctx context.Context, userID string, from, to time.Time) ([]*models.BodyMetric, error) {
+	query := `
+		SELECT ` + bodyMetricColumns + `
+		FROM body_metrics
+		WHERE user_id = ? AND measured_at >= ? AND measured_at < ?
+		ORDER BY measured_at, id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*models.BodyMetric
+	for rows.Next() {
+		metric, err := scanBodyMetric(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, metric)
+	}
+
+	return results, rows.Err()
+}
+
+// DeleteBodyMetric removes one of a user's measurements
+func (s *SQLDB) DeleteBodyMetric(ctx context.Context, userID, id string) error {
+	return expectOneRow(s.db.ExecContext(ctx, `
+		DELETE FROM body_metrics WHERE id = ? AND user_id = ?
+	`, id, userID))
+}
+
+// scanBodyMetric reads a row selected with bodyMetricColumns
+func scanBodyMetric(row rowScanner) (*models.BodyMetric, error) {
+	var m models.BodyMetric
+	var measuredAt, createdAt string
+	var weight, waist, bodyFat sql.NullFloat64
+	if err := row.Scan(&m.ID, &m.UserID, &measuredAt, &weight, &waist, &bodyFat, &createdAt); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if m.MeasuredAt, err = parseTimestamp(measuredAt); err != nil {
+		return nil, err
+	}
+	if m.CreatedAt, err = parseTimestamp(createdAt); err != nil {
+		return nil, err
+	}
+	m.WeightKg = floatPtr(weight)
+	m.WaistCm = floatPtr(waist)
+	m.BodyFatPercent = floatPtr(bodyFat)
+	return &m, nil
+}
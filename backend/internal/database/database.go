@@ -3,81 +3,300 @@ package database
 import (
 	"context"
 	"database/sql"
-	"embed"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"github.com/franckalain/nutritionalvalue/internal/config"
 	"github.com/franckalain/nutritionalvalue/internal/models"
+	_ "github.com/lib/pq"
 	_ "modernc.org/sqlite"
 )
 
-//go:embed schema.sql
-var schemaFS embed.FS
+// ErrNotFound is returned when an operation targets a row that does not exist
+var ErrNotFound = errors.New("not found")
 
 // DB interface defines the methods our database should implement
 type DB interface {
 	SaveNutritionalInfo(ctx context.Context, info *models.NutritionalInfo) error
 	GetNutritionalInfo(ctx context.Context, id string) (*models.NutritionalInfo, error)
 	SaveScan(ctx context.Context, scan *models.NutritionScan) error
+	ConfirmScan(ctx context.Context, entry *models.NutritionalInfo, shares []models.ConsumptionShare, pantry bool, scan *models.NutritionScan) (*models.PantryItem, error)
+	GetScansByEntry(ctx context.Context, entryIDs []string) (map[string]*models.NutritionScan, error)
+	UpdateEntry(ctx context.Context, entry *models.NutritionalInfo, shares []models.ConsumptionShare, confirmed *models.ModelOutput) (*models.PantryItem, error)
+	UpdateScanConfirmation(ctx context.Context, entryID string, confirmed *models.ModelOutput) error
+	ListConfirmedScans(ctx context.Context, householdID string, from, to time.Time) ([]*models.NutritionScan, error)
+	HouseholdHasImage(ctx context.Context, householdID, key string) (bool, error)
 	UpdateScanStatus(ctx context.Context, id, status string, errMsg string) error
 	GetRecentNutritionalInfo(ctx context.Context, limit int) ([]*models.NutritionalInfo, error)
+	ListNutritionalInfo(ctx context.Context, query HistoryQuery) ([]*models.NutritionalInfo, error)
+	SumNutritionalInfo(ctx context.Context, householdID string, periods []Period) ([]*models.NutritionTotals, error)
+	DeleteNutritionalInfo(ctx context.Context, id string) error
+	RestoreNutritionalInfo(ctx context.Context, id string) error
+
+	// Pantry
+	AddToPantry(ctx context.Context, entry *models.NutritionalInfo) (*models.PantryItem, error)
+	GetPantryItem(ctx context.Context, id string) (*models.PantryItem, error)
+	ListPantryItems(ctx context.Context, householdID string, includeEmpty bool) ([]*models.PantryItem, error)
+	LogPantryEvent(ctx context.Context, event *models.PantryEvent) (*models.PantryItem, error)
+	SumPantryActivity(ctx context.Context, householdID string, periods []Period) ([]*models.PantryActivity, error)
+
+	// Users and households
+	EnsureDefaultUser(ctx context.Context) (*models.User, error)
+	GetUser(ctx context.Context, id string) (*models.User, error)
+	CreateUser(ctx context.Context, user *models.User) error
+	ListUsers(ctx context.Context, householdID string) ([]*models.User, error)
+	GetHousehold(ctx context.Context, id string) (*models.Household, error)
+	SetTimezone(ctx context.Context, userID, timezone string) error
+	SetConsumptionShares(ctx context.Context, entryID string, shares []models.ConsumptionShare) error
+	SumUserConsumption(ctx context.Context, userID string, periods []Period) ([]*models.NutritionTotals, error)
+
+	// Goals
+	GetGoals(ctx context.Context, userID string) (*models.Goals, error)
+	SaveGoals(ctx context.Context, goals *models.Goals) error
+	DeleteGoals(ctx context.Context, userID string) error
+
+	// Body profiles
+	GetBodyProfile(ctx context.Context, userID string) (*models.BodyProfile, error)
+	SaveBodyProfile(ctx context.Context, profile *models.BodyProfile) error
+	AddBodyMetric(ctx context.Context, metric *models.BodyMetric) error
+	ListBodyMetrics(ctx context.Context, userID string, from, to time.Time) ([]*models.BodyMetric, error)
+	DeleteBodyMetric(ctx context.Context, userID, id string) error
+
+	// Recipes
+	SaveRecipe(ctx context.Context, recipe *models.Recipe) error
+	GetRecipe(ctx context.Context, id string) (*models.Recipe, error)
+	ListRecipes(ctx context.Context, householdID string) ([]*models.Recipe, error)
+	DeleteRecipe(ctx context.Context, householdID, id string) error
+	LogRecipe(ctx context.Context, entry *models.NutritionalInfo, shares []models.ConsumptionShare, cooked []*models.PantryEvent) ([]*models.PantryItem, error)
+
+	// Authentication
+	SetCredentials(ctx context.Context, userID, login, passwordHash string) error
+	GetCredentials(ctx context.Context, login string) (*models.User, string, error)
+	SaveToken(ctx context.Context, token *models.AuthToken) error
+	GetTokenByHash(ctx context.Context, hash string) (*models.AuthToken, error)
+	ListTokens(ctx context.Context, userID string) ([]*models.AuthToken, error)
+	DeleteToken(ctx context.Context, userID, id string) error
+
 	Close() error
 }
 
-// SQLiteDB implements the DB interface
-type SQLiteDB struct {
-	db *sql.DB
+// SQLDB implements the DB interface on SQLite or PostgreSQL
+type SQLDB struct {
+	db *sqlConn
+}
+
+// Open opens the configured database without touching its schema
+func Open(cfg config.DatabaseConfig) (*SQLDB, error) {
+	switch cfg.Type {
+	case "sqlite":
+		return openSQLite(cfg.Path)
+	case "postgres":
+		return openPostgres(cfg.URL)
+	default:
+		return nil, fmt.Errorf("unknown database type %q", cfg.Type)
+	}
+}
+
+// New opens the configured database and migrates its schema to the latest version
+func New(cfg config.DatabaseConfig) (*SQLDB, error) {
+	s, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := NewMigrator(s)
+	if err == nil {
+		_, err = migrator.Up(context.Background(), migrator.Latest())
+	}
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("error migrating database: %w", err)
+	}
+	log.Println("Database schema is up to date")
+
+	return s, nil
+}
+
+// NewSQLiteDB opens a SQLite database and migrates its schema to the latest version
+func NewSQLiteDB(dbPath string) (*SQLDB, error) {
+	return New(config.DatabaseConfig{Type: "sqlite", Path: dbPath})
+}
+
+// NewPostgresDB connects to a PostgreSQL database and migrates its schema to
+// the latest version
+func NewPostgresDB(url string) (*SQLDB, error) {
+	return New(config.DatabaseConfig{Type: "postgres", URL: url})
 }
 
-// NewSQLiteDB creates a new SQLite database connection
-func NewSQLiteDB(dbPath string) (*SQLiteDB, error) {
-	db, err := sql.Open("sqlite", dbPath)
+// openSQLite opens a SQLite database file
+func openSQLite(dbPath string) (*SQLDB, error) {
+	db, err := sql.Open(sqlite.driver, dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("error opening database: %w", err)
 	}
 
 	// Enable foreign keys and WAL mode for better concurrency
 	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("error enabling foreign keys: %w", err)
 	}
 	if _, err := db.Exec("PRAGMA journal_mode = WAL"); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("error enabling WAL mode: %w", err)
 	}
+	return &SQLDB{db: &sqlConn{DB: db, dialect: sqlite}}, nil
+}
 
-	// Initialize database schema
-	if err := initializeSchema(db); err != nil {
-		return nil, fmt.Errorf("error initializing schema: %w", err)
+// openPostgres connects to a PostgreSQL database
+func openPostgres(url string) (*SQLDB, error) {
+	if url == "" {
+		return nil, fmt.Errorf("no PostgreSQL connection URL configured")
+	}
+	db, err := sql.Open(postgres.driver, url)
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error connecting to database: %w", err)
 	}
+	return &SQLDB{db: &sqlConn{DB: db, dialect: postgres}}, nil
+}
+
+// timestampLayout is how timestamps are stored: in UTC, with every digit of
+// the nanoseconds, so that comparing the text compares the times. Arguments
+// of type time.Time are written this way by bindArgs.
+const timestampLayout = "2006-01-02T15:04:05.000000000Z"
 
-	return &SQLiteDB{db: db}, nil
+// timestamp formats a time the way it is stored
+func timestamp(t time.Time) string {
+	return t.UTC().Format(timestampLayout)
 }
 
-func initializeSchema(db *sql.DB) error {
-	// Read schema file
-	schemaBytes, err := schemaFS.ReadFile("schema.sql")
-	if err != nil {
-		return fmt.Errorf("error reading schema file: %w", err)
+// timeLayouts lists the formats timestamps have been written in. Before
+// migration 5 normalized them, the SQLite driver stored time.Time values using
+// their String() representation and the PostgreSQL one its own timestamp
+// format, both in the server's time zone.
+var timeLayouts = []string{
+	timestampLayout,
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	time.RFC3339Nano,
+}
+
+// parseTimestamp parses a timestamp read back from a TEXT column
+func parseTimestamp(value string) (time.Time, error) {
+	// Drop the monotonic clock reading, e.g. " m=+0.001965468"
+	if i := strings.Index(value, " m="); i >= 0 {
+		value = value[:i]
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", value)
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// nutritionalInfoColumns is the column list read by scanNutritionalInfo
+const nutritionalInfoColumns = `id, user_id, total_weight, consumed_weight,
+			calories, protein, carbs, fat, fiber, sugar,
+			image_path, meal, meal_date, recipe_id, eaten_at, created_at, updated_at, deleted_at`
+
+// entryScanner holds the scan destinations for nutritionalInfoColumns, so that
+// queries joining other tables can read an entry as part of their rows
+type entryScanner struct {
+	entry          models.NutritionalInfo
+	userID         sql.NullString
+	consumedWeight sql.NullFloat64
+	imagePath      sql.NullString
+	meal           sql.NullString
+	mealDate       sql.NullString
+	recipeID       sql.NullString
+	eatenAt        sql.NullString
+	deletedAt      sql.NullString
+	createdAt      string
+	updatedAt      string
+}
+
+// dest returns the Scan destinations in nutritionalInfoColumns order
+func (e *entryScanner) dest() []any {
+	return []any{
+		&e.entry.ID, &e.userID, &e.entry.TotalWeight, &e.consumedWeight,
+		&e.entry.Calories, &e.entry.Protein, &e.entry.Carbs, &e.entry.Fat, &e.entry.Fiber,
+		&e.entry.Sugar, &e.imagePath, &e.meal, &e.mealDate, &e.recipeID, &e.eatenAt, &e.createdAt, &e.updatedAt, &e.deletedAt,
 	}
+}
 
-	// Execute schema
-	if _, err := db.Exec(string(schemaBytes)); err != nil {
-		return fmt.Errorf("error executing schema: %w", err)
+// result converts the scanned values into an entry
+func (e *entryScanner) result() (*models.NutritionalInfo, error) {
+	info := e.entry
+	info.UserID = e.userID.String
+	if e.consumedWeight.Valid {
+		consumed := e.consumedWeight.Float64
+		info.ConsumedWeight = &consumed
 	}
+	info.ImagePath = e.imagePath.String
+	info.Meal = e.meal.String
+	info.MealDate = e.mealDate.String
+	info.RecipeID = e.recipeID.String
 
-	log.Println("Database schema initialized successfully")
-	return nil
+	var err error
+	if info.CreatedAt, err = parseTimestamp(e.createdAt); err != nil {
+		return nil, err
+	}
+	if info.UpdatedAt, err = parseTimestamp(e.updatedAt); err != nil {
+		return nil, err
+	}
+	info.EatenAt = info.CreatedAt
+	if e.eatenAt.Valid {
+		if info.EatenAt, err = parseTimestamp(e.eatenAt.String); err != nil {
+			return nil, err
+		}
+	}
+	if e.deletedAt.Valid {
+		t, err := parseTimestamp(e.deletedAt.String)
+		if err != nil {
+			return nil, err
+		}
+		info.DeletedAt = &t
+	}
+	return &info, nil
+}
+
+// scanNutritionalInfo reads a row selected with nutritionalInfoColumns
+func scanNutritionalInfo(row rowScanner) (*models.NutritionalInfo, error) {
+	e := &entryScanner{}
+	if err := row.Scan(e.dest()...); err != nil {
+		return nil, err
+	}
+	return e.result()
 }
 
 // SaveNutritionalInfo saves nutritional information to the database
-func (s *SQLiteDB) SaveNutritionalInfo(ctx context.Context, info *models.NutritionalInfo) error {
+func (s *SQLDB) SaveNutritionalInfo(ctx context.Context, info *models.NutritionalInfo) error {
+	return saveEntry(ctx, s.db, info)
+}
+
+// saveEntry inserts or updates an entry
+func saveEntry(ctx context.Context, q querier, info *models.NutritionalInfo) error {
 	query := `
 		INSERT INTO nutritional_info (
-			id, total_weight, calories, protein, carbs, fat, fiber, sugar,
-			image_path, created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			id, user_id, total_weight, consumed_weight, calories, protein, carbs, fat, fiber, sugar,
+			image_path, meal, meal_date, recipe_id, eaten_at, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
+			user_id = excluded.user_id,
 			total_weight = excluded.total_weight,
+			consumed_weight = excluded.consumed_weight,
 			calories = excluded.calories,
 			protein = excluded.protein,
 			carbs = excluded.carbs,
@@ -85,6 +304,10 @@ func (s *SQLiteDB) SaveNutritionalInfo(ctx context.Context, info *models.Nutriti
 			fiber = excluded.fiber,
 			sugar = excluded.sugar,
 			image_path = excluded.image_path,
+			meal = excluded.meal,
+			meal_date = excluded.meal_date,
+			recipe_id = excluded.recipe_id,
+			eaten_at = excluded.eaten_at,
 			updated_at = excluded.updated_at
 	`
 
@@ -94,28 +317,52 @@ func (s *SQLiteDB) SaveNutritionalInfo(ctx context.Context, info *models.Nutriti
 	}
 	info.UpdatedAt = now
 
-	_, err := s.db.ExecContext(ctx, query,
-		info.ID, info.TotalWeight,
+	eatenAt, err := entryEatenAt(ctx, q, info)
+	if err != nil {
+		return err
+	}
+	info.EatenAt = eatenAt
+
+	_, err = q.ExecContext(ctx, query,
+		info.ID, nullString(info.UserID), info.TotalWeight, info.ConsumedWeight,
 		info.Calories, info.Protein, info.Carbs, info.Fat, info.Fiber,
-		info.Sugar, info.ImagePath, info.CreatedAt, info.UpdatedAt,
+		info.Sugar, info.ImagePath, nullString(info.Meal), nullString(info.MealDate),
+		nullString(info.RecipeID), eatenAt, info.CreatedAt, info.UpdatedAt,
 	)
 	return err
 }
 
-// GetNutritionalInfo retrieves nutritional information from the database
-func (s *SQLiteDB) GetNutritionalInfo(ctx context.Context, id string) (*models.NutritionalInfo, error) {
+// entryEatenAt returns when an entry counts in history. An update keeps when
+// the entry was first logged, so that time is the one looked at.
+func entryEatenAt(ctx context.Context, q queryRower, info *models.NutritionalInfo) (time.Time, error) {
+	loggedAt := info.CreatedAt
+	var stored string
+	err := q.QueryRowContext(ctx, `SELECT created_at FROM nutritional_info WHERE id = ?`, info.ID).Scan(&stored)
+	switch {
+	case err == nil:
+		if loggedAt, err = parseTimestamp(stored); err != nil {
+			return time.Time{}, err
+		}
+	case err != sql.ErrNoRows:
+		return time.Time{}, err
+	}
+
+	loc, err := userLocation(ctx, q, info.UserID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return models.EatenAt(loggedAt, info.MealDate, loc), nil
+}
+
+// GetNutritionalInfo retrieves nutritional information from the database.
+// Soft-deleted entries are returned with DeletedAt set.
+func (s *SQLDB) GetNutritionalInfo(ctx context.Context, id string) (*models.NutritionalInfo, error) {
 	query := `
-		SELECT id, total_weight, calories, protein, carbs, fat, fiber, sugar,
-			image_path, created_at, updated_at
+		SELECT ` + nutritionalInfoColumns + `
 		FROM nutritional_info WHERE id = ?
 	`
 
-	info := &models.NutritionalInfo{}
-	err := s.db.QueryRowContext(ctx, query, id).Scan(
-		&info.ID, &info.TotalWeight,
-		&info.Calories, &info.Protein, &info.Carbs, &info.Fat, &info.Fiber,
-		&info.Sugar, &info.ImagePath, &info.CreatedAt, &info.UpdatedAt,
-	)
+	info, err := scanNutritionalInfo(s.db.QueryRowContext(ctx, query, id))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -126,28 +373,119 @@ func (s *SQLiteDB) GetNutritionalInfo(ctx context.Context, id string) (*models.N
 }
 
 // SaveScan saves a nutrition scan to the database
-func (s *SQLiteDB) SaveScan(ctx context.Context, scan *models.NutritionScan) error {
+func (s *SQLDB) SaveScan(ctx context.Context, scan *models.NutritionScan) error {
+	return saveScan(ctx, s.db, scan)
+}
+
+// saveScan inserts or updates a scan
+func saveScan(ctx context.Context, q querier, scan *models.NutritionScan) error {
 	query := `
-		INSERT OR REPLACE INTO nutrition_scans (
-			id, image_data, status, error, created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO nutrition_scans (
+			id, entry_id, image_path, model, model_output, confirmed_output,
+			status, error, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			entry_id = excluded.entry_id,
+			image_path = excluded.image_path,
+			model = excluded.model,
+			model_output = excluded.model_output,
+			confirmed_output = excluded.confirmed_output,
+			status = excluded.status,
+			error = excluded.error,
+			updated_at = excluded.updated_at
 	`
 
+	output, err := encodeOutput(scan.ModelOutput)
+	if err != nil {
+		return err
+	}
+	confirmed, err := encodeOutput(scan.ConfirmedOutput)
+	if err != nil {
+		return err
+	}
+
 	now := time.Now()
 	if scan.CreatedAt.IsZero() {
 		scan.CreatedAt = now
 	}
 	scan.UpdatedAt = now
 
-	_, err := s.db.ExecContext(ctx, query,
-		scan.ID, scan.ImageData, scan.Status, scan.Error,
+	_, err = q.ExecContext(ctx, query,
+		scan.ID, nullString(scan.EntryID), nullString(scan.ImagePath), nullString(scan.Model), output, confirmed,
+		scan.Status, scan.Error,
 		scan.CreatedAt, scan.UpdatedAt,
 	)
 	return err
 }
 
+// ConfirmScan saves the entry a scan was confirmed as together with the
+// scan, in one transaction so that a failed confirmation leaves nothing
+// behind and can be tried again. With pantry the entry is stocked as a pantry
+// item, which is returned; otherwise it is shared as given.
+func (s *SQLDB) ConfirmScan(ctx context.Context, entry *models.NutritionalInfo, shares []models.ConsumptionShare, pantry bool, scan *models.NutritionScan) (*models.PantryItem, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := saveEntry(ctx, tx, entry); err != nil {
+		return nil, fmt.Errorf("error saving entry: %w", err)
+	}
+	var item *models.PantryItem
+	if pantry {
+		if item, err = insertPantryItem(ctx, tx, entry); err != nil {
+			return nil, err
+		}
+	} else if err := replaceShares(ctx, tx, entry.ID, shares); err != nil {
+		return nil, err
+	}
+	if err := saveScan(ctx, tx, scan); err != nil {
+		return nil, fmt.Errorf("error saving scan: %w", err)
+	}
+	return item, tx.Commit()
+}
+
+// UpdateEntry saves a correction of a confirmed entry in one transaction, so
+// that a failed update leaves the entry as it was and can be tried again.
+// Shares replace who ate the entry and confirmed the values on its scan,
+// unless nil. A pantry item of the entry follows its total weight and is
+// returned when it was resized.
+func (s *SQLDB) UpdateEntry(ctx context.Context, entry *models.NutritionalInfo, shares []models.ConsumptionShare, confirmed *models.ModelOutput) (*models.PantryItem, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := saveEntry(ctx, tx, entry); err != nil {
+		return nil, fmt.Errorf("error saving entry: %w", err)
+	}
+	if shares != nil {
+		if err := replaceShares(ctx, tx, entry.ID, shares); err != nil {
+			return nil, err
+		}
+	}
+	if confirmed != nil {
+		if err := updateScanConfirmation(ctx, tx, entry.ID, confirmed); err != nil {
+			return nil, err
+		}
+	}
+	resized, err := resizePantryItem(ctx, tx, entry.ID, entry.TotalWeight)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if !resized {
+		return nil, nil
+	}
+	return s.GetPantryItem(ctx, entry.ID)
+}
+
 // UpdateScanStatus updates the status of a scan
-func (s *SQLiteDB) UpdateScanStatus(ctx context.Context, id, status string, errMsg string) error {
+func (s *SQLDB) UpdateScanStatus(ctx context.Context, id, status string, errMsg string) error {
 	query := `
 		UPDATE nutrition_scans
 		SET status = ?, error = ?, updated_at = ?
@@ -159,16 +497,18 @@ func (s *SQLiteDB) UpdateScanStatus(ctx context.Context, id, status string, errM
 }
 
 // Close closes the database connection
-func (s *SQLiteDB) Close() error {
+func (s *SQLDB) Close() error {
 	return s.db.Close()
 }
 
-// GetRecentNutritionalInfo retrieves the most recent nutritional info entries
-func (s *SQLiteDB) GetRecentNutritionalInfo(ctx context.Context, limit int) ([]*models.NutritionalInfo, error) {
+// GetRecentNutritionalInfo retrieves the most recent nutritional info entries,
+// skipping soft-deleted ones
+func (s *SQLDB) GetRecentNutritionalInfo(ctx context.Context, limit int) ([]*models.NutritionalInfo, error) {
 	query := `
-		SELECT id, total_weight, calories, protein, carbs, fat, fiber, sugar, image_path, created_at, updated_at
+		SELECT ` + nutritionalInfoColumns + `
 		FROM nutritional_info
-		ORDER BY created_at DESC
+		WHERE deleted_at IS NULL
+		ORDER BY created_at DESC, id DESC
 		LIMIT ?
 	`
 
@@ -180,24 +520,92 @@ func (s *SQLiteDB) GetRecentNutritionalInfo(ctx context.Context, limit int) ([]*
 
 	var results []*models.NutritionalInfo
 	for rows.Next() {
-		var info models.NutritionalInfo
-		var createdAt, updatedAt string
-
-		err := rows.Scan(
-			&info.ID, &info.TotalWeight, &info.Calories, &info.Protein,
-			&info.Carbs, &info.Fat, &info.Fiber, &info.Sugar,
-			&info.ImagePath, &createdAt, &updatedAt,
-		)
+		info, err := scanNutritionalInfo(rows)
 		if err != nil {
 			return nil, err
 		}
+		results = append(results, info)
+	}
+
+	return results, rows.Err()
+}
 
-		// Parse time strings
-		info.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
-		info.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+// DeleteNutritionalInfo soft-deletes an entry so that it can be restored later
+func (s *SQLDB) DeleteNutritionalInfo(ctx context.Context, id string) error {
+	query := `
+		UPDATE nutritional_info
+		SET deleted_at = ?, updated_at = ?
+		WHERE id = ? AND deleted_at IS NULL
+	`
+
+	now := time.Now()
+	return expectOneRow(s.db.ExecContext(ctx, query, now, now, id))
+}
 
-		results = append(results, &info)
+// RestoreNutritionalInfo undoes a previous DeleteNutritionalInfo
+func (s *SQLDB) RestoreNutritionalInfo(ctx context.Context, id string) error {
+	query := `
+		UPDATE nutritional_info
+		SET deleted_at = NULL, updated_at = ?
+		WHERE id = ? AND deleted_at IS NOT NULL
+	`
+
+	return expectOneRow(s.db.ExecContext(ctx, query, time.Now(), id))
+}
+
+// nullString stores empty strings as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullFloat stores nil numbers as NULL
+func nullFloat(f *float64) any {
+	if f == nil {
+		return nil
 	}
+	return *f
+}
 
-	return results, nil
+// floatPtr converts a nullable number column back into a pointer
+func floatPtr(f sql.NullFloat64) *float64 {
+	if !f.Valid {
+		return nil
+	}
+	v := f.Float64
+	return &v
+}
+
+// nullTime stores nil times as NULL
+func nullTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return *t
+}
+
+// parseNullTimestamp parses a nullable timestamp column
+func parseNullTimestamp(value sql.NullString) (*time.Time, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+	t, err := parseTimestamp(value.String)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// expectOneRow turns an UPDATE that matched nothing into ErrNotFound
+func expectOneRow(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
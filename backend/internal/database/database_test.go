@@ -0,0 +1,334 @@
+package database_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/config"
+	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/database/dbtest"
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"github.com/google/uuid"
+)
+
+// postgresURLEnv names a PostgreSQL database the tests may empty. Without it
+// the PostgreSQL tests are skipped.
+const postgresURLEnv = "NUTRITIONAL_TEST_POSTGRES_URL"
+
+// openSQLite returns a migrated database in a new file
+func openSQLite(t *testing.T) *database.SQLDB {
+	db, err := database.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// openPostgres returns the test PostgreSQL database, emptied and migrated
+func openPostgres(t *testing.T) *database.SQLDB {
+	url := os.Getenv(postgresURLEnv)
+	if url == "" {
+		t.Skip(postgresURLEnv + " is not set")
+	}
+	db, err := database.Open(config.DatabaseConfig{Type: "postgres", URL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// Start from an empty schema, which also checks the down migrations
+	migrator, err := database.NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Down(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background(), migrator.Latest()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSQLite(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) database.DB { return openSQLite(t) })
+}
+
+func TestPostgres(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) database.DB { return openPostgres(t) })
+}
+
+func TestMemory(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) database.DB { return database.NewMemoryDB() })
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	for name, open := range map[string]func(*testing.T) *database.SQLDB{
+		"sqlite":   openSQLite,
+		"postgres": openPostgres,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			migrator, err := database.NewMigrator(open(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := migrator.Down(ctx, 0); err != nil {
+				t.Fatal(err)
+			}
+			if v, _ := migrator.Version(ctx); v != 0 {
+				t.Fatalf("version after rolling back everything = %d", v)
+			}
+			if _, err := migrator.Up(ctx, migrator.Latest()); err != nil {
+				t.Fatal(err)
+			}
+			if v, _ := migrator.Version(ctx); v != migrator.Latest() {
+				t.Fatalf("version = %d, want %d", v, migrator.Latest())
+			}
+		})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	entry := &models.NutritionalInfo{ID: "entry", Calories: 120, ImagePath: "photo", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if err := db.SaveNutritionalInfo(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := db.Snapshot(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Snapshot(ctx, path); err == nil {
+		t.Fatal("snapshot over an existing file succeeded")
+	}
+	if keys, err := db.ImageKeys(ctx); err != nil || len(keys) != 1 || keys[0] != "photo" {
+		t.Fatalf("image keys = %v, %v", keys, err)
+	}
+
+	version, err := database.VerifySQLite(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := database.Open(config.DatabaseConfig{Type: "sqlite", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	migrator, err := database.NewMigrator(copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != migrator.Latest() {
+		t.Errorf("snapshot version = %d, want %d", version, migrator.Latest())
+	}
+	if got, err := copied.GetNutritionalInfo(ctx, entry.ID); err != nil || got == nil || got.Calories != entry.Calories {
+		t.Fatalf("entry in snapshot = %+v, %v", got, err)
+	}
+
+	garbage := filepath.Join(t.TempDir(), "garbage.db")
+	if err := os.WriteFile(garbage, []byte("not a database"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.VerifySQLite(ctx, garbage); err == nil {
+		t.Fatal("verifying a file that is not a database succeeded")
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	user, err := db.EnsureDefaultUser(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partner := &models.User{ID: uuid.New().String(), HouseholdID: user.HouseholdID, Name: "Sam", Timezone: "Europe/Paris"}
+	if err := db.CreateUser(ctx, partner); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	none := 0.0
+	entry := &models.NutritionalInfo{
+		ID: uuid.New().String(), UserID: user.ID, TotalWeight: 500, Calories: 364.5, Protein: 10,
+		ConsumedWeight: &none, Meal: "lunch", MealDate: "2024-03-10", ImagePath: "photo", CreatedAt: now,
+	}
+	if err := db.SaveNutritionalInfo(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddToPantry(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LogPantryEvent(ctx, &models.PantryEvent{
+		ID: uuid.New().String(), ItemID: entry.ID, UserID: user.ID, Kind: models.PantryEventEaten, Weight: 100,
+		Shares: []models.ConsumptionShare{{UserID: user.ID, Fraction: 0.5}, {UserID: partner.ID, Fraction: 0.5}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	recipe := &models.Recipe{
+		ID: uuid.New().String(), HouseholdID: user.HouseholdID, Name: "Bread", YieldWeight: 800, Servings: 4,
+		CreatedBy: user.ID, Ingredients: []models.RecipeIngredient{{EntryID: entry.ID, Weight: 300}},
+	}
+	if err := db.SaveRecipe(ctx, recipe); err != nil {
+		t.Fatal(err)
+	}
+	scan := &models.NutritionScan{
+		ID: uuid.New().String(), EntryID: entry.ID, ImagePath: "photo", Model: "fake",
+		ModelOutput: &models.ModelOutput{Calories: 360}, ConfirmedOutput: models.OutputOf(entry), Status: "completed",
+	}
+	if err := db.SaveScan(ctx, scan); err != nil {
+		t.Fatal(err)
+	}
+	calories := 2000.0
+	if err := db.SaveGoals(ctx, &models.Goals{UserID: user.ID, Weekday: models.DailyTarget{Calories: &calories}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveBodyProfile(ctx, &models.BodyProfile{
+		UserID: user.ID, Sex: "female", BirthDate: time.Date(1990, 5, 1, 0, 0, 0, 0, time.UTC),
+		HeightCm: 170, WeightKg: 65, ActivityLevel: "moderate", Plan: "maintain",
+		Formula: "mifflin_st_jeor", AutoGoals: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	weight := 64.8
+	if err := db.AddBodyMetric(ctx, &models.BodyMetric{ID: uuid.New().String(), UserID: user.ID, MeasuredAt: now, WeightKg: &weight}); err != nil {
+		t.Fatal(err)
+	}
+
+	export := func(db *database.SQLDB, householdID string) []byte {
+		t.Helper()
+		e, err := db.Export(ctx, householdID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.ExportedAt = time.Time{}
+		var buf bytes.Buffer
+		if err := e.WriteJSON(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	exported := export(db, user.HouseholdID)
+
+	// An instance that is already set up takes the rows into its own household
+	other := openSQLite(t)
+	home, err := other.EnsureDefaultUser(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := database.ReadExport(bytes.NewReader(exported))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := other.Import(ctx, archive, home.HouseholdID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range imported {
+		if table.Name == "households" {
+			if table.Added != 0 {
+				t.Errorf("import added a household: %+v", table)
+			}
+			continue
+		}
+		if table.Added == 0 || table.Skipped != 0 {
+			t.Errorf("first import of %s: %+v", table.Name, table)
+		}
+	}
+
+	// Every row but the household's comes back, moved to the household
+	// imported into, next to the user that was there
+	want, err := database.ReadExport(bytes.NewReader(bytes.ReplaceAll(exported,
+		[]byte(user.HouseholdID), []byte(home.HouseholdID))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := database.ReadExport(bytes.NewReader(export(other, home.HouseholdID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, table := range got.Tables {
+		wantRows := want.Tables[i].Rows
+		switch table.Name {
+		case "households":
+			continue
+		case "users":
+			wantRows = append(wantRows, []any{home.ID, home.HouseholdID, home.Name, nil, timestamp(home.CreatedAt)})
+		}
+		if !sameRows(table.Rows, wantRows) {
+			t.Errorf("%s after import = %v, want %v", table.Name, table.Rows, wantRows)
+		}
+	}
+
+	// Imported users are older, but the instance keeps its default user
+	if again, err := other.EnsureDefaultUser(ctx); err != nil || again.ID != home.ID {
+		t.Errorf("default user after import = %+v, %v; want %s", again, err, home.ID)
+	}
+
+	// Importing again, or into the original, adds nothing
+	for _, into := range []struct {
+		db        *database.SQLDB
+		household string
+	}{{other, home.HouseholdID}, {db, user.HouseholdID}} {
+		imported, err := into.db.Import(ctx, archive, into.household)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, table := range imported {
+			if table.Added != 0 {
+				t.Errorf("repeated import of %s: %+v", table.Name, table)
+			}
+		}
+	}
+
+	if keys := archive.ImageKeys(); len(keys) != 1 || keys[0] != "photo" {
+		t.Errorf("image keys = %v, want [photo]", keys)
+	}
+
+	var csv bytes.Buffer
+	if err := archive.WriteCSV(&csv); err != nil {
+		t.Fatal(err)
+	}
+	files, err := zip.NewReader(bytes.NewReader(csv.Bytes()), int64(csv.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files.File) != len(archive.Tables) {
+		t.Errorf("CSV export has %d files for %d tables", len(files.File), len(archive.Tables))
+	}
+}
+
+// sameRows reports whether two lists of exported rows hold the same rows, in
+// any order
+func sameRows(got, want [][]any) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	count := make(map[string]int)
+	for _, row := range got {
+		count[fmt.Sprint(row...)]++
+	}
+	for _, row := range want {
+		count[fmt.Sprint(row...)]--
+	}
+	for _, n := range count {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// timestamp formats a time the way exports write it
+func timestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
@@ -0,0 +1,1001 @@
+// Package dbtest checks that an implementation of database.DB behaves the way
+// the server expects. Each implementation runs the same suite from its own
+// tests:
+//
+//	func TestMemory(t *testing.T) {
+//		dbtest.Run(t, func(t *testing.T) database.DB {
+//			return database.NewMemoryDB()
+//		})
+//	}
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata" // the suite uses named time zones
+
+	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"github.com/google/uuid"
+)
+
+// Run runs every check of the suite as a subtest. open returns a fresh, empty
+// database for each; closing it is up to open, with t.Cleanup.
+func Run(t *testing.T, open func(t *testing.T) database.DB) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, db database.DB)
+	}{
+		{"Entries", testEntries},
+		{"EntryUpsert", testEntryUpsert},
+		{"RecentEntries", testRecentEntries},
+		{"HistoryPages", testHistoryPages},
+		{"Sums", testSums},
+		{"Pantry", testPantry},
+		{"ConcurrentPantryEvents", testConcurrentPantryEvents},
+		{"Users", testUsers},
+		{"Timezones", testTimezones},
+		{"CredentialsAndTokens", testCredentialsAndTokens},
+		{"GoalsAndBody", testGoalsAndBody},
+		{"Recipes", testRecipes},
+		{"LogRecipe", testLogRecipe},
+		{"Scans", testScans},
+		{"ConfirmScan", testConfirmScan},
+		{"UpdateEntry", testUpdateEntry},
+		{"ScanStatus", testScanStatus},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, open(t))
+		})
+	}
+}
+
+// testEntry returns an unsaved entry of 100g logged by user at createdAt
+func testEntry(user *models.User, createdAt time.Time, calories float64) *models.NutritionalInfo {
+	return &models.NutritionalInfo{
+		ID:          uuid.New().String(),
+		UserID:      user.ID,
+		TotalWeight: 100,
+		Calories:    calories,
+		Protein:     10,
+		Carbs:       20,
+		Fat:         5,
+		Fiber:       2,
+		Sugar:       8,
+		CreatedAt:   createdAt,
+	}
+}
+
+// testUser returns the default user of a fresh database
+func testUser(t *testing.T, db database.DB) *models.User {
+	t.Helper()
+	user, err := db.EnsureDefaultUser(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// saveEntry saves an entry or fails the test
+func saveEntry(t *testing.T, db database.DB, entry *models.NutritionalInfo) {
+	t.Helper()
+	if err := db.SaveNutritionalInfo(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testEntries(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+	createdAt := time.Date(2024, 3, 10, 8, 30, 0, 0, time.Local)
+
+	if got, err := db.GetNutritionalInfo(ctx, "missing"); got != nil || err != nil {
+		t.Fatalf("GetNutritionalInfo(missing) = %v, %v; want nil, nil", got, err)
+	}
+
+	entry := testEntry(user, createdAt, 250)
+	entry.Meal = "breakfast"
+	entry.MealDate = "2024-03-10"
+	if err := db.SaveNutritionalInfo(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving again updates the values but keeps when it was logged
+	entry.Calories = 300
+	entry.CreatedAt = createdAt.Add(time.Hour)
+	consumed := 40.0
+	entry.ConsumedWeight = &consumed
+	if err := db.SaveNutritionalInfo(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetNutritionalInfo(ctx, entry.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Calories != 300 || got.UserID != user.ID || got.Meal != "breakfast" || got.MealDate != "2024-03-10" {
+		t.Errorf("entry read back as %+v", got)
+	}
+	if got.ConsumedWeight == nil || *got.ConsumedWeight != 40 {
+		t.Errorf("consumed weight = %v, want 40", got.ConsumedWeight)
+	}
+	if !got.CreatedAt.Equal(createdAt) {
+		t.Errorf("created at %v, want %v", got.CreatedAt, createdAt)
+	}
+
+	if err := db.DeleteNutritionalInfo(ctx, entry.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteNutritionalInfo(ctx, entry.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("deleting twice: %v, want ErrNotFound", err)
+	}
+	if got, _ := db.GetNutritionalInfo(ctx, entry.ID); got == nil || got.DeletedAt == nil {
+		t.Errorf("deleted entry read back as %+v, want DeletedAt set", got)
+	}
+	if recent, _ := db.GetRecentNutritionalInfo(ctx, 10); len(recent) != 0 {
+		t.Errorf("recent entries include a deleted one")
+	}
+
+	if err := db.RestoreNutritionalInfo(ctx, entry.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RestoreNutritionalInfo(ctx, entry.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("restoring twice: %v, want ErrNotFound", err)
+	}
+}
+
+func testHistoryPages(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+	start := time.Date(2024, 3, 10, 0, 0, 0, 0, time.Local)
+
+	var ids []string
+	for i := 0; i < 5; i++ {
+		entry := testEntry(user, start.Add(time.Duration(i)*time.Hour), 100)
+		if err := db.SaveNutritionalInfo(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, entry.ID)
+	}
+	// Outside the range
+	if err := db.SaveNutritionalInfo(ctx, testEntry(user, start.AddDate(0, 0, -1), 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries given another diary day are listed on that day, at noon
+	moved := testEntry(user, start.Add(-2*time.Hour), 100)
+	moved.MealDate = start.Format(models.MealDateLayout)
+	away := testEntry(user, start.Add(6*time.Hour), 100)
+	away.MealDate = start.AddDate(0, 0, -1).Format(models.MealDateLayout)
+	for _, entry := range []*models.NutritionalInfo{moved, away} {
+		if err := db.SaveNutritionalInfo(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if noon := start.Add(12 * time.Hour); !moved.EatenAt.Equal(noon) {
+		t.Errorf("entry given the next day is eaten at %v, want %v", moved.EatenAt, noon)
+	}
+	ids = append(ids, moved.ID)
+
+	q := database.HistoryQuery{From: start, To: start.AddDate(0, 0, 1), Limit: 2, HouseholdID: user.HouseholdID}
+	var got []string
+	for {
+		page, err := db.ListNutritionalInfo(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range page {
+			got = append(got, entry.ID)
+		}
+		if len(page) < q.Limit {
+			break
+		}
+		last := page[len(page)-1]
+		q.Cursor = &database.HistoryCursor{EatenAt: last.EatenAt, ID: last.ID}
+	}
+
+	if len(got) != len(ids) {
+		t.Fatalf("paged through %d entries, want %d", len(got), len(ids))
+	}
+	for i, id := range got {
+		if want := ids[len(ids)-1-i]; id != want {
+			t.Errorf("entry %d is %s, want %s (newest first)", i, id, want)
+		}
+	}
+}
+
+func testSums(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.Local)
+
+	// 200g at 150 kcal per 100g
+	entry := testEntry(user, day.Add(9*time.Hour), 150)
+	entry.TotalWeight = 200
+	if err := db.SaveNutritionalInfo(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetConsumptionShares(ctx, entry.ID, []models.ConsumptionShare{{UserID: user.ID, Fraction: 0.5}}); err != nil {
+		t.Fatal(err)
+	}
+
+	periods := []database.Period{
+		{Start: day, End: day.AddDate(0, 0, 1)},
+		{Start: day.AddDate(0, 0, 1), End: day.AddDate(0, 0, 2)},
+	}
+	totals, err := db.SumNutritionalInfo(ctx, user.HouseholdID, periods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 2 {
+		t.Fatalf("got %d periods, want 2", len(totals))
+	}
+	if totals[0].Calories != 300 || totals[0].Entries != 1 || totals[1].Entries != 0 {
+		t.Errorf("household totals: %+v, %+v", totals[0], totals[1])
+	}
+
+	mine, err := db.SumUserConsumption(ctx, user.ID, periods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mine[0].Calories != 150 || mine[0].Weight != 100 {
+		t.Errorf("half of the entry counted as %.0f kcal, %.0fg", mine[0].Calories, mine[0].Weight)
+	}
+}
+
+func testPantry(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+
+	// Bought, none of it eaten yet
+	entry := testEntry(user, time.Now(), 100)
+	entry.TotalWeight = 500
+	none := 0.0
+	entry.ConsumedWeight = &none
+	if err := db.SaveNutritionalInfo(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddToPantry(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	eat := func(kind string, grams float64) (*models.PantryItem, error) {
+		return db.LogPantryEvent(ctx, &models.PantryEvent{
+			ID:     uuid.New().String(),
+			ItemID: entry.ID,
+			UserID: user.ID,
+			Kind:   kind,
+			Weight: grams,
+			Shares: []models.ConsumptionShare{{UserID: user.ID, Fraction: 1}},
+		})
+	}
+
+	item, err := eat(models.PantryEventEaten, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.RemainingWeight != 300 || item.Status != models.PantryInStock {
+		t.Errorf("after eating 200g: %.0fg left, %s", item.RemainingWeight, item.Status)
+	}
+	if item.Entry.ConsumedWeight == nil || *item.Entry.ConsumedWeight != 200 {
+		t.Errorf("consumed weight = %v, want 200", item.Entry.ConsumedWeight)
+	}
+
+	if _, err := eat(models.PantryEventEaten, 301); !errors.Is(err, database.ErrNotEnoughStock) {
+		t.Errorf("eating more than left: %v, want ErrNotEnoughStock", err)
+	}
+	if item, err = eat(models.PantryEventDiscarded, 300); err != nil {
+		t.Fatal(err)
+	}
+	if item.Status != models.PantryDiscarded {
+		t.Errorf("status = %s, want discarded", item.Status)
+	}
+	if _, err := eat(models.PantryEventEaten, 1); !errors.Is(err, database.ErrNotInStock) {
+		t.Errorf("eating from a discarded item: %v, want ErrNotInStock", err)
+	}
+
+	inStock, err := db.ListPantryItems(ctx, user.HouseholdID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := db.ListPantryItems(ctx, user.HouseholdID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inStock) != 0 || len(all) != 1 {
+		t.Errorf("listed %d items in stock and %d in all, want 0 and 1", len(inStock), len(all))
+	}
+}
+
+// testConcurrentPantryEvents checks that events logged at the same time are
+// all taken out of the item. A database may refuse some of them, but none
+// may be lost.
+func testConcurrentPantryEvents(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+	entry := testEntry(user, time.Now(), 100)
+	none := 0.0
+	entry.ConsumedWeight = &none
+	saveEntry(t, db, entry)
+	if _, err := db.AddToPantry(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	const events = 10
+	errs := make(chan error, events)
+	for i := 0; i < events; i++ {
+		go func() {
+			_, err := db.LogPantryEvent(ctx, &models.PantryEvent{
+				ID: uuid.New().String(), ItemID: entry.ID, UserID: user.ID,
+				Kind: models.PantryEventDiscarded, Weight: 5,
+			})
+			errs <- err
+		}()
+	}
+	logged := 0
+	for i := 0; i < events; i++ {
+		if err := <-errs; err == nil {
+			logged++
+		}
+	}
+	if logged == 0 {
+		t.Fatal("no event was logged")
+	}
+
+	item, err := db.GetPantryItem(ctx, entry.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 100 - 5*float64(logged); item.RemainingWeight != want {
+		t.Errorf("%d events of 5g left %vg, want %vg", logged, item.RemainingWeight, want)
+	}
+}
+
+func testUsers(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	first := testUser(t, db)
+	if again := testUser(t, db); again.ID != first.ID {
+		t.Errorf("EnsureDefaultUser created a second user")
+	}
+
+	second := &models.User{ID: uuid.New().String(), HouseholdID: first.HouseholdID, Name: "Sam"}
+	if err := db.CreateUser(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	users, err := db.ListUsers(ctx, first.HouseholdID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].ID != first.ID || users[1].ID != second.ID {
+		t.Errorf("household members: %+v", users)
+	}
+
+	household, err := db.GetHousehold(ctx, first.HouseholdID)
+	if err != nil || household == nil {
+		t.Fatalf("GetHousehold = %v, %v", household, err)
+	}
+	if got, err := db.GetUser(ctx, "missing"); got != nil || err != nil {
+		t.Errorf("GetUser(missing) = %v, %v; want nil, nil", got, err)
+	}
+}
+
+func testCredentialsAndTokens(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+	other := &models.User{ID: uuid.New().String(), HouseholdID: user.HouseholdID, Name: "Sam"}
+	if err := db.CreateUser(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.SetCredentials(ctx, user.ID, "alex", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetCredentials(ctx, other.ID, "alex", "hash"); !errors.Is(err, database.ErrLoginTaken) {
+		t.Errorf("taking another user's login: %v, want ErrLoginTaken", err)
+	}
+	got, hash, err := db.GetCredentials(ctx, "alex")
+	if err != nil || got == nil || got.ID != user.ID || hash != "hash" {
+		t.Errorf("GetCredentials = %v, %q, %v", got, hash, err)
+	}
+
+	expires := time.Now().Add(time.Hour)
+	token := &models.AuthToken{
+		ID: uuid.New().String(), UserID: user.ID, Kind: models.TokenSession,
+		Name: "phone", Hash: "secret-hash", CreatedAt: time.Now(), ExpiresAt: &expires,
+	}
+	if err := db.SaveToken(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	found, err := db.GetTokenByHash(ctx, "secret-hash")
+	if err != nil || found == nil {
+		t.Fatalf("GetTokenByHash = %v, %v", found, err)
+	}
+	if found.LastUsedAt == nil || found.ExpiresAt == nil || !found.ExpiresAt.Equal(expires) {
+		t.Errorf("token read back as %+v", found)
+	}
+	if err := db.DeleteToken(ctx, other.ID, token.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("deleting another user's token: %v, want ErrNotFound", err)
+	}
+	if err := db.DeleteToken(ctx, user.ID, token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, _ := db.ListTokens(ctx, user.ID); len(tokens) != 0 {
+		t.Errorf("%d tokens left after deleting the only one", len(tokens))
+	}
+}
+
+func testGoalsAndBody(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+
+	calories, weekend := 2000.0, 2400.0
+	goals := &models.Goals{
+		UserID:  user.ID,
+		Weekday: models.DailyTarget{Calories: &calories},
+		Weekend: &models.DailyTarget{Calories: &weekend},
+	}
+	if err := db.SaveGoals(ctx, goals); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.GetGoals(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || *got.Weekday.Calories != 2000 || got.Weekend == nil || *got.Weekend.Calories != 2400 {
+		t.Errorf("goals read back as %+v", got)
+	}
+	if err := db.DeleteGoals(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetGoals(ctx, user.ID); got != nil {
+		t.Errorf("goals left after deleting them")
+	}
+
+	profile := &models.BodyProfile{
+		UserID: user.ID, Sex: "female", BirthDate: time.Date(1990, 5, 1, 0, 0, 0, 0, time.UTC),
+		HeightCm: 170, WeightKg: 65, ActivityLevel: "moderate", Plan: "maintain",
+		Formula: "mifflin_st_jeor", AutoGoals: true,
+	}
+	if err := db.SaveBodyProfile(ctx, profile); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.GetBodyProfile(ctx, user.ID); err != nil || got == nil || !got.AutoGoals || got.HeightCm != 170 {
+		t.Errorf("GetBodyProfile = %+v, %v", got, err)
+	}
+
+	day := time.Date(2024, 3, 10, 7, 0, 0, 0, time.Local)
+	for i := 0; i < 3; i++ {
+		weight := 65.0 - float64(i)
+		metric := &models.BodyMetric{ID: uuid.New().String(), UserID: user.ID, MeasuredAt: day.AddDate(0, 0, i), WeightKg: &weight}
+		if err := db.AddBodyMetric(ctx, metric); err != nil {
+			t.Fatal(err)
+		}
+	}
+	metrics, err := db.ListBodyMetrics(ctx, user.ID, day, day.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 2 || *metrics[0].WeightKg != 65 || metrics[0].WaistCm != nil {
+		t.Errorf("metrics in range: %+v", metrics)
+	}
+}
+
+func testRecipes(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+	flour := testEntry(user, time.Now(), 364)
+	if err := db.SaveNutritionalInfo(ctx, flour); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"pancakes", "Bread"} {
+		recipe := &models.Recipe{
+			ID: uuid.New().String(), HouseholdID: user.HouseholdID, Name: name,
+			YieldWeight: 800, Servings: 4, CreatedBy: user.ID,
+			Ingredients: []models.RecipeIngredient{{EntryID: flour.ID, Weight: 500}},
+		}
+		if err := db.SaveRecipe(ctx, recipe); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recipes, err := db.ListRecipes(ctx, user.HouseholdID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recipes) != 2 || recipes[0].Name != "Bread" || recipes[1].Name != "pancakes" {
+		t.Fatalf("recipes not sorted by name regardless of case: %+v", recipes)
+	}
+	if len(recipes[0].Ingredients) != 1 || recipes[0].Ingredients[0].Entry.Calories != 364 {
+		t.Errorf("ingredients read back as %+v", recipes[0].Ingredients)
+	}
+
+	if err := db.DeleteRecipe(ctx, user.HouseholdID, recipes[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetRecipe(ctx, recipes[0].ID); got == nil || got.DeletedAt == nil {
+		t.Errorf("deleted recipe read back as %+v", got)
+	}
+	if recipes, _ := db.ListRecipes(ctx, user.HouseholdID); len(recipes) != 1 {
+		t.Errorf("%d recipes listed after deleting one of two", len(recipes))
+	}
+}
+
+func testLogRecipe(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+
+	flour := testEntry(user, time.Now(), 364)
+	none := 0.0
+	flour.ConsumedWeight = &none
+	saveEntry(t, db, flour)
+	if _, err := db.AddToPantry(ctx, flour); err != nil {
+		t.Fatal(err)
+	}
+
+	cook := func(itemID string, grams float64) *models.PantryEvent {
+		return &models.PantryEvent{
+			ID: uuid.New().String(), ItemID: itemID, UserID: user.ID,
+			Kind: models.PantryEventCooked, Weight: grams,
+		}
+	}
+	// 60g then 50g of the 100g of flour, and something never stocked
+	cooked := []*models.PantryEvent{cook(flour.ID, 60), cook(flour.ID, 50), cook("not-stocked", 30)}
+	bread := testEntry(user, time.Now(), 250)
+	items, err := db.LogRecipe(ctx, bread, []models.ConsumptionShare{{UserID: user.ID, Fraction: 1}}, cooked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []float64{60, 40, 0} {
+		if cooked[i].Weight != want {
+			t.Errorf("ingredient %d took %vg, want %vg", i+1, cooked[i].Weight, want)
+		}
+	}
+	if len(items) != 2 {
+		t.Fatalf("LogRecipe returned %d items, want 2", len(items))
+	}
+	if last := items[1]; last.RemainingWeight != 0 || last.Status != models.PantryFinished {
+		t.Errorf("flour after cooking: %+v", last)
+	}
+	if got, err := db.GetNutritionalInfo(ctx, bread.ID); err != nil || got == nil {
+		t.Errorf("recipe entry = %+v, %v", got, err)
+	}
+
+	// Nothing is taken once the flour is used up
+	cooked = []*models.PantryEvent{cook(flour.ID, 10)}
+	if _, err := db.LogRecipe(ctx, testEntry(user, time.Now(), 100), nil, cooked); err != nil {
+		t.Fatal(err)
+	}
+	if cooked[0].Weight != 0 {
+		t.Errorf("took %vg of finished flour", cooked[0].Weight)
+	}
+}
+
+func testScans(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+	entry := testEntry(user, time.Now(), 379)
+	if err := db.SaveNutritionalInfo(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	proposed := &models.ModelOutput{Calories: 370, Protein: 10, Carbs: 20, Fat: 5, Fiber: 2, Sugar: 8}
+	scan := &models.NutritionScan{
+		ID: uuid.New().String(), EntryID: entry.ID, ImagePath: "key", Model: "fake",
+		ModelOutput: proposed, ConfirmedOutput: models.OutputOf(entry), Status: "completed",
+	}
+	if err := db.SaveScan(ctx, scan); err != nil {
+		t.Fatal(err)
+	}
+	createdAt := scan.CreatedAt
+
+	// Saving again replaces the scan but keeps when it was made
+	scan.Model = "fake-v2"
+	scan.CreatedAt = createdAt.Add(time.Hour)
+	if err := db.SaveScan(ctx, scan); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateScanStatus(ctx, scan.ID, "failed", "timeout"); err != nil {
+		t.Fatal(err)
+	}
+
+	scans, err := db.GetScansByEntry(ctx, []string{entry.ID, "typed-in"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := scans[entry.ID]
+	if len(scans) != 1 || got == nil {
+		t.Fatalf("scans by entry: %+v", scans)
+	}
+	if got.Status != "failed" || got.Error != "timeout" || got.Model != "fake-v2" || *got.ModelOutput != *proposed {
+		t.Errorf("scan read back as %+v", got)
+	}
+	if !got.CreatedAt.Equal(createdAt) {
+		t.Errorf("created at %v after saving again, want %v", got.CreatedAt, createdAt)
+	}
+
+	corrected := &models.ModelOutput{Calories: 380, Protein: 10, Carbs: 20, Fat: 5, Fiber: 2, Sugar: 8}
+	if err := db.UpdateScanConfirmation(ctx, entry.ID, corrected); err != nil {
+		t.Fatal(err)
+	}
+	confirmed, err := db.ListConfirmedScans(ctx, user.HouseholdID, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(confirmed) != 1 || *confirmed[0].ConfirmedOutput != *corrected {
+		t.Errorf("confirmed scans: %+v", confirmed)
+	}
+
+	// Only the household whose scan refers to a photo may see it
+	for _, tt := range []struct {
+		household, key string
+		want           bool
+	}{
+		{user.HouseholdID, "key", true},
+		{user.HouseholdID, "other-key", false},
+		{"other-household", "key", false},
+	} {
+		if got, err := db.HouseholdHasImage(ctx, tt.household, tt.key); err != nil || got != tt.want {
+			t.Errorf("HouseholdHasImage(%s, %s) = %v, %v; want %v", tt.household, tt.key, got, err, tt.want)
+		}
+	}
+}
+
+func testConfirmScan(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+	newScan := func(entry *models.NutritionalInfo) *models.NutritionScan {
+		return &models.NutritionScan{
+			ID: uuid.New().String(), EntryID: entry.ID, ImagePath: "key", Model: "fake",
+			ConfirmedOutput: models.OutputOf(entry), Status: "completed",
+		}
+	}
+
+	// Eaten straight away: shared as given, and confirming again replaces it
+	eaten := testEntry(user, time.Now(), 200)
+	shares := []models.ConsumptionShare{{UserID: user.ID, Fraction: 1}}
+	for i := 0; i < 2; i++ {
+		item, err := db.ConfirmScan(ctx, eaten, shares, false, newScan(eaten))
+		if err != nil || item != nil {
+			t.Fatalf("confirming eaten entry, attempt %d: %v, %v", i+1, item, err)
+		}
+	}
+	day := database.Period{Start: time.Now().Add(-time.Hour), End: time.Now().Add(time.Hour)}
+	totals, err := db.SumUserConsumption(ctx, user.ID, []database.Period{day})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals[0].Calories != 200 {
+		t.Errorf("user ate %v kcal, want 200", totals[0].Calories)
+	}
+
+	// Stocked: the item is created from the entry
+	nothing := 0.0
+	stocked := testEntry(user, time.Now(), 300)
+	stocked.ConsumedWeight = &nothing
+	first := newScan(stocked)
+	item, err := db.ConfirmScan(ctx, stocked, nil, true, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item == nil || item.ID != stocked.ID || item.RemainingWeight != 100 {
+		t.Fatalf("pantry item: %+v", item)
+	}
+
+	// A failed confirmation leaves neither the entry nor the scan changed
+	retry := *stocked
+	retry.Calories = 999
+	if _, err := db.ConfirmScan(ctx, &retry, nil, true, newScan(&retry)); err == nil {
+		t.Fatal("stocking an entry twice succeeded")
+	}
+	got, err := db.GetNutritionalInfo(ctx, stocked.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Calories != 300 {
+		t.Errorf("failed confirmation saved %v kcal", got.Calories)
+	}
+	scans, err := db.GetScansByEntry(ctx, []string{stocked.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scans[stocked.ID] == nil || scans[stocked.ID].ID != first.ID {
+		t.Errorf("scan after failed confirmation: %+v", scans[stocked.ID])
+	}
+}
+
+func testUpdateEntry(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+	day := []database.Period{{Start: time.Now().Add(-time.Hour), End: time.Now().Add(time.Hour)}}
+	ate := func() float64 {
+		t.Helper()
+		totals, err := db.SumUserConsumption(ctx, user.ID, day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return totals[0].Calories
+	}
+
+	entry := testEntry(user, time.Now(), 200)
+	scan := &models.NutritionScan{
+		ID: uuid.New().String(), EntryID: entry.ID, ImagePath: "key", Model: "fake",
+		ConfirmedOutput: models.OutputOf(entry), Status: "completed",
+	}
+	half := []models.ConsumptionShare{{UserID: user.ID, Fraction: 0.5}}
+	if _, err := db.ConfirmScan(ctx, entry, half, false, scan); err != nil {
+		t.Fatal(err)
+	}
+
+	corrected := *entry
+	corrected.Calories = 300
+	all := []models.ConsumptionShare{{UserID: user.ID, Fraction: 1}}
+	if item, err := db.UpdateEntry(ctx, &corrected, all, models.OutputOf(&corrected)); err != nil || item != nil {
+		t.Fatalf("UpdateEntry = %v, %v", item, err)
+	}
+	if got := ate(); got != 300 {
+		t.Errorf("user ate %v kcal after the update, want 300", got)
+	}
+
+	// A failed update leaves the entry, its shares and its scan as they were
+	failed := corrected
+	failed.Calories = 999
+	unknown := []models.ConsumptionShare{{UserID: "unknown", Fraction: 1}}
+	if _, err := db.UpdateEntry(ctx, &failed, unknown, models.OutputOf(&failed)); err == nil {
+		t.Fatal("sharing with an unknown user succeeded")
+	}
+	if got, err := db.GetNutritionalInfo(ctx, entry.ID); err != nil || got.Calories != 300 {
+		t.Errorf("entry after a failed update: %+v, %v", got, err)
+	}
+	if got := ate(); got != 300 {
+		t.Errorf("user ate %v kcal after a failed update, want 300", got)
+	}
+	scans, err := db.GetScansByEntry(ctx, []string{entry.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scans[entry.ID]; got == nil || got.ConfirmedOutput.Calories != 300 {
+		t.Errorf("scan after a failed update: %+v", got)
+	}
+
+	// Nil shares leave who ate it alone
+	corrected.Calories = 400
+	if _, err := db.UpdateEntry(ctx, &corrected, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := ate(); got != 400 {
+		t.Errorf("user ate %v kcal after updating without shares, want 400", got)
+	}
+
+	// The pantry item of an entry follows its weight
+	nothing := 0.0
+	stocked := testEntry(user, time.Now(), 100)
+	stocked.ConsumedWeight = &nothing
+	saveEntry(t, db, stocked)
+	if _, err := db.AddToPantry(ctx, stocked); err != nil {
+		t.Fatal(err)
+	}
+	if item, err := db.UpdateEntry(ctx, stocked, nil, nil); err != nil || item != nil {
+		t.Errorf("updating without changing the weight: %v, %v", item, err)
+	}
+	stocked.TotalWeight = 150
+	item, err := db.UpdateEntry(ctx, stocked, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item == nil || item.InitialWeight != 150 || item.RemainingWeight != 150 {
+		t.Errorf("pantry item after the update: %+v", item)
+	}
+
+	// Shrunk below what was already taken out, it is finished
+	if _, err := db.LogPantryEvent(ctx, &models.PantryEvent{
+		ID: uuid.New().String(), ItemID: stocked.ID, UserID: user.ID,
+		Kind: models.PantryEventEaten, Weight: 120, Shares: all,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	eaten, err := db.GetNutritionalInfo(ctx, stocked.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eaten.TotalWeight = 100
+	if item, err = db.UpdateEntry(ctx, eaten, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if item == nil || item.RemainingWeight != 0 || item.Status != models.PantryFinished {
+		t.Errorf("pantry item shrunk below what was eaten: %+v", item)
+	}
+	if items, _ := db.ListPantryItems(ctx, user.HouseholdID, false); len(items) != 0 {
+		t.Errorf("%d items in stock after shrinking one below what was eaten", len(items))
+	}
+	if _, err := db.LogPantryEvent(ctx, &models.PantryEvent{
+		ID: uuid.New().String(), ItemID: stocked.ID, UserID: user.ID,
+		Kind: models.PantryEventEaten, Weight: 1, Shares: all,
+	}); !errors.Is(err, database.ErrNotInStock) {
+		t.Errorf("eating from the shrunk item: %v, want ErrNotInStock", err)
+	}
+}
+
+func testEntryUpsert(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+	createdAt := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+
+	entry := testEntry(user, createdAt, 100)
+	saveEntry(t, db, entry)
+	if err := db.DeleteNutritionalInfo(ctx, entry.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving a deleted entry again, as an edit from a stale screen would,
+	// neither restores it nor adds a second one
+	edited := testEntry(user, time.Time{}, 120)
+	edited.ID = entry.ID
+	edited.TotalWeight = 250
+	saveEntry(t, db, edited)
+
+	got, err := db.GetNutritionalInfo(ctx, entry.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Calories != 120 || got.TotalWeight != 250 {
+		t.Errorf("upserted entry read back as %+v", got)
+	}
+	if got.DeletedAt == nil {
+		t.Errorf("saving a deleted entry restored it")
+	}
+	if !got.CreatedAt.Equal(createdAt) {
+		t.Errorf("created at %v after saving again, want %v", got.CreatedAt, createdAt)
+	}
+
+	if err := db.RestoreNutritionalInfo(ctx, entry.ID); err != nil {
+		t.Fatal(err)
+	}
+	if recent, _ := db.GetRecentNutritionalInfo(ctx, 10); len(recent) != 1 {
+		t.Errorf("%d recent entries after saving one entry twice, want 1", len(recent))
+	}
+}
+
+func testRecentEntries(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+	start := time.Date(2024, 3, 10, 8, 0, 0, 0, time.Local)
+
+	if recent, err := db.GetRecentNutritionalInfo(ctx, 10); err != nil || len(recent) != 0 {
+		t.Fatalf("recent entries of an empty database: %v, %v", recent, err)
+	}
+
+	// Saved out of order, and two at the same time, ordered by ID
+	var entries []*models.NutritionalInfo
+	for _, hours := range []int{2, 0, 3, 1, 3} {
+		entry := testEntry(user, start.Add(time.Duration(hours)*time.Hour), 100)
+		saveEntry(t, db, entry)
+		entries = append(entries, entry)
+	}
+	deleted := testEntry(user, start.Add(4*time.Hour), 100)
+	saveEntry(t, db, deleted)
+	if err := db.DeleteNutritionalInfo(ctx, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	recent, err := db.GetRecentNutritionalInfo(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newest := []*models.NutritionalInfo{entries[2], entries[4]}
+	if newest[0].ID < newest[1].ID {
+		newest[0], newest[1] = newest[1], newest[0]
+	}
+	want := []string{newest[0].ID, newest[1].ID, entries[0].ID}
+	if len(recent) != len(want) {
+		t.Fatalf("got %d recent entries, want %d", len(recent), len(want))
+	}
+	for i, entry := range recent {
+		if entry.ID != want[i] {
+			t.Errorf("recent entry %d is %s logged at %v, want %s", i, entry.ID, entry.CreatedAt, want[i])
+		}
+	}
+}
+
+func testScanStatus(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+	entry := testEntry(user, time.Now(), 100)
+	saveEntry(t, db, entry)
+
+	scan := &models.NutritionScan{ID: uuid.New().String(), EntryID: entry.ID, ImagePath: "key", Model: "fake", Status: "pending"}
+	if err := db.SaveScan(ctx, scan); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range []struct{ status, err string }{
+		{"processing", ""},
+		{"failed", "model timed out"},
+		{"completed", ""},
+	} {
+		if err := db.UpdateScanStatus(ctx, scan.ID, status.status, status.err); err != nil {
+			t.Fatal(err)
+		}
+		scans, err := db.GetScansByEntry(ctx, []string{entry.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := scans[entry.ID]
+		if got == nil || got.Status != status.status || got.Error != status.err {
+			t.Errorf("after setting status %q: %+v", status.status, got)
+		}
+		if got != nil && !got.CreatedAt.Equal(scan.CreatedAt) {
+			t.Errorf("status update moved the scan from %v to %v", scan.CreatedAt, got.CreatedAt)
+		}
+	}
+
+	// Unknown scans are ignored, as a scan may be removed while it runs
+	if err := db.UpdateScanStatus(ctx, "missing", "failed", ""); err != nil {
+		t.Errorf("updating a missing scan: %v", err)
+	}
+	if scans, _ := db.GetScansByEntry(ctx, nil); len(scans) != 0 {
+		t.Errorf("scans for no entries: %+v", scans)
+	}
+}
+
+func testTimezones(t *testing.T, db database.DB) {
+	ctx := context.Background()
+	user := testUser(t, db)
+	if err := db.SetTimezone(ctx, user.ID, "Pacific/Auckland"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTimezone(ctx, "missing", "UTC"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("setting the time zone of a missing user: %v, want ErrNotFound", err)
+	}
+	got, err := db.GetUser(ctx, user.ID)
+	if err != nil || got == nil || got.Timezone != "Pacific/Auckland" {
+		t.Fatalf("GetUser = %+v, %v", got, err)
+	}
+
+	// 9:00 on March 11 in Auckland, still March 10 in UTC
+	loc := got.Location()
+	loggedAt := time.Date(2024, 3, 11, 9, 0, 0, 0, loc)
+	for _, mealDate := range []string{"2024-03-11", "2024-03-10"} {
+		entry := testEntry(user, loggedAt, 100)
+		entry.MealDate = mealDate
+		saveEntry(t, db, entry)
+	}
+
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, loc)
+	totals, err := db.SumNutritionalInfo(ctx, user.HouseholdID, []database.Period{
+		{Start: day, End: day.AddDate(0, 0, 1)},
+		{Start: day.AddDate(0, 0, 1), End: day.AddDate(0, 0, 2)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals[0].Entries != 1 || totals[1].Entries != 1 {
+		t.Errorf("entries per Auckland day: %d and %d, want 1 and 1", totals[0].Entries, totals[1].Entries)
+	}
+
+	// Moving to New York, where both were logged on March 10, keeps each on
+	// the day it was given
+	if err := db.SetTimezone(ctx, user.ID, "America/New_York"); err != nil {
+		t.Fatal(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day = time.Date(2024, 3, 9, 0, 0, 0, 0, newYork)
+	var periods []database.Period
+	for i := 0; i < 3; i++ {
+		periods = append(periods, database.Period{Start: day.AddDate(0, 0, i), End: day.AddDate(0, 0, i+1)})
+	}
+	totals, err = db.SumNutritionalInfo(ctx, user.HouseholdID, periods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals[0].Entries != 0 || totals[1].Entries != 1 || totals[2].Entries != 1 {
+		t.Errorf("entries per New York day from March 9: %d, %d and %d, want 0, 1 and 1",
+			totals[0].Entries, totals[1].Entries, totals[2].Entries)
+	}
+}
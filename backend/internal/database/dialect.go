@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialect describes a database the queries of this package run on. Queries
+// are written once, in the SQL SQLite and PostgreSQL share, with ?
+// placeholders; only the schema is written for each database.
+type dialect struct {
+	driver string
+
+	// Directory of the migrations written for the database
+	migrations string
+
+	// Placeholders are numbered $1, $2... instead of ?
+	numbered bool
+
+	// Counts the tables named by its parameter
+	hasTable string
+
+	// Ends a SELECT whose rows are updated later in the transaction, so that
+	// concurrent transactions wait instead of updating from the same values.
+	// SQLite needs none: it fails the second transaction that writes instead.
+	lockRows string
+}
+
+var (
+	sqlite = &dialect{
+		driver:     "sqlite",
+		migrations: "migrations/sqlite",
+		hasTable:   `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`,
+	}
+	postgres = &dialect{
+		driver:     "postgres",
+		migrations: "migrations/postgres",
+		numbered:   true,
+		hasTable:   `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?`,
+		lockRows:   ` FOR UPDATE`,
+	}
+)
+
+// rebind rewrites the ? placeholders of a query for the database. Question
+// marks within string literals are left alone.
+func (d *dialect) rebind(query string) string {
+	if !d.numbered || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// bindArgs replaces time.Time arguments with their stored form, so that
+// every timestamp is written alike whatever the driver would make of it
+func bindArgs(args []any) []any {
+	var bound []any
+	for i, arg := range args {
+		if t, ok := arg.(time.Time); ok {
+			if bound == nil {
+				bound = append([]any(nil), args...)
+			}
+			bound[i] = timestamp(t)
+		}
+	}
+	if bound == nil {
+		return args
+	}
+	return bound
+}
+
+// sqlConn is a database whose queries and arguments are bound for its dialect
+type sqlConn struct {
+	*sql.DB
+	dialect *dialect
+}
+
+func (c *sqlConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.DB.ExecContext(ctx, c.dialect.rebind(query), bindArgs(args)...)
+}
+
+func (c *sqlConn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return c.DB.QueryContext(ctx, c.dialect.rebind(query), bindArgs(args)...)
+}
+
+func (c *sqlConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return c.DB.QueryRowContext(ctx, c.dialect.rebind(query), bindArgs(args)...)
+}
+
+// BeginTx starts a transaction whose queries are bound too
+func (c *sqlConn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sqlTx, error) {
+	tx, err := c.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{Tx: tx, dialect: c.dialect}, nil
+}
+
+// sqlTx is a transaction whose queries and arguments are bound for its dialect
+type sqlTx struct {
+	*sql.Tx
+	dialect *dialect
+}
+
+func (t *sqlTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.Tx.ExecContext(ctx, t.dialect.rebind(query), bindArgs(args)...)
+}
+
+func (t *sqlTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.QueryContext(ctx, t.dialect.rebind(query), bindArgs(args)...)
+}
+
+func (t *sqlTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return t.Tx.QueryRowContext(ctx, t.dialect.rebind(query), bindArgs(args)...)
+}
+
+// querier is implemented by both *sqlConn and *sqlTx, for writes that run on
+// their own or as part of a larger transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
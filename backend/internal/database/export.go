@@ -0,0 +1,684 @@
+package database
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Identifies export archives; ExportVersion changes whenever the layout of the
+// archive itself does. Changes to the tables are told by SchemaVersion.
+const (
+	ExportFormat  = "nutritionalvalue-export"
+	ExportVersion = 1
+)
+
+// Export holds the rows of every table that describes what a household ate,
+// weighed and planned, so that they can be moved to another instance or read
+// in a spreadsheet. In JSON it reads:
+//
+//	{
+//	  "format": "nutritionalvalue-export",
+//	  "version": 1,
+//	  "schema_version": 6,
+//	  "exported_at": "2026-10-18T12:30:00Z",
+//	  "household_id": "…",
+//	  "tables": [
+//	    {"name": "households", "columns": ["id", "name", "created_at"], "rows": [["…", "Home", "…"]]},
+//	    …
+//	  ]
+//	}
+//
+// Tables come in the order they are imported in, each row with one value per
+// column: text as strings, with timestamps in UTC as
+// "2006-01-02T15:04:05.000000000Z", numbers as numbers, flags as booleans and
+// missing values as null. household_id is left out of exports of every
+// household. Sign-in credentials and sessions are never exported, nor are
+// photos, which stay in the image store under the keys in image_path: an
+// instance the export is imported into shows none of them unless they are
+// copied to its image store, see ImageKeys.
+type Export struct {
+	Format        string         `json:"format"`
+	Version       int            `json:"version"`
+	SchemaVersion int            `json:"schema_version"`
+	ExportedAt    time.Time      `json:"exported_at"`
+	HouseholdID   string         `json:"household_id,omitempty"`
+	Tables        []*ExportTable `json:"tables"`
+}
+
+// ExportTable holds the rows of a table
+type ExportTable struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+// ImportedTable counts the rows of a table that were added by an import and
+// those skipped because a row with the same key was already there
+type ImportedTable struct {
+	Name    string `json:"name"`
+	Added   int    `json:"added"`
+	Skipped int    `json:"skipped"`
+}
+
+// Exporter is implemented by databases whose rows can be exported
+type Exporter interface {
+	Export(ctx context.Context, householdID string) (*Export, error)
+}
+
+// Kinds of column values, which the databases do not store alike: SQLite
+// keeps flags as integers and gives numbers back as integers or reals
+const (
+	textColumn = iota
+	realColumn
+	integerColumn
+	boolColumn
+)
+
+type exportColumn struct {
+	name string
+	kind int
+}
+
+// exportTable describes how a table is exported
+type exportTable struct {
+	name    string
+	columns []exportColumn
+	orderBy string
+
+	// Restricts the rows to a household given as parameter
+	household string
+}
+
+// householdEntries selects the IDs of the entries of a household given as
+// parameter
+const householdEntries = `SELECT id FROM nutritional_info WHERE user_id ` + memberOf
+
+func textColumns(names ...string) []exportColumn {
+	columns := make([]exportColumn, len(names))
+	for i, name := range names {
+		columns[i] = exportColumn{name, textColumn}
+	}
+	return columns
+}
+
+func realColumns(names ...string) []exportColumn {
+	columns := textColumns(names...)
+	for i := range columns {
+		columns[i].kind = realColumn
+	}
+	return columns
+}
+
+func joinColumns(groups ...[]exportColumn) []exportColumn {
+	var all []exportColumn
+	for _, g := range groups {
+		all = append(all, g...)
+	}
+	return all
+}
+
+// exportTables lists the exported tables in an order that inserts rows after
+// those they refer to
+var exportTables = []exportTable{
+	{
+		name:      "households",
+		columns:   textColumns("id", "name", "created_at"),
+		orderBy:   "id",
+		household: `id = ?`,
+	},
+	{
+		name:      "users",
+		columns:   textColumns("id", "household_id", "name", "timezone", "created_at"),
+		orderBy:   "id",
+		household: `household_id = ?`,
+	},
+	{
+		name: "recipes",
+		columns: joinColumns(
+			textColumns("id", "household_id", "name"),
+			realColumns("yield_weight"),
+			[]exportColumn{{"servings", integerColumn}},
+			textColumns("created_by", "created_at", "updated_at", "deleted_at"),
+		),
+		orderBy:   "id",
+		household: `household_id = ?`,
+	},
+	{
+		name: "nutritional_info",
+		columns: joinColumns(
+			textColumns("id", "user_id"),
+			realColumns("total_weight", "consumed_weight", "calories", "protein", "carbs", "fat", "fiber", "sugar"),
+			textColumns("image_path", "meal", "meal_date", "recipe_id", "eaten_at", "created_at", "updated_at", "deleted_at"),
+		),
+		orderBy:   "id",
+		household: `user_id ` + memberOf,
+	},
+	{
+		name: "recipe_ingredients",
+		columns: joinColumns(
+			textColumns("recipe_id"),
+			[]exportColumn{{"position", integerColumn}},
+			textColumns("entry_id"),
+			realColumns("weight"),
+		),
+		orderBy:   "recipe_id, position",
+		household: `recipe_id IN (SELECT id FROM recipes WHERE household_id = ?)`,
+	},
+	{
+		name: "nutrition_scans",
+		columns: textColumns("id", "entry_id", "image_path", "model", "model_output", "confirmed_output",
+			"status", "error", "created_at", "updated_at"),
+		orderBy:   "id",
+		household: `entry_id IN (` + householdEntries + `)`,
+	},
+	{
+		name: "pantry_items",
+		columns: joinColumns(
+			textColumns("id"),
+			realColumns("initial_weight", "remaining_weight"),
+			textColumns("status", "created_at", "updated_at"),
+		),
+		orderBy:   "id",
+		household: `id IN (` + householdEntries + `)`,
+	},
+	{
+		name: "pantry_events",
+		columns: joinColumns(
+			textColumns("id", "item_id", "user_id", "kind"),
+			realColumns("weight"),
+			textColumns("meal", "meal_date", "eaten_at", "created_at"),
+		),
+		orderBy:   "id",
+		household: `item_id IN (` + householdEntries + `)`,
+	},
+	{
+		name: "consumption_shares",
+		columns: joinColumns(
+			textColumns("id", "entry_id", "event_id", "user_id"),
+			realColumns("fraction"),
+		),
+		orderBy:   "id",
+		household: `entry_id IN (` + householdEntries + `)`,
+	},
+	{
+		name: "goal_targets",
+		columns: joinColumns(
+			textColumns("user_id", "day_type"),
+			realColumns("calories", "protein_grams", "protein_percent", "carbs_grams", "carbs_percent",
+				"fat_grams", "fat_percent", "fiber_min", "sugar_max"),
+			textColumns("updated_at"),
+		),
+		orderBy:   "user_id, day_type",
+		household: `user_id ` + memberOf,
+	},
+	{
+		name: "body_profiles",
+		columns: joinColumns(
+			textColumns("user_id", "sex", "birth_date"),
+			realColumns("height_cm", "weight_kg"),
+			textColumns("activity_level", "plan", "formula"),
+			[]exportColumn{{"auto_goals", boolColumn}},
+			textColumns("updated_at"),
+		),
+		orderBy:   "user_id",
+		household: `user_id ` + memberOf,
+	},
+	{
+		name: "body_metrics",
+		columns: joinColumns(
+			textColumns("id", "user_id", "measured_at"),
+			realColumns("weight_kg", "waist_cm", "body_fat_percent"),
+			textColumns("created_at"),
+		),
+		orderBy:   "id",
+		household: `user_id ` + memberOf,
+	},
+}
+
+func (t *exportTable) columnNames() []string {
+	names := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+// Export reads the rows of a household, or of every household when
+// householdID is empty. Rows without a household, such as scans that failed
+// before an entry was made, are only part of the latter.
+func (s *SQLDB) Export(ctx context.Context, householdID string) (*Export, error) {
+	migrator, err := NewMigrator(s)
+	if err != nil {
+		return nil, err
+	}
+	version, err := migrator.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// One transaction, so that rows refer to rows of the same moment
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	export := &Export{
+		Format:        ExportFormat,
+		Version:       ExportVersion,
+		SchemaVersion: version,
+		ExportedAt:    time.Now().UTC(),
+		HouseholdID:   householdID,
+	}
+	for i := range exportTables {
+		table, err := exportRows(ctx, tx, &exportTables[i], householdID)
+		if err != nil {
+			return nil, err
+		}
+		export.Tables = append(export.Tables, table)
+	}
+	return export, nil
+}
+
+// exportRows reads the rows of a table
+func exportRows(ctx context.Context, tx *sqlTx, t *exportTable, householdID string) (*ExportTable, error) {
+	query := `SELECT ` + strings.Join(t.columnNames(), ", ") + ` FROM ` + t.name
+	var args []any
+	if householdID != "" {
+		query += ` WHERE ` + t.household
+		args = append(args, householdID)
+	}
+	query += ` ORDER BY ` + t.orderBy
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error exporting %s: %w", t.name, err)
+	}
+	defer rows.Close()
+
+	table := &ExportTable{Name: t.name, Columns: t.columnNames(), Rows: [][]any{}}
+	for rows.Next() {
+		values := make([]any, len(t.columns))
+		dest := make([]any, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("error exporting %s: %w", t.name, err)
+		}
+		for i, c := range t.columns {
+			if values[i], err = exportValue(c.kind, values[i]); err != nil {
+				return nil, fmt.Errorf("error exporting %s.%s: %w", t.name, c.name, err)
+			}
+		}
+		table.Rows = append(table.Rows, values)
+	}
+	return table, rows.Err()
+}
+
+// exportValue turns a value read by a driver into the type its kind of column
+// is exported as
+func exportValue(kind int, value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch kind {
+	case textColumn:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case []byte:
+			return string(v), nil
+		}
+	case realColumn:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int64:
+			return float64(v), nil
+		}
+	case integerColumn:
+		if v, ok := value.(int64); ok {
+			return v, nil
+		}
+	case boolColumn:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case int64:
+			return v != 0, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected value %v of type %T", value, value)
+}
+
+// importValue checks a value of an archive against its kind of column and
+// returns it in the type it is written as. Numbers decoded from JSON come as
+// json.Number.
+func importValue(kind int, value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch kind {
+	case textColumn:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+	case realColumn:
+		switch v := value.(type) {
+		case json.Number:
+			return v.Float64()
+		case float64:
+			return v, nil
+		case int64:
+			return float64(v), nil
+		}
+	case integerColumn:
+		switch v := value.(type) {
+		case json.Number:
+			return v.Int64()
+		case int64:
+			return v, nil
+		}
+	case boolColumn:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected value %v", value)
+}
+
+// Import merges an export into the household householdID by key: rows that
+// are not there yet are added, rows that are, including those an earlier
+// import added, are left as they are. The households of the export are not
+// imported; their members and recipes join householdID instead, so that the
+// data is reached by signing in as before, and imported members are never
+// made the default user. The export must come from a database at the same
+// schema version. Nothing is imported unless every row can be.
+func (s *SQLDB) Import(ctx context.Context, export *Export, householdID string) ([]ImportedTable, error) {
+	if err := export.check(); err != nil {
+		return nil, err
+	}
+	household, err := s.GetHousehold(ctx, householdID)
+	if err != nil {
+		return nil, err
+	}
+	if household == nil {
+		return nil, fmt.Errorf("household %s does not exist", householdID)
+	}
+	migrator, err := NewMigrator(s)
+	if err != nil {
+		return nil, err
+	}
+	version, err := migrator.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if export.SchemaVersion != version {
+		return nil, fmt.Errorf("export has schema version %d but the database has %d; bring both to the same release first",
+			export.SchemaVersion, version)
+	}
+
+	byName := make(map[string]*ExportTable)
+	for _, table := range export.Tables {
+		if byName[table.Name] != nil {
+			return nil, fmt.Errorf("export holds table %s twice", table.Name)
+		}
+		byName[table.Name] = table
+	}
+	for name := range byName {
+		if findExportTable(name) == nil {
+			return nil, fmt.Errorf("export holds unknown table %s", name)
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Values that replace those of the export, or fill columns it leaves out
+	set := map[string]map[string]any{
+		"users":   {"household_id": householdID, "imported_at": time.Now()},
+		"recipes": {"household_id": householdID},
+	}
+
+	var imported []ImportedTable
+	for i := range exportTables {
+		t := &exportTables[i]
+		table := byName[t.name]
+		if table == nil {
+			continue
+		}
+		if t.name == "households" {
+			imported = append(imported, ImportedTable{Name: t.name, Skipped: len(table.Rows)})
+			continue
+		}
+		if t.name == "users" {
+			if err := checkImportedUsers(ctx, tx, table, householdID); err != nil {
+				return nil, err
+			}
+		}
+		added, err := importRows(ctx, tx, t, table, set[t.name])
+		if err != nil {
+			return nil, err
+		}
+		imported = append(imported, ImportedTable{Name: t.name, Added: added, Skipped: len(table.Rows) - added})
+	}
+	return imported, tx.Commit()
+}
+
+// checkImportedUsers fails when a member of the export is already a member of
+// another household, whose data the import would otherwise add to
+func checkImportedUsers(ctx context.Context, tx *sqlTx, table *ExportTable, householdID string) error {
+	id := slices.Index(table.Columns, "id")
+	if id < 0 {
+		return nil // importRows reports the missing column
+	}
+	for _, row := range table.Rows {
+		if id >= len(row) {
+			continue
+		}
+		userID, ok := row[id].(string)
+		if !ok {
+			continue
+		}
+		var current string
+		err := tx.QueryRowContext(ctx, `SELECT household_id FROM users WHERE id = ?`, userID).Scan(&current)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if current != householdID {
+			return fmt.Errorf("user %s of the export belongs to another household of this instance", userID)
+		}
+	}
+	return nil
+}
+
+// importRows inserts the rows of a table that are not there yet and returns
+// how many were. set holds values for every row, replacing those of the
+// export or filling columns it does not have.
+func importRows(ctx context.Context, tx *sqlTx, t *exportTable, table *ExportTable, set map[string]any) (int, error) {
+	// Columns may come in any order but must all be there
+	position := make(map[string]int)
+	for i, name := range table.Columns {
+		position[name] = i
+	}
+	if len(position) != len(table.Columns) || len(table.Columns) != len(t.columns) {
+		return 0, fmt.Errorf("table %s of the export has columns %v, want %v", t.name, table.Columns, t.columnNames())
+	}
+	for _, c := range t.columns {
+		if _, ok := position[c.name]; !ok {
+			return 0, fmt.Errorf("table %s of the export has no column %s", t.name, c.name)
+		}
+	}
+
+	names := t.columnNames()
+	var extra []string
+	for name := range set {
+		if !slices.Contains(names, name) {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	names = append(names, extra...)
+
+	query := `INSERT INTO ` + t.name + ` (` + strings.Join(names, ", ") + `)
+		VALUES (?` + strings.Repeat(", ?", len(names)-1) + `)
+		ON CONFLICT DO NOTHING`
+	added := 0
+	for n, row := range table.Rows {
+		if len(row) != len(t.columns) {
+			return added, fmt.Errorf("row %d of table %s has %d values, want %d", n+1, t.name, len(row), len(t.columns))
+		}
+		args := make([]any, 0, len(names))
+		for _, c := range t.columns {
+			value, err := importValue(c.kind, row[position[c.name]])
+			if err != nil {
+				return added, fmt.Errorf("row %d of table %s: column %s: %w", n+1, t.name, c.name, err)
+			}
+			if v, ok := set[c.name]; ok {
+				value = v
+			}
+			args = append(args, value)
+		}
+		for _, name := range extra {
+			args = append(args, set[name])
+		}
+
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return added, fmt.Errorf("error importing row %d of table %s: %w", n+1, t.name, err)
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+			added++
+		}
+	}
+	return added, nil
+}
+
+// ImageKeys returns the keys of the photos the rows of the export refer to,
+// which are in the image store of the instance it was taken from
+func (e *Export) ImageKeys() []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, table := range e.Tables {
+		column := slices.Index(table.Columns, "image_path")
+		if column < 0 {
+			continue
+		}
+		for _, row := range table.Rows {
+			if column >= len(row) {
+				continue
+			}
+			if key, ok := row[column].(string); ok && key != "" && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func findExportTable(name string) *exportTable {
+	for i := range exportTables {
+		if exportTables[i].name == name {
+			return &exportTables[i]
+		}
+	}
+	return nil
+}
+
+// check verifies that an export is in a format this build reads
+func (e *Export) check() error {
+	if e.Format != ExportFormat {
+		return fmt.Errorf("not an export archive")
+	}
+	if e.Version != ExportVersion {
+		return fmt.Errorf("unsupported export version %d", e.Version)
+	}
+	return nil
+}
+
+// ReadExport decodes an export written by WriteJSON
+func ReadExport(r io.Reader) (*Export, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var export Export
+	if err := dec.Decode(&export); err != nil {
+		return nil, fmt.Errorf("error reading export: %w", err)
+	}
+	if err := export.check(); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// WriteJSON writes the export as JSON, the format Import reads back
+func (e *Export) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(e)
+}
+
+// WriteCSV writes the export as a ZIP file with one CSV file per table, named
+// after it, for spreadsheets. The first line of each names the columns;
+// missing values are left empty, which cannot be told apart from empty text,
+// so CSV files are not read back.
+func (e *Export) WriteCSV(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for _, table := range e.Tables {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: table.Name + ".csv", Method: zip.Deflate, Modified: e.ExportedAt})
+		if err != nil {
+			return err
+		}
+		cw := csv.NewWriter(f)
+		if err := cw.Write(table.Columns); err != nil {
+			return err
+		}
+		record := make([]string, len(table.Columns))
+		for _, row := range table.Rows {
+			for i, value := range row {
+				record[i] = csvValue(value)
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func csvValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
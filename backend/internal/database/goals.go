@@ -0,0 +1,137 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// goalTargetColumns is the column list read by scanGoalTarget
+const goalTargetColumns = `day_type, calories,
+			protein_grams, protein_percent, carbs_grams, carbs_percent, fat_grams, fat_percent,
+			fiber_min, sugar_max, updated_at`
+
+// GetGoals retrieves a user's goals, or nil if they have none
+func (s *SQLDB) GetGoals(ctx context.Context, userID string) (*models.Goals, error) {
+	query := `
+		SELECT ` + goalTargetColumns + `
+		FROM goal_targets WHERE user_id = ?
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var goals *models.Goals
+	for rows.Next() {
+		dayType, target, updatedAt, err := scanGoalTarget(rows)
+		if err != nil {
+			return nil, err
+		}
+		if goals == nil {
+			goals = &models.Goals{UserID: userID}
+		}
+		if updatedAt.After(goals.UpdatedAt) {
+			goals.UpdatedAt = updatedAt
+		}
+		if dayType == models.GoalWeekend {
+			goals.Weekend = target
+		} else {
+			goals.Weekday = *target
+		}
+	}
+
+	return goals, rows.Err()
+}
+
+// SaveGoals replaces a user's goals
+func (s *SQLDB) SaveGoals(ctx context.Context, goals *models.Goals) error {
+	if goals.UpdatedAt.IsZero() {
+		goals.UpdatedAt = time.Now()
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM goal_targets WHERE user_id = ?`, goals.UserID); err != nil {
+		return fmt.Errorf("error clearing goals: %w", err)
+	}
+
+	targets := map[string]*models.DailyTarget{models.GoalWeekday: &goals.Weekday}
+	if goals.Weekend != nil {
+		targets[models.GoalWeekend] = goals.Weekend
+	}
+	for dayType, t := range targets {
+		protein, carbs, fat := splitMacro(t.Protein), splitMacro(t.Carbs), splitMacro(t.Fat)
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO goal_targets (user_id, `+goalTargetColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, goals.UserID, dayType, nullFloat(t.Calories),
+			protein[0], protein[1], carbs[0], carbs[1], fat[0], fat[1],
+			nullFloat(t.FiberMin), nullFloat(t.SugarMax), goals.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("error saving %s goals: %w", dayType, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteGoals removes a user's goals
+func (s *SQLDB) DeleteGoals(ctx context.Context, userID string) error {
+	return expectOneRow(s.db.ExecContext(ctx, `DELETE FROM goal_targets WHERE user_id = ?`, userID))
+}
+
+// splitMacro returns the grams and percent columns of a macro target
+func splitMacro(m *models.MacroTarget) [2]any {
+	if m == nil {
+		return [2]any{nil, nil}
+	}
+	return [2]any{nullFloat(m.Grams), nullFloat(m.Percent)}
+}
+
+// joinMacro is the inverse of splitMacro
+func joinMacro(grams, percent sql.NullFloat64) *models.MacroTarget {
+	if !grams.Valid && !percent.Valid {
+		return nil
+	}
+	return &models.MacroTarget{Grams: floatPtr(grams), Percent: floatPtr(percent)}
+}
+
+// scanGoalTarget reads a row selected with goalTargetColumns
+func scanGoalTarget(row rowScanner) (string, *models.DailyTarget, time.Time, error) {
+	var (
+		dayType                                        string
+		calories, fiberMin, sugarMax                   sql.NullFloat64
+		proteinGrams, proteinPercent                   sql.NullFloat64
+		carbsGrams, carbsPercent, fatGrams, fatPercent sql.NullFloat64
+		updatedAt                                      string
+	)
+	if err := row.Scan(&dayType, &calories,
+		&proteinGrams, &proteinPercent, &carbsGrams, &carbsPercent, &fatGrams, &fatPercent,
+		&fiberMin, &sugarMax, &updatedAt); err != nil {
+		return "", nil, time.Time{}, err
+	}
+
+	updated, err := parseTimestamp(updatedAt)
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+
+	return dayType, &models.DailyTarget{
+		Calories: floatPtr(calories),
+		Protein:  joinMacro(proteinGrams, proteinPercent),
+		Carbs:    joinMacro(carbsGrams, carbsPercent),
+		Fat:      joinMacro(fatGrams, fatPercent),
+		FiberMin: floatPtr(fiberMin),
+		SugarMax: floatPtr(sugarMax),
+	}, updated, nil
+}
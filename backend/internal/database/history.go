@@ -0,0 +1,187 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// MaxPeriods bounds how many periods a single SumNutritionalInfo call may
+// aggregate, which keeps the number of query parameters reasonable
+const MaxPeriods = 500
+
+// eatenWeight is the SQL counterpart of models.NutritionalInfo.EatenWeight
+const eatenWeight = `COALESCE(n.consumed_weight, n.total_weight)`
+
+// memberOf restricts a user_id column to the members of a household given as parameter
+const memberOf = `IN (SELECT id FROM users WHERE household_id = ?)`
+
+// HistoryQuery selects a page of entries eaten in [From, To), latest first.
+// Entries are placed by eaten_at, as SumNutritionalInfo counts them, so an
+// entry given another diary day is listed on that day.
+type HistoryQuery struct {
+	From        time.Time
+	To          time.Time
+	Cursor      *HistoryCursor // continue after this entry; nil for the first page
+	Limit       int
+	HouseholdID string // entries logged by members of this household
+	UserID      string // optional: only entries this person ate from
+}
+
+// HistoryCursor identifies the last entry of the previous page
+type HistoryCursor struct {
+	EatenAt time.Time
+	ID      string
+}
+
+// Period is a half-open time range [Start, End)
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+// ListNutritionalInfo returns a page of entries matching the query, skipping
+// soft-deleted ones
+func (s *SQLDB) ListNutritionalInfo(ctx context.Context, q HistoryQuery) ([]*models.NutritionalInfo, error) {
+	query := `
+		SELECT ` + nutritionalInfoColumns + `
+		FROM nutritional_info
+		WHERE deleted_at IS NULL AND eaten_at >= ? AND eaten_at < ?
+			AND user_id ` + memberOf + `
+	`
+	args := []any{q.From, q.To, q.HouseholdID}
+
+	if q.UserID != "" {
+		query += ` AND id IN (SELECT entry_id FROM consumption_shares WHERE user_id = ?)`
+		args = append(args, q.UserID)
+	}
+	if q.Cursor != nil {
+		query += ` AND (eaten_at < ? OR (eaten_at = ? AND id < ?))`
+		args = append(args, q.Cursor.EatenAt, q.Cursor.EatenAt, q.Cursor.ID)
+	}
+	query += `
+		ORDER BY eaten_at DESC, id DESC
+		LIMIT ?
+	`
+	args = append(args, q.Limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*models.NutritionalInfo
+	for rows.Next() {
+		info, err := scanNutritionalInfo(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, info)
+	}
+
+	return results, rows.Err()
+}
+
+// SumNutritionalInfo adds up what the household ate in each period, scaling
+// the per-100g values by the grams eaten. Entries eaten straight away count
+// when they were logged; pantry items count when consumption was logged. The
+// result has one element per period, in the same order, including empty periods.
+func (s *SQLDB) SumNutritionalInfo(ctx context.Context, householdID string, periods []Period) ([]*models.NutritionTotals, error) {
+	source := `
+		SELECT n.eaten_at AS at, n.meal, ` + eatenWeight + ` AS grams,
+			n.calories, n.protein, n.carbs, n.fat, n.fiber, n.sugar
+		FROM nutritional_info n
+		WHERE n.deleted_at IS NULL AND n.user_id ` + memberOf + `
+			AND n.id NOT IN (SELECT id FROM pantry_items)
+		UNION ALL
+		SELECT e.eaten_at AS at, e.meal, e.weight AS grams,
+			n.calories, n.protein, n.carbs, n.fat, n.fiber, n.sugar
+		FROM pantry_events e
+		JOIN nutritional_info n ON n.id = e.item_id
+		WHERE n.deleted_at IS NULL AND n.user_id ` + memberOf + `
+			AND e.kind = ?
+	`
+	return s.sumByPeriod(ctx, periods, source, householdID, householdID, models.PantryEventEaten)
+}
+
+// SumUserConsumption adds up what one person ate in each period according to
+// their consumption shares
+func (s *SQLDB) SumUserConsumption(ctx context.Context, userID string, periods []Period) ([]*models.NutritionTotals, error) {
+	source := `
+		SELECT CASE WHEN e.id IS NULL THEN n.eaten_at ELSE e.eaten_at END AS at,
+			CASE WHEN e.id IS NULL THEN n.meal ELSE e.meal END AS meal,
+			sh.fraction * (CASE WHEN sh.event_id IS NULL THEN ` + eatenWeight + ` ELSE e.weight END) AS grams,
+			n.calories, n.protein, n.carbs, n.fat, n.fiber, n.sugar
+		FROM consumption_shares sh
+		JOIN nutritional_info n ON n.id = sh.entry_id
+		LEFT JOIN pantry_events e ON e.id = sh.event_id
+		WHERE n.deleted_at IS NULL AND sh.user_id = ?
+	`
+	return s.sumByPeriod(ctx, periods, source, userID)
+}
+
+// sumByPeriod aggregates the rows of source into periods, broken down by meal.
+// source is a query returning the columns at, meal, grams and the per-100g
+// nutrients; nutrients are scaled by grams.
+func (s *SQLDB) sumByPeriod(ctx context.Context, periods []Period, source string, sourceArgs ...any) ([]*models.NutritionTotals, error) {
+	if len(periods) == 0 {
+		return nil, nil
+	}
+	if len(periods) > MaxPeriods {
+		return nil, fmt.Errorf("too many periods: %d (max %d)", len(periods), MaxPeriods)
+	}
+
+	values := make([]string, len(periods))
+	args := make([]any, 0, len(periods)*3+len(sourceArgs))
+	for i, p := range periods {
+		// PostgreSQL cannot tell the type of bare parameters in VALUES
+		values[i] = "(CAST(? AS INTEGER), CAST(? AS TEXT), CAST(? AS TEXT))"
+		args = append(args, i, p.Start, p.End)
+	}
+	args = append(args, sourceArgs...)
+
+	query := `
+		WITH periods(idx, start_at, end_at) AS (VALUES ` + strings.Join(values, ", ") + `)
+		SELECT p.idx, x.meal, COUNT(x.at), COALESCE(SUM(x.grams), 0),
+			COALESCE(SUM(x.calories * x.grams / 100), 0),
+			COALESCE(SUM(x.protein * x.grams / 100), 0),
+			COALESCE(SUM(x.carbs * x.grams / 100), 0),
+			COALESCE(SUM(x.fat * x.grams / 100), 0),
+			COALESCE(SUM(x.fiber * x.grams / 100), 0),
+			COALESCE(SUM(x.sugar * x.grams / 100), 0)
+		FROM periods p
+		LEFT JOIN (` + source + `) x
+			ON x.at >= p.start_at AND x.at < p.end_at
+		GROUP BY p.idx, x.meal
+		ORDER BY p.idx
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]*models.NutritionTotals, len(periods))
+	for i, p := range periods {
+		results[i] = &models.NutritionTotals{PeriodStart: p.Start, PeriodEnd: p.End}
+	}
+	for rows.Next() {
+		var idx int
+		var meal sql.NullString
+		m := &models.MealTotals{}
+		err := rows.Scan(&idx, &meal, &m.Entries, &m.Weight,
+			&m.Calories, &m.Protein, &m.Carbs, &m.Fat, &m.Fiber, &m.Sugar)
+		if err != nil {
+			return nil, err
+		}
+		results[idx].AddMeal(meal.String, m)
+	}
+
+	return results, rows.Err()
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/franckalain/nutritionalvalue/internal/images"
+)
+
+// MoveScanImages moves the photos older scans kept in the database into the
+// image store and returns how many were moved. Scans are moved one at a time,
+// each photo stored before its bytes are cleared, so an interrupted move is
+// simply resumed by the next call.
+func (s *SQLDB) MoveScanImages(ctx context.Context, store images.Store) (int, error) {
+	moved := 0
+	for {
+		var (
+			id   string
+			data []byte
+		)
+		err := s.db.QueryRowContext(ctx, `
+			SELECT id, image_data FROM nutrition_scans WHERE image_data IS NOT NULL LIMIT 1
+		`).Scan(&id, &data)
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return moved, fmt.Errorf("error reading scan image: %w", err)
+		}
+
+		// Scans rolled back from the store to an older schema have no bytes left
+		var key sql.NullString
+		if len(data) > 0 {
+			k, err := store.Put(ctx, data)
+			if err != nil {
+				return moved, fmt.Errorf("error moving image of scan %s: %w", id, err)
+			}
+			key = sql.NullString{String: k, Valid: true}
+		}
+
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE nutrition_scans SET image_path = COALESCE(?, image_path), image_data = NULL WHERE id = ?
+		`, key, id)
+		if err != nil {
+			return moved, fmt.Errorf("error updating scan %s: %w", id, err)
+		}
+		if key.Valid {
+			moved++
+		}
+	}
+
+	if moved > 0 {
+		log.Printf("Moved %d scan images to the image store", moved)
+	}
+	return moved, nil
+}
+
+// HouseholdHasImage reports whether an entry of a household, or the scan of
+// one, refers to the photo with the key
+func (s *SQLDB) HouseholdHasImage(ctx context.Context, householdID, key string) (bool, error) {
+	var found int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM nutritional_info
+		WHERE user_id `+memberOf+` AND (image_path = ? OR id IN (
+			SELECT entry_id FROM nutrition_scans WHERE image_path = ?
+		))
+	`, householdID, key, key).Scan(&found)
+	if err != nil {
+		return false, fmt.Errorf("error looking up image: %w", err)
+	}
+	return found > 0, nil
+}
+
+// Compact gives back the space of deleted data, such as photos moved to the
+// image store. SQLite rebuilds the database file; PostgreSQL makes the space
+// available to new rows.
+func (s *SQLDB) Compact(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("error compacting database: %w", err)
+	}
+	return nil
+}
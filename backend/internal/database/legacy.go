@@ -0,0 +1,210 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// Databases created before migrations existed were kept up to date by
+// re-running the schema on every start and patching tables in place. This
+// file brings them up to the first migration once; new changes go into
+// numbered migrations instead.
+
+// isLegacyDatabase reports whether a database without schema_version already
+// holds tables from before migrations
+func isLegacyDatabase(ctx context.Context, db *sql.DB) (bool, error) {
+	var count int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_version'
+	`).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error inspecting database: %w", err)
+	}
+	return count > 0, nil
+}
+
+// upgradeLegacySchema adds what older releases lacked, then runs the first
+// migration, which only creates what is still missing
+func upgradeLegacySchema(ctx context.Context, db *sql.DB, baseline string) error {
+	// Columns added after the initial release. Indexes in the baseline need
+	// them, so they are added first; missing tables are created by the baseline.
+	columns := []struct{ table, column, definition string }{
+		{"nutritional_info", "deleted_at", "TEXT"},
+		{"nutritional_info", "consumed_weight", "REAL"},
+		{"nutritional_info", "user_id", "TEXT REFERENCES users(id)"},
+		{"nutritional_info", "meal", "TEXT"},
+		{"nutritional_info", "meal_date", "TEXT"},
+		{"nutritional_info", "recipe_id", "TEXT REFERENCES recipes(id)"},
+		{"pantry_events", "user_id", "TEXT REFERENCES users(id)"},
+		{"pantry_events", "meal", "TEXT"},
+		{"pantry_events", "meal_date", "TEXT"},
+		{"users", "login", "TEXT"},
+		{"users", "password_hash", "TEXT"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(db, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, baseline); err != nil {
+		return fmt.Errorf("error applying baseline schema: %w", err)
+	}
+	if err := backfillMeals(db); err != nil {
+		return err
+	}
+	return allowCookedEvents(db)
+}
+
+// backfillMeals assigns a meal slot and date to food eaten before meals were
+// tracked, from the time it was logged. Pantry items themselves are not meals.
+func backfillMeals(db *sql.DB) error {
+	sources := []struct{ table, where string }{
+		{"nutritional_info", `meal IS NULL AND id NOT IN (SELECT id FROM pantry_items)`},
+		{"pantry_events", `meal IS NULL AND kind = 'eaten'`},
+	}
+	for _, src := range sources {
+		rows, err := db.Query(`SELECT id, created_at FROM ` + src.table + ` WHERE ` + src.where)
+		if err != nil {
+			return fmt.Errorf("error reading %s for meal backfill: %w", src.table, err)
+		}
+		loggedAt := make(map[string]time.Time)
+		for rows.Next() {
+			var id, createdAt string
+			if err := rows.Scan(&id, &createdAt); err != nil {
+				rows.Close()
+				return err
+			}
+			if loggedAt[id], err = parseTimestamp(createdAt); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for id, t := range loggedAt {
+			_, err := db.Exec(`UPDATE `+src.table+` SET meal = ?, meal_date = ? WHERE id = ?`,
+				models.SuggestMeal(t), t.Format(models.MealDateLayout), id)
+			if err != nil {
+				return fmt.Errorf("error backfilling meal in %s: %w", src.table, err)
+			}
+		}
+		if len(loggedAt) > 0 {
+			log.Printf("Assigned meals to %d rows of %s", len(loggedAt), src.table)
+		}
+	}
+	return nil
+}
+
+// allowCookedEvents rebuilds the pantry_events table of older databases, whose
+// kind check predates recipes. SQLite cannot change a check in place.
+func allowCookedEvents(db *sql.DB) error {
+	var definition string
+	err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'pantry_events'`).Scan(&definition)
+	if err != nil {
+		return fmt.Errorf("error reading pantry_events definition: %w", err)
+	}
+	if strings.Contains(definition, "'cooked'") {
+		return nil
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Foreign keys cannot be switched off inside a transaction, and the shares
+	// referencing the events must survive the table being dropped
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`CREATE TABLE pantry_events_new (
+			id TEXT PRIMARY KEY,
+			item_id TEXT NOT NULL REFERENCES pantry_items(id),
+			user_id TEXT REFERENCES users(id),
+			kind TEXT NOT NULL CHECK(kind IN ('eaten', 'discarded', 'cooked')),
+			weight REAL NOT NULL,
+			meal TEXT,
+			meal_date TEXT,
+			created_at TEXT NOT NULL
+		)`,
+		`INSERT INTO pantry_events_new (id, item_id, user_id, kind, weight, meal, meal_date, created_at)
+			SELECT id, item_id, user_id, kind, weight, meal, meal_date, created_at FROM pantry_events`,
+		`DROP TABLE pantry_events`,
+		`ALTER TABLE pantry_events_new RENAME TO pantry_events`,
+		`CREATE INDEX IF NOT EXISTS idx_pantry_events_item_id ON pantry_events(item_id)`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("error rebuilding pantry_events: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Println("Rebuilt pantry_events to allow cooked events")
+	return nil
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is already
+// there. Tables that do not exist yet are left to the baseline.
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("error reading columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	found := false
+	for rows.Next() {
+		found = true
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return fmt.Errorf("error reading columns of %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading columns of %s: %w", table, err)
+	}
+	if !found {
+		return nil
+	}
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("error adding column %s.%s: %w", table, column, err)
+	}
+	log.Printf("Added column %s.%s", table, column)
+	return nil
+}
@@ -0,0 +1,1314 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"github.com/google/uuid"
+)
+
+// MemoryDB implements the DB interface in memory, for tests that need a
+// database but not a particular one. It behaves like SQLDB, which package
+// dbtest checks, and forgets everything when closed.
+type MemoryDB struct {
+	mu sync.Mutex
+
+	households map[string]models.Household
+	users      map[string]*memoryUser
+	tokens     map[string]models.AuthToken
+	goals      map[string]*models.Goals
+	profiles   map[string]models.BodyProfile
+	metrics    map[string]*models.BodyMetric
+	recipes    map[string]*models.Recipe // ingredients without their entries
+	entries    map[string]*models.NutritionalInfo
+	scans      map[string]*models.NutritionScan
+	items      map[string]*models.PantryItem  // without their entries
+	events     map[string]*models.PantryEvent // without their shares
+	shares     []memoryShare
+
+	// When entries and events count in history, by ID, as the SQL
+	// databases store it in eaten_at
+	eatenAt map[string]time.Time
+}
+
+// memoryUser is a user with the password hash set by SetCredentials
+type memoryUser struct {
+	models.User
+	passwordHash string
+}
+
+// memoryShare is a consumption share; eventID is empty for shares of a whole entry
+type memoryShare struct {
+	entryID string
+	eventID string
+	models.ConsumptionShare
+}
+
+// NewMemoryDB returns an empty in-memory database
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		households: make(map[string]models.Household),
+		users:      make(map[string]*memoryUser),
+		tokens:     make(map[string]models.AuthToken),
+		goals:      make(map[string]*models.Goals),
+		profiles:   make(map[string]models.BodyProfile),
+		metrics:    make(map[string]*models.BodyMetric),
+		recipes:    make(map[string]*models.Recipe),
+		entries:    make(map[string]*models.NutritionalInfo),
+		scans:      make(map[string]*models.NutritionScan),
+		items:      make(map[string]*models.PantryItem),
+		events:     make(map[string]*models.PantryEvent),
+		eatenAt:    make(map[string]time.Time),
+	}
+}
+
+// Close does nothing; the data stays readable until the MemoryDB is dropped
+func (m *MemoryDB) Close() error {
+	return nil
+}
+
+// SaveNutritionalInfo creates or updates an entry, keeping when it was logged
+// and whether it was deleted
+func (m *MemoryDB) SaveNutritionalInfo(ctx context.Context, info *models.NutritionalInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.saveEntry(info)
+	return nil
+}
+
+// saveEntry creates or updates an entry
+func (m *MemoryDB) saveEntry(info *models.NutritionalInfo) {
+	now := time.Now()
+	if info.CreatedAt.IsZero() {
+		info.CreatedAt = now
+	}
+	info.UpdatedAt = now
+
+	saved := copyEntry(info)
+	saved.DeletedAt = nil
+	if old := m.entries[info.ID]; old != nil {
+		saved.CreatedAt = old.CreatedAt
+		saved.DeletedAt = old.DeletedAt
+	}
+	saved.EatenAt = models.EatenAt(saved.CreatedAt, saved.MealDate, m.location(saved.UserID))
+	info.EatenAt = saved.EatenAt
+	m.entries[info.ID] = saved
+	m.eatenAt[info.ID] = saved.EatenAt
+}
+
+// GetNutritionalInfo retrieves an entry, or nil if there is none.
+// Soft-deleted entries are returned with DeletedAt set.
+func (m *MemoryDB) GetNutritionalInfo(ctx context.Context, id string) (*models.NutritionalInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.entries[id]
+	if entry == nil {
+		return nil, nil
+	}
+	return copyEntry(entry), nil
+}
+
+// GetRecentNutritionalInfo returns the latest entries, skipping soft-deleted ones
+func (m *MemoryDB) GetRecentNutritionalInfo(ctx context.Context, limit int) ([]*models.NutritionalInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := m.findEntries(func(n *models.NutritionalInfo) bool { return n.DeletedAt == nil })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// ListNutritionalInfo returns a page of entries matching the query, skipping
+// soft-deleted ones
+func (m *MemoryDB) ListNutritionalInfo(ctx context.Context, q HistoryQuery) ([]*models.NutritionalInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := m.findEntries(func(n *models.NutritionalInfo) bool {
+		if n.DeletedAt != nil || n.EatenAt.Before(q.From) || !n.EatenAt.Before(q.To) {
+			return false
+		}
+		if !m.memberOf(n.UserID, q.HouseholdID) {
+			return false
+		}
+		if q.UserID != "" && !m.ateFrom(q.UserID, n.ID) {
+			return false
+		}
+		if c := q.Cursor; c != nil {
+			return n.EatenAt.Before(c.EatenAt) || (n.EatenAt.Equal(c.EatenAt) && n.ID < c.ID)
+		}
+		return true
+	})
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].EatenAt.Equal(results[j].EatenAt) {
+			return results[i].EatenAt.After(results[j].EatenAt)
+		}
+		return results[i].ID > results[j].ID
+	})
+	if len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results, nil
+}
+
+// findEntries returns copies of the entries matching keep, newest first
+func (m *MemoryDB) findEntries(keep func(*models.NutritionalInfo) bool) []*models.NutritionalInfo {
+	var results []*models.NutritionalInfo
+	for _, n := range m.entries {
+		if keep(n) {
+			results = append(results, copyEntry(n))
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].CreatedAt.Equal(results[j].CreatedAt) {
+			return results[i].CreatedAt.After(results[j].CreatedAt)
+		}
+		return results[i].ID > results[j].ID
+	})
+	return results
+}
+
+// DeleteNutritionalInfo soft-deletes an entry so that it can be restored later
+func (m *MemoryDB) DeleteNutritionalInfo(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.entries[id]
+	if entry == nil || entry.DeletedAt != nil {
+		return ErrNotFound
+	}
+	now := time.Now()
+	entry.DeletedAt = &now
+	entry.UpdatedAt = now
+	return nil
+}
+
+// RestoreNutritionalInfo undoes a previous DeleteNutritionalInfo
+func (m *MemoryDB) RestoreNutritionalInfo(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.entries[id]
+	if entry == nil || entry.DeletedAt == nil {
+		return ErrNotFound
+	}
+	entry.DeletedAt = nil
+	entry.UpdatedAt = time.Now()
+	return nil
+}
+
+// memoryRow is something eaten, bought or thrown away, as summed by period
+type memoryRow struct {
+	at    time.Time
+	meal  string
+	grams float64
+	entry *models.NutritionalInfo // per-100g values
+}
+
+// SumNutritionalInfo adds up what the household ate in each period
+func (m *MemoryDB) SumNutritionalInfo(ctx context.Context, householdID string, periods []Period) ([]*models.NutritionTotals, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rows []memoryRow
+	for _, n := range m.entries {
+		if n.DeletedAt == nil && m.memberOf(n.UserID, householdID) && m.items[n.ID] == nil {
+			rows = append(rows, memoryRow{m.eatenAt[n.ID], n.Meal, n.EatenWeight(), n})
+		}
+	}
+	rows = append(rows, m.eventRows(householdID, models.PantryEventEaten)...)
+	return sumRows(periods, rows)
+}
+
+// SumUserConsumption adds up what one person ate in each period according to
+// their consumption shares
+func (m *MemoryDB) SumUserConsumption(ctx context.Context, userID string, periods []Period) ([]*models.NutritionTotals, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rows []memoryRow
+	for _, sh := range m.shares {
+		n := m.entries[sh.entryID]
+		if sh.UserID != userID || n == nil || n.DeletedAt != nil {
+			continue
+		}
+		if e := m.events[sh.eventID]; e != nil {
+			rows = append(rows, memoryRow{m.eatenAt[e.ID], e.Meal, sh.Fraction * e.Weight, n})
+		} else {
+			rows = append(rows, memoryRow{m.eatenAt[n.ID], n.Meal, sh.Fraction * n.EatenWeight(), n})
+		}
+	}
+	return sumRows(periods, rows)
+}
+
+// eventRows returns the pantry events of a kind on the household's items
+func (m *MemoryDB) eventRows(householdID, kind string) []memoryRow {
+	var rows []memoryRow
+	for _, e := range m.events {
+		n := m.entries[e.ItemID]
+		if e.Kind == kind && n != nil && n.DeletedAt == nil && m.memberOf(n.UserID, householdID) {
+			rows = append(rows, memoryRow{m.eatenAt[e.ID], e.Meal, e.Weight, n})
+		}
+	}
+	return rows
+}
+
+// sumRows is the counterpart of sumByPeriod
+func sumRows(periods []Period, rows []memoryRow) ([]*models.NutritionTotals, error) {
+	if len(periods) == 0 {
+		return nil, nil
+	}
+	if len(periods) > MaxPeriods {
+		return nil, fmt.Errorf("too many periods: %d (max %d)", len(periods), MaxPeriods)
+	}
+
+	results := make([]*models.NutritionTotals, len(periods))
+	for i, p := range periods {
+		results[i] = &models.NutritionTotals{PeriodStart: p.Start, PeriodEnd: p.End}
+		for _, r := range rows {
+			if r.at.Before(p.Start) || !r.at.Before(p.End) {
+				continue
+			}
+			factor := r.grams / 100
+			results[i].AddMeal(r.meal, &models.MealTotals{
+				Entries:  1,
+				Weight:   r.grams,
+				Calories: r.entry.Calories * factor,
+				Protein:  r.entry.Protein * factor,
+				Carbs:    r.entry.Carbs * factor,
+				Fat:      r.entry.Fat * factor,
+				Fiber:    r.entry.Fiber * factor,
+				Sugar:    r.entry.Sugar * factor,
+			})
+		}
+	}
+	return results, nil
+}
+
+// SaveScan creates or replaces a scan, keeping when it was made
+func (m *MemoryDB) SaveScan(ctx context.Context, scan *models.NutritionScan) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.saveScan(scan)
+	return nil
+}
+
+// saveScan creates or replaces a scan
+func (m *MemoryDB) saveScan(scan *models.NutritionScan) {
+	now := time.Now()
+	if scan.CreatedAt.IsZero() {
+		scan.CreatedAt = now
+	}
+	scan.UpdatedAt = now
+
+	saved := copyScan(scan)
+	if old := m.scans[scan.ID]; old != nil {
+		saved.CreatedAt = old.CreatedAt
+	}
+	m.scans[scan.ID] = saved
+}
+
+// ConfirmScan saves the entry a scan was confirmed as together with the
+// scan, stocking the entry as a pantry item with pantry
+func (m *MemoryDB) ConfirmScan(ctx context.Context, entry *models.NutritionalInfo, shares []models.ConsumptionShare, pantry bool, scan *models.NutritionScan) (*models.PantryItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if pantry {
+		if err := m.checkNotInPantry(entry.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	m.saveEntry(entry)
+	var item *models.PantryItem
+	if pantry {
+		item = m.addToPantry(entry)
+	} else {
+		m.replaceShares(entry.ID, shares)
+	}
+	m.saveScan(scan)
+	return item, nil
+}
+
+// UpdateEntry saves a correction of a confirmed entry, its shares and the
+// values confirmed on its scan, and resizes its pantry item. Nothing is
+// changed when a share is for an unknown user.
+func (m *MemoryDB) UpdateEntry(ctx context.Context, entry *models.NutritionalInfo, shares []models.ConsumptionShare, confirmed *models.ModelOutput) (*models.PantryItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, share := range shares {
+		if m.users[share.UserID] == nil {
+			return nil, fmt.Errorf("error saving consumption share: unknown user %s", share.UserID)
+		}
+	}
+
+	m.saveEntry(entry)
+	if shares != nil {
+		m.replaceShares(entry.ID, shares)
+	}
+	if confirmed != nil {
+		m.updateScanConfirmation(entry.ID, confirmed)
+	}
+	if !m.resizePantryItem(entry.ID, entry.TotalWeight) {
+		return nil, nil
+	}
+	return m.pantryItem(entry.ID), nil
+}
+
+// UpdateScanStatus updates the status of a scan
+func (m *MemoryDB) UpdateScanStatus(ctx context.Context, id, status string, errMsg string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if scan := m.scans[id]; scan != nil {
+		scan.Status = status
+		scan.Error = errMsg
+		scan.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+// GetScansByEntry returns the latest scan of each entry that has one
+func (m *MemoryDB) GetScansByEntry(ctx context.Context, entryIDs []string) (map[string]*models.NutritionScan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wanted := make(map[string]bool, len(entryIDs))
+	for _, id := range entryIDs {
+		wanted[id] = true
+	}
+	scans := make(map[string]*models.NutritionScan)
+	for _, scan := range m.scans {
+		if !wanted[scan.EntryID] {
+			continue
+		}
+		if latest := scans[scan.EntryID]; latest == nil || scan.CreatedAt.After(latest.CreatedAt) {
+			scans[scan.EntryID] = copyScan(scan)
+		}
+	}
+	return scans, nil
+}
+
+// UpdateScanConfirmation records new values the user confirmed for the scan
+// of an entry
+func (m *MemoryDB) UpdateScanConfirmation(ctx context.Context, entryID string, confirmed *models.ModelOutput) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.updateScanConfirmation(entryID, confirmed)
+	return nil
+}
+
+// updateScanConfirmation records new confirmed values on the scans of an entry
+func (m *MemoryDB) updateScanConfirmation(entryID string, confirmed *models.ModelOutput) {
+	for _, scan := range m.scans {
+		if scan.EntryID == entryID {
+			scan.ConfirmedOutput = copyOutput(confirmed)
+			scan.UpdatedAt = time.Now()
+		}
+	}
+}
+
+// ListConfirmedScans returns the confirmed scans made in [from, to) by a
+// household, or by everyone when householdID is empty, oldest first
+func (m *MemoryDB) ListConfirmedScans(ctx context.Context, householdID string, from, to time.Time) ([]*models.NutritionScan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var scans []*models.NutritionScan
+	for _, scan := range m.scans {
+		if scan.CreatedAt.Before(from) || !scan.CreatedAt.Before(to) ||
+			scan.ModelOutput == nil || scan.ConfirmedOutput == nil {
+			continue
+		}
+		entry := m.entries[scan.EntryID]
+		if entry == nil || entry.DeletedAt != nil || (householdID != "" && !m.memberOf(entry.UserID, householdID)) {
+			continue
+		}
+		scans = append(scans, copyScan(scan))
+	}
+	sort.Slice(scans, func(i, j int) bool { return scans[i].CreatedAt.Before(scans[j].CreatedAt) })
+	return scans, nil
+}
+
+// HouseholdHasImage reports whether an entry of a household, or the scan of
+// one, refers to the photo with the key
+func (m *MemoryDB) HouseholdHasImage(ctx context.Context, householdID, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, n := range m.entries {
+		if n.ImagePath == key && m.memberOf(n.UserID, householdID) {
+			return true, nil
+		}
+	}
+	for _, scan := range m.scans {
+		if n := m.entries[scan.EntryID]; scan.ImagePath == key && n != nil && m.memberOf(n.UserID, householdID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AddToPantry creates the pantry item for a confirmed entry
+func (m *MemoryDB) AddToPantry(ctx context.Context, entry *models.NutritionalInfo) (*models.PantryItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.entries[entry.ID] == nil {
+		return nil, fmt.Errorf("error creating pantry item: %w", ErrNotFound)
+	}
+	if err := m.checkNotInPantry(entry.ID); err != nil {
+		return nil, err
+	}
+	return m.addToPantry(entry), nil
+}
+
+// checkNotInPantry fails like the primary key of pantry items when an entry
+// is in the pantry already
+func (m *MemoryDB) checkNotInPantry(id string) error {
+	if m.items[id] != nil {
+		return fmt.Errorf("error creating pantry item: %s is already in the pantry", id)
+	}
+	return nil
+}
+
+// addToPantry creates the pantry item for a saved entry
+func (m *MemoryDB) addToPantry(entry *models.NutritionalInfo) *models.PantryItem {
+	now := time.Now()
+	eaten := entry.EatenWeight()
+	item := &models.PantryItem{
+		ID:              entry.ID,
+		InitialWeight:   entry.TotalWeight,
+		RemainingWeight: entry.TotalWeight - eaten,
+		Status:          models.PantryInStock,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if item.RemainingWeight <= stockEpsilon {
+		item.RemainingWeight = 0
+		item.Status = models.PantryFinished
+	}
+	m.items[item.ID] = item
+
+	if eaten > 0 {
+		m.addEvent(&models.PantryEvent{
+			ID:        uuid.New().String(),
+			ItemID:    item.ID,
+			UserID:    entry.UserID,
+			Kind:      models.PantryEventEaten,
+			Weight:    eaten,
+			CreatedAt: now,
+			Meal:      entry.Meal,
+			MealDate:  entry.MealDate,
+			Shares:    []models.ConsumptionShare{{UserID: entry.UserID, Fraction: 1}},
+		})
+	}
+
+	result := *item
+	result.Entry = entry
+	return &result
+}
+
+// GetPantryItem retrieves a pantry item with its entry, or nil if there is none
+func (m *MemoryDB) GetPantryItem(ctx context.Context, id string) (*models.PantryItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.pantryItem(id), nil
+}
+
+// pantryItem returns a copy of an item of a live entry, or nil
+func (m *MemoryDB) pantryItem(id string) *models.PantryItem {
+	item, entry := m.items[id], m.entries[id]
+	if item == nil || entry == nil || entry.DeletedAt != nil {
+		return nil
+	}
+	result := *item
+	result.Entry = copyEntry(entry)
+	return &result
+}
+
+// ListPantryItems returns the household's items in stock, oldest first. With
+// includeEmpty, finished and discarded items are included too.
+func (m *MemoryDB) ListPantryItems(ctx context.Context, householdID string, includeEmpty bool) ([]*models.PantryItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var results []*models.PantryItem
+	for id := range m.items {
+		item := m.pantryItem(id)
+		if item == nil || !m.memberOf(item.Entry.UserID, householdID) {
+			continue
+		}
+		if includeEmpty || item.Status == models.PantryInStock {
+			results = append(results, item)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].CreatedAt.Before(results[j].CreatedAt) })
+	return results, nil
+}
+
+// LogPantryEvent takes grams out of a pantry item and returns the updated item
+func (m *MemoryDB) LogPantryEvent(ctx context.Context, event *models.PantryEvent) (*models.PantryItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkStock(event); err != nil {
+		return nil, err
+	}
+	m.takeFromPantry(event)
+	return m.pantryItem(event.ItemID), nil
+}
+
+// checkStock returns why an event cannot be taken out of its item, if it cannot
+func (m *MemoryDB) checkStock(event *models.PantryEvent) error {
+	if m.pantryItem(event.ItemID) == nil {
+		return ErrNotFound
+	}
+	item := m.items[event.ItemID]
+	if item.Status != models.PantryInStock {
+		return ErrNotInStock
+	}
+	if event.Weight > item.RemainingWeight+stockEpsilon {
+		return ErrNotEnoughStock
+	}
+	return nil
+}
+
+// takeFromPantry logs an event that checkStock accepted
+func (m *MemoryDB) takeFromPantry(event *models.PantryEvent) {
+	item := m.items[event.ItemID]
+	remaining, status := item.RemainingWeight-event.Weight, item.Status
+	if remaining <= stockEpsilon {
+		remaining = 0
+		status = models.PantryFinished
+		if event.Kind == models.PantryEventDiscarded {
+			status = models.PantryDiscarded
+		}
+	}
+
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	m.addEvent(event)
+
+	item.RemainingWeight = remaining
+	item.Status = status
+	item.UpdatedAt = event.CreatedAt
+
+	if event.Kind == models.PantryEventEaten {
+		entry := m.entries[event.ItemID]
+		consumed := event.Weight
+		if entry.ConsumedWeight != nil {
+			consumed += *entry.ConsumedWeight
+		}
+		entry.ConsumedWeight = &consumed
+		entry.UpdatedAt = event.CreatedAt
+	}
+}
+
+// addEvent stores a pantry event, and its shares when it was eaten
+func (m *MemoryDB) addEvent(event *models.PantryEvent) {
+	saved := *event
+	saved.Shares = nil
+	m.events[event.ID] = &saved
+	m.eatenAt[event.ID] = models.EatenAt(event.CreatedAt, event.MealDate, m.location(event.UserID))
+
+	if event.Kind == models.PantryEventEaten {
+		for _, share := range event.Shares {
+			m.shares = append(m.shares, memoryShare{entryID: event.ItemID, eventID: event.ID, ConsumptionShare: share})
+		}
+	}
+}
+
+// resizePantryItem follows a correction of the total weight of an entry,
+// keeping the grams already taken out of its pantry item. It reports whether
+// the entry has a pantry item whose size changed.
+func (m *MemoryDB) resizePantryItem(id string, totalWeight float64) bool {
+	item := m.items[id]
+	if item == nil || item.InitialWeight == totalWeight {
+		return false
+	}
+	if item.Status == models.PantryInStock {
+		item.RemainingWeight += totalWeight - item.InitialWeight
+		if item.RemainingWeight <= stockEpsilon {
+			item.RemainingWeight = 0
+			item.Status = models.PantryFinished
+		}
+	}
+	item.InitialWeight = totalWeight
+	item.UpdatedAt = time.Now()
+	return true
+}
+
+// SumPantryActivity adds up, for each period, what the household bought,
+// ate, discarded and cooked from its pantry
+func (m *MemoryDB) SumPantryActivity(ctx context.Context, householdID string, periods []Period) ([]*models.PantryActivity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var bought []memoryRow
+	for _, item := range m.items {
+		n := m.entries[item.ID]
+		if n != nil && n.DeletedAt == nil && m.memberOf(n.UserID, householdID) {
+			bought = append(bought, memoryRow{item.CreatedAt, "", item.InitialWeight, n})
+		}
+	}
+
+	purchased, err := sumRows(periods, bought)
+	if err != nil {
+		return nil, err
+	}
+	eaten, _ := sumRows(periods, m.eventRows(householdID, models.PantryEventEaten))
+	discarded, _ := sumRows(periods, m.eventRows(householdID, models.PantryEventDiscarded))
+	cooked, _ := sumRows(periods, m.eventRows(householdID, models.PantryEventCooked))
+
+	results := make([]*models.PantryActivity, len(periods))
+	for i, p := range periods {
+		results[i] = &models.PantryActivity{
+			PeriodStart: p.Start,
+			PeriodEnd:   p.End,
+			Purchased:   purchased[i],
+			Eaten:       eaten[i],
+			Discarded:   discarded[i],
+			Cooked:      cooked[i],
+		}
+	}
+	return results, nil
+}
+
+// EnsureDefaultUser returns the oldest user, creating a household with one
+// member on a database without users
+func (m *MemoryDB) EnsureDefaultUser(ctx context.Context) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if users := m.sortedUsers(""); len(users) > 0 {
+		return users[0], nil
+	}
+
+	now := time.Now()
+	household := models.Household{ID: uuid.New().String(), Name: defaultHouseholdName, CreatedAt: now}
+	user := models.User{ID: uuid.New().String(), HouseholdID: household.ID, Name: defaultUserName, CreatedAt: now}
+	m.households[household.ID] = household
+	m.users[user.ID] = &memoryUser{User: user}
+	m.adoptUnownedRows(user.ID)
+
+	log.Printf("Created default household %q with user %q", household.Name, user.Name)
+	return &user, nil
+}
+
+// adoptUnownedRows is the counterpart of the SQL function of the same name
+func (m *MemoryDB) adoptUnownedRows(userID string) {
+	for _, n := range m.entries {
+		if n.UserID == "" {
+			n.UserID = userID
+		}
+	}
+	for _, e := range m.events {
+		if e.UserID == "" {
+			e.UserID = userID
+		}
+	}
+
+	sharedEntries, sharedEvents := make(map[string]bool), make(map[string]bool)
+	for _, sh := range m.shares {
+		sharedEntries[sh.entryID] = true
+		sharedEvents[sh.eventID] = true
+	}
+	share := models.ConsumptionShare{UserID: userID, Fraction: 1}
+	for _, n := range m.entries {
+		if m.items[n.ID] == nil && !sharedEntries[n.ID] {
+			m.shares = append(m.shares, memoryShare{entryID: n.ID, ConsumptionShare: share})
+		}
+	}
+	for _, e := range m.events {
+		if e.Kind == models.PantryEventEaten && !sharedEvents[e.ID] {
+			m.shares = append(m.shares, memoryShare{entryID: e.ItemID, eventID: e.ID, ConsumptionShare: share})
+		}
+	}
+}
+
+// GetUser retrieves a user, or nil if there is none
+func (m *MemoryDB) GetUser(ctx context.Context, id string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u := m.users[id]
+	if u == nil {
+		return nil, nil
+	}
+	user := u.User
+	return &user, nil
+}
+
+// CreateUser adds a member to an existing household
+func (m *MemoryDB) CreateUser(ctx context.Context, user *models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.households[user.HouseholdID]; !ok {
+		return fmt.Errorf("error creating user: household %s: %w", user.HouseholdID, ErrNotFound)
+	}
+	if m.users[user.ID] != nil {
+		return fmt.Errorf("error creating user: %s already exists", user.ID)
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	m.users[user.ID] = &memoryUser{User: *user}
+	return nil
+}
+
+// ListUsers returns the members of a household in the order they joined
+func (m *MemoryDB) ListUsers(ctx context.Context, householdID string) ([]*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.sortedUsers(householdID), nil
+}
+
+// sortedUsers returns copies of the members of a household, or of every user
+// when householdID is empty, in the order they joined
+func (m *MemoryDB) sortedUsers(householdID string) []*models.User {
+	var users []*models.User
+	for _, u := range m.users {
+		if householdID == "" || u.HouseholdID == householdID {
+			user := u.User
+			users = append(users, &user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.Before(users[j].CreatedAt)
+		}
+		return users[i].ID < users[j].ID
+	})
+	return users
+}
+
+// GetHousehold retrieves a household, or nil if there is none
+func (m *MemoryDB) GetHousehold(ctx context.Context, id string) (*models.Household, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	household, ok := m.households[id]
+	if !ok {
+		return nil, nil
+	}
+	return &household, nil
+}
+
+// SetConsumptionShares replaces who ate an entry that was eaten straight away
+func (m *MemoryDB) SetConsumptionShares(ctx context.Context, entryID string, shares []models.ConsumptionShare) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.replaceShares(entryID, shares)
+	return nil
+}
+
+// replaceShares replaces the shares of a whole entry
+func (m *MemoryDB) replaceShares(entryID string, shares []models.ConsumptionShare) {
+	kept := m.shares[:0]
+	for _, sh := range m.shares {
+		if sh.entryID != entryID || sh.eventID != "" {
+			kept = append(kept, sh)
+		}
+	}
+	m.shares = kept
+	for _, share := range shares {
+		m.shares = append(m.shares, memoryShare{entryID: entryID, ConsumptionShare: share})
+	}
+}
+
+// SetTimezone changes the time zone a user's days are counted in. What they
+// logged for a diary day keeps counting on that day.
+func (m *MemoryDB) SetTimezone(ctx context.Context, userID, timezone string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u := m.users[userID]
+	if u == nil {
+		return ErrNotFound
+	}
+	u.Timezone = timezone
+
+	for _, entry := range m.entries {
+		if entry.UserID == userID && entry.MealDate != "" {
+			entry.EatenAt = models.EatenAt(entry.CreatedAt, entry.MealDate, u.Location())
+			m.eatenAt[entry.ID] = entry.EatenAt
+		}
+	}
+	for _, event := range m.events {
+		if event.UserID == userID && event.MealDate != "" {
+			m.eatenAt[event.ID] = models.EatenAt(event.CreatedAt, event.MealDate, u.Location())
+		}
+	}
+	return nil
+}
+
+// location returns the time zone of a user, the server's for unknown users
+func (m *MemoryDB) location(userID string) *time.Location {
+	if u := m.users[userID]; u != nil {
+		return u.Location()
+	}
+	return time.Local
+}
+
+// memberOf reports whether a user belongs to a household
+func (m *MemoryDB) memberOf(userID, householdID string) bool {
+	u := m.users[userID]
+	return u != nil && u.HouseholdID == householdID
+}
+
+// ateFrom reports whether a user has a share of an entry
+func (m *MemoryDB) ateFrom(userID, entryID string) bool {
+	for _, sh := range m.shares {
+		if sh.entryID == entryID && sh.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// GetGoals retrieves a user's goals, or nil if they have none
+func (m *MemoryDB) GetGoals(ctx context.Context, userID string) (*models.Goals, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	goals := m.goals[userID]
+	if goals == nil {
+		return nil, nil
+	}
+	return copyGoals(goals), nil
+}
+
+// SaveGoals replaces a user's goals
+func (m *MemoryDB) SaveGoals(ctx context.Context, goals *models.Goals) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if goals.UpdatedAt.IsZero() {
+		goals.UpdatedAt = time.Now()
+	}
+	m.goals[goals.UserID] = copyGoals(goals)
+	return nil
+}
+
+// DeleteGoals removes a user's goals
+func (m *MemoryDB) DeleteGoals(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.goals[userID] == nil {
+		return ErrNotFound
+	}
+	delete(m.goals, userID)
+	return nil
+}
+
+// GetBodyProfile retrieves a user's body profile, or nil if they have none
+func (m *MemoryDB) GetBodyProfile(ctx context.Context, userID string) (*models.BodyProfile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	profile, ok := m.profiles[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &profile, nil
+}
+
+// SaveBodyProfile creates or replaces a user's body profile. Like the SQL
+// databases, only the calendar date of the birth date is kept.
+func (m *MemoryDB) SaveBodyProfile(ctx context.Context, p *models.BodyProfile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if p.UpdatedAt.IsZero() {
+		p.UpdatedAt = time.Now()
+	}
+	saved := *p
+	saved.BirthDate = time.Date(p.BirthDate.Year(), p.BirthDate.Month(), p.BirthDate.Day(), 0, 0, 0, 0, time.UTC)
+	m.profiles[p.UserID] = saved
+	return nil
+}
+
+// AddBodyMetric records a set of body measurements
+func (m *MemoryDB) AddBodyMetric(ctx context.Context, metric *models.BodyMetric) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.metrics[metric.ID] != nil {
+		return fmt.Errorf("error saving body metric: %s already exists", metric.ID)
+	}
+	if metric.CreatedAt.IsZero() {
+		metric.CreatedAt = time.Now()
+	}
+	m.metrics[metric.ID] = copyMetric(metric)
+	return nil
+}
+
+// ListBodyMetrics returns a user's measurements taken in [from, to), oldest first
+func (m *MemoryDB) ListBodyMetrics(ctx context.Context, userID string, from, to time.Time) ([]*models.BodyMetric, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var results []*models.BodyMetric
+	for _, metric := range m.metrics {
+		if metric.UserID == userID && !metric.MeasuredAt.Before(from) && metric.MeasuredAt.Before(to) {
+			results = append(results, copyMetric(metric))
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].MeasuredAt.Equal(results[j].MeasuredAt) {
+			return results[i].MeasuredAt.Before(results[j].MeasuredAt)
+		}
+		return results[i].ID < results[j].ID
+	})
+	return results, nil
+}
+
+// DeleteBodyMetric removes one of a user's measurements
+func (m *MemoryDB) DeleteBodyMetric(ctx context.Context, userID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metric := m.metrics[id]
+	if metric == nil || metric.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.metrics, id)
+	return nil
+}
+
+// SaveRecipe creates or replaces a recipe and its ingredients. The household,
+// author and creation time of an existing recipe are kept.
+func (m *MemoryDB) SaveRecipe(ctx context.Context, r *models.Recipe) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ing := range r.Ingredients {
+		if m.entries[ing.EntryID] == nil {
+			return fmt.Errorf("error saving ingredient: entry %s: %w", ing.EntryID, ErrNotFound)
+		}
+	}
+
+	now := time.Now()
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = now
+	}
+	r.UpdatedAt = now
+
+	saved := &models.Recipe{
+		ID:          r.ID,
+		HouseholdID: r.HouseholdID,
+		Name:        r.Name,
+		YieldWeight: r.YieldWeight,
+		Servings:    r.Servings,
+		CreatedBy:   r.CreatedBy,
+		CreatedAt:   r.CreatedAt,
+		UpdatedAt:   r.UpdatedAt,
+	}
+	if old := m.recipes[r.ID]; old != nil {
+		saved.HouseholdID = old.HouseholdID
+		saved.CreatedBy = old.CreatedBy
+		saved.CreatedAt = old.CreatedAt
+		saved.DeletedAt = old.DeletedAt
+	}
+	for _, ing := range r.Ingredients {
+		saved.Ingredients = append(saved.Ingredients, models.RecipeIngredient{EntryID: ing.EntryID, Weight: ing.Weight})
+	}
+	m.recipes[r.ID] = saved
+	return nil
+}
+
+// GetRecipe retrieves a recipe with its ingredients' entries, or nil if there
+// is none. Deleted recipes are returned with DeletedAt set.
+func (m *MemoryDB) GetRecipe(ctx context.Context, id string) (*models.Recipe, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.recipes[id]
+	if r == nil {
+		return nil, nil
+	}
+	return m.copyRecipe(r), nil
+}
+
+// ListRecipes returns the recipes of a household by name, without deleted ones
+func (m *MemoryDB) ListRecipes(ctx context.Context, householdID string) ([]*models.Recipe, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var results []*models.Recipe
+	for _, r := range m.recipes {
+		if r.HouseholdID == householdID && r.DeletedAt == nil {
+			results = append(results, m.copyRecipe(r))
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := strings.ToLower(results[i].Name), strings.ToLower(results[j].Name)
+		if a != b {
+			return a < b
+		}
+		return results[i].ID < results[j].ID
+	})
+	return results, nil
+}
+
+// DeleteRecipe soft-deletes a recipe; entries logged from it keep referring to it
+func (m *MemoryDB) DeleteRecipe(ctx context.Context, householdID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.recipes[id]
+	if r == nil || r.HouseholdID != householdID || r.DeletedAt != nil {
+		return ErrNotFound
+	}
+	now := time.Now()
+	r.DeletedAt = &now
+	r.UpdatedAt = now
+	return nil
+}
+
+// LogRecipe saves an entry logged from a recipe with its shares and takes up
+// to the Weight of each cooked event out of the pantry, lowering it to what
+// was taken
+func (m *MemoryDB) LogRecipe(ctx context.Context, entry *models.NutritionalInfo, shares []models.ConsumptionShare, cooked []*models.PantryEvent) ([]*models.PantryItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.saveEntry(entry)
+	m.replaceShares(entry.ID, shares)
+
+	var items []*models.PantryItem
+	for _, event := range cooked {
+		item := m.pantryItem(event.ItemID)
+		if item == nil || item.Status != models.PantryInStock {
+			event.Weight = 0
+			continue
+		}
+		event.Weight = min(event.Weight, item.RemainingWeight)
+		m.takeFromPantry(event)
+		items = append(items, m.pantryItem(event.ItemID))
+	}
+	return items, nil
+}
+
+// copyRecipe returns a copy of a stored recipe with its ingredients' entries
+func (m *MemoryDB) copyRecipe(r *models.Recipe) *models.Recipe {
+	result := *r
+	result.Ingredients = nil
+	if r.DeletedAt != nil {
+		deleted := *r.DeletedAt
+		result.DeletedAt = &deleted
+	}
+	for _, ing := range r.Ingredients {
+		if entry := m.entries[ing.EntryID]; entry != nil {
+			ing.Entry = copyEntry(entry)
+			result.Ingredients = append(result.Ingredients, ing)
+		}
+	}
+	return &result
+}
+
+// SetCredentials sets the login and password hash a user signs in with
+func (m *MemoryDB) SetCredentials(ctx context.Context, userID, login, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Login == login && u.ID != userID {
+			return ErrLoginTaken
+		}
+	}
+	u := m.users[userID]
+	if u == nil {
+		return ErrNotFound
+	}
+	u.Login = login
+	u.passwordHash = passwordHash
+	return nil
+}
+
+// GetCredentials returns the user with a login and their password hash, or nil
+// if no user has that login
+func (m *MemoryDB) GetCredentials(ctx context.Context, login string) (*models.User, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Login == login {
+			user := u.User
+			return &user, u.passwordHash, nil
+		}
+	}
+	return nil, "", nil
+}
+
+// SaveToken stores a new session or API key
+func (m *MemoryDB) SaveToken(ctx context.Context, token *models.AuthToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tokens {
+		if t.ID == token.ID || t.Hash == token.Hash {
+			return fmt.Errorf("error saving token: token already exists")
+		}
+	}
+	m.tokens[token.ID] = copyToken(token)
+	return nil
+}
+
+// GetTokenByHash looks up a token by the hash of its secret and records that
+// it was used. It returns nil if there is no such token.
+func (m *MemoryDB) GetTokenByHash(ctx context.Context, hash string) (*models.AuthToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, t := range m.tokens {
+		if t.Hash == hash {
+			now := time.Now()
+			t.LastUsedAt = &now
+			m.tokens[id] = t
+			result := copyToken(&t)
+			return &result, nil
+		}
+	}
+	return nil, nil
+}
+
+// ListTokens returns the sessions and API keys of a user, newest first
+func (m *MemoryDB) ListTokens(ctx context.Context, userID string) ([]*models.AuthToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var results []*models.AuthToken
+	for _, t := range m.tokens {
+		if t.UserID == userID {
+			token := copyToken(&t)
+			results = append(results, &token)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].CreatedAt.Equal(results[j].CreatedAt) {
+			return results[i].CreatedAt.After(results[j].CreatedAt)
+		}
+		return results[i].ID < results[j].ID
+	})
+	return results, nil
+}
+
+// DeleteToken revokes one of a user's tokens
+func (m *MemoryDB) DeleteToken(ctx context.Context, userID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tokens[id]
+	if !ok || t.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.tokens, id)
+	return nil
+}
+
+// The copy functions below keep callers from changing stored values through
+// the pointers they are given or handed back
+
+func copyEntry(n *models.NutritionalInfo) *models.NutritionalInfo {
+	c := *n
+	c.ConsumedWeight = copyFloat(n.ConsumedWeight)
+	if n.DeletedAt != nil {
+		deleted := *n.DeletedAt
+		c.DeletedAt = &deleted
+	}
+	return &c
+}
+
+func copyScan(s *models.NutritionScan) *models.NutritionScan {
+	c := *s
+	c.ModelOutput = copyOutput(s.ModelOutput)
+	c.ConfirmedOutput = copyOutput(s.ConfirmedOutput)
+	c.Corrections = nil
+	return &c
+}
+
+func copyOutput(o *models.ModelOutput) *models.ModelOutput {
+	if o == nil {
+		return nil
+	}
+	c := *o
+	return &c
+}
+
+func copyGoals(g *models.Goals) *models.Goals {
+	c := *g
+	c.Weekday = copyTarget(g.Weekday)
+	if g.Weekend != nil {
+		weekend := copyTarget(*g.Weekend)
+		c.Weekend = &weekend
+	}
+	return &c
+}
+
+func copyTarget(t models.DailyTarget) models.DailyTarget {
+	macro := func(m *models.MacroTarget) *models.MacroTarget {
+		if m == nil || (m.Grams == nil && m.Percent == nil) {
+			return nil
+		}
+		return &models.MacroTarget{Grams: copyFloat(m.Grams), Percent: copyFloat(m.Percent)}
+	}
+	return models.DailyTarget{
+		Calories: copyFloat(t.Calories),
+		Protein:  macro(t.Protein),
+		Carbs:    macro(t.Carbs),
+		Fat:      macro(t.Fat),
+		FiberMin: copyFloat(t.FiberMin),
+		SugarMax: copyFloat(t.SugarMax),
+	}
+}
+
+func copyMetric(metric *models.BodyMetric) *models.BodyMetric {
+	c := *metric
+	c.WeightKg = copyFloat(metric.WeightKg)
+	c.WaistCm = copyFloat(metric.WaistCm)
+	c.BodyFatPercent = copyFloat(metric.BodyFatPercent)
+	return &c
+}
+
+func copyToken(t *models.AuthToken) models.AuthToken {
+	c := *t
+	if t.ExpiresAt != nil {
+		expires := *t.ExpiresAt
+		c.ExpiresAt = &expires
+	}
+	if t.LastUsedAt != nil {
+		used := *t.LastUsedAt
+		c.LastUsedAt = &used
+	}
+	return c
+}
+
+func copyFloat(f *float64) *float64 {
+	if f == nil {
+		return nil
+	}
+	v := *f
+	return &v
+}
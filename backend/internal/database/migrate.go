@@ -0,0 +1,313 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/*/*.sql
+var migrationsFS embed.FS
+
+// migrationFile matches migration file names such as 0002_add_recipes.up.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one numbered step of the schema, with the SQL applying it and
+// the SQL undoing it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus tells whether a migration has been applied to a database
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies migrations to a database and rolls them back. Each
+// migration runs in its own transaction together with the update of the
+// schema_version table, so a failing migration leaves no trace.
+//
+// SQLite and PostgreSQL each have their own scripts, numbered alike so that a
+// version means the same schema on both.
+type Migrator struct {
+	db         *sqlConn
+	migrations []Migration
+}
+
+// NewMigrator returns a migrator for the migrations embedded in the binary
+func NewMigrator(s *SQLDB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationsFS, s.db.dialect.migrations)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: s.db, migrations: migrations}, nil
+}
+
+// Latest returns the version the last known migration brings the schema to
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version of the database schema, 0 for an empty database
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return 0, err
+	}
+	var version int
+	err := m.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("error reading schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Status lists every known migration and when it was applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := m.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema versions: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		if applied[version], err = parseTimestamp(appliedAt); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		status[i].Migration = migration
+		if t, ok := applied[migration.Version]; ok {
+			status[i].AppliedAt = &t
+		}
+	}
+	return status, nil
+}
+
+// Up applies the migrations after the current version up to and including
+// target, and returns those it applied
+func (m *Migrator) Up(ctx context.Context, target int) ([]Migration, error) {
+	current, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if current > m.Latest() {
+		return nil, fmt.Errorf("database schema version %d is newer than this program supports (%d)", current, m.Latest())
+	}
+
+	var applied []Migration
+	for _, migration := range m.migrations {
+		if migration.Version <= current || migration.Version > target {
+			continue
+		}
+		err := m.apply(ctx, migration.Up, func(tx *sqlTx) error {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)
+			`, migration.Version, migration.Name, time.Now())
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("error applying migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		log.Printf("Applied migration %d_%s", migration.Version, migration.Name)
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// Down rolls back the applied migrations above target, newest first, and
+// returns those it rolled back
+func (m *Migrator) Down(ctx context.Context, target int) ([]Migration, error) {
+	current, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rolledBack []Migration
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version > current || migration.Version <= target {
+			continue
+		}
+		err := m.apply(ctx, migration.Down, func(tx *sqlTx) error {
+			_, err := tx.ExecContext(ctx, `DELETE FROM schema_version WHERE version = ?`, migration.Version)
+			return err
+		})
+		if err != nil {
+			return rolledBack, fmt.Errorf("error rolling back migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		log.Printf("Rolled back migration %d_%s", migration.Version, migration.Name)
+		rolledBack = append(rolledBack, migration)
+	}
+	return rolledBack, nil
+}
+
+// apply runs a migration script and record in one transaction. On SQLite,
+// foreign keys are off while it runs so that tables can be rebuilt, as SQLite
+// requires to change a column; they are checked before committing instead.
+// PostgreSQL changes columns in place and checks foreign keys as it goes.
+func (m *Migrator) apply(ctx context.Context, script string, record func(*sqlTx) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Foreign keys cannot be switched off inside a transaction
+	if m.db.dialect == sqlite {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.Background(), "PRAGMA foreign_keys = ON")
+	}
+
+	t, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+	tx := &sqlTx{Tx: t, dialect: m.db.dialect}
+
+	// Scripts are run as they are written, without placeholders
+	if _, err := tx.Tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+
+	if m.db.dialect == sqlite {
+		rows, err := tx.QueryContext(ctx, "PRAGMA foreign_key_check")
+		if err != nil {
+			return err
+		}
+		violation := rows.Next()
+		rows.Close()
+		if violation {
+			return fmt.Errorf("migration leaves rows with broken foreign keys")
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ensureVersionTable creates the schema_version table. SQLite databases
+// created before migrations existed are brought up to the first migration and
+// recorded as such.
+func (m *Migrator) ensureVersionTable(ctx context.Context) error {
+	var exists int
+	err := m.db.QueryRowContext(ctx, m.db.dialect.hasTable, "schema_version").Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error looking for schema_version: %w", err)
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	_, err = m.db.ExecContext(ctx, `
+		CREATE TABLE schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_version: %w", err)
+	}
+
+	// PostgreSQL support came after migrations
+	if m.db.dialect != sqlite {
+		return nil
+	}
+	legacy, err := isLegacyDatabase(ctx, m.db.DB)
+	if err != nil || !legacy {
+		return err
+	}
+	log.Println("Upgrading database created before schema migrations")
+	if err := upgradeLegacySchema(ctx, m.db.DB, m.migrations[0].Up); err != nil {
+		return fmt.Errorf("error upgrading legacy database: %w", err)
+	}
+	_, err = m.db.ExecContext(ctx, `
+		INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)
+	`, m.migrations[0].Version, m.migrations[0].Name, time.Now())
+	return err
+}
+
+// loadMigrations reads the migrations in dir of fsys, sorted by version.
+// Versions must be numbered from 1 without gaps and each needs a down script.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", entry.Name(), err)
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down script", migration.Version, migration.Name)
+		}
+	}
+	return migrations, nil
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/franckalain/nutritionalvalue/internal/config"
+)
+
+func TestMigrationsMatch(t *testing.T) {
+	sqliteMigrations, err := loadMigrations(migrationsFS, sqlite.migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postgresMigrations, err := loadMigrations(migrationsFS, postgres.migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sqliteMigrations) != len(postgresMigrations) {
+		t.Fatalf("SQLite has %d migrations, PostgreSQL %d", len(sqliteMigrations), len(postgresMigrations))
+	}
+	for i, m := range sqliteMigrations {
+		if m.Name != postgresMigrations[i].Name {
+			t.Errorf("migration %d is %s on SQLite but %s on PostgreSQL", m.Version, m.Name, postgresMigrations[i].Name)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	got := postgres.rebind(`SELECT '?' FROM t WHERE a = ? AND b IN (?, ?)`)
+	want := `SELECT '?' FROM t WHERE a = $1 AND b IN ($2, $3)`
+	if got != want {
+		t.Errorf("rebind = %q, want %q", got, want)
+	}
+	if got := sqlite.rebind(`a = ?`); got != `a = ?` {
+		t.Errorf("SQLite query rewritten to %q", got)
+	}
+}
+
+func TestUTCTimestampsMigration(t *testing.T) {
+	ctx := context.Background()
+	db, err := Open(config.DatabaseConfig{Type: "sqlite", Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows as earlier releases wrote them, bypassing bindArgs
+	for _, query := range []string{
+		`INSERT INTO households (id, name, created_at) VALUES ('h', 'Home', '2024-03-10 08:30:00.5 +0100 CET m=+0.001965468')`,
+		`INSERT INTO users (id, household_id, name, created_at) VALUES ('u', 'h', 'Me', '2024-03-10 08:30:00 -0500 -0500')`,
+		`INSERT INTO nutritional_info (id, user_id, total_weight, calories, protein, carbs, fat, fiber, sugar, meal_date, created_at, updated_at, deleted_at)
+			VALUES ('late', 'u', 100, 100, 0, 0, 0, 0, 0, '2024-03-10', '2024-03-10 23:30:00.123456789 -0500 EST', '2024-03-10 23:30:00+01:00', '2024-03-11T08:00:00Z')`,
+		`INSERT INTO nutritional_info (id, user_id, total_weight, calories, protein, carbs, fat, fiber, sugar, meal_date, created_at, updated_at)
+			VALUES ('moved', 'u', 100, 100, 0, 0, 0, 0, 0, '2024-03-09', '2024-03-10 08:00:00 +0100 CET', '2024-03-10 08:00:00 +0100 CET')`,
+	} {
+		if _, err := db.db.DB.ExecContext(ctx, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := migrator.Up(ctx, migrator.Latest()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct{ query, want string }{
+		{`SELECT created_at FROM households`, "2024-03-10T07:30:00.500000000Z"},
+		{`SELECT created_at FROM users`, "2024-03-10T13:30:00.000000000Z"},
+		{`SELECT created_at FROM nutritional_info WHERE id = 'late'`, "2024-03-11T04:30:00.123456789Z"},
+		{`SELECT updated_at FROM nutritional_info WHERE id = 'late'`, "2024-03-10T22:30:00.000000000Z"},
+		{`SELECT deleted_at FROM nutritional_info WHERE id = 'late'`, "2024-03-11T08:00:00.000000000Z"},
+		// Logged on its meal date in the server's time zone
+		{`SELECT eaten_at FROM nutritional_info WHERE id = 'late'`, "2024-03-11T04:30:00.123456789Z"},
+		// Assigned to the day before
+		{`SELECT eaten_at FROM nutritional_info WHERE id = 'moved'`, "2024-03-09T12:00:00.000000000Z"},
+	} {
+		var got string
+		if err := db.db.QueryRowContext(ctx, c.query).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%s = %q, want %q", c.query, got, c.want)
+		}
+	}
+
+	var count int
+	if err := db.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_version WHERE applied_at NOT LIKE '%Z'`).Scan(&count); err != nil || count != 0 {
+		t.Errorf("%d migrations recorded with a local time (%v)", count, err)
+	}
+}
+
+// notExported lists the tables and columns exports leave out on purpose
+var notExported = map[string][]string{
+	"schema_version":  nil,
+	"auth_tokens":     nil,
+	"users":           {"login", "password_hash", "imported_at"},
+	"nutrition_scans": {"image_data"},
+}
+
+func TestExportCoversSchema(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.db.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	for _, table := range tables {
+		skipped, ok := notExported[table]
+		if ok && skipped == nil {
+			continue
+		}
+		exported := findExportTable(table)
+		if exported == nil {
+			t.Errorf("table %s is not exported", table)
+			continue
+		}
+
+		want := make(map[string]bool)
+		for _, name := range exported.columnNames() {
+			want[name] = true
+		}
+		for _, name := range skipped {
+			want[name] = true
+		}
+		rows, err := db.db.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for rows.Next() {
+			var column string
+			if err := rows.Scan(&column); err != nil {
+				t.Fatal(err)
+			}
+			if !want[column] {
+				t.Errorf("column %s.%s is not exported", table, column)
+			}
+			n++
+		}
+		rows.Close()
+		if n != len(want) {
+			t.Errorf("table %s has %d columns, exports know of %d", table, n, len(want))
+		}
+	}
+}
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS consumption_shares;
+DROP TABLE IF EXISTS pantry_events;
+DROP TABLE IF EXISTS pantry_items;
+DROP TABLE IF EXISTS nutrition_scans;
+DROP TABLE IF EXISTS recipe_ingredients;
+DROP TABLE IF EXISTS nutritional_info;
+DROP TABLE IF EXISTS recipes;
+DROP TABLE IF EXISTS body_metrics;
+DROP TABLE IF EXISTS body_profiles;
+DROP TABLE IF EXISTS goal_targets;
+DROP TABLE IF EXISTS auth_tokens;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS households;
@@ -0,0 +1,8 @@
+-- Rolling back loses data: image_path is dropped, so scans whose photo was
+-- moved to the image store no longer say which photo is theirs. The photos
+-- stay in the store, named by the SHA-256 of their content, but nothing in
+-- the database points to them any more; those scans are kept with an empty
+-- image. Take a backup before migrating down.
+UPDATE nutrition_scans SET image_data = '' WHERE image_data IS NULL;
+ALTER TABLE nutrition_scans ALTER COLUMN image_data SET NOT NULL;
+ALTER TABLE nutrition_scans DROP COLUMN image_path;
@@ -0,0 +1,5 @@
+-- Photos move out of the database into the image store. Scans refer to their
+-- photo by key; image_data only holds photos of older scans until they are
+-- moved.
+ALTER TABLE nutrition_scans ADD COLUMN image_path TEXT;
+ALTER TABLE nutrition_scans ALTER COLUMN image_data DROP NOT NULL;
@@ -0,0 +1,4 @@
+DROP INDEX idx_nutrition_scans_entry_id;
+ALTER TABLE nutrition_scans DROP COLUMN model_output;
+ALTER TABLE nutrition_scans DROP COLUMN model;
+ALTER TABLE nutrition_scans DROP COLUMN entry_id;
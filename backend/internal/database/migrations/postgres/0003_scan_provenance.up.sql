@@ -0,0 +1,17 @@
+-- A scan records the entry it created, the model that read the label and
+-- what the model proposed, as JSON, before the user confirmed or corrected it
+ALTER TABLE nutrition_scans ADD COLUMN entry_id TEXT REFERENCES nutritional_info(id);
+ALTER TABLE nutrition_scans ADD COLUMN model TEXT;
+ALTER TABLE nutrition_scans ADD COLUMN model_output TEXT;
+
+-- Scans saved since photos moved to the image store share their photo with
+-- their entry. Older scans cannot be told apart and stay unlinked.
+UPDATE nutrition_scans SET entry_id = (
+    SELECT n.id FROM nutritional_info n
+    WHERE n.image_path = nutrition_scans.image_path
+    ORDER BY n.created_at DESC
+    LIMIT 1
+)
+WHERE image_path IS NOT NULL;
+
+CREATE INDEX idx_nutrition_scans_entry_id ON nutrition_scans(entry_id);
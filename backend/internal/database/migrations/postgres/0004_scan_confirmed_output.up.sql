@@ -0,0 +1,19 @@
+-- The values the user confirmed for a scan, as JSON, to measure how far they
+-- are from what the model proposed
+ALTER TABLE nutrition_scans ADD COLUMN confirmed_output TEXT;
+
+-- Scans linked to their entry take the entry's values, which are what the
+-- user confirmed unless edited since
+UPDATE nutrition_scans SET confirmed_output = (
+    SELECT json_build_object(
+        'calories', n.calories,
+        'protein', n.protein,
+        'carbs', n.carbs,
+        'fat', n.fat,
+        'fiber', n.fiber,
+        'sugar', n.sugar
+    )::text
+    FROM nutritional_info n
+    WHERE n.id = nutrition_scans.entry_id
+)
+WHERE entry_id IS NOT NULL;
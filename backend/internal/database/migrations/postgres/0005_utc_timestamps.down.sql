@@ -0,0 +1,9 @@
+-- Timestamps stay in UTC, which earlier releases read too
+DROP INDEX idx_nutrition_scans_created_at;
+DROP INDEX idx_pantry_events_eaten_at;
+DROP INDEX idx_nutritional_info_eaten_at;
+DROP INDEX idx_nutritional_info_created_at;
+
+ALTER TABLE users DROP COLUMN timezone;
+ALTER TABLE pantry_events DROP COLUMN eaten_at;
+ALTER TABLE nutritional_info DROP COLUMN eaten_at;
@@ -0,0 +1,64 @@
+-- Timestamps were stored the way each driver formats times, in the server's
+-- time zone, so comparing them as text did not always compare the times. They
+-- are now stored in UTC with all nine fractional digits, as in
+-- 2024-03-10T07:30:00.000000000Z, which sorts as text in time order.
+
+-- Values written by the PostgreSQL driver carry their offset, as in
+-- 2024-03-10 09:30:00.123456789+02:00. The fraction is kept apart since
+-- timestamptz only has microseconds.
+CREATE FUNCTION pg_temp.utc_timestamp(value TEXT) RETURNS TEXT AS $$
+    SELECT to_char(regexp_replace(value, '\.\d+', '')::timestamptz AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS')
+        || '.' || rpad(COALESCE(substring(value FROM '\.(\d+)'), ''), 9, '0') || 'Z'
+$$ LANGUAGE SQL STRICT;
+
+-- When an entry or pantry event counts in history was worked out in queries
+-- from the local date of created_at, which UTC timestamps no longer give. It
+-- is now stored, computed in the user's time zone when the row is saved. Rows
+-- assigned to another day count at noon UTC that day, which is that day in
+-- nearly every time zone.
+ALTER TABLE nutritional_info ADD COLUMN eaten_at TEXT;
+ALTER TABLE pantry_events ADD COLUMN eaten_at TEXT;
+
+UPDATE nutritional_info SET eaten_at = CASE
+    WHEN meal_date IS NULL OR substr(created_at, 1, 10) = meal_date
+    THEN pg_temp.utc_timestamp(created_at)
+    ELSE meal_date || 'T12:00:00.000000000Z' END;
+UPDATE pantry_events SET eaten_at = CASE
+    WHEN meal_date IS NULL OR substr(created_at, 1, 10) = meal_date
+    THEN pg_temp.utc_timestamp(created_at)
+    ELSE meal_date || 'T12:00:00.000000000Z' END;
+
+ALTER TABLE nutritional_info ALTER COLUMN eaten_at SET NOT NULL;
+ALTER TABLE pantry_events ALTER COLUMN eaten_at SET NOT NULL;
+
+UPDATE households SET created_at = pg_temp.utc_timestamp(created_at);
+UPDATE users SET created_at = pg_temp.utc_timestamp(created_at);
+UPDATE auth_tokens SET created_at = pg_temp.utc_timestamp(created_at);
+UPDATE auth_tokens SET expires_at = pg_temp.utc_timestamp(expires_at);
+UPDATE auth_tokens SET last_used_at = pg_temp.utc_timestamp(last_used_at);
+UPDATE goal_targets SET updated_at = pg_temp.utc_timestamp(updated_at);
+UPDATE body_profiles SET updated_at = pg_temp.utc_timestamp(updated_at);
+UPDATE body_metrics SET measured_at = pg_temp.utc_timestamp(measured_at);
+UPDATE body_metrics SET created_at = pg_temp.utc_timestamp(created_at);
+UPDATE recipes SET created_at = pg_temp.utc_timestamp(created_at);
+UPDATE recipes SET updated_at = pg_temp.utc_timestamp(updated_at);
+UPDATE recipes SET deleted_at = pg_temp.utc_timestamp(deleted_at);
+UPDATE nutritional_info SET created_at = pg_temp.utc_timestamp(created_at);
+UPDATE nutritional_info SET updated_at = pg_temp.utc_timestamp(updated_at);
+UPDATE nutritional_info SET deleted_at = pg_temp.utc_timestamp(deleted_at);
+UPDATE nutrition_scans SET created_at = pg_temp.utc_timestamp(created_at);
+UPDATE nutrition_scans SET updated_at = pg_temp.utc_timestamp(updated_at);
+UPDATE pantry_items SET created_at = pg_temp.utc_timestamp(created_at);
+UPDATE pantry_items SET updated_at = pg_temp.utc_timestamp(updated_at);
+UPDATE pantry_events SET created_at = pg_temp.utc_timestamp(created_at);
+UPDATE schema_version SET applied_at = pg_temp.utc_timestamp(applied_at);
+
+DROP FUNCTION pg_temp.utc_timestamp(TEXT);
+
+-- Users may count their days in a time zone other than the server's
+ALTER TABLE users ADD COLUMN timezone TEXT;
+
+CREATE INDEX idx_nutritional_info_created_at ON nutritional_info(created_at);
+CREATE INDEX idx_nutritional_info_eaten_at ON nutritional_info(eaten_at);
+CREATE INDEX idx_pantry_events_eaten_at ON pantry_events(eaten_at);
+CREATE INDEX idx_nutrition_scans_created_at ON nutrition_scans(created_at);
@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN imported_at;
@@ -0,0 +1,171 @@
+-- Schema as of the introduction of migrations. Statements are idempotent so
+-- that databases created before then can be brought up to this version.
+
+-- Create households table
+CREATE TABLE IF NOT EXISTS households (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TEXT NOT NULL
+);
+
+-- Create users table
+CREATE TABLE IF NOT EXISTS users (
+    id TEXT PRIMARY KEY,
+    household_id TEXT NOT NULL REFERENCES households(id),
+    name TEXT NOT NULL,
+    login TEXT,
+    password_hash TEXT,
+    created_at TEXT NOT NULL
+);
+
+-- Create auth_tokens table; only a hash of each session token or API key is kept
+CREATE TABLE IF NOT EXISTS auth_tokens (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    kind TEXT NOT NULL CHECK(kind IN ('session', 'api_key')),
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TEXT NOT NULL,
+    expires_at TEXT,
+    last_used_at TEXT
+);
+
+-- Create goal_targets table; every user with goals has a weekday row and may
+-- have a weekend row. Macros are given in grams or as a percentage of calories.
+CREATE TABLE IF NOT EXISTS goal_targets (
+    user_id TEXT NOT NULL REFERENCES users(id),
+    day_type TEXT NOT NULL CHECK(day_type IN ('weekday', 'weekend')),
+    calories REAL,
+    protein_grams REAL,
+    protein_percent REAL,
+    carbs_grams REAL,
+    carbs_percent REAL,
+    fat_grams REAL,
+    fat_percent REAL,
+    fiber_min REAL,
+    sugar_max REAL,
+    updated_at TEXT NOT NULL,
+    PRIMARY KEY (user_id, day_type)
+);
+
+-- Create body_profiles table; birth_date is a YYYY-MM-DD date
+CREATE TABLE IF NOT EXISTS body_profiles (
+    user_id TEXT PRIMARY KEY REFERENCES users(id),
+    sex TEXT NOT NULL CHECK(sex IN ('male', 'female')),
+    birth_date TEXT NOT NULL,
+    height_cm REAL NOT NULL,
+    weight_kg REAL NOT NULL,
+    activity_level TEXT NOT NULL,
+    plan TEXT NOT NULL,
+    formula TEXT NOT NULL,
+    auto_goals INTEGER NOT NULL DEFAULT 0,
+    updated_at TEXT NOT NULL
+);
+
+-- Create body_metrics table
+CREATE TABLE IF NOT EXISTS body_metrics (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id),
+    measured_at TEXT NOT NULL,
+    weight_kg REAL,
+    waist_cm REAL,
+    body_fat_percent REAL,
+    created_at TEXT NOT NULL
+);
+
+-- Create recipes table
+CREATE TABLE IF NOT EXISTS recipes (
+    id TEXT PRIMARY KEY,
+    household_id TEXT NOT NULL REFERENCES households(id),
+    name TEXT NOT NULL,
+    yield_weight REAL NOT NULL,
+    servings INTEGER NOT NULL,
+    created_by TEXT REFERENCES users(id),
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL,
+    deleted_at TEXT
+);
+
+-- Create nutritional_info table
+CREATE TABLE IF NOT EXISTS nutritional_info (
+    id TEXT PRIMARY KEY,
+    user_id TEXT REFERENCES users(id),
+    total_weight REAL NOT NULL,
+    consumed_weight REAL,
+    calories REAL NOT NULL,
+    protein REAL NOT NULL,
+    carbs REAL NOT NULL,
+    fat REAL NOT NULL,
+    fiber REAL NOT NULL,
+    sugar REAL NOT NULL,
+    image_path TEXT,
+    meal TEXT,
+    meal_date TEXT,
+    recipe_id TEXT REFERENCES recipes(id),
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL,
+    deleted_at TEXT
+);
+
+-- Create recipe_ingredients table; entries are the scanned products used
+CREATE TABLE IF NOT EXISTS recipe_ingredients (
+    recipe_id TEXT NOT NULL REFERENCES recipes(id),
+    position INTEGER NOT NULL,
+    entry_id TEXT NOT NULL REFERENCES nutritional_info(id),
+    weight REAL NOT NULL CHECK(weight > 0),
+    PRIMARY KEY (recipe_id, position)
+);
+
+-- Create nutrition_scans table
+CREATE TABLE IF NOT EXISTS nutrition_scans (
+    id TEXT PRIMARY KEY,
+    image_data BLOB NOT NULL,
+    status TEXT NOT NULL CHECK(status IN ('pending', 'processing', 'completed', 'failed')),
+    error TEXT,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+
+-- Create pantry_items table; an item shares its id with the entry it was bought as
+CREATE TABLE IF NOT EXISTS pantry_items (
+    id TEXT PRIMARY KEY REFERENCES nutritional_info(id),
+    initial_weight REAL NOT NULL,
+    remaining_weight REAL NOT NULL,
+    status TEXT NOT NULL CHECK(status IN ('in_stock', 'finished', 'discarded')),
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+
+-- Create pantry_events table
+CREATE TABLE IF NOT EXISTS pantry_events (
+    id TEXT PRIMARY KEY,
+    item_id TEXT NOT NULL REFERENCES pantry_items(id),
+    user_id TEXT REFERENCES users(id),
+    kind TEXT NOT NULL CHECK(kind IN ('eaten', 'discarded', 'cooked')),
+    weight REAL NOT NULL,
+    meal TEXT,
+    meal_date TEXT,
+    created_at TEXT NOT NULL
+);
+
+-- Create consumption_shares table; a share without event_id covers an entry
+-- eaten straight away, one with event_id covers an eaten pantry event
+CREATE TABLE IF NOT EXISTS consumption_shares (
+    id TEXT PRIMARY KEY,
+    entry_id TEXT NOT NULL REFERENCES nutritional_info(id),
+    event_id TEXT REFERENCES pantry_events(id),
+    user_id TEXT NOT NULL REFERENCES users(id),
+    fraction REAL NOT NULL CHECK(fraction > 0 AND fraction <= 1)
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_nutrition_scans_status ON nutrition_scans(status);
+CREATE INDEX IF NOT EXISTS idx_pantry_items_status ON pantry_items(status);
+CREATE INDEX IF NOT EXISTS idx_pantry_events_item_id ON pantry_events(item_id);
+CREATE INDEX IF NOT EXISTS idx_users_household_id ON users(household_id);
+CREATE INDEX IF NOT EXISTS idx_consumption_shares_user_id ON consumption_shares(user_id);
+CREATE INDEX IF NOT EXISTS idx_consumption_shares_entry_id ON consumption_shares(entry_id);
+CREATE INDEX IF NOT EXISTS idx_auth_tokens_user_id ON auth_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_body_metrics_user_measured ON body_metrics(user_id, measured_at);
+CREATE INDEX IF NOT EXISTS idx_recipes_household_id ON recipes(household_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_login ON users(login);
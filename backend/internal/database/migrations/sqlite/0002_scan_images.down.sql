@@ -0,0 +1,21 @@
+-- Rolling back loses data: image_path is dropped, so scans whose photo was
+-- moved to the image store no longer say which photo is theirs. The photos
+-- stay in the store, named by the SHA-256 of their content, but nothing in
+-- the database points to them any more; those scans are kept with an empty
+-- image. Take a backup before migrating down.
+CREATE TABLE nutrition_scans_old (
+    id TEXT PRIMARY KEY,
+    image_data BLOB NOT NULL,
+    status TEXT NOT NULL CHECK(status IN ('pending', 'processing', 'completed', 'failed')),
+    error TEXT,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+
+INSERT INTO nutrition_scans_old (id, image_data, status, error, created_at, updated_at)
+    SELECT id, COALESCE(image_data, X''), status, error, created_at, updated_at FROM nutrition_scans;
+
+DROP TABLE nutrition_scans;
+ALTER TABLE nutrition_scans_old RENAME TO nutrition_scans;
+
+CREATE INDEX IF NOT EXISTS idx_nutrition_scans_status ON nutrition_scans(status);
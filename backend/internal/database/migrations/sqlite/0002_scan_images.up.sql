@@ -0,0 +1,20 @@
+-- Photos move out of the database into the image store. Scans refer to their
+-- photo by key; image_data only holds photos of older scans until they are
+-- moved, and SQLite cannot drop its NOT NULL in place.
+CREATE TABLE nutrition_scans_new (
+    id TEXT PRIMARY KEY,
+    image_path TEXT,
+    image_data BLOB,
+    status TEXT NOT NULL CHECK(status IN ('pending', 'processing', 'completed', 'failed')),
+    error TEXT,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+
+INSERT INTO nutrition_scans_new (id, image_data, status, error, created_at, updated_at)
+    SELECT id, image_data, status, error, created_at, updated_at FROM nutrition_scans;
+
+DROP TABLE nutrition_scans;
+ALTER TABLE nutrition_scans_new RENAME TO nutrition_scans;
+
+CREATE INDEX IF NOT EXISTS idx_nutrition_scans_status ON nutrition_scans(status);
@@ -0,0 +1,18 @@
+-- SQLite cannot drop a column that is part of a foreign key
+CREATE TABLE nutrition_scans_old (
+    id TEXT PRIMARY KEY,
+    image_path TEXT,
+    image_data BLOB,
+    status TEXT NOT NULL CHECK(status IN ('pending', 'processing', 'completed', 'failed')),
+    error TEXT,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+
+INSERT INTO nutrition_scans_old (id, image_path, image_data, status, error, created_at, updated_at)
+    SELECT id, image_path, image_data, status, error, created_at, updated_at FROM nutrition_scans;
+
+DROP TABLE nutrition_scans;
+ALTER TABLE nutrition_scans_old RENAME TO nutrition_scans;
+
+CREATE INDEX IF NOT EXISTS idx_nutrition_scans_status ON nutrition_scans(status);
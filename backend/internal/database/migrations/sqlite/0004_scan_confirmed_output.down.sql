@@ -0,0 +1 @@
+ALTER TABLE nutrition_scans DROP COLUMN confirmed_output;
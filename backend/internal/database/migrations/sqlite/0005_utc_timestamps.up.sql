@@ -0,0 +1,100 @@
+-- Timestamps were stored the way each driver formats times, in the server's
+-- time zone, so comparing them as text did not always compare the times. They
+-- are now stored in UTC with all nine fractional digits, as in
+-- 2024-03-10T07:30:00.000000000Z, which sorts as text in time order.
+
+-- Each distinct value is converted once. The fraction and the zone, which
+-- follow the seconds, are taken apart first: "+0200 CEST" as written by the
+-- SQLite driver, "+02:00" or "Z" otherwise.
+CREATE TEMP TABLE utc_timestamps (
+    value TEXT PRIMARY KEY,
+    fraction TEXT,
+    zone TEXT,
+    utc TEXT
+);
+
+INSERT INTO utc_timestamps (value)
+    SELECT created_at FROM households
+    UNION SELECT created_at FROM users
+    UNION SELECT created_at FROM auth_tokens
+    UNION SELECT expires_at FROM auth_tokens
+    UNION SELECT last_used_at FROM auth_tokens
+    UNION SELECT updated_at FROM goal_targets
+    UNION SELECT updated_at FROM body_profiles
+    UNION SELECT measured_at FROM body_metrics
+    UNION SELECT created_at FROM body_metrics
+    UNION SELECT created_at FROM recipes
+    UNION SELECT updated_at FROM recipes
+    UNION SELECT deleted_at FROM recipes
+    UNION SELECT created_at FROM nutritional_info
+    UNION SELECT updated_at FROM nutritional_info
+    UNION SELECT deleted_at FROM nutritional_info
+    UNION SELECT created_at FROM nutrition_scans
+    UNION SELECT updated_at FROM nutrition_scans
+    UNION SELECT created_at FROM pantry_items
+    UNION SELECT updated_at FROM pantry_items
+    UNION SELECT created_at FROM pantry_events
+    UNION SELECT applied_at FROM schema_version;
+DELETE FROM utc_timestamps WHERE value IS NULL;
+
+UPDATE utc_timestamps SET fraction = CASE
+    WHEN substr(value, 20, 1) = '.'
+    THEN substr(value, 21, length(value) - 20 - length(ltrim(substr(value, 21), '0123456789')))
+    ELSE '' END;
+UPDATE utc_timestamps SET zone = ltrim(substr(value, 20 + CASE WHEN fraction = '' THEN 0 ELSE length(fraction) + 1 END));
+UPDATE utc_timestamps SET zone = CASE
+    WHEN zone = '' OR substr(zone, 1, 1) = 'Z' THEN '+00:00'
+    WHEN substr(zone, 4, 1) = ':' THEN substr(zone, 1, 6)
+    ELSE substr(zone, 1, 3) || ':' || substr(zone, 4, 2) END;
+UPDATE utc_timestamps SET utc =
+    strftime('%Y-%m-%dT%H:%M:%S', substr(value, 1, 10) || ' ' || substr(value, 12, 8) || zone)
+    || '.' || substr(fraction || '000000000', 1, 9) || 'Z';
+
+-- When an entry or pantry event counts in history was worked out in queries
+-- from the local date of created_at, which UTC timestamps no longer give. It
+-- is now stored, computed in the user's time zone when the row is saved. Rows
+-- assigned to another day count at noon UTC that day, which is that day in
+-- nearly every time zone.
+ALTER TABLE nutritional_info ADD COLUMN eaten_at TEXT;
+ALTER TABLE pantry_events ADD COLUMN eaten_at TEXT;
+
+UPDATE nutritional_info SET eaten_at = CASE
+    WHEN meal_date IS NULL OR substr(created_at, 1, 10) = meal_date
+    THEN (SELECT utc FROM utc_timestamps WHERE value = created_at)
+    ELSE meal_date || 'T12:00:00.000000000Z' END;
+UPDATE pantry_events SET eaten_at = CASE
+    WHEN meal_date IS NULL OR substr(created_at, 1, 10) = meal_date
+    THEN (SELECT utc FROM utc_timestamps WHERE value = created_at)
+    ELSE meal_date || 'T12:00:00.000000000Z' END;
+
+UPDATE households SET created_at = (SELECT utc FROM utc_timestamps WHERE value = created_at);
+UPDATE users SET created_at = (SELECT utc FROM utc_timestamps WHERE value = created_at);
+UPDATE auth_tokens SET created_at = (SELECT utc FROM utc_timestamps WHERE value = created_at);
+UPDATE auth_tokens SET expires_at = (SELECT utc FROM utc_timestamps WHERE value = expires_at) WHERE expires_at IS NOT NULL;
+UPDATE auth_tokens SET last_used_at = (SELECT utc FROM utc_timestamps WHERE value = last_used_at) WHERE last_used_at IS NOT NULL;
+UPDATE goal_targets SET updated_at = (SELECT utc FROM utc_timestamps WHERE value = updated_at);
+UPDATE body_profiles SET updated_at = (SELECT utc FROM utc_timestamps WHERE value = updated_at);
+UPDATE body_metrics SET measured_at = (SELECT utc FROM utc_timestamps WHERE value = measured_at);
+UPDATE body_metrics SET created_at = (SELECT utc FROM utc_timestamps WHERE value = created_at);
+UPDATE recipes SET created_at = (SELECT utc FROM utc_timestamps WHERE value = created_at);
+UPDATE recipes SET updated_at = (SELECT utc FROM utc_timestamps WHERE value = updated_at);
+UPDATE recipes SET deleted_at = (SELECT utc FROM utc_timestamps WHERE value = deleted_at) WHERE deleted_at IS NOT NULL;
+UPDATE nutritional_info SET created_at = (SELECT utc FROM utc_timestamps WHERE value = created_at);
+UPDATE nutritional_info SET updated_at = (SELECT utc FROM utc_timestamps WHERE value = updated_at);
+UPDATE nutritional_info SET deleted_at = (SELECT utc FROM utc_timestamps WHERE value = deleted_at) WHERE deleted_at IS NOT NULL;
+UPDATE nutrition_scans SET created_at = (SELECT utc FROM utc_timestamps WHERE value = created_at);
+UPDATE nutrition_scans SET updated_at = (SELECT utc FROM utc_timestamps WHERE value = updated_at);
+UPDATE pantry_items SET created_at = (SELECT utc FROM utc_timestamps WHERE value = created_at);
+UPDATE pantry_items SET updated_at = (SELECT utc FROM utc_timestamps WHERE value = updated_at);
+UPDATE pantry_events SET created_at = (SELECT utc FROM utc_timestamps WHERE value = created_at);
+UPDATE schema_version SET applied_at = (SELECT utc FROM utc_timestamps WHERE value = applied_at);
+
+DROP TABLE utc_timestamps;
+
+-- Users may count their days in a time zone other than the server's
+ALTER TABLE users ADD COLUMN timezone TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_nutritional_info_created_at ON nutritional_info(created_at);
+CREATE INDEX IF NOT EXISTS idx_nutritional_info_eaten_at ON nutritional_info(eaten_at);
+CREATE INDEX IF NOT EXISTS idx_pantry_events_eaten_at ON pantry_events(eaten_at);
+CREATE INDEX IF NOT EXISTS idx_nutrition_scans_created_at ON nutrition_scans(created_at);
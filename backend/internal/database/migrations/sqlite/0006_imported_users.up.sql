@@ -0,0 +1,3 @@
+-- Users brought in by an import are not picked as the default user over
+-- those of this instance, however long ago they were created
+ALTER TABLE users ADD COLUMN imported_at TEXT;
@@ -0,0 +1,380 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNotInStock is returned when taking from a finished or discarded item
+	ErrNotInStock = errors.New("pantry item is no longer in stock")
+	// ErrNotEnoughStock is returned when taking more than what is left
+	ErrNotEnoughStock = errors.New("not enough left in the pantry item")
+)
+
+// stockEpsilon absorbs rounding when the remaining weight is taken out exactly
+const stockEpsilon = 1e-6
+
+// pantryItemColumns is the column list read by scanPantryItem
+var pantryItemColumns = `i.id, i.initial_weight, i.remaining_weight, i.status,
+			i.created_at, i.updated_at, ` + prefixColumns("n", nutritionalInfoColumns)
+
+// prefixColumns qualifies every column of a comma separated list with a table alias
+func prefixColumns(alias, columns string) string {
+	parts := strings.Split(columns, ",")
+	for i, part := range parts {
+		parts[i] = alias + "." + strings.TrimSpace(part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// scanPantryItem reads a row selected with pantryItemColumns
+func scanPantryItem(row rowScanner) (*models.PantryItem, error) {
+	var item models.PantryItem
+	var createdAt, updatedAt string
+	entry := &entryScanner{}
+
+	dest := []any{&item.ID, &item.InitialWeight, &item.RemainingWeight, &item.Status,
+		&createdAt, &updatedAt}
+	if err := row.Scan(append(dest, entry.dest()...)...); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if item.CreatedAt, err = parseTimestamp(createdAt); err != nil {
+		return nil, err
+	}
+	if item.UpdatedAt, err = parseTimestamp(updatedAt); err != nil {
+		return nil, err
+	}
+	if item.Entry, err = entry.result(); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// AddToPantry creates the pantry item for a confirmed entry. Whatever was
+// already eaten according to the entry is logged as the first event.
+func (s *SQLDB) AddToPantry(ctx context.Context, entry *models.NutritionalInfo) (*models.PantryItem, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	item, err := insertPantryItem(ctx, tx, entry)
+	if err != nil {
+		return nil, err
+	}
+	return item, tx.Commit()
+}
+
+// insertPantryItem creates the pantry item for an entry within a transaction
+func insertPantryItem(ctx context.Context, tx *sqlTx, entry *models.NutritionalInfo) (*models.PantryItem, error) {
+	now := time.Now()
+	eaten := entry.EatenWeight()
+	item := &models.PantryItem{
+		ID:              entry.ID,
+		Entry:           entry,
+		InitialWeight:   entry.TotalWeight,
+		RemainingWeight: entry.TotalWeight - eaten,
+		Status:          models.PantryInStock,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if item.RemainingWeight <= stockEpsilon {
+		item.RemainingWeight = 0
+		item.Status = models.PantryFinished
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO pantry_items (
+			id, initial_weight, remaining_weight, status, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?)
+	`, item.ID, item.InitialWeight, item.RemainingWeight, item.Status, item.CreatedAt, item.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error creating pantry item: %w", err)
+	}
+
+	if eaten > 0 {
+		event := &models.PantryEvent{
+			ID:        uuid.New().String(),
+			ItemID:    item.ID,
+			UserID:    entry.UserID,
+			Kind:      models.PantryEventEaten,
+			Weight:    eaten,
+			CreatedAt: now,
+			Meal:      entry.Meal,
+			MealDate:  entry.MealDate,
+			Shares:    []models.ConsumptionShare{{UserID: entry.UserID, Fraction: 1}},
+		}
+		if err := insertPantryEvent(ctx, tx, event); err != nil {
+			return nil, err
+		}
+	}
+	return item, nil
+}
+
+// GetPantryItem retrieves a pantry item with its entry, or nil if there is none
+func (s *SQLDB) GetPantryItem(ctx context.Context, id string) (*models.PantryItem, error) {
+	query := `
+		SELECT ` + pantryItemColumns + `
+		FROM pantry_items i
+		JOIN nutritional_info n ON n.id = i.id
+		WHERE i.id = ? AND n.deleted_at IS NULL
+	`
+
+	item, err := scanPantryItem(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// ListPantryItems returns the household's items in stock, oldest first. With
+// includeEmpty, finished and discarded items are included too.
+func (s *SQLDB) ListPantryItems(ctx context.Context, householdID string, includeEmpty bool) ([]*models.PantryItem, error) {
+	query := `
+		SELECT ` + pantryItemColumns + `
+		FROM pantry_items i
+		JOIN nutritional_info n ON n.id = i.id
+		WHERE n.deleted_at IS NULL AND n.user_id ` + memberOf + `
+	`
+	args := []any{householdID}
+	if !includeEmpty {
+		query += ` AND i.status = ?`
+		args = append(args, models.PantryInStock)
+	}
+	query += ` ORDER BY i.created_at`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*models.PantryItem
+	for rows.Next() {
+		item, err := scanPantryItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, item)
+	}
+
+	return results, rows.Err()
+}
+
+// LogPantryEvent takes grams out of a pantry item. Eaten grams are also added
+// to the consumed weight of the item's entry and attributed to the event's
+// shares. It returns the updated item.
+func (s *SQLDB) LogPantryEvent(ctx context.Context, event *models.PantryEvent) (*models.PantryItem, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := takeFromPantry(ctx, tx, event); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetPantryItem(ctx, event.ItemID)
+}
+
+// takeFromPantry logs an event taking grams out of a pantry item within a
+// transaction, see LogPantryEvent
+func takeFromPantry(ctx context.Context, tx *sqlTx, event *models.PantryEvent) error {
+	remaining, status, err := pantryStock(ctx, tx, event.ItemID)
+	if err != nil {
+		return err
+	}
+
+	if status != models.PantryInStock {
+		return ErrNotInStock
+	}
+	if event.Weight > remaining+stockEpsilon {
+		return ErrNotEnoughStock
+	}
+
+	remaining -= event.Weight
+	if remaining <= stockEpsilon {
+		remaining = 0
+		status = models.PantryFinished
+		if event.Kind == models.PantryEventDiscarded {
+			status = models.PantryDiscarded
+		}
+	}
+
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	if err := insertPantryEvent(ctx, tx, event); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE pantry_items
+		SET remaining_weight = ?, status = ?, updated_at = ?
+		WHERE id = ?
+	`, remaining, status, event.CreatedAt, event.ItemID)
+	if err != nil {
+		return fmt.Errorf("error updating pantry item: %w", err)
+	}
+
+	if event.Kind == models.PantryEventEaten {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE nutritional_info
+			SET consumed_weight = COALESCE(consumed_weight, 0) + ?, updated_at = ?
+			WHERE id = ?
+		`, event.Weight, event.CreatedAt, event.ItemID)
+		if err != nil {
+			return fmt.Errorf("error updating consumed weight: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// pantryStock reads what is left of a pantry item of a live entry within a
+// transaction, locking the item until the transaction ends
+func pantryStock(ctx context.Context, tx *sqlTx, id string) (float64, string, error) {
+	var remaining float64
+	var status string
+	err := tx.QueryRowContext(ctx, `
+		SELECT i.remaining_weight, i.status
+		FROM pantry_items i
+		JOIN nutritional_info n ON n.id = i.id
+		WHERE i.id = ? AND n.deleted_at IS NULL`+tx.dialect.lockRows, id).Scan(&remaining, &status)
+	if err == sql.ErrNoRows {
+		return 0, "", ErrNotFound
+	}
+	return remaining, status, err
+}
+
+// resizePantryItem follows a correction of the total weight of an entry
+// within a transaction, keeping the grams already taken out of its pantry
+// item. It reports whether the entry has a pantry item whose size changed.
+func resizePantryItem(ctx context.Context, tx *sqlTx, id string, totalWeight float64) (bool, error) {
+	var initial, remaining float64
+	var status string
+	err := tx.QueryRowContext(ctx, `
+		SELECT initial_weight, remaining_weight, status FROM pantry_items WHERE id = ?`+tx.dialect.lockRows,
+		id).Scan(&initial, &remaining, &status)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if initial == totalWeight {
+		return false, nil
+	}
+
+	// Finished and discarded items stay empty; an item that turns out to be
+	// smaller than what was taken out of it is finished
+	if status == models.PantryInStock {
+		remaining += totalWeight - initial
+		if remaining <= stockEpsilon {
+			remaining = 0
+			status = models.PantryFinished
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE pantry_items
+		SET initial_weight = ?, remaining_weight = ?, status = ?, updated_at = ?
+		WHERE id = ?
+	`, totalWeight, remaining, status, time.Now(), id)
+	if err != nil {
+		return false, fmt.Errorf("error resizing pantry item: %w", err)
+	}
+	return true, nil
+}
+
+// SumPantryActivity adds up, for each period, the pantry items the household
+// bought and the grams eaten, discarded and used in recipes. The result has one element per period.
+func (s *SQLDB) SumPantryActivity(ctx context.Context, householdID string, periods []Period) ([]*models.PantryActivity, error) {
+	purchased, err := s.sumByPeriod(ctx, periods, `
+		SELECT i.created_at AS at, NULL AS meal, i.initial_weight AS grams,
+			n.calories, n.protein, n.carbs, n.fat, n.fiber, n.sugar
+		FROM pantry_items i
+		JOIN nutritional_info n ON n.id = i.id
+		WHERE n.deleted_at IS NULL AND n.user_id `+memberOf+`
+	`, householdID)
+	if err != nil {
+		return nil, err
+	}
+
+	events := `
+		SELECT e.eaten_at AS at, e.meal, e.weight AS grams,
+			n.calories, n.protein, n.carbs, n.fat, n.fiber, n.sugar
+		FROM pantry_events e
+		JOIN nutritional_info n ON n.id = e.item_id
+		WHERE n.deleted_at IS NULL AND n.user_id ` + memberOf + ` AND e.kind = ?
+	`
+	eaten, err := s.sumByPeriod(ctx, periods, events, householdID, models.PantryEventEaten)
+	if err != nil {
+		return nil, err
+	}
+	discarded, err := s.sumByPeriod(ctx, periods, events, householdID, models.PantryEventDiscarded)
+	if err != nil {
+		return nil, err
+	}
+	cooked, err := s.sumByPeriod(ctx, periods, events, householdID, models.PantryEventCooked)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*models.PantryActivity, len(periods))
+	for i, p := range periods {
+		results[i] = &models.PantryActivity{
+			PeriodStart: p.Start,
+			PeriodEnd:   p.End,
+			Purchased:   purchased[i],
+			Eaten:       eaten[i],
+			Discarded:   discarded[i],
+			Cooked:      cooked[i],
+		}
+	}
+	return results, nil
+}
+
+// insertPantryEvent writes a pantry event and its shares within a transaction
+func insertPantryEvent(ctx context.Context, tx *sqlTx, event *models.PantryEvent) error {
+	loc, err := userLocation(ctx, tx, event.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO pantry_events (id, item_id, user_id, kind, weight, meal, meal_date, eaten_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, event.ID, event.ItemID, nullString(event.UserID), event.Kind, event.Weight,
+		nullString(event.Meal), nullString(event.MealDate),
+		models.EatenAt(event.CreatedAt, event.MealDate, loc), event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error logging pantry event: %w", err)
+	}
+
+	if event.Kind == models.PantryEventEaten {
+		for _, share := range event.Shares {
+			if err := insertShare(ctx, tx, event.ItemID, event.ID, share); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
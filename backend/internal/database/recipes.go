@@ -0,0 +1,229 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// recipeColumns is the column list read by scanRecipe
+const recipeColumns = `id, household_id, name, yield_weight, servings, created_by,
+			created_at, updated_at, deleted_at`
+
+// SaveRecipe creates or replaces a recipe and its ingredients
+func (s *SQLDB) SaveRecipe(ctx context.Context, r *models.Recipe) error {
+	now := time.Now()
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = now
+	}
+	r.UpdatedAt = now
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO recipes (`+recipeColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, NULL)
+		ON CONFLICT(id) DO UPDATE SET
+			name = excluded.name,
+			yield_weight = excluded.yield_weight,
+			servings = excluded.servings,
+			updated_at = excluded.updated_at
+	`, r.ID, r.HouseholdID, r.Name, r.YieldWeight, r.Servings, nullString(r.CreatedBy),
+		r.CreatedAt, r.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error saving recipe: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recipe_ingredients WHERE recipe_id = ?`, r.ID); err != nil {
+		return fmt.Errorf("error replacing ingredients: %w", err)
+	}
+	for i, ing := range r.Ingredients {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO recipe_ingredients (recipe_id, position, entry_id, weight)
+			VALUES (?, ?, ?, ?)
+		`, r.ID, i, ing.EntryID, ing.Weight)
+		if err != nil {
+			return fmt.Errorf("error saving ingredient: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetRecipe retrieves a recipe with its ingredients' entries, or nil if there
+// is none. Deleted recipes are returned with DeletedAt set.
+func (s *SQLDB) GetRecipe(ctx context.Context, id string) (*models.Recipe, error) {
+	query := `SELECT ` + recipeColumns + ` FROM recipes WHERE id = ?`
+
+	recipe, err := scanRecipe(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadIngredients(ctx, recipe); err != nil {
+		return nil, err
+	}
+	return recipe, nil
+}
+
+// ListRecipes returns the recipes of a household by name, without deleted ones
+func (s *SQLDB) ListRecipes(ctx context.Context, householdID string) ([]*models.Recipe, error) {
+	query := `
+		SELECT ` + recipeColumns + `
+		FROM recipes
+		WHERE household_id = ? AND deleted_at IS NULL
+		ORDER BY lower(name), id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, householdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*models.Recipe
+	for rows.Next() {
+		recipe, err := scanRecipe(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, recipe)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, recipe := range results {
+		if err := s.loadIngredients(ctx, recipe); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// DeleteRecipe soft-deletes a recipe; entries logged from it keep referring to it
+func (s *SQLDB) DeleteRecipe(ctx context.Context, householdID, id string) error {
+	now := time.Now()
+	return expectOneRow(s.db.ExecContext(ctx, `
+		UPDATE recipes
+		SET deleted_at = ?, updated_at = ?
+		WHERE id = ? AND household_id = ? AND deleted_at IS NULL
+	`, now, now, id, householdID))
+}
+
+// loadIngredients reads a recipe's ingredients along with their entries
+func (s *SQLDB) loadIngredients(ctx context.Context, r *models.Recipe) error {
+	query := `
+		SELECT ri.weight, ` + prefixColumns("n", nutritionalInfoColumns) + `
+		FROM recipe_ingredients ri
+		JOIN nutritional_info n ON n.id = ri.entry_id
+		WHERE ri.recipe_id = ?
+		ORDER BY ri.position
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, r.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	r.Ingredients = nil
+	for rows.Next() {
+		var ing models.RecipeIngredient
+		entry := &entryScanner{}
+		if err := rows.Scan(append([]any{&ing.Weight}, entry.dest()...)...); err != nil {
+			return err
+		}
+		if ing.Entry, err = entry.result(); err != nil {
+			return err
+		}
+		ing.EntryID = ing.Entry.ID
+		r.Ingredients = append(r.Ingredients, ing)
+	}
+	return rows.Err()
+}
+
+// scanRecipe reads a row selected with recipeColumns
+func scanRecipe(row rowScanner) (*models.Recipe, error) {
+	var r models.Recipe
+	var createdBy, deletedAt sql.NullString
+	var createdAt, updatedAt string
+	if err := row.Scan(&r.ID, &r.HouseholdID, &r.Name, &r.YieldWeight, &r.Servings, &createdBy,
+		&createdAt, &updatedAt, &deletedAt); err != nil {
+		return nil, err
+	}
+
+	var err error
+	r.CreatedBy = createdBy.String
+	if r.CreatedAt, err = parseTimestamp(createdAt); err != nil {
+		return nil, err
+	}
+	if r.UpdatedAt, err = parseTimestamp(updatedAt); err != nil {
+		return nil, err
+	}
+	if r.DeletedAt, err = parseNullTimestamp(deletedAt); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// LogRecipe saves an entry logged from a recipe with its shares and takes its
+// ingredients out of the pantry, in one transaction. Each cooked event takes
+// up to its Weight out of its item; its Weight is lowered to what the item
+// had left, or to zero when the item is gone or out of stock, in which case
+// the event is not logged. It returns the items taken from.
+func (s *SQLDB) LogRecipe(ctx context.Context, entry *models.NutritionalInfo, shares []models.ConsumptionShare, cooked []*models.PantryEvent) ([]*models.PantryItem, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := saveEntry(ctx, tx, entry); err != nil {
+		return nil, fmt.Errorf("error saving entry: %w", err)
+	}
+	if err := replaceShares(ctx, tx, entry.ID, shares); err != nil {
+		return nil, err
+	}
+
+	var taken []string
+	for _, event := range cooked {
+		remaining, status, err := pantryStock(ctx, tx, event.ItemID)
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+		if err == ErrNotFound || status != models.PantryInStock {
+			event.Weight = 0
+			continue
+		}
+		event.Weight = min(event.Weight, remaining)
+		if err := takeFromPantry(ctx, tx, event); err != nil {
+			return nil, err
+		}
+		taken = append(taken, event.ItemID)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	var items []*models.PantryItem
+	for _, id := range taken {
+		item, err := s.GetPantryItem(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if item != nil {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+const scanColumns = `
+	id, entry_id, image_path, model, model_output, confirmed_output, status, error, created_at, updated_at`
+
+// GetScansByEntry returns the scans the entries were created from, by entry
+// ID. Entries typed in by hand or logged from recipes have no scan and are
+// left out; should an entry have been confirmed twice, its latest scan wins.
+func (s *SQLDB) GetScansByEntry(ctx context.Context, entryIDs []string) (map[string]*models.NutritionScan, error) {
+	scans := make(map[string]*models.NutritionScan)
+	if len(entryIDs) == 0 {
+		return scans, nil
+	}
+
+	args := make([]any, len(entryIDs))
+	for i, id := range entryIDs {
+		args[i] = id
+	}
+	query := `
+		SELECT ` + scanColumns + `
+		FROM nutrition_scans
+		WHERE entry_id IN (?` + strings.Repeat(", ?", len(entryIDs)-1) + `)
+		ORDER BY created_at
+	`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying scans: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		scan, err := scanNutritionScan(rows)
+		if err != nil {
+			return nil, err
+		}
+		scans[scan.EntryID] = scan
+	}
+	return scans, rows.Err()
+}
+
+// UpdateScanConfirmation records new values the user confirmed for the scan
+// of an entry. Entries without a scan are left alone.
+func (s *SQLDB) UpdateScanConfirmation(ctx context.Context, entryID string, confirmed *models.ModelOutput) error {
+	return updateScanConfirmation(ctx, s.db, entryID, confirmed)
+}
+
+// updateScanConfirmation records new confirmed values, see UpdateScanConfirmation
+func updateScanConfirmation(ctx context.Context, q querier, entryID string, confirmed *models.ModelOutput) error {
+	output, err := encodeOutput(confirmed)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, `
+		UPDATE nutrition_scans SET confirmed_output = ?, updated_at = ? WHERE entry_id = ?
+	`, output, time.Now(), entryID)
+	if err != nil {
+		return fmt.Errorf("error updating scan of entry %s: %w", entryID, err)
+	}
+	return nil
+}
+
+// ListConfirmedScans returns the scans made in [from, to) by members of a
+// household, or of every household when householdID is empty, that have both
+// the model's proposal and the confirmed values, oldest first. Scans of
+// deleted entries are left out.
+func (s *SQLDB) ListConfirmedScans(ctx context.Context, householdID string, from, to time.Time) ([]*models.NutritionScan, error) {
+	entries := `SELECT id FROM nutritional_info WHERE deleted_at IS NULL`
+	args := []any{from, to}
+	if householdID != "" {
+		entries += ` AND user_id ` + memberOf
+		args = append(args, householdID)
+	}
+	query := `
+		SELECT ` + scanColumns + `
+		FROM nutrition_scans
+		WHERE created_at >= ? AND created_at < ?
+			AND model_output IS NOT NULL AND confirmed_output IS NOT NULL
+			AND entry_id IN (` + entries + `)
+		ORDER BY created_at
+	`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying scans: %w", err)
+	}
+	defer rows.Close()
+
+	var scans []*models.NutritionScan
+	for rows.Next() {
+		scan, err := scanNutritionScan(rows)
+		if err != nil {
+			return nil, err
+		}
+		scans = append(scans, scan)
+	}
+	return scans, rows.Err()
+}
+
+// scanNutritionScan reads a row of scanColumns
+func scanNutritionScan(row rowScanner) (*models.NutritionScan, error) {
+	var (
+		scan                           models.NutritionScan
+		entryID, imagePath, model, msg sql.NullString
+		output, confirmed              sql.NullString
+		createdAt, updatedAt           string
+	)
+	err := row.Scan(&scan.ID, &entryID, &imagePath, &model, &output, &confirmed, &scan.Status, &msg,
+		&createdAt, &updatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning scan: %w", err)
+	}
+	scan.EntryID = entryID.String
+	scan.ImagePath = imagePath.String
+	scan.Model = model.String
+	scan.Error = msg.String
+	if scan.ModelOutput, err = decodeOutput(output); err != nil {
+		return nil, fmt.Errorf("error decoding model output of scan %s: %w", scan.ID, err)
+	}
+	if scan.ConfirmedOutput, err = decodeOutput(confirmed); err != nil {
+		return nil, fmt.Errorf("error decoding confirmed output of scan %s: %w", scan.ID, err)
+	}
+	if scan.CreatedAt, err = parseTimestamp(createdAt); err != nil {
+		return nil, err
+	}
+	if scan.UpdatedAt, err = parseTimestamp(updatedAt); err != nil {
+		return nil, err
+	}
+	return &scan, nil
+}
+
+// encodeOutput stores an output as JSON, nil as NULL
+func encodeOutput(output *models.ModelOutput) (sql.NullString, error) {
+	if output == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(output)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("error encoding model output: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func decodeOutput(value sql.NullString) (*models.ModelOutput, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+	var output models.ModelOutput
+	if err := json.Unmarshal([]byte(value.String), &output); err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
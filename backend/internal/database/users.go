@@ -0,0 +1,353 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"github.com/google/uuid"
+)
+
+// Names given to the household and user created on first start
+const (
+	defaultHouseholdName = "Home"
+	defaultUserName      = "Me"
+)
+
+// EnsureDefaultUser returns the oldest user, preferring those created here to
+// those brought in by an import. On a database without users it creates a
+// household with one member and hands everything logged so far to them.
+func (s *SQLDB) EnsureDefaultUser(ctx context.Context) (*models.User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		ORDER BY CASE WHEN imported_at IS NULL THEN 0 ELSE 1 END, created_at, id
+		LIMIT 1
+	`
+	user, err := scanUser(s.db.QueryRowContext(ctx, query))
+	if err == nil {
+		return user, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	household := &models.Household{ID: uuid.New().String(), Name: defaultHouseholdName, CreatedAt: now}
+	user = &models.User{ID: uuid.New().String(), HouseholdID: household.ID, Name: defaultUserName, CreatedAt: now}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO households (id, name, created_at) VALUES (?, ?, ?)
+	`, household.ID, household.Name, household.CreatedAt); err != nil {
+		return nil, fmt.Errorf("error creating household: %w", err)
+	}
+	if err := insertUser(ctx, tx, user); err != nil {
+		return nil, err
+	}
+
+	if err := adoptUnownedRows(ctx, tx, user.ID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	log.Printf("Created default household %q with user %q", household.Name, user.Name)
+	return user, nil
+}
+
+// adoptUnownedRows assigns what was logged before users existed to userID and
+// attributes everything eaten so far to them
+func adoptUnownedRows(ctx context.Context, tx *sqlTx, userID string) error {
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE nutritional_info SET user_id = ? WHERE user_id IS NULL
+	`, userID); err != nil {
+		return fmt.Errorf("error assigning entries: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE pantry_events SET user_id = ? WHERE user_id IS NULL
+	`, userID); err != nil {
+		return fmt.Errorf("error assigning pantry events: %w", err)
+	}
+
+	// Collect first so that no rows are open while inserting
+	type eaten struct{ entryID, eventID string }
+	var unshared []eaten
+	rows, err := tx.QueryContext(ctx, `
+		SELECT n.id, '' FROM nutritional_info n
+		WHERE n.id NOT IN (SELECT id FROM pantry_items)
+			AND n.id NOT IN (SELECT entry_id FROM consumption_shares)
+		UNION ALL
+		SELECT e.item_id, e.id FROM pantry_events e
+		WHERE e.kind = ? AND e.id NOT IN (SELECT event_id FROM consumption_shares WHERE event_id IS NOT NULL)
+	`, models.PantryEventEaten)
+	if err != nil {
+		return fmt.Errorf("error finding unattributed consumption: %w", err)
+	}
+	for rows.Next() {
+		var e eaten
+		if err := rows.Scan(&e.entryID, &e.eventID); err != nil {
+			rows.Close()
+			return err
+		}
+		unshared = append(unshared, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range unshared {
+		share := models.ConsumptionShare{UserID: userID, Fraction: 1}
+		if err := insertShare(ctx, tx, e.entryID, e.eventID, share); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUser retrieves a user, or nil if there is none
+func (s *SQLDB) GetUser(ctx context.Context, id string) (*models.User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users WHERE id = ?
+	`
+
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// CreateUser adds a member to an existing household
+func (s *SQLDB) CreateUser(ctx context.Context, user *models.User) error {
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertUser(ctx, tx, user); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListUsers returns the members of a household in the order they joined
+func (s *SQLDB) ListUsers(ctx context.Context, householdID string) ([]*models.User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users WHERE household_id = ?
+		ORDER BY created_at, id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, householdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, user)
+	}
+
+	return results, rows.Err()
+}
+
+// GetHousehold retrieves a household, or nil if there is none
+func (s *SQLDB) GetHousehold(ctx context.Context, id string) (*models.Household, error) {
+	query := `SELECT id, name, created_at FROM households WHERE id = ?`
+
+	var household models.Household
+	var createdAt string
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&household.ID, &household.Name, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if household.CreatedAt, err = parseTimestamp(createdAt); err != nil {
+		return nil, err
+	}
+	return &household, nil
+}
+
+// SetConsumptionShares replaces who ate an entry that was eaten straight away.
+// Shares of pantry items are given per event with LogPantryEvent.
+func (s *SQLDB) SetConsumptionShares(ctx context.Context, entryID string, shares []models.ConsumptionShare) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceShares(ctx, tx, entryID, shares); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replaceShares replaces the shares of a whole entry within a transaction
+func replaceShares(ctx context.Context, tx *sqlTx, entryID string, shares []models.ConsumptionShare) error {
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM consumption_shares WHERE entry_id = ? AND event_id IS NULL
+	`, entryID); err != nil {
+		return fmt.Errorf("error clearing shares: %w", err)
+	}
+
+	for _, share := range shares {
+		if err := insertShare(ctx, tx, entryID, "", share); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertShare writes a consumption share; eventID is empty for shares of a
+// whole entry
+func insertShare(ctx context.Context, tx *sqlTx, entryID, eventID string, share models.ConsumptionShare) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO consumption_shares (id, entry_id, event_id, user_id, fraction)
+		VALUES (?, ?, ?, ?, ?)
+	`, uuid.New().String(), entryID, nullString(eventID), share.UserID, share.Fraction)
+	if err != nil {
+		return fmt.Errorf("error saving consumption share: %w", err)
+	}
+	return nil
+}
+
+// SetTimezone changes the time zone a user's days are counted in. What they
+// logged earlier for a diary day keeps counting on that day: when it was
+// eaten is worked out again in the new time zone.
+func (s *SQLDB) SetTimezone(ctx context.Context, userID, timezone string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := expectOneRow(tx.ExecContext(ctx, `
+		UPDATE users SET timezone = ? WHERE id = ?
+	`, nullString(timezone), userID)); err != nil {
+		return err
+	}
+	user := models.User{Timezone: timezone}
+	for _, table := range []string{"nutritional_info", "pantry_events"} {
+		if err := moveEatenAt(ctx, tx, table, userID, user.Location()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// moveEatenAt sets eaten_at again for the rows of a user in table that were
+// given a diary day, counting that day in loc
+func moveEatenAt(ctx context.Context, tx *sqlTx, table, userID string, loc *time.Location) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, created_at, meal_date FROM `+table+`
+		WHERE user_id = ? AND meal_date IS NOT NULL AND meal_date <> ''
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("error reading meal dates: %w", err)
+	}
+	eatenAt := make(map[string]time.Time)
+	for rows.Next() {
+		var id, createdAt, mealDate string
+		if err := rows.Scan(&id, &createdAt, &mealDate); err != nil {
+			rows.Close()
+			return err
+		}
+		loggedAt, err := parseTimestamp(createdAt)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		eatenAt[id] = models.EatenAt(loggedAt, mealDate, loc)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, at := range eatenAt {
+		if _, err := tx.ExecContext(ctx, `UPDATE `+table+` SET eaten_at = ? WHERE id = ?`, at, id); err != nil {
+			return fmt.Errorf("error moving meal to its day: %w", err)
+		}
+	}
+	return nil
+}
+
+// queryRower is implemented by both *sqlConn and *sqlTx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// userLocation returns the time zone of a user; rows without a user count in
+// the server's
+func userLocation(ctx context.Context, q queryRower, userID string) (*time.Location, error) {
+	var timezone sql.NullString
+	err := q.QueryRowContext(ctx, `SELECT timezone FROM users WHERE id = ?`, userID).Scan(&timezone)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error reading time zone: %w", err)
+	}
+	user := models.User{Timezone: timezone.String}
+	return user.Location(), nil
+}
+
+// insertUser writes a new user within a transaction
+func insertUser(ctx context.Context, tx *sqlTx, user *models.User) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO users (id, household_id, name, timezone, created_at) VALUES (?, ?, ?, ?, ?)
+	`, user.ID, user.HouseholdID, user.Name, nullString(user.Timezone), user.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error creating user: %w", err)
+	}
+	return nil
+}
+
+// userColumns is the column list read by scanUser
+const userColumns = `id, household_id, name, login, timezone, created_at`
+
+// scanUser reads a row selected with userColumns
+func scanUser(row rowScanner, extra ...any) (*models.User, error) {
+	var user models.User
+	var login, timezone sql.NullString
+	var createdAt string
+	dest := append([]any{&user.ID, &user.HouseholdID, &user.Name, &login, &timezone, &createdAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	user.Login = login.String
+	user.Timezone = timezone.String
+
+	var err error
+	if user.CreatedAt, err = parseTimestamp(createdAt); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
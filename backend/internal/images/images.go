@@ -0,0 +1,76 @@
+// Package images keeps the photos taken when scanning food out of the
+// database. Photos are content addressed: a photo's key is the SHA-256 of its
+// bytes, so storing the same photo twice keeps a single copy and a key cannot
+// be guessed without the photo.
+//
+// Every store lays photos out the same way, in two levels of directories
+// named after the start of the key, so a local store can be copied into a
+// bucket as is and the other way around.
+package images
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/franckalain/nutritionalvalue/internal/config"
+)
+
+// ErrNotFound is returned when no photo has the requested key
+var ErrNotFound = errors.New("image not found")
+
+// Store keeps photos by key
+type Store interface {
+	// Put stores a photo and returns its key. Storing a photo that is already
+	// there succeeds without writing it again.
+	Put(ctx context.Context, data []byte) (string, error)
+	// Get returns the photo with the key, or ErrNotFound
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes the photo with the key; deleting a missing photo is not
+	// an error
+	Delete(ctx context.Context, key string) error
+}
+
+// New creates the store chosen in the configuration
+func New(cfg config.ImagesConfig) (Store, error) {
+	switch cfg.Type {
+	case "local", "":
+		return NewLocalStore(cfg.Dir)
+	case "s3":
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unsupported image store type: %s", cfg.Type)
+	}
+}
+
+// Key returns the key a photo is stored under
+func Key(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidKey reports whether key could have been returned by Key. Keys are
+// checked before they are turned into paths, so they cannot escape the store.
+func ValidKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	for _, r := range key {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// objectPath returns where a photo is kept relative to the root of a store,
+// such as "3f/a2/3fa2…"
+func objectPath(key string) (string, error) {
+	if !ValidKey(key) {
+		return "", fmt.Errorf("invalid image key %q", key)
+	}
+	return path.Join(key[:2], key[2:4], key), nil
+}
@@ -0,0 +1,89 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) {
+	key := Key([]byte("photo"))
+	if !ValidKey(key) {
+		t.Errorf("key %q is not valid", key)
+	}
+	for _, key := range []string{"", "../../etc/passwd", key[:63], key + "0", "ABCDEF" + key[6:], "../" + key[3:]} {
+		if ValidKey(key) {
+			t.Errorf("key %q is valid", key)
+		}
+		if _, err := objectPath(key); err == nil {
+			t.Errorf("key %q has a path", key)
+		}
+	}
+	if p, err := objectPath(key); err != nil || p != key[:2]+"/"+key[2:4]+"/"+key {
+		t.Errorf("path = %q, %v", p, err)
+	}
+}
+
+// testStore checks the behaviour every store shares
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	photo := []byte("\xff\xd8\xff\xe0 label photo")
+
+	key, err := store.Put(ctx, photo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != Key(photo) {
+		t.Errorf("key = %q, want %q", key, Key(photo))
+	}
+	if again, err := store.Put(ctx, photo); err != nil || again != key {
+		t.Errorf("storing again = %q, %v", again, err)
+	}
+	if data, err := store.Get(ctx, key); err != nil || !bytes.Equal(data, photo) {
+		t.Errorf("photo = %q, %v", data, err)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted photo: %v", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("deleting a missing photo: %v", err)
+	}
+
+	for _, key := range []string{"../escape", ""} {
+		if _, err := store.Get(ctx, key); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("getting invalid key %q: %v", key, err)
+		}
+		if err := store.Delete(ctx, key); err == nil {
+			t.Errorf("deleting invalid key %q succeeded", key)
+		}
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "images")
+	store, err := NewLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+
+	// Photos are laid out by key with no temporary files left behind
+	key, err := store.Put(context.Background(), []byte("photo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, key[:2], key[2:4]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != key {
+		t.Errorf("image directory holds %v", entries)
+	}
+}
@@ -0,0 +1,105 @@
+package ml
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"github.com/google/uuid"
+)
+
+// FakeConfig sets what the fake model answers. It reads every label as the
+// same values, which default to those of a typical breakfast cereal.
+type FakeConfig struct {
+	BaseConfig
+	Calories float64 `json:"calories"`
+	Protein  float64 `json:"protein"`
+	Carbs    float64 `json:"carbs"`
+	Fat      float64 `json:"fat"`
+	Fiber    float64 `json:"fiber"`
+	Sugar    float64 `json:"sugar"`
+
+	// How long each answer takes, such as "800ms", to mimic a real model
+	Delay string `json:"delay"`
+}
+
+// Load loads the fake configuration
+func (c *FakeConfig) Load() error {
+	if err := c.LoadConfig(c.ConfigPath, "fake", c); err != nil {
+		return err
+	}
+
+	if c.Calories == 0 && c.Protein == 0 && c.Carbs == 0 && c.Fat == 0 {
+		c.Calories, c.Protein, c.Carbs, c.Fat, c.Fiber, c.Sugar = 379, 8, 75, 3.5, 7, 21
+	}
+	if c.Delay != "" {
+		if _, err := time.ParseDuration(c.Delay); err != nil {
+			return fmt.Errorf("invalid delay %q: %w", c.Delay, err)
+		}
+	}
+
+	return nil
+}
+
+// FakeModel implements the Model interface without any network or model
+// files, for development and for evaluating the tooling around models
+type FakeModel struct {
+	config FakeConfig
+	delay  time.Duration
+}
+
+// FakeModelFactory implements ModelFactory for fake models
+type FakeModelFactory struct {
+	config FakeConfig
+}
+
+// NewFakeModelFactory creates a new fake model factory
+func NewFakeModelFactory(config FakeConfig) *FakeModelFactory {
+	return &FakeModelFactory{config: config}
+}
+
+// CreateModel creates a new fake model instance
+func (f *FakeModelFactory) CreateModel() (Model, error) {
+	delay, _ := time.ParseDuration(f.config.Delay)
+	return &FakeModel{
+		config: f.config,
+		delay:  delay,
+	}, nil
+}
+
+// Load does nothing; the fake model needs no resources
+func (m *FakeModel) Load(ctx context.Context) error {
+	return nil
+}
+
+// Name identifies the fake model
+func (m *FakeModel) Name() string {
+	return "fake"
+}
+
+// ProcessImage answers the configured values after the configured delay.
+// Data that is not an image cannot be read, as with a real model.
+func (m *FakeModel) ProcessImage(ctx context.Context, imageData []byte) (*models.NutritionalInfo, error) {
+	select {
+	case <-time.After(m.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if contentType := http.DetectContentType(imageData); !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("%w: %s is not an image", ErrParse, contentType)
+	}
+
+	return &models.NutritionalInfo{
+		ID:       uuid.New().String(),
+		Calories: m.config.Calories,
+		Protein:  m.config.Protein,
+		Carbs:    m.config.Carbs,
+		Fat:      m.config.Fat,
+		Fiber:    m.config.Fiber,
+		Sugar:    m.config.Sugar,
+	}, nil
+}
@@ -41,6 +41,9 @@ func (c *GoogleConfig) Load() error {
 	return nil
 }
 
+// googleModelName is the Gemini model labels are read with
+const googleModelName = "gemini-pro-vision"
+
 // GoogleModel implements the Model interface for Google's Vertex AI
 type GoogleModel struct {
 	config GoogleConfig
@@ -79,10 +82,15 @@ func (m *GoogleModel) Load(ctx context.Context) error {
 	}
 
 	m.client = client
-	m.model = client.GenerativeModel("gemini-pro-vision")
+	m.model = client.GenerativeModel(googleModelName)
 	return nil
 }
 
+// Name identifies the Gemini model used
+func (m *GoogleModel) Name() string {
+	return "google/" + googleModelName
+}
+
 // ProcessImage processes an image using Google's Vertex AI
 func (m *GoogleModel) ProcessImage(ctx context.Context, imageData []byte) (*models.NutritionalInfo, error) {
 	if m.model == nil {
@@ -121,6 +129,10 @@ Not all values can be zero. If most values are zero, raise an error explaining w
 	fmt.Println("Calling the model")
 	resp, err := m.model.GenerateContent(ctx, genai.Text(prompt), img)
 	if err != nil {
+		// Report cancellation and timeouts as such rather than as RPC errors
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("failed to call ai: %w", ctxErr)
+		}
 		return nil, fmt.Errorf("failed to call ai: %w", err)
 	}
 
@@ -162,25 +174,25 @@ Not all values can be zero. If most values are zero, raise an error explaining w
 	// First unmarshal into a map to check for missing fields
 	var rawMap map[string]interface{}
 	if err := json.Unmarshal([]byte(textContent), &rawMap); err != nil {
-		return nil, fmt.Errorf("failed to parse model response: %w while parsing %s", err, textContent)
+		return nil, fmt.Errorf("%w: %w while parsing %s", ErrParse, err, textContent)
 	}
 
 	// Check if success object exists and has all required fields
 	successObj, ok := rawMap["success"].(map[string]interface{})
 	if !ok {
-		return nil, fmt.Errorf("missing or invalid success object in response")
+		return nil, fmt.Errorf("%w: missing or invalid success object", ErrParse)
 	}
 
 	requiredFields := []string{"calories", "protein", "carbs", "fat"}
 	for _, field := range requiredFields {
 		if _, exists := successObj[field]; !exists {
-			return nil, fmt.Errorf("missing required field '%s' in response", field)
+			return nil, fmt.Errorf("%w: missing required field '%s'", ErrParse, field)
 		}
 	}
 
 	// Now unmarshal into our struct
 	if err := json.Unmarshal([]byte(textContent), &output); err != nil {
-		return nil, fmt.Errorf("failed to parse model response: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrParse, err)
 	}
 
 	if output.Error.ErrorReason != "" {
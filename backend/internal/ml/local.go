@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/franckalain/nutritionalvalue/internal/models"
 )
@@ -63,8 +64,20 @@ func (m *LocalModel) Load(ctx context.Context) error {
 	return nil
 }
 
+// Name identifies the local model by its file
+func (m *LocalModel) Name() string {
+	if m.config.ModelPath == "" {
+		return "local"
+	}
+	return "local/" + filepath.Base(m.config.ModelPath)
+}
+
 // ProcessImage processes an image using the local model
 func (m *LocalModel) ProcessImage(ctx context.Context, imageData []byte) (*models.NutritionalInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// TODO: Implement actual image processing
 	return nil, fmt.Errorf("unimplemented: local model processing not yet implemented")
 }
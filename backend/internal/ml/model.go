@@ -2,18 +2,26 @@ package ml
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 
 	"github.com/franckalain/nutritionalvalue/internal/models"
 )
 
+// ErrParse is returned, wrapped, when a model answers with something that is
+// not the expected nutritional values
+var ErrParse = errors.New("failed to parse model response")
+
 // Model represents a machine learning model that can process images
 type Model interface {
 	// Load initializes the model with its configuration
 	Load(ctx context.Context) error
 	// ProcessImage takes an image and returns nutritional information
 	ProcessImage(ctx context.Context, imageData []byte) (*models.NutritionalInfo, error)
+	// Name identifies the backend and model, e.g. "google/gemini-pro-vision",
+	// so that scans record what read them
+	Name() string
 }
 
 // ModelFactory creates a new model instance based on configuration
@@ -42,6 +50,16 @@ func NewModel(modelType string) (Model, error) {
 			return nil, fmt.Errorf("failed to load Google config: %w", err)
 		}
 		factory = NewGoogleModelFactory(config)
+	case "fake":
+		config := FakeConfig{
+			BaseConfig: BaseConfig{
+				ConfigPath: configPath,
+			},
+		}
+		if err := config.Load(); err != nil {
+			return nil, fmt.Errorf("failed to load fake config: %w", err)
+		}
+		factory = NewFakeModelFactory(config)
 	case "local":
 		config := LocalConfig{
 			BaseConfig: BaseConfig{
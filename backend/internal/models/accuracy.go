@@ -0,0 +1,69 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// ModelAccuracy is how well one model read labels, over a whole range and
+// period by period
+type ModelAccuracy struct {
+	Model   string           `json:"model"`
+	Total   *AccuracyStats   `json:"total"`
+	Periods []*AccuracyStats `json:"periods"`
+}
+
+// AccuracyStats compares what a model proposed with what users confirmed for
+// the scans of a period
+type AccuracyStats struct {
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Scans       int       `json:"scans"`
+	Corrected   int       `json:"corrected"` // scans with at least one value changed
+
+	// By nutrient, such as "calories"
+	Fields map[string]*FieldAccuracy `json:"fields"`
+}
+
+// FieldAccuracy measures the error on one nutrient
+type FieldAccuracy struct {
+	MAE            float64 `json:"mae"`              // mean absolute error, in grams or kcal per 100g
+	ExactMatchRate float64 `json:"exact_match_rate"` // share of scans left as proposed
+}
+
+// NewAccuracyStats returns empty stats for a period
+func NewAccuracyStats(start, end time.Time) *AccuracyStats {
+	return &AccuracyStats{
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Fields:      make(map[string]*FieldAccuracy),
+	}
+}
+
+// Add counts a scan given what the model proposed and what was confirmed.
+// Means are kept up to date as scans are added.
+func (a *AccuracyStats) Add(proposed, confirmed *ModelOutput) {
+	a.Scans++
+	n := float64(a.Scans)
+	corrected := false
+
+	want := confirmed.fields()
+	for i, f := range proposed.fields() {
+		stats := a.Fields[f.name]
+		if stats == nil {
+			stats = &FieldAccuracy{}
+			a.Fields[f.name] = stats
+		}
+		exact := 0.0
+		if f.value == want[i].value {
+			exact = 1
+		} else {
+			corrected = true
+		}
+		stats.MAE += (math.Abs(f.value-want[i].value) - stats.MAE) / n
+		stats.ExactMatchRate += (exact - stats.ExactMatchRate) / n
+	}
+	if corrected {
+		a.Corrected++
+	}
+}
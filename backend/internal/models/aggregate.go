@@ -0,0 +1,51 @@
+package models
+
+// Entries store values per 100g; these helpers turn them into the absolute
+// amounts that were actually eaten.
+
+// EatenWeight returns how many grams of the entry were consumed
+func (n *NutritionalInfo) EatenWeight() float64 {
+	if n.ConsumedWeight != nil {
+		return *n.ConsumedWeight
+	}
+	return n.TotalWeight
+}
+
+// SetRemainingWeight records partial consumption from the grams left over
+func (n *NutritionalInfo) SetRemainingWeight(remaining float64) {
+	consumed := n.TotalWeight - remaining
+	n.ConsumedWeight = &consumed
+}
+
+// AddEntry adds the amounts eaten from an entry
+func (t *NutritionTotals) AddEntry(n *NutritionalInfo) {
+	t.AddWeight(n, n.EatenWeight())
+	t.Entries++
+}
+
+// AddWeight adds the nutrients contained in the given grams of an entry
+func (t *NutritionTotals) AddWeight(n *NutritionalInfo, grams float64) {
+	factor := grams / 100
+	t.Weight += grams
+	t.Calories += n.Calories * factor
+	t.Protein += n.Protein * factor
+	t.Carbs += n.Carbs * factor
+	t.Fat += n.Fat * factor
+	t.Fiber += n.Fiber * factor
+	t.Sugar += n.Sugar * factor
+}
+
+// Merge adds another total into this one
+func (t *NutritionTotals) Merge(other *NutritionTotals) {
+	t.Entries += other.Entries
+	t.Weight += other.Weight
+	t.Calories += other.Calories
+	t.Protein += other.Protein
+	t.Carbs += other.Carbs
+	t.Fat += other.Fat
+	t.Fiber += other.Fiber
+	t.Sugar += other.Sugar
+	for meal, m := range other.Meals {
+		t.mealTotals(meal).add(m)
+	}
+}
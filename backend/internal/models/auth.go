@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// Kinds of AuthToken
+const (
+	TokenSession = "session" // issued by logging in or pairing a device; expires
+	TokenAPIKey  = "api_key" // created by a user for scripts; valid until revoked
+)
+
+// AuthToken is a session or API key. Only a hash of the secret is stored; the
+// secret itself is handed out once, when the token is created.
+type AuthToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Kind       string     `json:"kind"`
+	Name       string     `json:"name"` // device or key name chosen by the user
+	Hash       string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Expired reports whether the token can no longer be used at the given time
+func (t *AuthToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
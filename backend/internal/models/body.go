@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+)
+
+// BodyProfile holds what is needed to estimate a person's energy needs
+type BodyProfile struct {
+	UserID        string    `json:"user_id"`
+	Sex           string    `json:"sex"` // "male" or "female", as used by the BMR equations
+	BirthDate     time.Time `json:"birth_date"`
+	HeightCm      float64   `json:"height_cm"`
+	WeightKg      float64   `json:"weight_kg"`      // latest logged weight
+	ActivityLevel string    `json:"activity_level"` // sedentary, light, moderate, active or very_active
+	Plan          string    `json:"plan"`           // lose, maintain or gain
+	Formula       string    `json:"formula"`        // mifflin_st_jeor (default) or harris_benedict
+	AutoGoals     bool      `json:"auto_goals"`     // keep goals in line with the profile
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// BodyMetric is one set of body measurements. Any of them may be missing, e.g.
+// when only weight was measured.
+type BodyMetric struct {
+	ID             string    `json:"id"`
+	UserID         string    `json:"user_id"`
+	MeasuredAt     time.Time `json:"measured_at"`
+	WeightKg       *float64  `json:"weight_kg,omitempty"`
+	WaistCm        *float64  `json:"waist_cm,omitempty"`
+	BodyFatPercent *float64  `json:"body_fat_percent,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// BodyTrend holds the smoothed body measurements at a point in time, leaving
+// out day-to-day noise such as water weight
+type BodyTrend struct {
+	MeasuredAt     time.Time `json:"measured_at"`
+	WeightKg       *float64  `json:"weight_kg,omitempty"`
+	WaistCm        *float64  `json:"waist_cm,omitempty"`
+	BodyFatPercent *float64  `json:"body_fat_percent,omitempty"`
+}
+
+// PeriodTrend is the body trend at the end of a history period, next to what
+// was eaten in it
+type PeriodTrend struct {
+	PeriodStart  time.Time  `json:"period_start"`
+	PeriodEnd    time.Time  `json:"period_end"`
+	Trend        *BodyTrend `json:"trend,omitempty"` // nil before the first measurement
+	Measurements int        `json:"measurements"`    // taken during the period
+}
@@ -0,0 +1,199 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Energy per gram of each macronutrient, used to turn percentages of the
+// daily calories into grams
+const (
+	CaloriesPerGramProtein = 4.0
+	CaloriesPerGramCarbs   = 4.0
+	CaloriesPerGramFat     = 9.0
+)
+
+// TargetTolerance is how far, as a fraction of the target, calories and
+// macros may land from their target before a day is flagged over or under.
+// Nobody eats exactly 2000 kcal; 1950 is on target.
+const TargetTolerance = 0.05
+
+// Day types of a goal
+const (
+	GoalWeekday = "weekday"
+	GoalWeekend = "weekend"
+)
+
+// Goals are what a person aims to eat each day. Weekend targets apply on
+// Saturdays and Sundays; without them the weekday targets apply every day.
+type Goals struct {
+	UserID    string       `json:"user_id"`
+	Weekday   DailyTarget  `json:"weekday"`
+	Weekend   *DailyTarget `json:"weekend,omitempty"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// DailyTarget is one day's targets. Nutrients without a target are not tracked.
+type DailyTarget struct {
+	Calories *float64     `json:"calories,omitempty"` // budget in kcal
+	Protein  *MacroTarget `json:"protein,omitempty"`
+	Carbs    *MacroTarget `json:"carbs,omitempty"`
+	Fat      *MacroTarget `json:"fat,omitempty"`
+	FiberMin *float64     `json:"fiber_min,omitempty"` // grams to eat at least
+	SugarMax *float64     `json:"sugar_max,omitempty"` // grams to eat at most
+}
+
+// MacroTarget is a macronutrient target, either in grams or as a percentage
+// of the daily calories
+type MacroTarget struct {
+	Grams   *float64 `json:"grams,omitempty"`
+	Percent *float64 `json:"percent,omitempty"`
+}
+
+// TargetFor returns the targets that apply on the day of t
+func (g *Goals) TargetFor(t time.Time) DailyTarget {
+	if g.Weekend != nil && (t.Weekday() == time.Saturday || t.Weekday() == time.Sunday) {
+		return *g.Weekend
+	}
+	return g.Weekday
+}
+
+// Validate checks the weekday and weekend targets
+func (g *Goals) Validate() error {
+	if err := g.Weekday.Validate(); err != nil {
+		return fmt.Errorf("weekday: %w", err)
+	}
+	if g.Weekend != nil {
+		if err := g.Weekend.Validate(); err != nil {
+			return fmt.Errorf("weekend: %w", err)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the targets are consistent
+func (t *DailyTarget) Validate() error {
+	if t.Calories != nil && *t.Calories <= 0 {
+		return fmt.Errorf("calories must be positive")
+	}
+	if t.FiberMin != nil && *t.FiberMin < 0 {
+		return fmt.Errorf("fiber_min cannot be negative")
+	}
+	if t.SugarMax != nil && *t.SugarMax < 0 {
+		return fmt.Errorf("sugar_max cannot be negative")
+	}
+
+	percent := 0.0
+	macros := []struct {
+		name   string
+		target *MacroTarget
+	}{
+		{"protein", t.Protein},
+		{"carbs", t.Carbs},
+		{"fat", t.Fat},
+	}
+	for _, m := range macros {
+		if m.target == nil {
+			continue
+		}
+		switch {
+		case m.target.Grams != nil && m.target.Percent != nil:
+			return fmt.Errorf("%s: give grams or percent, not both", m.name)
+		case m.target.Grams != nil:
+			if *m.target.Grams < 0 {
+				return fmt.Errorf("%s grams cannot be negative", m.name)
+			}
+		case m.target.Percent != nil:
+			if *m.target.Percent < 0 || *m.target.Percent > 100 {
+				return fmt.Errorf("%s percent must be between 0 and 100", m.name)
+			}
+			if t.Calories == nil {
+				return fmt.Errorf("%s: a percentage needs a calorie target", m.name)
+			}
+			percent += *m.target.Percent
+		default:
+			return fmt.Errorf("%s: give grams or percent", m.name)
+		}
+	}
+	if percent > 100 {
+		return fmt.Errorf("macro percentages add up to more than 100")
+	}
+	return nil
+}
+
+// macroGrams resolves a macro target to grams given the energy per gram
+func (t *DailyTarget) macroGrams(m *MacroTarget, caloriesPerGram float64) *float64 {
+	if m == nil {
+		return nil
+	}
+	if m.Grams != nil {
+		return m.Grams
+	}
+	if m.Percent == nil || t.Calories == nil {
+		return nil
+	}
+	grams := *t.Calories * *m.Percent / 100 / caloriesPerGram
+	return &grams
+}
+
+// TargetProgress compares what was eaten of a nutrient with its target
+type TargetProgress struct {
+	Target    float64 `json:"target"`
+	Eaten     float64 `json:"eaten"`
+	Remaining float64 `json:"remaining"` // negative once the target is exceeded
+	Progress  float64 `json:"progress"`  // eaten / target
+	Over      bool    `json:"over"`
+	Under     bool    `json:"under"`
+}
+
+// DayProgress is how a day went against its targets
+type DayProgress struct {
+	PeriodStart time.Time       `json:"period_start"`
+	PeriodEnd   time.Time       `json:"period_end"`
+	Target      DailyTarget     `json:"target"`
+	Calories    *TargetProgress `json:"calories,omitempty"`
+	Protein     *TargetProgress `json:"protein,omitempty"`
+	Carbs       *TargetProgress `json:"carbs,omitempty"`
+	Fat         *TargetProgress `json:"fat,omitempty"`
+	Fiber       *TargetProgress `json:"fiber,omitempty"`
+	Sugar       *TargetProgress `json:"sugar,omitempty"`
+}
+
+// Evaluate compares a day's totals with the goals for that day. Calories and
+// macros are flagged when over or under their target by more than
+// TargetTolerance, fiber only when under its minimum and sugar only when over
+// its maximum, with no tolerance as those are limits.
+func (g *Goals) Evaluate(day *NutritionTotals) *DayProgress {
+	target := g.TargetFor(day.PeriodStart)
+	return &DayProgress{
+		PeriodStart: day.PeriodStart,
+		PeriodEnd:   day.PeriodEnd,
+		Target:      target,
+		Calories:    newTargetProgress(target.Calories, day.Calories, true, true, TargetTolerance),
+		Protein:     newTargetProgress(target.macroGrams(target.Protein, CaloriesPerGramProtein), day.Protein, true, true, TargetTolerance),
+		Carbs:       newTargetProgress(target.macroGrams(target.Carbs, CaloriesPerGramCarbs), day.Carbs, true, true, TargetTolerance),
+		Fat:         newTargetProgress(target.macroGrams(target.Fat, CaloriesPerGramFat), day.Fat, true, true, TargetTolerance),
+		Fiber:       newTargetProgress(target.FiberMin, day.Fiber, false, true, 0),
+		Sugar:       newTargetProgress(target.SugarMax, day.Sugar, true, false, 0),
+	}
+}
+
+// newTargetProgress returns nil when there is no target. Eating within
+// tolerance of the target, as a fraction of it, is neither over nor under.
+func newTargetProgress(target *float64, eaten float64, flagOver, flagUnder bool, tolerance float64) *TargetProgress {
+	if target == nil {
+		return nil
+	}
+	band := *target * tolerance
+	p := &TargetProgress{
+		Target:    *target,
+		Eaten:     eaten,
+		Remaining: *target - eaten,
+		Over:      flagOver && eaten > *target+band,
+		Under:     flagUnder && eaten < *target-band,
+	}
+	if *target > 0 {
+		p.Progress = eaten / *target
+	}
+	return p
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func ptr(v float64) *float64 { return &v }
+
+func TestEvaluate(t *testing.T) {
+	goals := &Goals{
+		Weekday: DailyTarget{
+			Calories: ptr(2000),
+			Protein:  &MacroTarget{Percent: ptr(20)}, // 100g
+			Fat:      &MacroTarget{Grams: ptr(70)},
+			FiberMin: ptr(30),
+			SugarMax: ptr(50),
+		},
+		Weekend: &DailyTarget{Calories: ptr(2500)},
+	}
+	monday := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	saturday := time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)
+
+	for _, tt := range []struct {
+		name        string
+		day         NutritionTotals
+		over, under []string
+	}{
+		{
+			name: "on target",
+			day:  NutritionTotals{PeriodStart: monday, Calories: 2000, Protein: 100, Fat: 70, Fiber: 30, Sugar: 50},
+		},
+		{
+			name: "within tolerance",
+			day:  NutritionTotals{PeriodStart: monday, Calories: 1950, Protein: 104, Fat: 66.5, Fiber: 35, Sugar: 20},
+		},
+		{
+			name: "edges of the tolerance",
+			day:  NutritionTotals{PeriodStart: monday, Calories: 2100, Protein: 95, Fat: 73.5, Fiber: 30, Sugar: 50},
+		},
+		{
+			name: "beyond tolerance",
+			day:  NutritionTotals{PeriodStart: monday, Calories: 2101, Protein: 94.9, Fat: 74, Fiber: 30, Sugar: 50},
+			over: []string{"calories", "fat"}, under: []string{"protein"},
+		},
+		{
+			name: "limits have no tolerance",
+			day:  NutritionTotals{PeriodStart: monday, Calories: 2000, Protein: 100, Fat: 70, Fiber: 29.5, Sugar: 50.5},
+			over: []string{"sugar"}, under: []string{"fiber"},
+		},
+		{
+			name: "limits flag one way only",
+			day:  NutritionTotals{PeriodStart: monday, Calories: 2000, Protein: 100, Fat: 70, Fiber: 60, Sugar: 0},
+		},
+		{
+			name:  "nothing eaten",
+			day:   NutritionTotals{PeriodStart: monday},
+			under: []string{"calories", "protein", "fat", "fiber"},
+		},
+		{
+			name: "weekend target",
+			day:  NutritionTotals{PeriodStart: saturday, Calories: 2400},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := goals.Evaluate(&tt.day)
+			targets := map[string]*TargetProgress{
+				"calories": p.Calories, "protein": p.Protein, "carbs": p.Carbs,
+				"fat": p.Fat, "fiber": p.Fiber, "sugar": p.Sugar,
+			}
+			for name, progress := range targets {
+				if progress == nil {
+					continue
+				}
+				if want := slices.Contains(tt.over, name); progress.Over != want {
+					t.Errorf("%s over = %v, want %v (%+v)", name, progress.Over, want, progress)
+				}
+				if want := slices.Contains(tt.under, name); progress.Under != want {
+					t.Errorf("%s under = %v, want %v (%+v)", name, progress.Under, want, progress)
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateProgress(t *testing.T) {
+	goals := &Goals{Weekday: DailyTarget{Calories: ptr(2000), Carbs: &MacroTarget{Percent: ptr(50)}}}
+	p := goals.Evaluate(&NutritionTotals{PeriodStart: time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), Calories: 1500, Carbs: 300})
+
+	if p.Calories.Remaining != 500 || p.Calories.Progress != 0.75 {
+		t.Errorf("calories = %+v", p.Calories)
+	}
+	if p.Carbs.Target != 250 || p.Carbs.Remaining != -50 || p.Carbs.Progress != 1.2 || !p.Carbs.Over {
+		t.Errorf("carbs = %+v", p.Carbs)
+	}
+	if p.Protein != nil || p.Fat != nil || p.Fiber != nil || p.Sugar != nil {
+		t.Errorf("progress without a target: %+v", p)
+	}
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Meal slots
+const (
+	MealBreakfast = "breakfast"
+	MealLunch     = "lunch"
+	MealDinner    = "dinner"
+	MealSnack     = "snack"
+)
+
+// Meals lists the meal slots in the order of the day
+var Meals = []string{MealBreakfast, MealLunch, MealDinner, MealSnack}
+
+// MealDateLayout is the format of meal dates
+const MealDateLayout = "2006-01-02"
+
+// EatenAt is when food logged at loggedAt and assigned to the diary day
+// mealDate counts in history: when it was logged, unless it was assigned to
+// another day in loc, in which case it counts at noon that day
+func EatenAt(loggedAt time.Time, mealDate string, loc *time.Location) time.Time {
+	if mealDate == "" || loggedAt.In(loc).Format(MealDateLayout) == mealDate {
+		return loggedAt
+	}
+	day, err := time.ParseInLocation(MealDateLayout, mealDate, loc)
+	if err != nil {
+		return loggedAt
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, loc)
+}
+
+// SuggestMeal guesses the meal slot from the local time food was logged at
+func SuggestMeal(t time.Time) string {
+	minutes := t.Hour()*60 + t.Minute()
+	switch {
+	case minutes >= 4*60 && minutes < 10*60+30:
+		return MealBreakfast
+	case minutes >= 10*60+30 && minutes < 15*60:
+		return MealLunch
+	case minutes >= 17*60 && minutes < 22*60:
+		return MealDinner
+	default:
+		return MealSnack
+	}
+}
+
+// ValidateMeal checks a meal slot and a YYYY-MM-DD meal date
+func ValidateMeal(meal, date string) error {
+	known := false
+	for _, m := range Meals {
+		known = known || m == meal
+	}
+	if !known {
+		return fmt.Errorf("meal must be breakfast, lunch, dinner or snack")
+	}
+	if _, err := time.Parse(MealDateLayout, date); err != nil {
+		return fmt.Errorf("meal_date must be a YYYY-MM-DD date")
+	}
+	return nil
+}
+
+// MealTotals is what was eaten at one meal slot within a period
+type MealTotals struct {
+	Entries  int     `json:"entries"`
+	Weight   float64 `json:"weight"`
+	Calories float64 `json:"calories"`
+	Protein  float64 `json:"protein"`
+	Carbs    float64 `json:"carbs"`
+	Fat      float64 `json:"fat"`
+	Fiber    float64 `json:"fiber"`
+	Sugar    float64 `json:"sugar"`
+}
+
+// add adds another meal total into this one
+func (m *MealTotals) add(other *MealTotals) {
+	m.Entries += other.Entries
+	m.Weight += other.Weight
+	m.Calories += other.Calories
+	m.Protein += other.Protein
+	m.Carbs += other.Carbs
+	m.Fat += other.Fat
+	m.Fiber += other.Fiber
+	m.Sugar += other.Sugar
+}
+
+// AddMeal adds what was eaten at a meal slot to the totals and to their
+// breakdown by meal
+func (t *NutritionTotals) AddMeal(meal string, m *MealTotals) {
+	t.Entries += m.Entries
+	t.Weight += m.Weight
+	t.Calories += m.Calories
+	t.Protein += m.Protein
+	t.Carbs += m.Carbs
+	t.Fat += m.Fat
+	t.Fiber += m.Fiber
+	t.Sugar += m.Sugar
+
+	if meal != "" {
+		t.mealTotals(meal).add(m)
+	}
+}
+
+// mealTotals returns the breakdown entry of a meal slot, creating it if needed
+func (t *NutritionTotals) mealTotals(meal string) *MealTotals {
+	if t.Meals == nil {
+		t.Meals = make(map[string]*MealTotals)
+	}
+	if t.Meals[meal] == nil {
+		t.Meals[meal] = &MealTotals{}
+	}
+	return t.Meals[meal]
+}
@@ -1,13 +1,29 @@
 package models
 
 import (
+	"fmt"
 	"time"
 )
 
 // NutritionalInfo represents the nutritional information extracted from a label
 type NutritionalInfo struct {
 	ID          string  `json:"id"`
-	TotalWeight float64 `json:"total_weight"` // in grams
+	UserID      string  `json:"user_id,omitempty"` // who logged the entry
+	TotalWeight float64 `json:"total_weight"`      // in grams
+
+	// Grams actually eaten; nil when the whole package was consumed
+	ConsumedWeight *float64 `json:"consumed_weight,omitempty"`
+
+	// Meal slot and diary day the entry was eaten at; suggested from the time
+	// it was logged
+	Meal     string `json:"meal,omitempty"`
+	MealDate string `json:"meal_date,omitempty"` // YYYY-MM-DD
+
+	// When the entry counts in history, see EatenAt; set by the database
+	EatenAt time.Time `json:"eaten_at"`
+
+	// Set when the entry is a portion of a recipe
+	RecipeID string `json:"recipe_id,omitempty"`
 
 	// Macronutrients (per 100g)
 	Calories float64 `json:"calories"` // kcal
@@ -18,18 +34,66 @@ type NutritionalInfo struct {
 	Sugar    float64 `json:"sugar"`    // grams
 
 	// Additional information
-	ImagePath string    `json:"image_path"` // path to the stored image
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ImagePath string     `json:"image_path"` // key of the photo in the image store
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // set when soft-deleted
+}
+
+// Validate checks that the values are physically plausible
+func (n *NutritionalInfo) Validate() error {
+	if n.TotalWeight <= 0 {
+		return fmt.Errorf("total weight must be positive")
+	}
+
+	values := []struct {
+		name  string
+		value float64
+	}{
+		{"calories", n.Calories},
+		{"protein", n.Protein},
+		{"carbs", n.Carbs},
+		{"fat", n.Fat},
+		{"fiber", n.Fiber},
+		{"sugar", n.Sugar},
+	}
+	for _, v := range values {
+		if v.value < 0 {
+			return fmt.Errorf("%s cannot be negative", v.name)
+		}
+	}
+
+	// Values are per 100g, so the macronutrients cannot weigh more than that
+	if n.Protein+n.Carbs+n.Fat > 100 {
+		return fmt.Errorf("protein, carbs and fat add up to more than 100g per 100g")
+	}
+	if n.Sugar > n.Carbs {
+		return fmt.Errorf("sugar cannot exceed carbs")
+	}
+
+	if n.ConsumedWeight != nil && (*n.ConsumedWeight < 0 || *n.ConsumedWeight > n.TotalWeight) {
+		return fmt.Errorf("consumed weight must be between 0 and the total weight")
+	}
+
+	return nil
 }
 
-// NutritionScan represents a scanning session
-type NutritionScan struct {
-	ID        string           `json:"id"`
-	ImageData []byte           `json:"image_data"` // Base64 encoded image
-	Status    string           `json:"status"`     // "pending", "processing", "completed", "failed"
-	Result    *NutritionalInfo `json:"result,omitempty"`
-	Error     string           `json:"error,omitempty"`
-	CreatedAt time.Time        `json:"created_at"`
-	UpdatedAt time.Time        `json:"updated_at"`
+// NutritionTotals is the sum of the entries logged in a period
+type NutritionTotals struct {
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Entries     int       `json:"entries"`
+	Weight      float64   `json:"weight"` // grams consumed
+
+	// Absolute amounts consumed in the period, not per 100g
+	Calories float64 `json:"calories"`
+	Protein  float64 `json:"protein"`
+	Carbs    float64 `json:"carbs"`
+	Fat      float64 `json:"fat"`
+	Fiber    float64 `json:"fiber"`
+	Sugar    float64 `json:"sugar"`
+
+	// Breakdown by meal slot; food that was not eaten at a meal, such as
+	// pantry purchases, is only in the totals above
+	Meals map[string]*MealTotals `json:"meals,omitempty"`
 }
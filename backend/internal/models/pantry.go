@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+)
+
+// Pantry item statuses
+const (
+	PantryInStock   = "in_stock"
+	PantryFinished  = "finished"
+	PantryDiscarded = "discarded"
+)
+
+// Pantry event kinds
+const (
+	PantryEventEaten     = "eaten"
+	PantryEventDiscarded = "discarded"
+	PantryEventCooked    = "cooked" // used in a recipe, eaten through the recipe's entries
+)
+
+// PantryItem is a confirmed scan kept in stock at home. It shares its ID with
+// the NutritionalInfo entry it was created from.
+type PantryItem struct {
+	ID              string           `json:"id"`
+	Entry           *NutritionalInfo `json:"entry,omitempty"`
+	InitialWeight   float64          `json:"initial_weight"`   // grams bought
+	RemainingWeight float64          `json:"remaining_weight"` // grams still in stock
+	Status          string           `json:"status"`           // "in_stock", "finished", "discarded"
+	CreatedAt       time.Time        `json:"created_at"`
+	UpdatedAt       time.Time        `json:"updated_at"`
+}
+
+// PantryEvent records grams taken out of a pantry item
+type PantryEvent struct {
+	ID        string    `json:"id"`
+	ItemID    string    `json:"item_id"`
+	UserID    string    `json:"user_id,omitempty"` // who logged the event
+	Kind      string    `json:"kind"`              // "eaten", "discarded" or "cooked"
+	Weight    float64   `json:"weight"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Meal slot and diary day; only for "eaten" events
+	Meal     string `json:"meal,omitempty"`
+	MealDate string `json:"meal_date,omitempty"`
+
+	// Who ate it; only for "eaten" events
+	Shares []ConsumptionShare `json:"shares,omitempty"`
+}
+
+// PantryActivity compares what came into the pantry with what left it in a period
+type PantryActivity struct {
+	PeriodStart time.Time        `json:"period_start"`
+	PeriodEnd   time.Time        `json:"period_end"`
+	Purchased   *NutritionTotals `json:"purchased"`
+	Eaten       *NutritionTotals `json:"eaten"`
+	Discarded   *NutritionTotals `json:"discarded"`
+	Cooked      *NutritionTotals `json:"cooked"`
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Recipe combines scanned products into a dish cooked at home
+type Recipe struct {
+	ID          string             `json:"id"`
+	HouseholdID string             `json:"household_id"`
+	Name        string             `json:"name"`
+	Ingredients []RecipeIngredient `json:"ingredients"`
+	YieldWeight float64            `json:"yield_weight"` // grams once cooked
+	Servings    int                `json:"servings"`
+	CreatedBy   string             `json:"created_by,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+	DeletedAt   *time.Time         `json:"deleted_at,omitempty"`
+
+	// Computed from the ingredients by Compute
+	Total      *Nutrients `json:"total,omitempty"`
+	Per100g    *Nutrients `json:"per_100g,omitempty"`
+	PerServing *Nutrients `json:"per_serving,omitempty"`
+}
+
+// RecipeIngredient is a quantity of a scanned product
+type RecipeIngredient struct {
+	EntryID string           `json:"entry_id"`
+	Weight  float64          `json:"weight"` // grams used in the whole recipe
+	Entry   *NutritionalInfo `json:"entry,omitempty"`
+}
+
+// Nutrients are the absolute amounts contained in a weight of food
+type Nutrients struct {
+	Weight   float64 `json:"weight"` // grams
+	Calories float64 `json:"calories"`
+	Protein  float64 `json:"protein"`
+	Carbs    float64 `json:"carbs"`
+	Fat      float64 `json:"fat"`
+	Fiber    float64 `json:"fiber"`
+	Sugar    float64 `json:"sugar"`
+}
+
+// scaled returns the nutrients in a weight of the same food
+func (n *Nutrients) scaled(weight float64) *Nutrients {
+	factor := 0.0
+	if n.Weight > 0 {
+		factor = weight / n.Weight
+	}
+	return &Nutrients{
+		Weight:   weight,
+		Calories: n.Calories * factor,
+		Protein:  n.Protein * factor,
+		Carbs:    n.Carbs * factor,
+		Fat:      n.Fat * factor,
+		Fiber:    n.Fiber * factor,
+		Sugar:    n.Sugar * factor,
+	}
+}
+
+// Compute works out the nutrition of the whole dish, per 100g once cooked
+// and per serving. The ingredients' entries must be loaded.
+func (r *Recipe) Compute() {
+	total := &Nutrients{}
+	for _, ing := range r.Ingredients {
+		if ing.Entry == nil {
+			continue
+		}
+		factor := ing.Weight / 100
+		total.Calories += ing.Entry.Calories * factor
+		total.Protein += ing.Entry.Protein * factor
+		total.Carbs += ing.Entry.Carbs * factor
+		total.Fat += ing.Entry.Fat * factor
+		total.Fiber += ing.Entry.Fiber * factor
+		total.Sugar += ing.Entry.Sugar * factor
+	}
+
+	// Cooking changes the weight, mostly water, but not the nutrients
+	total.Weight = r.YieldWeight
+	r.Total = total
+	r.Per100g = total.scaled(100)
+	if r.Servings > 0 {
+		r.PerServing = total.scaled(r.YieldWeight / float64(r.Servings))
+	}
+}
+
+// RawWeight is the total weight of the ingredients before cooking
+func (r *Recipe) RawWeight() float64 {
+	weight := 0.0
+	for _, ing := range r.Ingredients {
+		weight += ing.Weight
+	}
+	return weight
+}
+
+// Validate checks the recipe and, once computed, that its values per 100g are
+// physically possible
+func (r *Recipe) Validate() error {
+	switch {
+	case r.Name == "":
+		return fmt.Errorf("name is required")
+	case len(r.Ingredients) == 0:
+		return fmt.Errorf("a recipe needs ingredients")
+	case r.YieldWeight <= 0:
+		return fmt.Errorf("yield weight must be positive")
+	case r.Servings < 1:
+		return fmt.Errorf("servings must be at least 1")
+	}
+
+	seen := make(map[string]bool)
+	for _, ing := range r.Ingredients {
+		if ing.Weight <= 0 {
+			return fmt.Errorf("ingredient weights must be positive")
+		}
+		if seen[ing.EntryID] {
+			return fmt.Errorf("product %s is listed twice", ing.EntryID)
+		}
+		seen[ing.EntryID] = true
+	}
+
+	if r.Per100g != nil && r.Per100g.Protein+r.Per100g.Carbs+r.Per100g.Fat > 100 {
+		return fmt.Errorf("yield weight is too low for the ingredients")
+	}
+	return nil
+}
+
+// Entry turns grams of the cooked dish into a diary entry
+func (r *Recipe) Entry(weight float64) *NutritionalInfo {
+	return &NutritionalInfo{
+		TotalWeight: weight,
+		RecipeID:    r.ID,
+		Calories:    r.Per100g.Calories,
+		Protein:     r.Per100g.Protein,
+		Carbs:       r.Per100g.Carbs,
+		Fat:         r.Per100g.Fat,
+		Fiber:       r.Per100g.Fiber,
+		Sugar:       r.Per100g.Sugar,
+	}
+}
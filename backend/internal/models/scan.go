@@ -0,0 +1,89 @@
+package models
+
+import "time"
+
+// NutritionScan is a photo of a label read by a model, and the entry that
+// was created from it once confirmed
+type NutritionScan struct {
+	ID          string       `json:"id"`
+	EntryID     string       `json:"entry_id,omitempty"`
+	ImagePath   string       `json:"image_path"`             // key of the photo in the image store
+	Model       string       `json:"model,omitempty"`        // backend and model that read the label
+	ModelOutput *ModelOutput `json:"model_output,omitempty"` // what the model proposed
+	Status      string       `json:"status"`                 // "pending", "processing", "completed", "failed"
+	Error       string       `json:"error,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+
+	// What the user confirmed, including later edits of the entry's values
+	ConfirmedOutput *ModelOutput `json:"confirmed_output,omitempty"`
+
+	// Values of the entry that differ from what the model proposed; filled
+	// in when the scan is returned with its entry
+	Corrections []Correction `json:"corrections,omitempty"`
+}
+
+// ModelOutput holds the values per 100g a model read from a label
+type ModelOutput struct {
+	Calories float64 `json:"calories"`
+	Protein  float64 `json:"protein"`
+	Carbs    float64 `json:"carbs"`
+	Fat      float64 `json:"fat"`
+	Fiber    float64 `json:"fiber"`
+	Sugar    float64 `json:"sugar"`
+}
+
+// Correction is a value the user changed from what the model proposed
+type Correction struct {
+	Field     string  `json:"field"`
+	Model     float64 `json:"model"`
+	Confirmed float64 `json:"confirmed"`
+}
+
+// OutputOf returns the values a model proposed in a scan result
+func OutputOf(info *NutritionalInfo) *ModelOutput {
+	return &ModelOutput{
+		Calories: info.Calories,
+		Protein:  info.Protein,
+		Carbs:    info.Carbs,
+		Fat:      info.Fat,
+		Fiber:    info.Fiber,
+		Sugar:    info.Sugar,
+	}
+}
+
+// Corrections lists the values of an entry that differ from the output, in a
+// fixed order
+func (o *ModelOutput) Corrections(info *NutritionalInfo) []Correction {
+	return o.Diff(OutputOf(info))
+}
+
+// Diff lists the values of confirmed that differ from the output, in a fixed
+// order
+func (o *ModelOutput) Diff(confirmed *ModelOutput) []Correction {
+	want := confirmed.fields()
+	var corrections []Correction
+	for i, f := range o.fields() {
+		if f.value != want[i].value {
+			corrections = append(corrections, Correction{Field: f.name, Model: f.value, Confirmed: want[i].value})
+		}
+	}
+	return corrections
+}
+
+type outputField struct {
+	name  string
+	value float64
+}
+
+// fields lists the values of an output in a fixed order
+func (o *ModelOutput) fields() []outputField {
+	return []outputField{
+		{"calories", o.Calories},
+		{"protein", o.Protein},
+		{"carbs", o.Carbs},
+		{"fat", o.Fat},
+		{"fiber", o.Fiber},
+		{"sugar", o.Sugar},
+	}
+}
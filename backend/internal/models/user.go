@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+)
+
+// Household groups the people sharing a pantry
+type Household struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// User is a member of a household with their own diary
+type User struct {
+	ID          string    `json:"id"`
+	HouseholdID string    `json:"household_id"`
+	Name        string    `json:"name"`
+	Login       string    `json:"login,omitempty"`    // set once the user can sign in with a password
+	Timezone    string    `json:"timezone,omitempty"` // IANA name; empty for the server's time zone
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Location returns the time zone the user's days start and end in. Time zones
+// the system does not know fall back to the server's.
+func (u *User) Location() *time.Location {
+	if u.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// ConsumptionShare attributes a fraction of what was eaten to a person
+type ConsumptionShare struct {
+	UserID   string  `json:"user_id"`
+	Fraction float64 `json:"fraction"` // between 0 and 1; the shares of a meal add up to 1
+}
+
+// PersonTotals holds the consumption totals of one household member
+type PersonTotals struct {
+	User       *User              `json:"user"`
+	Totals     []*NutritionTotals `json:"totals"`
+	RangeTotal *NutritionTotals   `json:"range_total"`
+	Goals      *Goals             `json:"goals,omitempty"`
+	Progress   []*DayProgress     `json:"progress,omitempty"` // per day, when they have goals
+	Trend      []*PeriodTrend     `json:"trend,omitempty"`    // per period, when they log measurements
+}
@@ -0,0 +1,249 @@
+// Package nutrition estimates energy needs from body measurements and turns
+// them into daily goals.
+//
+// Basal metabolic rate (BMR) is the energy used at rest. Total daily energy
+// expenditure (TDEE) is BMR scaled by an activity factor. Calories are then
+// set below, at or above TDEE depending on whether the person wants to lose,
+// maintain or gain weight, and split into macronutrients.
+package nutrition
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// Sexes used by the BMR equations
+const (
+	SexMale   = "male"
+	SexFemale = "female"
+)
+
+// BMR equations
+const (
+	MifflinStJeor  = "mifflin_st_jeor"
+	HarrisBenedict = "harris_benedict"
+)
+
+// Plans
+const (
+	PlanLose     = "lose"
+	PlanMaintain = "maintain"
+	PlanGain     = "gain"
+)
+
+// ActivityFactors multiply BMR into TDEE
+var ActivityFactors = map[string]float64{
+	"sedentary":   1.2,   // desk job, little exercise
+	"light":       1.375, // exercise 1-3 days a week
+	"moderate":    1.55,  // exercise 3-5 days a week
+	"active":      1.725, // exercise 6-7 days a week
+	"very_active": 1.9,   // physical job or training twice a day
+}
+
+// planCalorieFactors scale TDEE for each plan: a 20% deficit to lose weight
+// and a 10% surplus to gain it
+var planCalorieFactors = map[string]float64{
+	PlanLose:     0.8,
+	PlanMaintain: 1.0,
+	PlanGain:     1.1,
+}
+
+// proteinPerKg is the daily protein target in grams per kg of body weight;
+// more protein preserves muscle in a deficit and builds it in a surplus
+var proteinPerKg = map[string]float64{
+	PlanLose:     1.6,
+	PlanMaintain: 1.2,
+	PlanGain:     1.6,
+}
+
+const (
+	// Share of calories from fat
+	fatShare = 0.30
+	// Fiber recommendation of 14g per 1000 kcal
+	fiberPer1000Calories = 14.0
+	// Free sugars should stay under 10% of calories
+	sugarShare = 0.10
+	// The equations are only valid for adults
+	minAge = 18
+)
+
+// Body holds the measurements the BMR equations need
+type Body struct {
+	Sex      string
+	Age      int
+	HeightCm float64
+	WeightKg float64
+}
+
+// Suggestion is what a person should eat each day according to their body
+// and plan, along with the figures it was derived from
+type Suggestion struct {
+	Formula  string  `json:"formula"`
+	BMR      float64 `json:"bmr"`
+	TDEE     float64 `json:"tdee"`
+	Calories float64 `json:"calories"`
+	Protein  float64 `json:"protein"`
+	Carbs    float64 `json:"carbs"`
+	Fat      float64 `json:"fat"`
+	FiberMin float64 `json:"fiber_min"`
+	SugarMax float64 `json:"sugar_max"`
+}
+
+// BMR computes the basal metabolic rate in kcal per day with the given equation
+func BMR(formula string, b Body) (float64, error) {
+	if err := b.validate(); err != nil {
+		return 0, err
+	}
+
+	switch formula {
+	case MifflinStJeor, "":
+		bmr := 10*b.WeightKg + 6.25*b.HeightCm - 5*float64(b.Age)
+		if b.Sex == SexMale {
+			return bmr + 5, nil
+		}
+		return bmr - 161, nil
+	case HarrisBenedict:
+		// Revised by Roza and Shizgal (1984)
+		if b.Sex == SexMale {
+			return 88.362 + 13.397*b.WeightKg + 4.799*b.HeightCm - 5.677*float64(b.Age), nil
+		}
+		return 447.593 + 9.247*b.WeightKg + 3.098*b.HeightCm - 4.330*float64(b.Age), nil
+	default:
+		return 0, fmt.Errorf("unknown formula %q", formula)
+	}
+}
+
+// TDEE scales a BMR by an activity level
+func TDEE(bmr float64, activity string) (float64, error) {
+	factor, ok := ActivityFactors[activity]
+	if !ok {
+		return 0, fmt.Errorf("unknown activity level %q", activity)
+	}
+	return bmr * factor, nil
+}
+
+// Suggest works out daily targets for a body, activity level and plan. Calories
+// never go below BMR.
+func Suggest(formula string, b Body, activity, plan string) (*Suggestion, error) {
+	if formula == "" {
+		formula = MifflinStJeor
+	}
+	bmr, err := BMR(formula, b)
+	if err != nil {
+		return nil, err
+	}
+	tdee, err := TDEE(bmr, activity)
+	if err != nil {
+		return nil, err
+	}
+	factor, ok := planCalorieFactors[plan]
+	if !ok {
+		return nil, fmt.Errorf("unknown plan %q", plan)
+	}
+
+	calories := math.Max(tdee*factor, bmr)
+	protein := proteinPerKg[plan] * b.WeightKg
+	fat := calories * fatShare / models.CaloriesPerGramFat
+	carbs := (calories - protein*models.CaloriesPerGramProtein - fat*models.CaloriesPerGramFat) / models.CaloriesPerGramCarbs
+
+	return &Suggestion{
+		Formula:  formula,
+		BMR:      round(bmr),
+		TDEE:     round(tdee),
+		Calories: round(calories),
+		Protein:  round(protein),
+		Carbs:    round(math.Max(carbs, 0)),
+		Fat:      round(fat),
+		FiberMin: round(calories / 1000 * fiberPer1000Calories),
+		SugarMax: round(calories * sugarShare / models.CaloriesPerGramCarbs),
+	}, nil
+}
+
+// SuggestFor works out the targets of a stored body profile at the given time
+func SuggestFor(p *models.BodyProfile, now time.Time) (*Suggestion, error) {
+	body := Body{
+		Sex:      p.Sex,
+		Age:      Age(p.BirthDate, now),
+		HeightCm: p.HeightCm,
+		WeightKg: p.WeightKg,
+	}
+	return Suggest(p.Formula, body, p.ActivityLevel, p.Plan)
+}
+
+// Goals turns a suggestion into the same targets for every day of the week
+func (s *Suggestion) Goals(userID string) *models.Goals {
+	return &models.Goals{
+		UserID: userID,
+		Weekday: models.DailyTarget{
+			Calories: ptr(s.Calories),
+			Protein:  &models.MacroTarget{Grams: ptr(s.Protein)},
+			Carbs:    &models.MacroTarget{Grams: ptr(s.Carbs)},
+			Fat:      &models.MacroTarget{Grams: ptr(s.Fat)},
+			FiberMin: ptr(s.FiberMin),
+			SugarMax: ptr(s.SugarMax),
+		},
+	}
+}
+
+// ValidateProfile checks the values of a body profile. Profiles of children
+// can be stored, but the equations do not apply to them so they cannot have
+// automatic goals.
+func ValidateProfile(p *models.BodyProfile, now time.Time) error {
+	switch {
+	case p.Sex != SexMale && p.Sex != SexFemale:
+		return fmt.Errorf("sex must be %s or %s", SexMale, SexFemale)
+	case p.BirthDate.IsZero() || !p.BirthDate.Before(now):
+		return fmt.Errorf("birth date must be in the past")
+	case p.HeightCm <= 0:
+		return fmt.Errorf("height must be positive")
+	case p.WeightKg <= 0:
+		return fmt.Errorf("weight must be positive")
+	}
+	if _, ok := ActivityFactors[p.ActivityLevel]; !ok {
+		return fmt.Errorf("unknown activity level %q", p.ActivityLevel)
+	}
+	if _, ok := planCalorieFactors[p.Plan]; !ok {
+		return fmt.Errorf("unknown plan %q", p.Plan)
+	}
+	if p.Formula != "" && p.Formula != MifflinStJeor && p.Formula != HarrisBenedict {
+		return fmt.Errorf("unknown formula %q", p.Formula)
+	}
+	if p.AutoGoals && Age(p.BirthDate, now) < minAge {
+		return fmt.Errorf("automatic goals are only available from age %d", minAge)
+	}
+	return nil
+}
+
+// Age returns the age in whole years at the given time
+func Age(birthDate, now time.Time) int {
+	age := now.Year() - birthDate.Year()
+	if now.Month() < birthDate.Month() || (now.Month() == birthDate.Month() && now.Day() < birthDate.Day()) {
+		age--
+	}
+	return age
+}
+
+func (b Body) validate() error {
+	switch {
+	case b.Sex != SexMale && b.Sex != SexFemale:
+		return fmt.Errorf("sex must be %s or %s", SexMale, SexFemale)
+	case b.Age < minAge:
+		return fmt.Errorf("the equations only apply from age %d", minAge)
+	case b.HeightCm <= 0:
+		return fmt.Errorf("height must be positive")
+	case b.WeightKg <= 0:
+		return fmt.Errorf("weight must be positive")
+	}
+	return nil
+}
+
+func round(v float64) float64 {
+	return math.Round(v)
+}
+
+func ptr(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,162 @@
+package nutrition
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+var (
+	man   = Body{Sex: SexMale, Age: 30, HeightCm: 180, WeightKg: 80}
+	woman = Body{Sex: SexFemale, Age: 25, HeightCm: 165, WeightKg: 60}
+)
+
+func TestBMR(t *testing.T) {
+	for _, tt := range []struct {
+		formula string
+		body    Body
+		want    float64
+	}{
+		// 10×80 + 6.25×180 − 5×30 + 5
+		{MifflinStJeor, man, 1780},
+		// 10×60 + 6.25×165 − 5×25 − 161
+		{MifflinStJeor, woman, 1345.25},
+		{"", man, 1780},
+		// 88.362 + 13.397×80 + 4.799×180 − 5.677×30
+		{HarrisBenedict, man, 1853.632},
+		// 447.593 + 9.247×60 + 3.098×165 − 4.330×25
+		{HarrisBenedict, woman, 1405.333},
+	} {
+		got, err := BMR(tt.formula, tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("BMR(%q, %+v) = %v, want %v", tt.formula, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestBMRInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		formula string
+		body    Body
+	}{
+		{"katch_mcardle", man},
+		{MifflinStJeor, Body{Sex: "other", Age: 30, HeightCm: 180, WeightKg: 80}},
+		{MifflinStJeor, Body{Sex: SexMale, Age: 17, HeightCm: 180, WeightKg: 80}},
+		{MifflinStJeor, Body{Sex: SexMale, Age: 30, HeightCm: 0, WeightKg: 80}},
+		{HarrisBenedict, Body{Sex: SexFemale, Age: 30, HeightCm: 165, WeightKg: -1}},
+	} {
+		if bmr, err := BMR(tt.formula, tt.body); err == nil {
+			t.Errorf("BMR(%q, %+v) = %v, want an error", tt.formula, tt.body, bmr)
+		}
+	}
+}
+
+func TestTDEE(t *testing.T) {
+	for activity, want := range map[string]float64{
+		"sedentary":   2136,
+		"light":       2447.5,
+		"moderate":    2759,
+		"active":      3070.5,
+		"very_active": 3382,
+	} {
+		got, err := TDEE(1780, activity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("TDEE(1780, %q) = %v, want %v", activity, got, want)
+		}
+	}
+	if _, err := TDEE(1780, "couch"); err == nil {
+		t.Error("unknown activity level accepted")
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		formula  string
+		body     Body
+		activity string
+		plan     string
+		want     Suggestion
+	}{
+		{
+			// TDEE 1780 × 1.55; fat 30% of 2759 kcal; carbs the rest after
+			// 1.2 g/kg of protein
+			name: "maintain", body: man, activity: "moderate", plan: PlanMaintain,
+			want: Suggestion{Formula: MifflinStJeor, BMR: 1780, TDEE: 2759, Calories: 2759,
+				Protein: 96, Carbs: 387, Fat: 92, FiberMin: 39, SugarMax: 69},
+		},
+		{
+			// 80% of TDEE 1614.3 is below BMR 1345.25, so calories stay at BMR
+			name: "deficit floored at BMR", body: woman, activity: "sedentary", plan: PlanLose,
+			want: Suggestion{Formula: MifflinStJeor, BMR: 1345, TDEE: 1614, Calories: 1345,
+				Protein: 96, Carbs: 139, Fat: 45, FiberMin: 19, SugarMax: 34},
+		},
+		{
+			// TDEE 1853.632 × 1.725 with a 10% surplus and 1.6 g/kg of protein
+			name: "gain", formula: HarrisBenedict, body: man, activity: "active", plan: PlanGain,
+			want: Suggestion{Formula: HarrisBenedict, BMR: 1854, TDEE: 3198, Calories: 3517,
+				Protein: 128, Carbs: 488, Fat: 117, FiberMin: 49, SugarMax: 88},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Suggest(tt.formula, tt.body, tt.activity, tt.plan)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("Suggest = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Suggest("", man, "moderate", "bulk"); err == nil {
+		t.Error("unknown plan accepted")
+	}
+	if _, err := Suggest("", man, "couch", PlanMaintain); err == nil {
+		t.Error("unknown activity level accepted")
+	}
+}
+
+func TestSuggestFor(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	profile := &models.BodyProfile{
+		Sex: SexMale, BirthDate: time.Date(1994, 6, 2, 0, 0, 0, 0, time.UTC),
+		HeightCm: 180, WeightKg: 80, ActivityLevel: "moderate", Plan: PlanMaintain,
+	}
+	// 29 until the next day: 5 kcal more than at 30
+	got, err := SuggestFor(profile, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.BMR != 1785 {
+		t.Errorf("BMR = %v, want 1785", got.BMR)
+	}
+	goals := got.Goals("user")
+	if *goals.Weekday.Calories != got.Calories || *goals.Weekday.Protein.Grams != got.Protein || goals.Weekend != nil {
+		t.Errorf("goals = %+v", goals)
+	}
+}
+
+func TestAge(t *testing.T) {
+	birth := time.Date(2000, 2, 29, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		now  time.Time
+		want int
+	}{
+		{time.Date(2018, 2, 28, 0, 0, 0, 0, time.UTC), 17},
+		{time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), 18},
+		{time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), 24},
+	} {
+		if got := Age(birth, tt.now); got != tt.want {
+			t.Errorf("Age on %s = %d, want %d", tt.now.Format(time.DateOnly), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package nutrition
+
+import (
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// TrendSmoothing is the weight of each new measurement in the exponential
+// moving average; 0.1 follows the Hacker's Diet and evens out a week or two
+// of daily weigh-ins
+const TrendSmoothing = 0.1
+
+// Trend smooths measurements sorted by time with an exponential moving
+// average. Each measurement gets the trend as of that point; weight, waist and
+// body fat are smoothed separately since they are not always measured together.
+func Trend(metrics []*models.BodyMetric) []*models.BodyTrend {
+	trends := make([]*models.BodyTrend, 0, len(metrics))
+	var weight, waist, bodyFat *float64
+	for _, m := range metrics {
+		weight = smooth(weight, m.WeightKg)
+		waist = smooth(waist, m.WaistCm)
+		bodyFat = smooth(bodyFat, m.BodyFatPercent)
+		trends = append(trends, &models.BodyTrend{
+			MeasuredAt:     m.MeasuredAt,
+			WeightKg:       weight,
+			WaistCm:        waist,
+			BodyFatPercent: bodyFat,
+		})
+	}
+	return trends
+}
+
+// smooth moves the trend towards a new value. The first value starts the trend
+// and a missing one leaves it as it was.
+func smooth(trend, value *float64) *float64 {
+	switch {
+	case value == nil:
+		return trend
+	case trend == nil:
+		return ptr(*value)
+	default:
+		return ptr(*trend + TrendSmoothing*(*value-*trend))
+	}
+}
@@ -0,0 +1,134 @@
+package nutrition
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// metrics makes daily measurements from weights and waists, where NaN stands
+// for a value that was not measured
+func metrics(weights, waists []float64) []*models.BodyMetric {
+	day := time.Date(2024, 3, 1, 7, 0, 0, 0, time.UTC)
+	value := func(values []float64, i int) *float64 {
+		if i >= len(values) || math.IsNaN(values[i]) {
+			return nil
+		}
+		return ptr(values[i])
+	}
+	var result []*models.BodyMetric
+	for i := 0; i < max(len(weights), len(waists)); i++ {
+		result = append(result, &models.BodyMetric{
+			MeasuredAt: day.AddDate(0, 0, i),
+			WeightKg:   value(weights, i),
+			WaistCm:    value(waists, i),
+		})
+	}
+	return result
+}
+
+// values reads a series of trends back, NaN where there is none yet
+func values(trends []*models.BodyTrend, field func(*models.BodyTrend) *float64) []float64 {
+	result := make([]float64, len(trends))
+	for i, trend := range trends {
+		result[i] = math.NaN()
+		if v := field(trend); v != nil {
+			result[i] = *v
+		}
+	}
+	return result
+}
+
+func TestTrend(t *testing.T) {
+	nan := math.NaN()
+	weight := func(trend *models.BodyTrend) *float64 { return trend.WeightKg }
+	waist := func(trend *models.BodyTrend) *float64 { return trend.WaistCm }
+
+	for _, tt := range []struct {
+		name                  string
+		weights, waists       []float64
+		wantWeight, wantWaist []float64
+	}{
+		{name: "no measurements"},
+		{
+			name:       "single measurement starts the trend",
+			weights:    []float64{80},
+			wantWeight: []float64{80}, wantWaist: []float64{nan},
+		},
+		{
+			// 80 + 0.1×(81 − 80), then 80.1 + 0.1×(79 − 80.1)
+			name:       "moves a tenth of the way",
+			weights:    []float64{80, 81, 79},
+			wantWeight: []float64{80, 80.1, 79.99}, wantWaist: []float64{nan, nan, nan},
+		},
+		{
+			name:       "steady weight stays put",
+			weights:    []float64{72.5, 72.5, 72.5, 72.5},
+			wantWeight: []float64{72.5, 72.5, 72.5, 72.5}, wantWaist: []float64{nan, nan, nan, nan},
+		},
+		{
+			// A day without a weigh-in keeps the trend; the next one moves it
+			// from there as if the day had not happened
+			name:       "gaps keep the trend",
+			weights:    []float64{80, nan, nan, 90},
+			wantWeight: []float64{80, 80, 80, 81}, wantWaist: []float64{nan, nan, nan, nan},
+		},
+		{
+			// Each measurement is smoothed on its own: the waist starts its
+			// trend on the day it is first measured
+			name:       "measurements taken on different days",
+			weights:    []float64{80, nan, 70},
+			waists:     []float64{nan, 90, 100},
+			wantWeight: []float64{80, 80, 79}, wantWaist: []float64{nan, 90, 91},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			input := metrics(tt.weights, tt.waists)
+			trends := Trend(input)
+			if trends == nil || len(trends) != len(input) {
+				t.Fatalf("%d trends for %d measurements", len(trends), len(input))
+			}
+			for i, trend := range trends {
+				if !trend.MeasuredAt.Equal(input[i].MeasuredAt) {
+					t.Errorf("trend %d at %v, want %v", i, trend.MeasuredAt, input[i].MeasuredAt)
+				}
+				if trend.BodyFatPercent != nil {
+					t.Errorf("trend %d has body fat %v without measurements", i, *trend.BodyFatPercent)
+				}
+			}
+			if got := values(trends, weight); !sameSeries(got, tt.wantWeight) {
+				t.Errorf("weight trend = %v, want %v", got, tt.wantWeight)
+			}
+			if got := values(trends, waist); !sameSeries(got, tt.wantWaist) {
+				t.Errorf("waist trend = %v, want %v", got, tt.wantWaist)
+			}
+		})
+	}
+}
+
+func TestTrendDoesNotAliasMeasurements(t *testing.T) {
+	input := metrics([]float64{80}, nil)
+	trends := Trend(input)
+	*input[0].WeightKg = 90
+	if *trends[0].WeightKg != 80 {
+		t.Errorf("changing a measurement changed its trend to %v", *trends[0].WeightKg)
+	}
+}
+
+// sameSeries compares series to within rounding, NaN matching NaN
+func sameSeries(got, want []float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if math.IsNaN(got[i]) != math.IsNaN(want[i]) {
+			return false
+		}
+		if !math.IsNaN(got[i]) && math.Abs(got[i]-want[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
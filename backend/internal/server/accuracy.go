@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// handleGetAccuracyReport measures how often users correct what each model
+// read from labels: per nutrient, the mean absolute error and the share of
+// scans confirmed as proposed, over the range and period by period, so that
+// a change of prompt or model can be judged. It takes the same from, to and
+// group_by parameters as get_history.
+func (s *Server) handleGetAccuracyReport(ctx context.Context, c *client, data map[string]any) {
+	req, err := parseHistoryRequest(data, time.Now().In(c.currentUser().Location()))
+	if err != nil {
+		s.sendError(c, "Invalid report request: "+err.Error())
+		return
+	}
+
+	periods, err := splitPeriods(req.from, req.to, req.groupBy)
+	if err != nil {
+		s.sendError(c, "Invalid report request: "+err.Error())
+		return
+	}
+
+	scans, err := s.db.ListConfirmedScans(ctx, c.currentUser().HouseholdID, req.from, req.to)
+	if err != nil {
+		log.Printf("Error computing accuracy report: %v", err)
+		s.sendError(c, "Failed to compute accuracy report")
+		return
+	}
+
+	byModel := make(map[string]*models.ModelAccuracy)
+	report := []*models.ModelAccuracy{}
+	for _, scan := range scans {
+		accuracy := byModel[scan.Model]
+		if accuracy == nil {
+			accuracy = &models.ModelAccuracy{
+				Model: scan.Model,
+				Total: models.NewAccuracyStats(req.from, req.to),
+			}
+			for _, p := range periods {
+				accuracy.Periods = append(accuracy.Periods, models.NewAccuracyStats(p.Start, p.End))
+			}
+			byModel[scan.Model] = accuracy
+			report = append(report, accuracy)
+		}
+
+		accuracy.Total.Add(scan.ModelOutput, scan.ConfirmedOutput)
+		i := sort.Search(len(periods), func(i int) bool { return scan.CreatedAt.Before(periods[i].End) })
+		if i < len(periods) {
+			accuracy.Periods[i].Add(scan.ModelOutput, scan.ConfirmedOutput)
+		}
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Model < report[j].Model })
+
+	s.sendMessage(c, "accuracy_report", map[string]any{
+		"from":     req.from,
+		"to":       req.to,
+		"group_by": req.groupBy,
+		"models":   report,
+	})
+}
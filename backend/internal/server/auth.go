@@ -0,0 +1,478 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookie     = "nv_session"
+	minPasswordLength = 8
+	maxAuthBodySize   = 64 << 10
+)
+
+// errUnauthenticated is returned by authenticate when the request carries no
+// valid session or API key
+var errUnauthenticated = errors.New("not signed in")
+
+// dummyPasswordHash is compared against when a login does not exist, so that
+// unknown logins take as long to reject as wrong passwords
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// checkOrigin lets browsers connect from the server's own pages and from the
+// configured origins. Requests without an Origin header do not come from a
+// web page and are let through; they still need to authenticate.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range s.auth.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+
+	log.Printf("Rejected request from origin %s", origin)
+	return false
+}
+
+// authenticate returns the user a request acts for. The session or API key is
+// read from the Authorization header, the session cookie or the token query
+// parameter, in that order.
+func (s *Server) authenticate(r *http.Request) (*models.User, *models.AuthToken, error) {
+	secret := requestToken(r)
+	if secret == "" {
+		return nil, nil, errUnauthenticated
+	}
+
+	token, err := s.db.GetTokenByHash(r.Context(), hashToken(secret))
+	if err != nil {
+		return nil, nil, err
+	}
+	if token == nil || token.Expired(time.Now()) {
+		return nil, nil, errUnauthenticated
+	}
+
+	user, err := s.db.GetUser(r.Context(), token.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, errUnauthenticated
+	}
+	return user, token, nil
+}
+
+// requestToken extracts the secret of a session or API key from a request
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		return cookie.Value
+	}
+	return r.URL.Query().Get("token")
+}
+
+// issueToken creates a session or API key for a user and returns its secret,
+// which is not stored and cannot be retrieved later
+func (s *Server) issueToken(ctx context.Context, userID, kind, name string) (string, *models.AuthToken, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, fmt.Errorf("error generating token: %w", err)
+	}
+	secret := base64.RawURLEncoding.EncodeToString(buf)
+
+	token := &models.AuthToken{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Kind:      kind,
+		Name:      name,
+		Hash:      hashToken(secret),
+		CreatedAt: time.Now(),
+	}
+	if kind == models.TokenSession {
+		expires := token.CreatedAt.Add(s.auth.SessionTTL.Duration)
+		token.ExpiresAt = &expires
+	}
+
+	if err := s.db.SaveToken(ctx, token); err != nil {
+		return "", nil, err
+	}
+	return secret, token, nil
+}
+
+// hashToken is how secrets are stored; they are random, so a plain hash suffices
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// handleLogin signs a device in with a login and password:
+//
+//	POST /api/login {"login": "...", "password": "...", "device": "Kitchen tablet"}
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Login    string `json:"login"`
+		Password string `json:"password"`
+		Device   string `json:"device"`
+	}
+	if !decodeAuthRequest(w, r, &req) {
+		return
+	}
+
+	// Guessing is slowed down both from one client and against one login
+	keys := []string{clientKey(r), loginKey(req.Login)}
+	if refuseThrottled(w, s.authFailures.wait(time.Now(), keys...)) {
+		log.Printf("Refused sign in for %s from %s after too many failures", req.Login, r.RemoteAddr)
+		return
+	}
+
+	user, passwordHash, err := s.db.GetCredentials(r.Context(), req.Login)
+	if err != nil {
+		log.Printf("Error retrieving credentials: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to sign in")
+		return
+	}
+	if user == nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(req.Password))
+		s.authFailures.fail(time.Now(), keys...)
+		writeJSONError(w, http.StatusUnauthorized, "Wrong login or password")
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
+		log.Printf("Failed sign in for %s from %s", req.Login, r.RemoteAddr)
+		s.authFailures.fail(time.Now(), keys...)
+		writeJSONError(w, http.StatusUnauthorized, "Wrong login or password")
+		return
+	}
+
+	s.authFailures.reset(keys...)
+	s.startSession(w, r, user, req.Device)
+}
+
+// handlePair signs a device in with a one-time code shown on the server console:
+//
+//	POST /api/pair {"code": "ABCD-EFGH", "device": "Phone"}
+func (s *Server) handlePair(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code   string `json:"code"`
+		Device string `json:"device"`
+	}
+	if !decodeAuthRequest(w, r, &req) {
+		return
+	}
+
+	client := clientKey(r)
+	if refuseThrottled(w, s.authFailures.wait(time.Now(), client)) {
+		log.Printf("Refused pairing code from %s after too many failures", r.RemoteAddr)
+		return
+	}
+	userID, ok := s.pairing.redeem(req.Code, time.Now())
+	if !ok {
+		log.Printf("Rejected pairing code from %s", r.RemoteAddr)
+		s.authFailures.fail(time.Now(), client)
+		writeJSONError(w, http.StatusUnauthorized, "Invalid or expired pairing code")
+		return
+	}
+	s.authFailures.reset(client)
+
+	user, err := s.db.GetUser(r.Context(), userID)
+	if err != nil || user == nil {
+		log.Printf("Error retrieving user %s: %v", userID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to pair device")
+		return
+	}
+
+	s.startSession(w, r, user, req.Device)
+}
+
+// startSession issues a session token, sets it as a cookie for browsers and
+// returns it for other clients
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, user *models.User, device string) {
+	if device == "" {
+		device = r.UserAgent()
+	}
+
+	secret, token, err := s.issueToken(r.Context(), user.ID, models.TokenSession, device)
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to sign in")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    secret,
+		Path:     "/",
+		Expires:  *token.ExpiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	log.Printf("User %s signed in on %q", user.ID, device)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"token":   secret,
+		"session": token,
+		"user":    user,
+	})
+}
+
+// handleLogout revokes the session the request was made with
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Use POST")
+		return
+	}
+
+	user, token, err := s.authenticate(r)
+	if err == nil {
+		if err := s.db.DeleteToken(r.Context(), user.ID, token.ID); err != nil && !errors.Is(err, database.ErrNotFound) {
+			log.Printf("Error revoking session %s: %v", token.ID, err)
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSession tells the web app whether it is signed in, and as whom
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	if s.auth.Disabled {
+		user, err := s.db.EnsureDefaultUser(r.Context())
+		if err != nil {
+			log.Printf("Error retrieving default user: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to retrieve session")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"user": user, "auth": false})
+		return
+	}
+
+	user, token, err := s.authenticate(r)
+	if errors.Is(err, errUnauthenticated) {
+		writeJSONError(w, http.StatusUnauthorized, "Not signed in")
+		return
+	}
+	if err != nil {
+		log.Printf("Error checking session: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to retrieve session")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"user": user, "session": token, "auth": true})
+}
+
+// decodeAuthRequest checks the method of a sign in request and decodes its
+// JSON body. It writes the error response and returns false if
+// the request is rejected.
+func decodeAuthRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Use POST")
+		return false
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAuthBodySize)).Decode(v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// withOrigin rejects requests from web pages on origins that are not allowed
+func (s *Server) withOrigin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.checkOrigin(r) {
+			writeJSONError(w, http.StatusForbidden, "Origin not allowed")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleSetPassword lets the user sign in with a login and password from now on.
+// A user who already has a password must give it as current_password, so that
+// a device left signed in cannot take the account over.
+func (s *Server) handleSetPassword(ctx context.Context, c *client, data map[string]any) {
+	login, _ := data["login"].(string)
+	password, _ := data["password"].(string)
+	current, _ := data["current_password"].(string)
+	login = strings.TrimSpace(login)
+	if login == "" {
+		s.sendError(c, "Missing login")
+		return
+	}
+	if len(password) < minPasswordLength {
+		s.sendError(c, fmt.Sprintf("Password must be at least %d characters", minPasswordLength))
+		return
+	}
+
+	me := c.currentUser()
+	if !s.checkCurrentPassword(ctx, c, me, current) {
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		s.sendError(c, "Failed to set password")
+		return
+	}
+
+	err = s.db.SetCredentials(ctx, me.ID, login, string(hash))
+	if errors.Is(err, database.ErrLoginTaken) {
+		s.sendError(c, "Login "+login+" is already taken")
+		return
+	}
+	if err != nil {
+		log.Printf("Error setting credentials of user %s: %v", me.ID, err)
+		s.sendError(c, "Failed to set password")
+		return
+	}
+
+	updated := *me
+	updated.Login = login
+	c.user.Store(&updated)
+	log.Printf("User %s set their password", me.ID)
+	s.sendMessage(c, "password_set", &updated)
+}
+
+// checkCurrentPassword reports whether password is the one the user signs in
+// with, sending an error if not. Users without a password pass. Wrong
+// passwords count towards the throttling of the user's login.
+func (s *Server) checkCurrentPassword(ctx context.Context, c *client, me *models.User, password string) bool {
+	// The login may have been set from another device since this one signed in
+	user, err := s.db.GetUser(ctx, me.ID)
+	if err != nil || user == nil {
+		log.Printf("Error retrieving user %s: %v", me.ID, err)
+		s.sendError(c, "Failed to set password")
+		return false
+	}
+	if user.Login == "" {
+		return true
+	}
+	key := loginKey(user.Login)
+	if s.authFailures.wait(time.Now(), key) > 0 {
+		s.sendError(c, "Too many failed attempts, try again later")
+		return false
+	}
+
+	_, passwordHash, err := s.db.GetCredentials(ctx, user.Login)
+	if err != nil {
+		log.Printf("Error retrieving credentials of user %s: %v", user.ID, err)
+		s.sendError(c, "Failed to set password")
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+		log.Printf("Wrong current password for user %s", user.ID)
+		s.authFailures.fail(time.Now(), key)
+		s.sendError(c, "Wrong current password")
+		return false
+	}
+	return true
+}
+
+// handleCreateAPIKey creates a key for scripts and other non-browser clients.
+// The key is only ever returned here.
+func (s *Server) handleCreateAPIKey(ctx context.Context, c *client, data map[string]any) {
+	name, ok := data["name"].(string)
+	if !ok || name == "" {
+		s.sendError(c, "Missing key name")
+		return
+	}
+
+	secret, token, err := s.issueToken(ctx, c.currentUser().ID, models.TokenAPIKey, name)
+	if err != nil {
+		log.Printf("Error creating API key: %v", err)
+		s.sendError(c, "Failed to create API key")
+		return
+	}
+
+	s.sendMessage(c, "api_key_created", map[string]any{
+		"key":   secret,
+		"token": token,
+	})
+}
+
+// handleListTokens lists the user's signed in devices and API keys
+func (s *Server) handleListTokens(ctx context.Context, c *client, data map[string]any) {
+	tokens, err := s.db.ListTokens(ctx, c.currentUser().ID)
+	if err != nil {
+		log.Printf("Error retrieving tokens: %v", err)
+		s.sendError(c, "Failed to retrieve devices")
+		return
+	}
+
+	s.sendMessage(c, "tokens", map[string]any{
+		"tokens": tokens,
+	})
+}
+
+// handleRevokeToken signs a device out or deletes an API key. Connections
+// already open with it stay open until they disconnect.
+func (s *Server) handleRevokeToken(ctx context.Context, c *client, data map[string]any) {
+	id, ok := data["id"].(string)
+	if !ok || id == "" {
+		s.sendError(c, "Missing token ID")
+		return
+	}
+
+	err := s.db.DeleteToken(ctx, c.currentUser().ID, id)
+	if errors.Is(err, database.ErrNotFound) {
+		s.sendError(c, "Token not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking token %s: %v", id, err)
+		s.sendError(c, "Failed to revoke token")
+		return
+	}
+
+	s.sendMessage(c, "token_revoked", map[string]any{"id": id})
+}
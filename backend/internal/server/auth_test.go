@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/config"
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// post sends a JSON body to an auth handler from the client at addr
+func post(handler http.HandlerFunc, addr, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.RemoteAddr = addr
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestSetPassword(t *testing.T) {
+	s, _, c := testServer(t)
+	other := testClient(s, c.currentUser())
+
+	// The first password needs no current one
+	send(t, s, c, "set_password", `{"login": "alex", "password": "first password"}`)
+	if user := find(t, received(t, c), "password_set"); user["login"] != "alex" {
+		t.Errorf("user after setting password = %v", user)
+	}
+
+	// From then on it does, from every device
+	for _, data := range []string{
+		`{"login": "alex", "password": "second password"}`,
+		`{"login": "alex", "password": "second password", "current_password": "wrong password"}`,
+	} {
+		send(t, s, other, "set_password", data)
+		if messages := received(t, other); len(messages) != 1 || messages[0]["type"] != "error" {
+			t.Errorf("set password %s: %v", data, messages)
+		}
+	}
+	send(t, s, other, "set_password", `{"login": "alex", "password": "second password", "current_password": "first password"}`)
+	find(t, received(t, other), "password_set")
+
+	s.auth.SessionTTL = config.Duration{Duration: time.Hour}
+	if w := post(s.handleLogin, "192.0.2.1:1234", `{"login": "alex", "password": "second password"}`); w.Code != http.StatusOK {
+		t.Errorf("signing in with the new password: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestLoginThrottled(t *testing.T) {
+	s, _, c := testServer(t)
+	s.auth.SessionTTL = config.Duration{Duration: time.Hour}
+	send(t, s, c, "set_password", `{"login": "alex", "password": "right password"}`)
+	find(t, received(t, c), "password_set")
+
+	// Guessing from one client is refused, whichever login it tries
+	for i := 0; i < maxAuthFailures; i++ {
+		if w := post(s.handleLogin, "192.0.2.1:1234", `{"login": "user`+string(rune('a'+i))+`", "password": "guess"}`); w.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: %d %s", i, w.Code, w.Body)
+		}
+	}
+	w := post(s.handleLogin, "192.0.2.1:1234", `{"login": "alex", "password": "right password"}`)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("client that guessed: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := post(s.handleLogin, "192.0.2.2:1234", `{"login": "alex", "password": "right password"}`); w.Code != http.StatusOK {
+		t.Errorf("another client: %d %s", w.Code, w.Body)
+	}
+
+	// Guessing one login from many clients is refused too
+	for i := 0; i < maxAuthFailures; i++ {
+		addr := "198.51.100." + string(rune('0'+i)) + ":1234"
+		post(s.handleLogin, addr, `{"login": "alex", "password": "guess"}`)
+	}
+	if w := post(s.handleLogin, "192.0.2.3:1234", `{"login": "alex", "password": "right password"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("login that was guessed: %d %s", w.Code, w.Body)
+	}
+
+	// Until the failures are old enough
+	later := time.Now().Add(authFailureWindow)
+	if wait := s.authFailures.wait(later, "client:192.0.2.1", loginKey("alex")); wait > 0 {
+		t.Errorf("still refused after the window: %s", wait)
+	}
+}
+
+func TestPairThrottled(t *testing.T) {
+	s, _, c := testServer(t)
+	s.auth.SessionTTL = config.Duration{Duration: time.Hour}
+	s.auth.PairingCodeTTL = config.Duration{Duration: time.Minute}
+
+	send(t, s, c, "create_pairing_code", `{}`)
+	code, _ := find(t, received(t, c), "pairing_code")["code"].(string)
+
+	for i := 0; i < maxAuthFailures; i++ {
+		if w := post(s.handlePair, "192.0.2.1:1234", `{"code": "AAAA-AAAA"}`); w.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: %d %s", i, w.Code, w.Body)
+		}
+	}
+	if w := post(s.handlePair, "192.0.2.1:1234", `{"code": "`+code+`"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("client that guessed: %d %s", w.Code, w.Body)
+	}
+
+	// The code of the user was not cancelled by the guessing
+	w := post(s.handlePair, "192.0.2.2:1234", `{"code": "`+code+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("pairing from another client: %d %s", w.Code, w.Body)
+	}
+	var paired struct{ User models.User }
+	if err := json.Unmarshal(w.Body.Bytes(), &paired); err != nil || paired.User.ID != c.currentUser().ID {
+		t.Errorf("paired as %+v, %v", paired.User, err)
+	}
+}
+
+func TestCreatePairingCodeForOtherMember(t *testing.T) {
+	s, _, c := testServer(t)
+	s.auth.SessionTTL = config.Duration{Duration: time.Hour}
+	s.auth.PairingCodeTTL = config.Duration{Duration: time.Minute}
+	send(t, s, c, "add_user", `{"name": "Sam"}`)
+	sam := find(t, received(t, c), "user_added")
+	samID := sam["id"].(string)
+
+	// A member who was just added gets their first device from someone else
+	send(t, s, c, "create_pairing_code", `{"user_id": "`+samID+`"}`)
+	pairing := find(t, received(t, c), "pairing_code")
+	code, _ := pairing["code"].(string)
+	w := post(s.handlePair, "192.0.2.1:1234", `{"code": "`+code+`", "device": "Sam's phone"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("pairing the new member: %d %s", w.Code, w.Body)
+	}
+	var paired struct {
+		Token string
+		User  models.User
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &paired); err != nil || paired.User.ID != samID || paired.Token == "" {
+		t.Errorf("paired as %+v, %v", paired.User, err)
+	}
+
+	// Once they have a session, only they pair their devices
+	send(t, s, c, "create_pairing_code", `{"user_id": "`+samID+`"}`)
+	if messages := received(t, c); len(messages) != 1 || messages[0]["type"] != "error" {
+		t.Errorf("pairing a device as a member who signed in: %v", messages)
+	}
+	send(t, s, c, "create_pairing_code", `{"user_id": "unknown"}`)
+	if messages := received(t, c); len(messages) != 1 || messages[0]["type"] != "error" {
+		t.Errorf("pairing a device as an unknown user: %v", messages)
+	}
+	send(t, s, c, "create_pairing_code", `{"user_id": "`+c.currentUser().ID+`"}`)
+	find(t, received(t, c), "pairing_code")
+
+	// So do members with a password
+	send(t, s, c, "set_password", `{"login": "alex", "password": "right password"}`)
+	find(t, received(t, c), "password_set")
+	other := testClient(s, &models.User{ID: samID, HouseholdID: c.currentUser().HouseholdID, Name: "Sam"})
+	send(t, s, other, "create_pairing_code", `{"user_id": "`+c.currentUser().ID+`"}`)
+	if messages := received(t, other); len(messages) != 1 || messages[0]["type"] != "error" {
+		t.Errorf("pairing a device as a member with a password: %v", messages)
+	}
+}
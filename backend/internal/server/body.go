@@ -0,0 +1,381 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"github.com/franckalain/nutritionalvalue/internal/nutrition"
+	"github.com/google/uuid"
+)
+
+// handleGetBodyProfile returns the body profile of a household member and the
+// goals suggested from it; profile is null when they have none
+func (s *Server) handleGetBodyProfile(ctx context.Context, c *client, data map[string]any) {
+	user, ok := s.subjectUser(ctx, c, data)
+	if !ok {
+		return
+	}
+
+	profile, err := s.db.GetBodyProfile(ctx, user.ID)
+	if err != nil {
+		log.Printf("Error retrieving body profile of user %s: %v", user.ID, err)
+		s.sendError(c, "Failed to retrieve body profile")
+		return
+	}
+
+	s.sendMessage(c, "body_profile", bodyProfileResponse(user.ID, profile))
+}
+
+// handleSetBodyProfile creates or updates the body profile of a household
+// member. Only the fields present in data are changed; a new profile needs
+// sex, birth_date (YYYY-MM-DD), height_cm, weight_kg, activity_level and plan.
+// With auto_goals set, the member's goals follow the suggestion.
+func (s *Server) handleSetBodyProfile(ctx context.Context, c *client, data map[string]any) {
+	user, ok := s.subjectUser(ctx, c, data)
+	if !ok {
+		return
+	}
+
+	profile, err := s.db.GetBodyProfile(ctx, user.ID)
+	if err != nil {
+		log.Printf("Error retrieving body profile of user %s: %v", user.ID, err)
+		s.sendError(c, "Failed to retrieve body profile")
+		return
+	}
+	if profile == nil {
+		profile = &models.BodyProfile{UserID: user.ID, Formula: nutrition.MifflinStJeor}
+	}
+
+	texts := map[string]*string{
+		"sex":            &profile.Sex,
+		"activity_level": &profile.ActivityLevel,
+		"plan":           &profile.Plan,
+		"formula":        &profile.Formula,
+	}
+	for name, field := range texts {
+		if value, present := data[name]; present {
+			str, ok := value.(string)
+			if !ok {
+				s.sendError(c, "Invalid value for "+name)
+				return
+			}
+			*field = str
+		}
+	}
+
+	numbers := map[string]*float64{
+		"height_cm": &profile.HeightCm,
+		"weight_kg": &profile.WeightKg,
+	}
+	for name, field := range numbers {
+		if value, present := data[name]; present {
+			number, ok := value.(float64)
+			if !ok {
+				s.sendError(c, "Invalid value for "+name)
+				return
+			}
+			*field = number
+		}
+	}
+
+	if value, present := data["birth_date"]; present {
+		str, _ := value.(string)
+		birthDate, err := time.Parse("2006-01-02", str)
+		if err != nil {
+			s.sendError(c, "birth_date must be a YYYY-MM-DD date")
+			return
+		}
+		profile.BirthDate = birthDate
+	}
+	if value, present := data["auto_goals"]; present {
+		auto, ok := value.(bool)
+		if !ok {
+			s.sendError(c, "Invalid value for auto_goals")
+			return
+		}
+		profile.AutoGoals = auto
+	}
+
+	s.saveBodyProfile(ctx, c, profile)
+}
+
+// saveBodyProfile validates and stores a profile, then brings the goals in
+// line with it when it has auto_goals set
+func (s *Server) saveBodyProfile(ctx context.Context, c *client, profile *models.BodyProfile) {
+	now := time.Now()
+	if err := nutrition.ValidateProfile(profile, now); err != nil {
+		s.sendError(c, "Invalid body profile: "+err.Error())
+		return
+	}
+
+	profile.UpdatedAt = now
+	if err := s.db.SaveBodyProfile(ctx, profile); err != nil {
+		log.Printf("Error saving body profile of user %s: %v", profile.UserID, err)
+		s.sendError(c, "Failed to save body profile")
+		return
+	}
+	s.publish(c, eventBodyProfileUpdated, bodyProfileResponse(profile.UserID, profile))
+
+	if !profile.AutoGoals {
+		return
+	}
+
+	suggestion, err := nutrition.SuggestFor(profile, now)
+	if err != nil {
+		s.sendError(c, "Cannot suggest goals: "+err.Error())
+		return
+	}
+	goals := suggestion.Goals(profile.UserID)
+	goals.UpdatedAt = now
+	if err := s.db.SaveGoals(ctx, goals); err != nil {
+		log.Printf("Error saving goals of user %s: %v", profile.UserID, err)
+		s.sendError(c, "Failed to save goals")
+		return
+	}
+
+	log.Printf("Recalculated goals of user %s: %.0f kcal", profile.UserID, suggestion.Calories)
+	s.publish(c, eventGoalsUpdated, map[string]any{
+		"user_id": profile.UserID,
+		"goals":   goals,
+	})
+}
+
+// bodyProfileResponse pairs a profile with the goals suggested from it. There
+// is no suggestion for a missing profile or when the equations do not apply.
+func bodyProfileResponse(userID string, profile *models.BodyProfile) map[string]any {
+	response := map[string]any{
+		"user_id": userID,
+		"profile": profile,
+	}
+	if profile != nil {
+		if suggestion, err := nutrition.SuggestFor(profile, time.Now()); err == nil {
+			response["suggestion"] = suggestion
+		} else {
+			response["suggestion_error"] = err.Error()
+		}
+	}
+	return response
+}
+
+// defaultMetricsDays is how far back get_body_metrics looks by default
+const defaultMetricsDays = 90
+
+// handleLogBodyMetrics records body measurements of a household member:
+// weight_kg, waist_cm and body_fat_percent, at least one of them, taken at
+// measured_at (RFC 3339 or YYYY-MM-DD, default now). The latest weight is
+// copied into their body profile so that automatic goals follow it.
+func (s *Server) handleLogBodyMetrics(ctx context.Context, c *client, data map[string]any) {
+	user, ok := s.subjectUser(ctx, c, data)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	metric := &models.BodyMetric{
+		ID:         uuid.New().String(),
+		UserID:     user.ID,
+		MeasuredAt: now,
+		CreatedAt:  now,
+	}
+
+	measurements := []struct {
+		name  string
+		field **float64
+		max   float64
+	}{
+		{"weight_kg", &metric.WeightKg, 0},
+		{"waist_cm", &metric.WaistCm, 0},
+		{"body_fat_percent", &metric.BodyFatPercent, 100},
+	}
+	for _, m := range measurements {
+		value, present := data[m.name]
+		if !present {
+			continue
+		}
+		number, ok := value.(float64)
+		if !ok || number <= 0 || (m.max > 0 && number >= m.max) {
+			s.sendError(c, "Invalid value for "+m.name)
+			return
+		}
+		*m.field = &number
+	}
+	if metric.WeightKg == nil && metric.WaistCm == nil && metric.BodyFatPercent == nil {
+		s.sendError(c, "Give weight_kg, waist_cm or body_fat_percent")
+		return
+	}
+
+	if value, present := data["measured_at"]; present {
+		str, _ := value.(string)
+		measuredAt, _, err := parseHistoryTime(str, user.Location())
+		if err != nil {
+			s.sendError(c, "Invalid measured_at: "+err.Error())
+			return
+		}
+		if measuredAt.After(now) {
+			s.sendError(c, "measured_at cannot be in the future")
+			return
+		}
+		metric.MeasuredAt = measuredAt
+	}
+
+	if err := s.db.AddBodyMetric(ctx, metric); err != nil {
+		log.Printf("Error saving body metric of user %s: %v", user.ID, err)
+		s.sendError(c, "Failed to save measurements")
+		return
+	}
+	s.publish(c, eventBodyMetricLogged, metric)
+
+	if metric.WeightKg == nil {
+		return
+	}
+	profile, err := s.db.GetBodyProfile(ctx, user.ID)
+	if err != nil {
+		log.Printf("Error retrieving body profile of user %s: %v", user.ID, err)
+		s.sendError(c, "Failed to retrieve body profile")
+		return
+	}
+	if profile == nil {
+		return
+	}
+
+	// Back-filled weights do not replace a more recent one
+	later, err := s.db.ListBodyMetrics(ctx, user.ID, metric.MeasuredAt.Add(time.Nanosecond), now.Add(time.Second))
+	if err != nil {
+		log.Printf("Error retrieving body metrics of user %s: %v", user.ID, err)
+		s.sendError(c, "Failed to retrieve body metrics")
+		return
+	}
+	for _, m := range later {
+		if m.WeightKg != nil {
+			return
+		}
+	}
+
+	profile.WeightKg = *metric.WeightKg
+	s.saveBodyProfile(ctx, c, profile)
+}
+
+// handleGetBodyMetrics returns the measurements of a household member in a
+// range, by default the last 90 days, with their trend. from and to take the
+// same forms as in get_history.
+func (s *Server) handleGetBodyMetrics(ctx context.Context, c *client, data map[string]any) {
+	user, ok := s.subjectUser(ctx, c, data)
+	if !ok {
+		return
+	}
+
+	now := time.Now().In(user.Location())
+	to := startOf(now, "day").AddDate(0, 0, 1)
+	from := to.AddDate(0, 0, -defaultMetricsDays)
+	if value, present := data["from"]; present {
+		str, _ := value.(string)
+		var err error
+		if from, _, err = parseHistoryTime(str, now.Location()); err != nil {
+			s.sendError(c, "Invalid from: "+err.Error())
+			return
+		}
+	}
+	if value, present := data["to"]; present {
+		str, _ := value.(string)
+		t, isDate, err := parseHistoryTime(str, now.Location())
+		if err != nil {
+			s.sendError(c, "Invalid to: "+err.Error())
+			return
+		}
+		if isDate {
+			t = t.AddDate(0, 0, 1)
+		}
+		to = t
+	}
+	if !from.Before(to) {
+		s.sendError(c, "from must be before to")
+		return
+	}
+
+	// The trend carries over from earlier measurements
+	metrics, err := s.db.ListBodyMetrics(ctx, user.ID, time.Time{}, to)
+	if err != nil {
+		log.Printf("Error retrieving body metrics of user %s: %v", user.ID, err)
+		s.sendError(c, "Failed to retrieve body metrics")
+		return
+	}
+	trend := nutrition.Trend(metrics)
+
+	first := len(metrics)
+	for i, m := range metrics {
+		if !m.MeasuredAt.Before(from) {
+			first = i
+			break
+		}
+	}
+
+	s.sendMessage(c, "body_metrics", map[string]any{
+		"user_id": user.ID,
+		"from":    from,
+		"to":      to,
+		"metrics": metrics[first:],
+		"trend":   trend[first:],
+	})
+}
+
+// handleDeleteBodyMetric removes a measurement of a household member
+func (s *Server) handleDeleteBodyMetric(ctx context.Context, c *client, data map[string]any) {
+	user, ok := s.subjectUser(ctx, c, data)
+	if !ok {
+		return
+	}
+	id, ok := data["id"].(string)
+	if !ok || id == "" {
+		s.sendError(c, "Missing measurement ID")
+		return
+	}
+
+	err := s.db.DeleteBodyMetric(ctx, user.ID, id)
+	if errors.Is(err, database.ErrNotFound) {
+		s.sendError(c, "Measurement not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting body metric %s: %v", id, err)
+		s.sendError(c, "Failed to delete measurement")
+		return
+	}
+
+	s.publish(c, eventBodyMetricDeleted, map[string]any{
+		"id":      id,
+		"user_id": user.ID,
+	})
+}
+
+// bodyTrend returns a user's body trend at the end of each period, or nil if
+// they never logged any measurement
+func (s *Server) bodyTrend(ctx context.Context, userID string, periods []database.Period) ([]*models.PeriodTrend, error) {
+	if len(periods) == 0 {
+		return nil, nil
+	}
+	metrics, err := s.db.ListBodyMetrics(ctx, userID, time.Time{}, periods[len(periods)-1].End)
+	if err != nil || len(metrics) == 0 {
+		return nil, err
+	}
+	trend := nutrition.Trend(metrics)
+
+	result := make([]*models.PeriodTrend, 0, len(periods))
+	i := 0
+	var latest *models.BodyTrend
+	for _, p := range periods {
+		pt := &models.PeriodTrend{PeriodStart: p.Start, PeriodEnd: p.End}
+		for ; i < len(metrics) && metrics[i].MeasuredAt.Before(p.End); i++ {
+			latest = trend[i]
+			if !metrics[i].MeasuredAt.Before(p.Start) {
+				pt.Measurements++
+			}
+		}
+		pt.Trend = latest
+		result = append(result, pt)
+	}
+	return result, nil
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// handleUpdateEntry corrects the values of an already confirmed entry.
+// Only the fields present in data are changed; shares or users change who ate
+// an entry that is not in the pantry, and meal and meal_date when. Shares and
+// users that are missing or null leave who ate it unchanged.
+func (s *Server) handleUpdateEntry(ctx context.Context, c *client, data map[string]any) {
+	id, ok := data["id"].(string)
+	if !ok || id == "" {
+		s.sendError(c, "Missing entry ID")
+		return
+	}
+
+	info, err := s.db.GetNutritionalInfo(ctx, id)
+	if err != nil {
+		log.Printf("Error retrieving entry %s: %v", id, err)
+		s.sendError(c, "Failed to retrieve entry")
+		return
+	}
+	if info == nil || info.DeletedAt != nil || !s.ownsEntry(ctx, c, info) {
+		s.sendError(c, "Entry not found")
+		return
+	}
+
+	// Consumption of pantry items is tracked through log_consumption
+	item, err := s.db.GetPantryItem(ctx, id)
+	if err != nil {
+		log.Printf("Error retrieving pantry item %s: %v", id, err)
+		s.sendError(c, "Failed to retrieve entry")
+		return
+	}
+	if item != nil {
+		_, hasConsumed := data["consumed_weight"]
+		_, hasRemaining := data["remaining_weight"]
+		_, hasMeal := data["meal"]
+		_, hasMealDate := data["meal_date"]
+		if hasConsumed || hasRemaining || hasShares(data) || hasMeal || hasMealDate {
+			s.sendError(c, "Log consumption of pantry items with log_consumption")
+			return
+		}
+	}
+	previousOutput := models.OutputOf(info)
+
+	fields := map[string]*float64{
+		"total_weight": &info.TotalWeight,
+		"calories":     &info.Calories,
+		"protein":      &info.Protein,
+		"carbs":        &info.Carbs,
+		"fat":          &info.Fat,
+		"fiber":        &info.Fiber,
+		"sugar":        &info.Sugar,
+	}
+	for name, field := range fields {
+		value, present := data[name]
+		if !present {
+			continue
+		}
+		number, ok := value.(float64)
+		if !ok {
+			s.sendError(c, "Invalid value for "+name)
+			return
+		}
+		*field = number
+	}
+
+	if err := applyConsumption(info, data); err != nil {
+		s.sendError(c, "Invalid entry: "+err.Error())
+		return
+	}
+	if item == nil {
+		if err := applyMeal(&info.Meal, &info.MealDate, data, info.CreatedAt.In(c.currentUser().Location())); err != nil {
+			s.sendError(c, "Invalid entry: "+err.Error())
+			return
+		}
+	}
+
+	if err := info.Validate(); err != nil {
+		s.sendError(c, "Invalid entry: "+err.Error())
+		return
+	}
+
+	var shares []models.ConsumptionShare
+	if hasShares(data) {
+		if shares, ok = s.parseShares(ctx, c, data); !ok {
+			return
+		}
+	}
+
+	// Corrections made after confirming a scan count towards the model's accuracy
+	var confirmed *models.ModelOutput
+	if output := models.OutputOf(info); *output != *previousOutput {
+		confirmed = output
+	}
+
+	resized, err := s.db.UpdateEntry(ctx, info, shares, confirmed)
+	if err != nil {
+		log.Printf("Error updating entry %s: %v", id, err)
+		s.sendError(c, "Failed to update entry")
+		return
+	}
+	if resized != nil {
+		s.publish(c, eventPantryUpdated, resized)
+	}
+
+	log.Printf("Updated entry %s", id)
+	s.publish(c, eventEntryUpdated, info)
+	s.broadcastTotals(ctx, c.currentUser().HouseholdID)
+}
+
+// applyConsumption records partial consumption from either consumed_weight or
+// remaining_weight in data. A null consumed_weight means everything was eaten.
+func applyConsumption(info *models.NutritionalInfo, data map[string]any) error {
+	consumed, hasConsumed := data["consumed_weight"]
+	remaining, hasRemaining := data["remaining_weight"]
+
+	switch {
+	case hasConsumed && hasRemaining:
+		return fmt.Errorf("give either consumed_weight or remaining_weight, not both")
+	case hasConsumed:
+		switch weight := consumed.(type) {
+		case nil:
+			info.ConsumedWeight = nil
+		case float64:
+			info.ConsumedWeight = &weight
+		default:
+			return fmt.Errorf("invalid consumed_weight")
+		}
+	case hasRemaining:
+		weight, ok := remaining.(float64)
+		if !ok {
+			return fmt.Errorf("invalid remaining_weight")
+		}
+		info.SetRemainingWeight(weight)
+	}
+	return nil
+}
+
+// applyMeal sets a meal slot and date from the meal and meal_date fields of
+// data. Whatever is still missing is suggested from the time the food was
+// logged, given in the time zone of the user logging it.
+func applyMeal(meal, date *string, data map[string]any, loggedAt time.Time) error {
+	fields := map[string]*string{"meal": meal, "meal_date": date}
+	for name, field := range fields {
+		value, present := data[name]
+		if !present {
+			continue
+		}
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("invalid %s", name)
+		}
+		*field = str
+	}
+
+	if *meal == "" {
+		*meal = models.SuggestMeal(loggedAt)
+	}
+	if *date == "" {
+		*date = loggedAt.Format(models.MealDateLayout)
+	}
+	return models.ValidateMeal(*meal, *date)
+}
+
+// handleDeleteEntry soft-deletes an entry; it can be brought back with restore_entry
+func (s *Server) handleDeleteEntry(ctx context.Context, c *client, data map[string]any) {
+	id, ok := data["id"].(string)
+	if !ok || id == "" {
+		s.sendError(c, "Missing entry ID")
+		return
+	}
+	if !s.ownsEntryID(ctx, c, id) {
+		s.sendError(c, "Entry not found")
+		return
+	}
+
+	err := s.db.DeleteNutritionalInfo(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		s.sendError(c, "Entry not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting entry %s: %v", id, err)
+		s.sendError(c, "Failed to delete entry")
+		return
+	}
+
+	log.Printf("Deleted entry %s", id)
+	s.publish(c, eventEntryDeleted, map[string]any{
+		"id":       id,
+		"can_undo": true,
+	})
+	s.broadcastTotals(ctx, c.currentUser().HouseholdID)
+}
+
+// handleRestoreEntry undoes a delete_entry
+func (s *Server) handleRestoreEntry(ctx context.Context, c *client, data map[string]any) {
+	id, ok := data["id"].(string)
+	if !ok || id == "" {
+		s.sendError(c, "Missing entry ID")
+		return
+	}
+	if !s.ownsEntryID(ctx, c, id) {
+		s.sendError(c, "No deleted entry to restore")
+		return
+	}
+
+	err := s.db.RestoreNutritionalInfo(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		s.sendError(c, "No deleted entry to restore")
+		return
+	}
+	if err != nil {
+		log.Printf("Error restoring entry %s: %v", id, err)
+		s.sendError(c, "Failed to restore entry")
+		return
+	}
+
+	info, err := s.db.GetNutritionalInfo(ctx, id)
+	if err != nil || info == nil {
+		log.Printf("Error retrieving restored entry %s: %v", id, err)
+		s.sendError(c, "Failed to retrieve entry")
+		return
+	}
+
+	log.Printf("Restored entry %s", id)
+	s.publish(c, eventEntryRestored, info)
+	s.broadcastTotals(ctx, c.currentUser().HouseholdID)
+}
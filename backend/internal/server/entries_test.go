@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"github.com/google/uuid"
+)
+
+// saveTestEntry saves 100g of food with 200 kcal per 100g logged by the
+// client's user
+func saveTestEntry(t *testing.T, db database.DB, c *client) *models.NutritionalInfo {
+	t.Helper()
+	entry := &models.NutritionalInfo{
+		ID: uuid.New().String(), UserID: c.currentUser().ID, TotalWeight: 100,
+		Calories: 200, Protein: 10, Carbs: 20, Fat: 5, CreatedAt: time.Now(),
+	}
+	if err := db.SaveNutritionalInfo(context.Background(), entry); err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+func TestUpdateEntry(t *testing.T) {
+	ctx := context.Background()
+	s, db, c := testServer(t)
+	other := testClient(s, c.currentUser())
+	entry := saveTestEntry(t, db, c)
+
+	send(t, s, c, "update_entry", `{"id": "`+entry.ID+`", "calories": 250, "consumed_weight": 40, "meal": "dinner"}`)
+	updated := find(t, received(t, c), eventEntryUpdated)
+	if updated["calories"] != 250.0 || updated["consumed_weight"] != 40.0 || updated["meal"] != "dinner" {
+		t.Errorf("updated entry = %v", updated)
+	}
+	// The other devices of the household hear of it too
+	find(t, received(t, other), eventEntryUpdated)
+
+	got, err := db.GetNutritionalInfo(ctx, entry.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Calories != 250 || got.EatenWeight() != 40 || got.Protein != 10 {
+		t.Errorf("stored entry = %+v", got)
+	}
+
+	for _, data := range []string{
+		`{"id": "` + entry.ID + `", "calories": "lots"}`,
+		`{"id": "` + entry.ID + `", "total_weight": 0}`,
+		`{"id": "` + entry.ID + `", "consumed_weight": 10, "remaining_weight": 90}`,
+		`{"id": "missing", "calories": 1}`,
+	} {
+		send(t, s, c, "update_entry", data)
+		if messages := received(t, c); len(messages) != 1 || messages[0]["type"] != "error" {
+			t.Errorf("update %s: %v", data, messages)
+		}
+	}
+	if got, _ := db.GetNutritionalInfo(ctx, entry.ID); got.TotalWeight != 100 {
+		t.Errorf("rejected update changed the entry: %+v", got)
+	}
+}
+
+func TestDeleteAndRestoreEntry(t *testing.T) {
+	ctx := context.Background()
+	s, db, c := testServer(t)
+	entry := saveTestEntry(t, db, c)
+
+	send(t, s, c, "delete_entry", `{"id": "`+entry.ID+`"}`)
+	deleted := find(t, received(t, c), eventEntryDeleted)
+	if deleted["id"] != entry.ID || deleted["can_undo"] != true {
+		t.Errorf("deleted = %v", deleted)
+	}
+	if got, _ := db.GetNutritionalInfo(ctx, entry.ID); got == nil || got.DeletedAt == nil {
+		t.Fatalf("entry after delete = %+v", got)
+	}
+
+	// A deleted entry can no longer be changed, only restored
+	send(t, s, c, "update_entry", `{"id": "`+entry.ID+`", "calories": 1}`)
+	if messages := received(t, c); len(messages) != 1 || messages[0]["type"] != "error" {
+		t.Errorf("updating a deleted entry: %v", messages)
+	}
+
+	send(t, s, c, "restore_entry", `{"id": "`+entry.ID+`"}`)
+	messages := received(t, c)
+	restored := find(t, messages, eventEntryRestored)
+	if restored["id"] != entry.ID || restored["deleted_at"] != nil {
+		t.Errorf("restored = %v", restored)
+	}
+	find(t, messages, eventTotalsChanged)
+
+	send(t, s, c, "restore_entry", `{"id": "`+entry.ID+`"}`)
+	if messages := received(t, c); len(messages) != 1 || messages[0]["type"] != "error" {
+		t.Errorf("restoring an entry that is not deleted: %v", messages)
+	}
+}
+
+func TestUpdateEntryShares(t *testing.T) {
+	ctx := context.Background()
+	s, db, c := testServer(t)
+	me := c.currentUser()
+	send(t, s, c, "add_user", `{"name": "Sam"}`)
+	sam, _ := find(t, received(t, c), "user_added")["id"].(string)
+	entry := saveTestEntry(t, db, c)
+
+	// ateFrom reports whether a member has a share of the entry
+	ateFrom := func(userID string) bool {
+		t.Helper()
+		entries, err := db.ListNutritionalInfo(ctx, database.HistoryQuery{
+			From: entry.CreatedAt.Add(-time.Hour), To: entry.CreatedAt.Add(time.Hour),
+			Limit: 10, HouseholdID: me.HouseholdID, UserID: userID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries) == 1
+	}
+
+	send(t, s, c, "update_entry", `{"id": "`+entry.ID+`", "users": ["`+me.ID+`", "`+sam+`"]}`)
+	find(t, received(t, c), eventEntryUpdated)
+	if !ateFrom(me.ID) || !ateFrom(sam) {
+		t.Fatal("entry was not split between both members")
+	}
+
+	// Updates that do not say who ate it keep the split
+	for _, data := range []string{
+		`{"id": "` + entry.ID + `", "calories": 210}`,
+		`{"id": "` + entry.ID + `", "calories": 220, "shares": null}`,
+		`{"id": "` + entry.ID + `", "calories": 230, "shares": null, "users": null}`,
+	} {
+		send(t, s, c, "update_entry", data)
+		find(t, received(t, c), eventEntryUpdated)
+		if !ateFrom(me.ID) || !ateFrom(sam) {
+			t.Errorf("update %s changed who ate the entry", data)
+		}
+	}
+
+	send(t, s, c, "update_entry", `{"id": "`+entry.ID+`", "shares": [{"user_id": "`+sam+`", "fraction": 1}]}`)
+	find(t, received(t, c), eventEntryUpdated)
+	if ateFrom(me.ID) || !ateFrom(sam) {
+		t.Error("entry was not given to Sam alone")
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/franckalain/nutritionalvalue/internal/database"
+)
+
+// handleExport downloads everything the household of the signed in user has
+// logged: with format=json, the default, as the archive nvctl import reads
+// into another instance; with format=csv, as a ZIP file of one CSV file per
+// table for spreadsheets.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Use GET")
+		return
+	}
+	user, err := s.resolveUser(r)
+	if errors.Is(err, errUnauthenticated) {
+		writeJSONError(w, http.StatusUnauthorized, "Not signed in")
+		return
+	}
+	if err != nil {
+		log.Printf("Error resolving user: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to identify user")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		writeJSONError(w, http.StatusBadRequest, "Format must be json or csv")
+		return
+	}
+	exporter, ok := s.db.(database.Exporter)
+	if !ok {
+		writeJSONError(w, http.StatusNotImplemented, "This database cannot be exported")
+		return
+	}
+
+	export, err := exporter.Export(r.Context(), user.HouseholdID)
+	if err != nil {
+		log.Printf("Error exporting household %s: %v", user.HouseholdID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to export data")
+		return
+	}
+
+	name := "nutritional-" + export.ExportedAt.Format("20060102")
+	w.Header().Set("Cache-Control", "no-store")
+	if format == "csv" {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+		err = export.WriteCSV(w)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, name))
+		err = export.WriteJSON(w)
+	}
+	if err != nil {
+		log.Printf("Error writing export: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// subjectUser returns the household member a message is about: the
+// one given by user_id, or the connection's user
+func (s *Server) subjectUser(ctx context.Context, c *client, data map[string]any) (*models.User, bool) {
+	if id, ok := data["user_id"].(string); ok && id != "" {
+		return s.householdMember(ctx, c, id)
+	}
+	return c.currentUser(), true
+}
+
+// handleGetGoals returns the goals of a household member; goals is null when
+// they have none
+func (s *Server) handleGetGoals(ctx context.Context, c *client, data map[string]any) {
+	user, ok := s.subjectUser(ctx, c, data)
+	if !ok {
+		return
+	}
+
+	goals, err := s.db.GetGoals(ctx, user.ID)
+	if err != nil {
+		log.Printf("Error retrieving goals of user %s: %v", user.ID, err)
+		s.sendError(c, "Failed to retrieve goals")
+		return
+	}
+
+	s.sendMessage(c, "goals", map[string]any{
+		"user_id": user.ID,
+		"goals":   goals,
+	})
+}
+
+// handleSetGoals replaces the goals of a household member and stops them
+// following their body profile. data holds weekday
+// targets and optionally different weekend targets, for example
+//
+//	{"weekday": {"calories": 2000, "protein": {"percent": 25}, "fiber_min": 30},
+//	 "weekend": {"calories": 2300, "protein": {"grams": 120}, "sugar_max": 50}}
+func (s *Server) handleSetGoals(ctx context.Context, c *client, data map[string]any) {
+	user, ok := s.subjectUser(ctx, c, data)
+	if !ok {
+		return
+	}
+
+	// The targets are nested, so let encoding/json do the type checking
+	var goals models.Goals
+	raw, err := json.Marshal(data)
+	if err == nil {
+		err = json.Unmarshal(raw, &goals)
+	}
+	if err != nil {
+		s.sendError(c, "Invalid goals: "+err.Error())
+		return
+	}
+	goals.UserID = user.ID
+	goals.UpdatedAt = time.Now()
+
+	if err := goals.Validate(); err != nil {
+		s.sendError(c, "Invalid goals: "+err.Error())
+		return
+	}
+
+	// Goals set by hand would be overwritten at the next weight log
+	if !s.stopAutoGoals(ctx, c, user.ID) {
+		return
+	}
+
+	if err := s.db.SaveGoals(ctx, &goals); err != nil {
+		log.Printf("Error saving goals of user %s: %v", user.ID, err)
+		s.sendError(c, "Failed to save goals")
+		return
+	}
+
+	log.Printf("Updated goals of user %s", user.ID)
+	s.publish(c, eventGoalsUpdated, map[string]any{
+		"user_id": user.ID,
+		"goals":   &goals,
+	})
+}
+
+// handleClearGoals removes the goals of a household member
+func (s *Server) handleClearGoals(ctx context.Context, c *client, data map[string]any) {
+	user, ok := s.subjectUser(ctx, c, data)
+	if !ok {
+		return
+	}
+
+	if !s.stopAutoGoals(ctx, c, user.ID) {
+		return
+	}
+
+	err := s.db.DeleteGoals(ctx, user.ID)
+	if errors.Is(err, database.ErrNotFound) {
+		s.sendError(c, "No goals to clear")
+		return
+	}
+	if err != nil {
+		log.Printf("Error clearing goals of user %s: %v", user.ID, err)
+		s.sendError(c, "Failed to clear goals")
+		return
+	}
+
+	log.Printf("Cleared goals of user %s", user.ID)
+	s.publish(c, eventGoalsUpdated, map[string]any{
+		"user_id": user.ID,
+		"goals":   nil,
+	})
+}
+
+// stopAutoGoals turns off auto_goals in the user's body profile, if they have
+// one. It reports the error to the client and returns false if that fails.
+func (s *Server) stopAutoGoals(ctx context.Context, c *client, userID string) bool {
+	profile, err := s.db.GetBodyProfile(ctx, userID)
+	if err == nil && profile != nil && profile.AutoGoals {
+		profile.AutoGoals = false
+		profile.UpdatedAt = time.Now()
+		if err = s.db.SaveBodyProfile(ctx, profile); err == nil {
+			s.publish(c, eventBodyProfileUpdated, bodyProfileResponse(userID, profile))
+		}
+	}
+	if err != nil {
+		log.Printf("Error updating body profile of user %s: %v", userID, err)
+		s.sendError(c, "Failed to save goals")
+		return false
+	}
+	return true
+}
+
+// goalProgress evaluates each day of [from, to) against a user's goals. byDay
+// are the user's totals if they were already computed per day. It returns nil
+// when the range has too many days to evaluate.
+func (s *Server) goalProgress(ctx context.Context, goals *models.Goals, byDay []*models.NutritionTotals, from, to time.Time) ([]*models.DayProgress, error) {
+	if byDay == nil {
+		days, err := splitPeriods(from, to, "day")
+		if err != nil {
+			return nil, nil
+		}
+		if byDay, err = s.db.SumUserConsumption(ctx, goals.UserID, days); err != nil {
+			return nil, err
+		}
+	}
+
+	progress := make([]*models.DayProgress, 0, len(byDay))
+	for _, day := range byDay {
+		progress = append(progress, goals.Evaluate(day))
+	}
+	return progress, nil
+}
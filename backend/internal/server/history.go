@@ -0,0 +1,415 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+// historyRequest holds the parsed parameters of a get_history message
+type historyRequest struct {
+	from    time.Time
+	to      time.Time
+	cursor  *database.HistoryCursor
+	limit   int
+	groupBy string
+	userID  string
+}
+
+// handleGetHistory returns a page of entries in a date range together with
+// totals for the whole range, grouped by day, week or month. Entries are
+// listed and added up on the diary day they were given, by eaten_at.
+//
+// All parameters are optional:
+//   - from, to: RFC 3339 timestamps or YYYY-MM-DD dates; a date for "to" includes
+//     that whole day. Defaults to the current week. Dates, days, weeks and
+//     months are those of the user's time zone.
+//   - cursor: next_cursor from the previous page
+//   - limit: page size, at most 100
+//   - group_by: "day" (default), "week" or "month"
+//   - user_id: only list the entries this household member ate from
+//
+// Entries created from a scan have it in scans, keyed by entry ID: the photo,
+// the model that read it, what it proposed and what the user corrected.
+//
+// Totals are broken down by meal slot. They are given for the whole household
+// and for each of its members, along with how each day went against the goals
+// of members who set any and the body trend of members who log measurements.
+// The totals of a member are for the same dates in their own time zone.
+func (s *Server) handleGetHistory(ctx context.Context, c *client, data map[string]any) {
+	me := c.currentUser()
+	req, err := parseHistoryRequest(data, time.Now().In(me.Location()))
+	if err != nil {
+		s.sendError(c, "Invalid history request: "+err.Error())
+		return
+	}
+
+	if req.userID != "" {
+		if _, ok := s.householdMember(ctx, c, req.userID); !ok {
+			return
+		}
+	}
+
+	// Fetch one extra entry to know whether there is a next page
+	items, err := s.db.ListNutritionalInfo(ctx, database.HistoryQuery{
+		From:        req.from,
+		To:          req.to,
+		Cursor:      req.cursor,
+		Limit:       req.limit + 1,
+		HouseholdID: me.HouseholdID,
+		UserID:      req.userID,
+	})
+	if err != nil {
+		log.Printf("Error retrieving history: %v", err)
+		s.sendError(c, "Failed to retrieve history")
+		return
+	}
+
+	nextCursor := ""
+	if len(items) > req.limit {
+		items = items[:req.limit]
+		last := items[len(items)-1]
+		nextCursor = encodeCursor(&database.HistoryCursor{EatenAt: last.EatenAt, ID: last.ID})
+	}
+
+	scans, err := s.entryScans(ctx, items)
+	if err != nil {
+		log.Printf("Error retrieving scans: %v", err)
+		s.sendError(c, "Failed to retrieve history")
+		return
+	}
+
+	periods, err := splitPeriods(req.from, req.to, req.groupBy)
+	if err != nil {
+		s.sendError(c, "Invalid history request: "+err.Error())
+		return
+	}
+	totals, err := s.db.SumNutritionalInfo(ctx, me.HouseholdID, periods)
+	if err != nil {
+		log.Printf("Error computing history totals: %v", err)
+		s.sendError(c, "Failed to retrieve history")
+		return
+	}
+
+	people, err := s.personTotals(ctx, me.HouseholdID, data)
+	if err != nil {
+		log.Printf("Error computing personal totals: %v", err)
+		s.sendError(c, "Failed to retrieve history")
+		return
+	}
+
+	dayTotal, weekTotal, err := s.currentTotals(ctx, me.HouseholdID, me.Location())
+	if err != nil {
+		log.Printf("Error computing current totals: %v", err)
+		s.sendError(c, "Failed to retrieve history")
+		return
+	}
+
+	response := map[string]any{
+		"items":       items,
+		"scans":       scans,
+		"next_cursor": nextCursor,
+		"from":        req.from,
+		"to":          req.to,
+		"group_by":    req.groupBy,
+		"totals":      totals,
+		"range_total": sumTotals(totals, req.from, req.to),
+		"people":      people,
+		"day_total":   dayTotal,
+		"week_total":  weekTotal,
+	}
+
+	s.sendMessage(c, "history", response)
+}
+
+// entryScans returns the scans the entries were created from, with the values
+// the user changed from what the model proposed
+func (s *Server) entryScans(ctx context.Context, items []*models.NutritionalInfo) (map[string]*models.NutritionScan, error) {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	scans, err := s.db.GetScansByEntry(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if scan := scans[item.ID]; scan != nil && scan.ModelOutput != nil {
+			scan.Corrections = scan.ModelOutput.Corrections(item)
+		}
+	}
+	return scans, nil
+}
+
+// personTotals computes what each member of the household ate in the range
+// of a get_history request and, for those with goals, their daily progress.
+// Members who log body measurements also get their trend at the end of each
+// period. Dates, days, weeks and months are those of each member's time zone.
+func (s *Server) personTotals(ctx context.Context, householdID string, data map[string]any) ([]*models.PersonTotals, error) {
+	users, err := s.db.ListUsers(ctx, householdID)
+	if err != nil {
+		return nil, err
+	}
+
+	people := make([]*models.PersonTotals, 0, len(users))
+	for _, user := range users {
+		req, err := parseHistoryRequest(data, time.Now().In(user.Location()))
+		if err != nil {
+			return nil, err
+		}
+		periods, err := splitPeriods(req.from, req.to, req.groupBy)
+		if err != nil {
+			return nil, err
+		}
+
+		totals, err := s.db.SumUserConsumption(ctx, user.ID, periods)
+		if err != nil {
+			return nil, err
+		}
+		person := &models.PersonTotals{
+			User:       user,
+			Totals:     totals,
+			RangeTotal: sumTotals(totals, req.from, req.to),
+		}
+
+		if person.Goals, err = s.db.GetGoals(ctx, user.ID); err != nil {
+			return nil, err
+		}
+		if person.Goals != nil {
+			var byDay []*models.NutritionTotals
+			if req.groupBy == "day" {
+				byDay = totals
+			}
+			if person.Progress, err = s.goalProgress(ctx, person.Goals, byDay, req.from, req.to); err != nil {
+				return nil, err
+			}
+		}
+
+		if person.Trend, err = s.bodyTrend(ctx, user.ID, periods); err != nil {
+			return nil, err
+		}
+
+		people = append(people, person)
+	}
+	return people, nil
+}
+
+// broadcastTotals recomputes a household's day and week totals and pushes
+// them to its clients. Members in different time zones get the totals of
+// their own day and week.
+func (s *Server) broadcastTotals(ctx context.Context, householdID string) {
+	users, err := s.db.ListUsers(ctx, householdID)
+	if err != nil {
+		log.Printf("Error computing current totals: %v", err)
+		return
+	}
+
+	byTimezone := make(map[string]map[string]any)
+	byUser := make(map[string]any, len(users))
+	for _, user := range users {
+		if byTimezone[user.Timezone] == nil {
+			dayTotal, weekTotal, err := s.currentTotals(ctx, householdID, user.Location())
+			if err != nil {
+				log.Printf("Error computing current totals: %v", err)
+				return
+			}
+			byTimezone[user.Timezone] = map[string]any{
+				"day_total":  dayTotal,
+				"week_total": weekTotal,
+			}
+		}
+		byUser[user.ID] = byTimezone[user.Timezone]
+	}
+
+	s.hub.broadcastEach(householdID, eventTotalsChanged, func(user *models.User) any {
+		return byUser[user.ID]
+	})
+}
+
+// currentTotals sums what the household ate today and since the start of the
+// week in a time zone
+func (s *Server) currentTotals(ctx context.Context, householdID string, loc *time.Location) (day, week *models.NutritionTotals, err error) {
+	now := time.Now().In(loc)
+	startOfDay := startOf(now, "day")
+	startOfWeek := startOf(now, "week")
+
+	totals, err := s.db.SumNutritionalInfo(ctx, householdID, []database.Period{
+		{Start: startOfDay, End: startOfDay.AddDate(0, 0, 1)},
+		{Start: startOfWeek, End: startOfWeek.AddDate(0, 0, 7)},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return totals[0], totals[1], nil
+}
+
+// parseHistoryRequest validates the get_history parameters and fills in
+// defaults. Dates are taken in the time zone of now.
+func parseHistoryRequest(data map[string]any, now time.Time) (*historyRequest, error) {
+	req := &historyRequest{
+		from:    startOf(now, "week"),
+		limit:   defaultHistoryLimit,
+		groupBy: "day",
+	}
+	req.to = req.from.AddDate(0, 0, 7)
+
+	if v, ok := data["group_by"]; ok {
+		groupBy, ok := v.(string)
+		if !ok || (groupBy != "day" && groupBy != "week" && groupBy != "month") {
+			return nil, fmt.Errorf("group_by must be day, week or month")
+		}
+		req.groupBy = groupBy
+	}
+
+	if v, ok := data["from"]; ok {
+		str, _ := v.(string)
+		from, _, err := parseHistoryTime(str, now.Location())
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		req.from = from
+	}
+
+	if v, ok := data["to"]; ok {
+		str, _ := v.(string)
+		to, isDate, err := parseHistoryTime(str, now.Location())
+		if err != nil {
+			return nil, fmt.Errorf("to: %w", err)
+		}
+		if isDate {
+			to = to.AddDate(0, 0, 1)
+		}
+		req.to = to
+	}
+
+	if !req.from.Before(req.to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	if v, ok := data["limit"]; ok {
+		limit, ok := v.(float64)
+		if !ok || limit < 1 || limit > maxHistoryLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxHistoryLimit)
+		}
+		req.limit = int(limit)
+	}
+
+	if v, ok := data["user_id"]; ok {
+		userID, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("user_id must be a string")
+		}
+		req.userID = userID
+	}
+
+	if v, ok := data["cursor"]; ok {
+		str, _ := v.(string)
+		if str != "" {
+			cursor, err := decodeCursor(str)
+			if err != nil {
+				return nil, err
+			}
+			req.cursor = cursor
+		}
+	}
+
+	return req, nil
+}
+
+// parseHistoryTime accepts an RFC 3339 timestamp or a YYYY-MM-DD date, which
+// is taken as midnight in loc. isDate reports which form was used.
+func parseHistoryTime(value string, loc *time.Location) (t time.Time, isDate bool, err error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+		return t, true, nil
+	}
+	t, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("expected YYYY-MM-DD or RFC 3339 time, got %q", value)
+	}
+	return t.In(loc), false, nil
+}
+
+// startOf truncates t to the start of its day, week (Sunday) or month in its
+// own time zone
+func startOf(t time.Time, unit string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch unit {
+	case "week":
+		return day.AddDate(0, 0, -int(day.Weekday()))
+	case "month":
+		return day.AddDate(0, 0, 1-day.Day())
+	default:
+		return day
+	}
+}
+
+// splitPeriods cuts [from, to) into calendar days, weeks or months. The first
+// and last periods are clipped to the range.
+func splitPeriods(from, to time.Time, unit string) ([]database.Period, error) {
+	var periods []database.Period
+	start := from
+	for start.Before(to) {
+		boundary := startOf(start, unit)
+		var end time.Time
+		switch unit {
+		case "week":
+			end = boundary.AddDate(0, 0, 7)
+		case "month":
+			end = boundary.AddDate(0, 1, 0)
+		default:
+			end = boundary.AddDate(0, 0, 1)
+		}
+		if end.After(to) {
+			end = to
+		}
+		periods = append(periods, database.Period{Start: start, End: end})
+		if len(periods) > database.MaxPeriods {
+			return nil, fmt.Errorf("range too long for grouping by %s", unit)
+		}
+		start = end
+	}
+	return periods, nil
+}
+
+// sumTotals adds per-period totals into one total for the whole range
+func sumTotals(totals []*models.NutritionTotals, from, to time.Time) *models.NutritionTotals {
+	sum := &models.NutritionTotals{PeriodStart: from, PeriodEnd: to}
+	for _, t := range totals {
+		sum.Merge(t)
+	}
+	return sum
+}
+
+// encodeCursor turns a cursor into an opaque string for the client
+func encodeCursor(cursor *database.HistoryCursor) string {
+	raw := cursor.EatenAt.Format(time.RFC3339Nano) + "|" + cursor.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor reverses encodeCursor
+func decodeCursor(value string) (*database.HistoryCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	eatenAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, eatenAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &database.HistoryCursor{EatenAt: t.In(time.Local), ID: id}, nil
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+	_ "time/tzdata" // the tests use named time zones
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"github.com/google/uuid"
+)
+
+func TestGetHistory(t *testing.T) {
+	ctx := context.Background()
+	s, db, c := testServer(t)
+	user := c.currentUser()
+
+	// 25 entries of 100g with 100 kcal per 100g on one day, and one the week
+	// before that stays out of range
+	day := time.Date(2024, 3, 5, 12, 0, 0, 0, user.Location())
+	for i := 0; i < 26; i++ {
+		createdAt := day.Add(time.Duration(i) * time.Minute)
+		if i == 25 {
+			createdAt = day.AddDate(0, 0, -7)
+		}
+		entry := &models.NutritionalInfo{
+			ID: uuid.New().String(), UserID: user.ID, TotalWeight: 100, Calories: 100, CreatedAt: createdAt,
+		}
+		if err := db.SaveNutritionalInfo(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	cursor := ""
+	for page := 1; ; page++ {
+		send(t, s, c, "get_history", fmt.Sprintf(`{"from": "2024-03-04", "to": "2024-03-10", "limit": 10, "cursor": %q}`, cursor))
+		history := find(t, received(t, c), "history")
+
+		items, _ := history["items"].([]any)
+		for _, item := range items {
+			id := item.(map[string]any)["id"].(string)
+			if seen[id] {
+				t.Errorf("entry %s listed twice", id)
+			}
+			seen[id] = true
+		}
+
+		total, _ := history["range_total"].(map[string]any)
+		if total["calories"] != 2500.0 || total["entries"] != 25.0 {
+			t.Errorf("page %d: range total = %v", page, total)
+		}
+		if days, _ := history["totals"].([]any); len(days) != 7 {
+			t.Errorf("page %d: %d daily totals, want 7", page, len(days))
+		}
+
+		cursor, _ = history["next_cursor"].(string)
+		if cursor == "" {
+			if len(items) != 5 || page != 3 {
+				t.Errorf("last page %d has %d entries, want page 3 with 5", page, len(items))
+			}
+			break
+		}
+		if len(items) != 10 {
+			t.Errorf("page %d has %d entries, want 10", page, len(items))
+		}
+		if page > 3 {
+			t.Fatal("too many pages")
+		}
+	}
+	if len(seen) != 25 {
+		t.Errorf("listed %d entries, want 25", len(seen))
+	}
+
+	send(t, s, c, "get_history", `{"group_by": "year"}`)
+	if messages := received(t, c); len(messages) != 1 || messages[0]["type"] != "error" {
+		t.Errorf("grouping by year: %v", messages)
+	}
+}
+
+func TestGetHistoryTimezones(t *testing.T) {
+	ctx := context.Background()
+	s, db, c := testServer(t)
+	alex := c.currentUser()
+	sam := &models.User{ID: uuid.New().String(), HouseholdID: alex.HouseholdID, Name: "Sam", CreatedAt: time.Now()}
+	if err := db.CreateUser(ctx, sam); err != nil {
+		t.Fatal(err)
+	}
+	for user, timezone := range map[*models.User]string{alex: "Asia/Tokyo", sam: "America/New_York"} {
+		if err := db.SetTimezone(ctx, user.ID, timezone); err != nil {
+			t.Fatal(err)
+		}
+		user.Timezone = timezone
+	}
+	c.user.Store(alex)
+
+	// Each ate dinner on 10 July in their own time zone, which is another day
+	// in the other's
+	for user, calories := range map[*models.User]float64{alex: 300, sam: 200} {
+		entry := &models.NutritionalInfo{
+			ID: uuid.New().String(), UserID: user.ID, TotalWeight: 100, Calories: calories,
+			Meal: "dinner", MealDate: "2024-07-10",
+			CreatedAt: time.Date(2024, 7, 10, 21, 0, 0, 0, user.Location()),
+		}
+		if err := db.SaveNutritionalInfo(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetConsumptionShares(ctx, entry.ID, []models.ConsumptionShare{{UserID: user.ID, Fraction: 1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(t, s, c, "get_history", `{"from": "2024-07-10", "to": "2024-07-10"}`)
+	history := find(t, received(t, c), "history")
+	people, _ := history["people"].([]any)
+	if len(people) != 2 {
+		t.Fatalf("%d people in history, want 2", len(people))
+	}
+	for _, p := range people {
+		person := p.(map[string]any)
+		user := person["user"].(map[string]any)
+		want := map[string]float64{alex.ID: 300, sam.ID: 200}[user["id"].(string)]
+		total := person["range_total"].(map[string]any)
+		if total["calories"] != want {
+			t.Errorf("%s ate %v kcal on 10 July, want %v", user["name"], total["calories"], want)
+		}
+
+		loc, _ := time.LoadLocation(user["timezone"].(string))
+		start, _ := time.Parse(time.RFC3339, total["period_start"].(string))
+		if want := time.Date(2024, 7, 10, 0, 0, 0, 0, loc); !start.Equal(want) {
+			t.Errorf("%s's day starts at %v, want %v", user["name"], start, want)
+		}
+	}
+}
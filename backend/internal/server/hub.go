@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"github.com/gorilla/websocket"
+)
+
+// Events broadcast to every subscribed client
+const (
+	eventEntryCreated       = "entry_created"
+	eventEntryUpdated       = "entry_updated"
+	eventEntryDeleted       = "entry_deleted"
+	eventEntryRestored      = "entry_restored"
+	eventTotalsChanged      = "totals_changed"
+	eventPantryUpdated      = "pantry_updated"
+	eventHousehold          = "household_updated"
+	eventGoalsUpdated       = "goals_updated"
+	eventBodyProfileUpdated = "body_profile_updated"
+	eventBodyMetricLogged   = "body_metric_logged"
+	eventBodyMetricDeleted  = "body_metric_deleted"
+	eventRecipeUpdated      = "recipe_updated"
+	eventRecipeDeleted      = "recipe_deleted"
+)
+
+// sendQueueSize is how many messages may be waiting for a slow client before
+// it is disconnected
+const sendQueueSize = 32
+
+// closeFrameTimeout bounds how long sending a close frame may take
+const closeFrameTimeout = time.Second
+
+// client is a websocket connection together with its outgoing queue.
+// gorilla/websocket allows a single concurrent writer, so every write goes
+// through the queue and is performed by writePump.
+type client struct {
+	id        string
+	conn      *websocket.Conn
+	ctx       context.Context // cancelled when the connection goes away
+	cancel    context.CancelFunc
+	user      atomic.Pointer[models.User]
+	send      chan any
+	goodbye   chan []byte   // close frame to send once the queue is flushed
+	finished  chan struct{} // closed when writePump returns
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newClient(parent context.Context, id string, conn *websocket.Conn, user *models.User) *client {
+	ctx, cancel := context.WithCancel(parent)
+	c := &client{
+		id:       id,
+		conn:     conn,
+		ctx:      ctx,
+		cancel:   cancel,
+		send:     make(chan any, sendQueueSize),
+		goodbye:  make(chan []byte, 1),
+		finished: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	c.user.Store(user)
+	return c
+}
+
+// currentUser returns the person using this connection
+func (c *client) currentUser() *models.User {
+	return c.user.Load()
+}
+
+// enqueue queues a message for the client. It returns false if the client is
+// gone or too far behind, in which case the connection is closed.
+func (c *client) enqueue(msg any) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		log.Printf("Send queue full for client %s, disconnecting", c.id)
+		c.close()
+		return false
+	}
+}
+
+// writePump writes queued messages to the connection until the client is closed
+func (c *client) writePump() {
+	defer close(c.finished)
+
+	for {
+		select {
+		case msg := <-c.send:
+			if !c.write(msg) {
+				return
+			}
+		case frame := <-c.goodbye:
+			// Flush what is already queued, then say goodbye
+			for len(c.send) > 0 {
+				if !c.write(<-c.send) {
+					return
+				}
+			}
+			deadline := time.Now().Add(closeFrameTimeout)
+			if err := c.conn.WriteControl(websocket.CloseMessage, frame, deadline); err != nil {
+				log.Printf("Error sending close frame to client %s: %v", c.id, err)
+			}
+			c.close()
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// write sends a single message, closing the client on failure
+func (c *client) write(msg any) bool {
+	if err := c.conn.WriteJSON(msg); err != nil {
+		log.Println("Error sending message:", err)
+		c.close()
+		return false
+	}
+	return true
+}
+
+// close stops the writer and closes the connection, which also ends the
+// reader and cancels the work still running for this client
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// Hub keeps track of the connected clients and fans events out to them
+type Hub struct {
+	mu      sync.RWMutex
+	clients map[*client]struct{}
+}
+
+func newHub() *Hub {
+	return &Hub{clients: make(map[*client]struct{})}
+}
+
+// subscribe registers a client for broadcasts
+func (h *Hub) subscribe(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[c] = struct{}{}
+}
+
+// unsubscribe removes a client; it receives no further broadcasts
+func (h *Hub) unsubscribe(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, c)
+}
+
+// broadcast sends an event to every subscribed client of a household
+func (h *Hub) broadcast(householdID, eventType string, data any) {
+	msg := map[string]any{
+		"type": eventType,
+		"data": data,
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	log.Printf("Broadcasting %s to household %s", eventType, householdID)
+	for c := range h.clients {
+		if c.currentUser().HouseholdID == householdID {
+			c.enqueue(msg)
+		}
+	}
+}
+
+// broadcastEach sends an event to every subscribed client of a household,
+// with data that depends on the client's user
+func (h *Hub) broadcastEach(householdID, eventType string, data func(user *models.User) any) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	log.Printf("Broadcasting %s to household %s", eventType, householdID)
+	for c := range h.clients {
+		user := c.currentUser()
+		if user.HouseholdID == householdID {
+			c.enqueue(map[string]any{
+				"type": eventType,
+				"data": data(user),
+			})
+		}
+	}
+}
+
+// updateUser gives the clients acting for a user its new details
+func (h *Hub) updateUser(user *models.User) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for c := range h.clients {
+		if c.currentUser().ID == user.ID {
+			c.user.Store(user)
+		}
+	}
+}
+
+// publish broadcasts an event to the household of the client that caused it
+func (s *Server) publish(c *client, eventType string, data any) {
+	s.hub.broadcast(c.currentUser().HouseholdID, eventType, data)
+}
+
+// closeAll flushes every client's queue, sends a close frame and closes the
+// connections
+func (h *Hub) closeAll(code int, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	frame := websocket.FormatCloseMessage(code, reason)
+	for c := range h.clients {
+		select {
+		case c.goodbye <- frame:
+		default:
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), closeFrameTimeout)
+	defer cancel()
+	for c := range h.clients {
+		select {
+		case <-c.finished:
+		case <-ctx.Done():
+			log.Printf("Timed out closing client %s", c.id)
+		}
+		c.close()
+		delete(h.clients, c)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+func TestHubBroadcastsToHousehold(t *testing.T) {
+	s, _, c := testServer(t)
+	home := c.currentUser()
+	partner := testClient(s, &models.User{ID: "partner", HouseholdID: home.HouseholdID})
+	neighbour := testClient(s, &models.User{ID: "neighbour", HouseholdID: "next-door"})
+	gone := testClient(s, home)
+	s.hub.unsubscribe(gone)
+
+	s.publish(c, eventEntryCreated, map[string]any{"id": "entry"})
+	for _, member := range []*client{c, partner} {
+		if data := find(t, received(t, member), eventEntryCreated); data["id"] != "entry" {
+			t.Errorf("%s received %v", member.currentUser().ID, data)
+		}
+	}
+	if messages := received(t, neighbour); len(messages) != 0 {
+		t.Errorf("another household received %v", messages)
+	}
+	if messages := received(t, gone); len(messages) != 0 {
+		t.Errorf("unsubscribed client received %v", messages)
+	}
+
+	// Data given per member reaches each member only
+	s.hub.broadcastEach(home.HouseholdID, eventTotalsChanged, func(user *models.User) any {
+		return map[string]any{"user": user.ID}
+	})
+	for _, member := range []*client{c, partner} {
+		if data := find(t, received(t, member), eventTotalsChanged); data["user"] != member.currentUser().ID {
+			t.Errorf("%s received totals of %v", member.currentUser().ID, data["user"])
+		}
+	}
+	if messages := received(t, neighbour); len(messages) != 0 {
+		t.Errorf("another household received %v", messages)
+	}
+
+	// A member who moves household follows it
+	moved := *partner.currentUser()
+	moved.HouseholdID = "next-door"
+	s.hub.updateUser(&moved)
+	s.publish(c, eventEntryDeleted, map[string]any{"id": "entry"})
+	if messages := received(t, partner); len(messages) != 0 {
+		t.Errorf("member who left received %v", messages)
+	}
+	find(t, received(t, c), eventEntryDeleted)
+	s.publish(partner, eventEntryRestored, map[string]any{"id": "entry"})
+	find(t, received(t, neighbour), eventEntryRestored)
+	if messages := received(t, c); len(messages) != 0 {
+		t.Errorf("former household received %v", messages)
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/franckalain/nutritionalvalue/internal/images"
+)
+
+// imageTypes are the content types photos are served as; anything else is
+// served as an opaque download, so that an upload that is really a web page
+// never runs on the app's origin
+var imageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// handleImage serves the photo of an entry by the key in its image_path, to
+// signed in members of the household whose entry or scan refers to it.
+// Keys are hashes of the photos, so they are only known to those who have
+// seen the entry.
+func (s *Server) handleImage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Use GET")
+		return
+	}
+	user, err := s.resolveUser(r)
+	if errors.Is(err, errUnauthenticated) {
+		writeJSONError(w, http.StatusUnauthorized, "Not signed in")
+		return
+	}
+	if err != nil {
+		log.Printf("Error checking session: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to retrieve image")
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/api/images/")
+	if !images.ValidKey(key) {
+		writeJSONError(w, http.StatusNotFound, "Image not found")
+		return
+	}
+	if ok, err := s.db.HouseholdHasImage(r.Context(), user.HouseholdID, key); err != nil {
+		log.Printf("Error looking up image %s: %v", key, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to retrieve image")
+		return
+	} else if !ok {
+		writeJSONError(w, http.StatusNotFound, "Image not found")
+		return
+	}
+
+	// A key always refers to the same bytes
+	etag := `"` + key + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data, err := s.imageStore.Get(r.Context(), key)
+	if errors.Is(err, images.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "Image not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading image %s: %v", key, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to retrieve image")
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	if !imageTypes[contentType] {
+		contentType = "application/octet-stream"
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Header().Set("ETag", etag)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
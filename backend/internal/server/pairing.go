@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"rsc.io/qr"
+)
+
+// pairingAlphabet leaves out characters that are easily mistaken for each other
+const pairingAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// pairingCodes holds the one-time codes that sign a new device in as a user.
+// Clients that send wrong codes are throttled by handlePair.
+type pairingCodes struct {
+	mu    sync.Mutex
+	codes map[string]pairingCode
+}
+
+type pairingCode struct {
+	userID  string
+	expires time.Time
+}
+
+func newPairingCodes() *pairingCodes {
+	return &pairingCodes{codes: make(map[string]pairingCode)}
+}
+
+// create returns a new code for the user, valid for ttl
+func (p *pairingCodes) create(userID string, ttl time.Duration) (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("error generating pairing code: %w", err)
+	}
+	for i, b := range buf {
+		buf[i] = pairingAlphabet[int(b)%len(pairingAlphabet)]
+	}
+	code := string(buf[:4]) + "-" + string(buf[4:])
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.codes[normalizePairingCode(code)] = pairingCode{userID: userID, expires: time.Now().Add(ttl)}
+	return code, nil
+}
+
+// redeem uses up a code and returns the user it was created for
+func (p *pairingCodes) redeem(code string, now time.Time) (string, bool) {
+	key := normalizePairingCode(code)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for k, c := range p.codes {
+		if !now.Before(c.expires) {
+			delete(p.codes, k)
+		}
+	}
+
+	c, ok := p.codes[key]
+	if !ok {
+		return "", false
+	}
+	delete(p.codes, key)
+	return c.userID, true
+}
+
+// normalizePairingCode makes codes typed by hand match: case, spaces and
+// dashes do not matter
+func normalizePairingCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// announcePairingCode creates a pairing code for the user and prints it on the
+// server console with a QR code of the pairing link
+func (s *Server) announcePairingCode(user *models.User) (string, string, error) {
+	code, err := s.pairing.create(user.ID, s.auth.PairingCodeTTL.Duration)
+	if err != nil {
+		return "", "", err
+	}
+	link := s.pairingURL(code)
+
+	log.Printf("Pairing code for %s: %s (valid for %s)", user.Name, code, s.auth.PairingCodeTTL.Duration)
+	log.Printf("Open %s or scan the code below to sign a device in", link)
+	if qrCode, err := qr.Encode(link, qr.M); err == nil {
+		fmt.Fprint(os.Stderr, renderQR(qrCode))
+	} else {
+		log.Printf("Error rendering QR code: %v", err)
+	}
+	return code, link, nil
+}
+
+// canSignIn reports whether the user has a login or a session or API key
+// that has not expired
+func (s *Server) canSignIn(ctx context.Context, user *models.User) (bool, error) {
+	if user.Login != "" {
+		return true, nil
+	}
+	tokens, err := s.db.ListTokens(ctx, user.ID)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	for _, token := range tokens {
+		if !token.Expired(now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pairingURL is the web app link that signs a device in with code
+func (s *Server) pairingURL(code string) string {
+	base := s.auth.PublicURL
+	if base == "" {
+		base = s.scheme + "://" + net.JoinHostPort(localAddress(), s.port)
+	}
+	return strings.TrimSuffix(base, "/") + "/#pair=" + code
+}
+
+// localAddress guesses the address other devices on the network reach us at
+func localAddress() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "localhost"
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP.String()
+		}
+	}
+	return "localhost"
+}
+
+// renderQR draws a QR code with block characters, two modules per line, as
+// light modules on a dark terminal
+func renderQR(code *qr.Code) string {
+	const quiet = 2
+	light := func(x, y int) bool { return !code.Black(x, y) }
+
+	var b strings.Builder
+	for y := -quiet; y < code.Size+quiet; y += 2 {
+		for x := -quiet; x < code.Size+quiet; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// handleCreatePairingCode signs another device in as the user, or as a
+// member of the household who cannot sign in yet. The code is shown on the
+// server console and returned to the requesting device. Members who have a
+// password or a session pair their own devices only: a code for them would
+// let anyone in the household sign in as them.
+func (s *Server) handleCreatePairingCode(ctx context.Context, c *client, data map[string]any) {
+	user := c.currentUser()
+	if id, ok := data["user_id"].(string); ok && id != "" && id != user.ID {
+		member, ok := s.householdMember(ctx, c, id)
+		if !ok {
+			return
+		}
+		signedIn, err := s.canSignIn(ctx, member)
+		if err != nil {
+			log.Printf("Error checking sessions of user %s: %v", member.ID, err)
+			s.sendError(c, "Failed to create pairing code")
+			return
+		}
+		if signedIn {
+			s.sendError(c, member.Name+" pairs their own devices")
+			return
+		}
+		user = member
+	}
+
+	code, link, err := s.announcePairingCode(user)
+	if err != nil {
+		log.Printf("Error creating pairing code: %v", err)
+		s.sendError(c, "Failed to create pairing code")
+		return
+	}
+
+	s.sendMessage(c, "pairing_code", map[string]any{
+		"code":       code,
+		"url":        link,
+		"user":       user,
+		"expires_in": s.auth.PairingCodeTTL.Seconds(),
+	})
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"github.com/google/uuid"
+)
+
+// handleGetPantry lists the pantry. Set include_empty to also get finished and
+// discarded items.
+func (s *Server) handleGetPantry(ctx context.Context, c *client, data map[string]any) {
+	includeEmpty, _ := data["include_empty"].(bool)
+
+	items, err := s.db.ListPantryItems(ctx, c.currentUser().HouseholdID, includeEmpty)
+	if err != nil {
+		log.Printf("Error retrieving pantry: %v", err)
+		s.sendError(c, "Failed to retrieve pantry")
+		return
+	}
+
+	s.sendMessage(c, "pantry", map[string]any{
+		"items": items,
+	})
+}
+
+// handleLogConsumption takes food out of a pantry item. The action is one of
+//   - "eaten": weight grams were eaten
+//   - "finished": whatever was left has been eaten
+//   - "discarded": weight grams, or everything left if no weight is given, were thrown away
+//
+// Eaten food is attributed with shares or users, see parseShares, and to a
+// meal with meal and meal_date, see applyMeal.
+func (s *Server) handleLogConsumption(ctx context.Context, c *client, data map[string]any) {
+	itemID, ok := data["item_id"].(string)
+	if !ok || itemID == "" {
+		s.sendError(c, "Missing pantry item ID")
+		return
+	}
+
+	item, err := s.db.GetPantryItem(ctx, itemID)
+	if err != nil {
+		log.Printf("Error retrieving pantry item %s: %v", itemID, err)
+		s.sendError(c, "Failed to retrieve pantry item")
+		return
+	}
+	if item == nil || !s.ownsEntry(ctx, c, item.Entry) {
+		s.sendError(c, "Pantry item not found")
+		return
+	}
+
+	weight, hasWeight := data["weight"].(float64)
+	if _, present := data["weight"]; present && (!hasWeight || weight <= 0) {
+		s.sendError(c, "Weight must be a positive number")
+		return
+	}
+
+	event := &models.PantryEvent{
+		ID:        uuid.New().String(),
+		ItemID:    itemID,
+		UserID:    c.currentUser().ID,
+		CreatedAt: time.Now(),
+	}
+
+	action, _ := data["action"].(string)
+	switch action {
+	case "eaten":
+		if !hasWeight {
+			s.sendError(c, "Missing weight eaten")
+			return
+		}
+		event.Kind = models.PantryEventEaten
+		event.Weight = weight
+	case "finished":
+		event.Kind = models.PantryEventEaten
+		event.Weight = item.RemainingWeight
+	case "discarded":
+		event.Kind = models.PantryEventDiscarded
+		event.Weight = item.RemainingWeight
+		if hasWeight {
+			event.Weight = weight
+		}
+	default:
+		s.sendError(c, "Action must be eaten, finished or discarded")
+		return
+	}
+
+	if event.Kind == models.PantryEventEaten {
+		if err := applyMeal(&event.Meal, &event.MealDate, data, event.CreatedAt.In(c.currentUser().Location())); err != nil {
+			s.sendError(c, "Invalid consumption: "+err.Error())
+			return
+		}
+		if event.Shares, ok = s.parseShares(ctx, c, data); !ok {
+			return
+		}
+	}
+
+	item, err = s.db.LogPantryEvent(ctx, event)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		s.sendError(c, "Pantry item not found")
+		return
+	case errors.Is(err, database.ErrNotInStock):
+		s.sendError(c, "This item is no longer in stock")
+		return
+	case errors.Is(err, database.ErrNotEnoughStock):
+		s.sendError(c, "That is more than what is left")
+		return
+	case err != nil:
+		log.Printf("Error logging consumption of %s: %v", itemID, err)
+		s.sendError(c, "Failed to log consumption")
+		return
+	}
+
+	log.Printf("Logged %s of %.1fg from pantry item %s", event.Kind, event.Weight, itemID)
+	s.publish(c, eventPantryUpdated, item)
+	if event.Kind == models.PantryEventEaten {
+		s.publish(c, eventEntryUpdated, item.Entry)
+		s.broadcastTotals(ctx, c.currentUser().HouseholdID)
+	}
+}
+
+// handleGetPantryReport compares the nutrition bought with what was eaten,
+// thrown away and cooked into recipes. It takes the same from, to and group_by parameters as get_history.
+func (s *Server) handleGetPantryReport(ctx context.Context, c *client, data map[string]any) {
+	req, err := parseHistoryRequest(data, time.Now().In(c.currentUser().Location()))
+	if err != nil {
+		s.sendError(c, "Invalid report request: "+err.Error())
+		return
+	}
+
+	periods, err := splitPeriods(req.from, req.to, req.groupBy)
+	if err != nil {
+		s.sendError(c, "Invalid report request: "+err.Error())
+		return
+	}
+
+	activity, err := s.db.SumPantryActivity(ctx, c.currentUser().HouseholdID, periods)
+	if err != nil {
+		log.Printf("Error computing pantry report: %v", err)
+		s.sendError(c, "Failed to compute pantry report")
+		return
+	}
+
+	total := &models.PantryActivity{
+		PeriodStart: req.from,
+		PeriodEnd:   req.to,
+		Purchased:   &models.NutritionTotals{PeriodStart: req.from, PeriodEnd: req.to},
+		Eaten:       &models.NutritionTotals{PeriodStart: req.from, PeriodEnd: req.to},
+		Discarded:   &models.NutritionTotals{PeriodStart: req.from, PeriodEnd: req.to},
+		Cooked:      &models.NutritionTotals{PeriodStart: req.from, PeriodEnd: req.to},
+	}
+	for _, a := range activity {
+		total.Purchased.Merge(a.Purchased)
+		total.Eaten.Merge(a.Eaten)
+		total.Discarded.Merge(a.Discarded)
+		total.Cooked.Merge(a.Cooked)
+	}
+
+	s.sendMessage(c, "pantry_report", map[string]any{
+		"from":     req.from,
+		"to":       req.to,
+		"group_by": req.groupBy,
+		"periods":  activity,
+		"total":    total,
+	})
+}
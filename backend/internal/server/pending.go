@@ -0,0 +1,72 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+)
+
+// pendingScanTTL is how long a scan waits for confirmation before its photo
+// is dropped and it has to be taken again
+const pendingScanTTL = time.Hour
+
+// pendingScan is a scan shown to the user but not confirmed yet
+type pendingScan struct {
+	householdID string // of the user who scanned, the only one who may confirm
+	image       []byte
+	model       string
+	output      *models.ModelOutput
+	created     time.Time
+}
+
+// pendingScans holds the scans awaiting confirmation, by entry ID. Scans that
+// are never confirmed expire after ttl.
+type pendingScans struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	scans map[string]*pendingScan
+}
+
+func newPendingScans(ttl time.Duration) *pendingScans {
+	return &pendingScans{ttl: ttl, scans: make(map[string]*pendingScan)}
+}
+
+// add keeps a scan until it is confirmed or expires
+func (p *pendingScans) add(id string, scan *pendingScan) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire(scan.created)
+	p.scans[id] = scan
+}
+
+// get returns a scan awaiting confirmation by a member of the household
+func (p *pendingScans) get(id, householdID string, now time.Time) (*pendingScan, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire(now)
+	scan, ok := p.scans[id]
+	if !ok || scan.householdID != householdID {
+		return nil, false
+	}
+	return scan, true
+}
+
+// remove forgets a scan once it is confirmed
+func (p *pendingScans) remove(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.scans, id)
+}
+
+// expire drops the scans older than ttl
+func (p *pendingScans) expire(now time.Time) {
+	for id, scan := range p.scans {
+		if !now.Before(scan.created.Add(p.ttl)) {
+			delete(p.scans, id)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"github.com/google/uuid"
+)
+
+// handleListRecipes returns the household's recipes with their nutrition
+func (s *Server) handleListRecipes(ctx context.Context, c *client, data map[string]any) {
+	recipes, err := s.db.ListRecipes(ctx, c.currentUser().HouseholdID)
+	if err != nil {
+		log.Printf("Error listing recipes: %v", err)
+		s.sendError(c, "Failed to retrieve recipes")
+		return
+	}
+	for _, r := range recipes {
+		r.Compute()
+	}
+
+	s.sendMessage(c, "recipes", map[string]any{
+		"recipes": recipes,
+	})
+}
+
+// handleCreateRecipe adds a recipe to the household. data holds a name, the
+// ingredients as a list of {entry_id, weight} where weight is the grams of the
+// scanned product used, the servings it makes (default 1) and the yield_weight
+// once cooked (default the weight of the ingredients).
+func (s *Server) handleCreateRecipe(ctx context.Context, c *client, data map[string]any) {
+	me := c.currentUser()
+	recipe := &models.Recipe{
+		ID:          uuid.New().String(),
+		HouseholdID: me.HouseholdID,
+		CreatedBy:   me.ID,
+		Servings:    1,
+	}
+	if !s.applyRecipe(ctx, c, recipe, data) {
+		return
+	}
+
+	if err := s.db.SaveRecipe(ctx, recipe); err != nil {
+		log.Printf("Error saving recipe: %v", err)
+		s.sendError(c, "Failed to save recipe")
+		return
+	}
+
+	log.Printf("Created recipe %s (%s)", recipe.ID, recipe.Name)
+	s.publish(c, eventRecipeUpdated, recipe)
+}
+
+// handleUpdateRecipe changes the fields of a recipe present in data; they are
+// the same as for create_recipe. Ingredients are replaced as a whole.
+func (s *Server) handleUpdateRecipe(ctx context.Context, c *client, data map[string]any) {
+	recipe, ok := s.householdRecipe(ctx, c, data["id"])
+	if !ok {
+		return
+	}
+	if !s.applyRecipe(ctx, c, recipe, data) {
+		return
+	}
+
+	if err := s.db.SaveRecipe(ctx, recipe); err != nil {
+		log.Printf("Error updating recipe %s: %v", recipe.ID, err)
+		s.sendError(c, "Failed to save recipe")
+		return
+	}
+
+	log.Printf("Updated recipe %s", recipe.ID)
+	s.publish(c, eventRecipeUpdated, recipe)
+}
+
+// handleDeleteRecipe removes a recipe; what was logged from it stays in the diary
+func (s *Server) handleDeleteRecipe(ctx context.Context, c *client, data map[string]any) {
+	id, ok := data["id"].(string)
+	if !ok || id == "" {
+		s.sendError(c, "Missing recipe ID")
+		return
+	}
+
+	err := s.db.DeleteRecipe(ctx, c.currentUser().HouseholdID, id)
+	if errors.Is(err, database.ErrNotFound) {
+		s.sendError(c, "Recipe not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting recipe %s: %v", id, err)
+		s.sendError(c, "Failed to delete recipe")
+		return
+	}
+
+	log.Printf("Deleted recipe %s", id)
+	s.publish(c, eventRecipeDeleted, map[string]any{"id": id})
+}
+
+// ingredientShortage is what could not be taken out of the pantry for a dish
+type ingredientShortage struct {
+	EntryID string  `json:"entry_id"`
+	Missing float64 `json:"missing"` // grams
+}
+
+// handleLogRecipe logs a portion of a recipe as eaten: servings (default 1) or
+// weight grams of the cooked dish, shared and assigned to a meal like any
+// other entry. The ingredients used for that portion are taken out of the
+// pantry; those that are not in stock, or not in sufficient quantity, are
+// reported as shortages.
+func (s *Server) handleLogRecipe(ctx context.Context, c *client, data map[string]any) {
+	recipe, ok := s.householdRecipe(ctx, c, data["recipe_id"])
+	if !ok {
+		return
+	}
+
+	servingWeight := recipe.YieldWeight / float64(recipe.Servings)
+	weight := servingWeight
+	_, hasServings := data["servings"]
+	_, hasWeight := data["weight"]
+	switch {
+	case hasServings && hasWeight:
+		s.sendError(c, "Give either servings or weight, not both")
+		return
+	case hasServings:
+		servings, ok := data["servings"].(float64)
+		if !ok || servings <= 0 {
+			s.sendError(c, "Servings must be a positive number")
+			return
+		}
+		weight = servings * servingWeight
+	case hasWeight:
+		if weight, ok = data["weight"].(float64); !ok || weight <= 0 {
+			s.sendError(c, "Weight must be a positive number")
+			return
+		}
+	}
+
+	me := c.currentUser()
+	now := time.Now()
+	entry := recipe.Entry(weight)
+	entry.ID = uuid.New().String()
+	entry.UserID = me.ID
+	entry.CreatedAt = now
+	entry.UpdatedAt = now
+	if err := applyMeal(&entry.Meal, &entry.MealDate, data, now.In(me.Location())); err != nil {
+		s.sendError(c, "Invalid entry: "+err.Error())
+		return
+	}
+	if err := entry.Validate(); err != nil {
+		s.sendError(c, "Invalid entry: "+err.Error())
+		return
+	}
+	shares, ok := s.parseShares(ctx, c, data)
+	if !ok {
+		return
+	}
+
+	// The entry is saved and the ingredients taken out of the pantry at once,
+	// so that a failure leaves neither behind
+	cooked := cookedIngredients(recipe, weight/recipe.YieldWeight, me.ID, now)
+	needed := make([]float64, len(cooked))
+	for i, event := range cooked {
+		needed[i] = event.Weight
+	}
+	items, err := s.db.LogRecipe(ctx, entry, shares, cooked)
+	if err != nil {
+		log.Printf("Error logging recipe %s: %v", recipe.ID, err)
+		s.sendError(c, "Failed to log recipe")
+		return
+	}
+
+	shortages := []ingredientShortage{}
+	for i, event := range cooked {
+		if missing := needed[i] - event.Weight; missing > 0 {
+			shortages = append(shortages, ingredientShortage{EntryID: event.ItemID, Missing: missing})
+		}
+	}
+	for _, item := range items {
+		s.publish(c, eventPantryUpdated, item)
+	}
+
+	log.Printf("Logged %.0fg of recipe %s", weight, recipe.ID)
+	s.sendMessage(c, "recipe_logged", map[string]any{
+		"entry":     entry,
+		"shortages": shortages,
+	})
+	s.publish(c, eventEntryCreated, entry)
+	s.broadcastTotals(ctx, me.HouseholdID)
+}
+
+// cookedIngredients returns the events taking a fraction of each ingredient
+// of a recipe out of the pantry
+func cookedIngredients(recipe *models.Recipe, fraction float64, userID string, at time.Time) []*models.PantryEvent {
+	cooked := make([]*models.PantryEvent, len(recipe.Ingredients))
+	for i, ing := range recipe.Ingredients {
+		cooked[i] = &models.PantryEvent{
+			ID:        uuid.New().String(),
+			ItemID:    ing.EntryID,
+			UserID:    userID,
+			Kind:      models.PantryEventCooked,
+			Weight:    ing.Weight * fraction,
+			CreatedAt: at,
+		}
+	}
+	return cooked
+}
+
+// householdRecipe loads a recipe of the connection's household. It reports
+// the error to the client and returns false if there is no such recipe.
+func (s *Server) householdRecipe(ctx context.Context, c *client, value any) (*models.Recipe, bool) {
+	id, ok := value.(string)
+	if !ok || id == "" {
+		s.sendError(c, "Missing recipe ID")
+		return nil, false
+	}
+
+	recipe, err := s.db.GetRecipe(ctx, id)
+	if err != nil {
+		log.Printf("Error retrieving recipe %s: %v", id, err)
+		s.sendError(c, "Failed to retrieve recipe")
+		return nil, false
+	}
+	if recipe == nil || recipe.DeletedAt != nil || recipe.HouseholdID != c.currentUser().HouseholdID {
+		s.sendError(c, "Recipe not found")
+		return nil, false
+	}
+
+	recipe.Compute()
+	return recipe, true
+}
+
+// applyRecipe sets the fields of a recipe present in data and recomputes its
+// nutrition. It reports the error to the client and returns false if the
+// recipe is invalid.
+func (s *Server) applyRecipe(ctx context.Context, c *client, recipe *models.Recipe, data map[string]any) bool {
+	if value, present := data["name"]; present {
+		name, ok := value.(string)
+		if !ok {
+			s.sendError(c, "Invalid value for name")
+			return false
+		}
+		recipe.Name = name
+	}
+	if value, present := data["servings"]; present {
+		servings, ok := value.(float64)
+		if !ok || servings != math.Trunc(servings) {
+			s.sendError(c, "Servings must be a whole number")
+			return false
+		}
+		recipe.Servings = int(servings)
+	}
+
+	_, hasIngredients := data["ingredients"]
+	if hasIngredients {
+		ingredients, err := s.parseIngredients(ctx, c, data["ingredients"])
+		if err != nil {
+			s.sendError(c, "Invalid recipe: "+err.Error())
+			return false
+		}
+		recipe.Ingredients = ingredients
+	}
+
+	if value, present := data["yield_weight"]; present {
+		weight, ok := value.(float64)
+		if !ok {
+			s.sendError(c, "Invalid value for yield_weight")
+			return false
+		}
+		recipe.YieldWeight = weight
+	} else if recipe.YieldWeight == 0 {
+		recipe.YieldWeight = recipe.RawWeight()
+	}
+
+	recipe.Compute()
+	if err := recipe.Validate(); err != nil {
+		s.sendError(c, "Invalid recipe: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// parseIngredients reads a list of {entry_id, weight}. Each product must have
+// been scanned by the household.
+func (s *Server) parseIngredients(ctx context.Context, c *client, value any) ([]models.RecipeIngredient, error) {
+	list, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("ingredients must be a list")
+	}
+
+	ingredients := make([]models.RecipeIngredient, 0, len(list))
+	for _, v := range list {
+		fields, _ := v.(map[string]any)
+		entryID, okID := fields["entry_id"].(string)
+		weight, okWeight := fields["weight"].(float64)
+		if !okID || !okWeight {
+			return nil, fmt.Errorf("ingredients need an entry_id and a weight")
+		}
+
+		entry, err := s.db.GetNutritionalInfo(ctx, entryID)
+		if err != nil {
+			log.Printf("Error retrieving entry %s: %v", entryID, err)
+			return nil, fmt.Errorf("could not load product %s", entryID)
+		}
+		if entry == nil || entry.DeletedAt != nil || !s.ownsEntry(ctx, c, entry) {
+			return nil, fmt.Errorf("unknown product %s", entryID)
+		}
+		ingredients = append(ingredients, models.RecipeIngredient{EntryID: entryID, Weight: weight, Entry: entry})
+	}
+	return ingredients, nil
+}
@@ -4,89 +4,204 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 
+	"github.com/franckalain/nutritionalvalue/internal/config"
 	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/images"
 	"github.com/franckalain/nutritionalvalue/internal/ml"
 	"github.com/franckalain/nutritionalvalue/internal/models"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // In production, this should be more restrictive
-	},
-}
-
 type Server struct {
-	db            database.DB
-	model         ml.Model
-	clients       sync.Map
-	tempImageData sync.Map // Temporary storage for image data
-	debug         bool
+	db           database.DB
+	model        ml.Model
+	imageStore   images.Store
+	hub          *Hub
+	upgrader     websocket.Upgrader
+	httpServer   *http.Server
+	httpRedirect *http.Server // plain HTTP port when serving HTTPS
+	port         string
+	scheme       string // "http" or "https"
+	caFile       string // generated certificate authority, if any
+	jobs         jobTracker
+	timeouts     config.TimeoutsConfig
+	auth         config.AuthConfig
+	pairing      *pairingCodes
+	authFailures *throttle       // wrong passwords and pairing codes
+	baseCtx      context.Context // parent of every client context
+	cancelBase   context.CancelFunc
+	pendingScans *pendingScans
+	debug        bool
 }
 
-func New(db database.DB, model ml.Model, debug bool) *Server {
+func New(db database.DB, model ml.Model, imageStore images.Store, debug bool) *Server {
 	if debug {
 		log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 		log.Println("Debug logging enabled")
 	}
-	return &Server{
-		db:    db,
-		model: model,
-		debug: debug,
-	}
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	s := &Server{
+		db:           db,
+		model:        model,
+		imageStore:   imageStore,
+		hub:          newHub(),
+		pairing:      newPairingCodes(),
+		authFailures: newThrottle(maxAuthFailures, authFailureWindow),
+		pendingScans: newPendingScans(pendingScanTTL),
+		scheme:       "http",
+		baseCtx:      baseCtx,
+		cancelBase:   cancelBase,
+		debug:        debug,
+	}
+	s.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     s.checkOrigin,
+	}
+	return s
 }
 
-func (s *Server) Start(port, staticDir string) error {
+// Start serves HTTP until SIGINT or SIGTERM, then shuts down gracefully
+func (s *Server) Start(cfg config.ServerConfig) error {
 	// Setup signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	// Setup HTTP routes
-	http.HandleFunc("/ws", s.handleWebSocket)
-	http.HandleFunc("/health", s.handleHealth)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.handleWebSocket)
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/ca.crt", s.handleCA)
+	mux.HandleFunc("/api/login", s.withOrigin(s.handleLogin))
+	mux.HandleFunc("/api/pair", s.withOrigin(s.handlePair))
+	mux.HandleFunc("/api/logout", s.withOrigin(s.handleLogout))
+	mux.HandleFunc("/api/session", s.withOrigin(s.handleSession))
+	mux.HandleFunc("/api/images/", s.withOrigin(s.handleImage))
+	mux.HandleFunc("/api/export", s.withOrigin(s.handleExport))
 
 	// Serve static files
-	fs := http.FileServer(http.Dir(staticDir))
-	http.Handle("/", fs)
+	fs := http.FileServer(http.Dir(cfg.StaticDir))
+	mux.Handle("/", fs)
+
+	s.timeouts = cfg.Timeouts
+	s.auth = cfg.Auth
+	s.port = cfg.Port
+
+	var certFile, keyFile string
+	if cfg.TLS.Enabled {
+		var err error
+		if certFile, keyFile, err = s.setupTLS(cfg.TLS); err != nil {
+			return err
+		}
+		s.scheme = "https"
+	}
+
+	if s.auth.Disabled {
+		log.Println("WARNING: authentication is disabled, anyone who can reach the server can use it")
+	} else if user, err := s.db.EnsureDefaultUser(s.baseCtx); err != nil {
+		return fmt.Errorf("error retrieving default user: %w", err)
+	} else if _, _, err := s.announcePairingCode(user); err != nil {
+		return err
+	}
+	s.httpServer = &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: mux,
+	}
 
 	// Start server
+	errChan := make(chan error, 2)
 	go func() {
-		log.Printf("Starting server on port %s\n", port)
-		if err := http.ListenAndServe(":"+port, nil); err != nil {
-			log.Fatal("ListenAndServe:", err)
+		var err error
+		if cfg.TLS.Enabled {
+			log.Printf("Starting HTTPS server on port %s\n", cfg.Port)
+			err = s.httpServer.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			log.Printf("Starting server on port %s\n", cfg.Port)
+			err = s.httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			errChan <- err
 		}
 	}()
 
+	if cfg.TLS.Enabled && cfg.TLS.HTTPPort != "" {
+		s.httpRedirect = &http.Server{
+			Addr:    ":" + cfg.TLS.HTTPPort,
+			Handler: s.redirectHandler(),
+		}
+		go func() {
+			log.Printf("Redirecting HTTP on port %s to HTTPS\n", cfg.TLS.HTTPPort)
+			if err := s.httpRedirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				errChan <- err
+			}
+		}()
+	}
+	if cfg.TLS.Enabled {
+		s.logCAInstructions(cfg.TLS.HTTPPort)
+	}
+
 	// Wait for shutdown signal
-	<-sigChan
+	select {
+	case err := <-errChan:
+		return fmt.Errorf("ListenAndServe: %w", err)
+	case <-sigChan:
+	}
+
 	log.Println("Shutting down server...")
+	s.shutdown(cfg.ShutdownTimeout.Duration)
+	log.Println("Server stopped")
 	return nil
 }
 
+// requestContext derives the context for handling one message, bounded by the
+// timeout configured for that kind of operation
+func (s *Server) requestContext(parent context.Context, messageType string) (context.Context, context.CancelFunc) {
+	timeout := s.timeouts.Database.Duration
+	if messageType == "scan" {
+		timeout = s.timeouts.Scan.Duration
+	}
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// Refuse before upgrading so that clients get a proper HTTP status
+	user, err := s.resolveUser(r)
+	if errors.Is(err, errUnauthenticated) {
+		http.Error(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Println("Error resolving user:", err)
+		http.Error(w, "Failed to identify user", http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("WebSocket upgrade failed:", err)
 		return
 	}
-	defer conn.Close()
 
-	// Store client connection
-	clientID := uuid.New().String()
-	s.clients.Store(clientID, conn)
-	defer s.clients.Delete(clientID)
+	// Register the client; all writes go through its writer goroutine
+	c := newClient(s.baseCtx, uuid.New().String(), conn, user)
+	defer c.close()
+	go c.writePump()
+
+	s.hub.subscribe(c)
+	defer s.hub.unsubscribe(c)
 
 	for {
 		_, message, err := conn.ReadMessage()
@@ -102,42 +217,119 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		s.handleWebSocketMessage(conn, msg)
+		s.handleWebSocketMessage(c, msg)
 	}
 }
 
-func (s *Server) handleWebSocketMessage(conn *websocket.Conn, message map[string]any) {
+func (s *Server) handleWebSocketMessage(c *client, message map[string]any) {
 	messageType, ok := message["type"].(string)
 	if !ok {
-		s.sendError(conn, "Invalid message format")
+		s.sendError(c, "Invalid message format")
 		return
 	}
 
 	data, _ := message["data"].(map[string]any)
 
+	if !s.jobs.begin() {
+		s.sendError(c, "Server is shutting down")
+		return
+	}
+
+	// Scans wait on the model, so they run in the background to keep reading
+	// from the connection; a disconnect then cancels them
+	if messageType == "scan" {
+		go func() {
+			defer s.jobs.end()
+			ctx, cancel := s.requestContext(c.ctx, messageType)
+			defer cancel()
+			s.handleScan(ctx, c, data)
+		}()
+		return
+	}
+	defer s.jobs.end()
+
+	ctx, cancel := s.requestContext(c.ctx, messageType)
+	defer cancel()
+
 	switch messageType {
-	case "scan":
-		s.handleScan(conn, data)
 	case "confirm_scan":
-		s.handleConfirmScan(conn, data)
+		s.handleConfirmScan(ctx, c, data)
 	case "get_history":
-		s.handleGetHistory(conn)
+		s.handleGetHistory(ctx, c, data)
+	case "update_entry":
+		s.handleUpdateEntry(ctx, c, data)
+	case "delete_entry":
+		s.handleDeleteEntry(ctx, c, data)
+	case "restore_entry":
+		s.handleRestoreEntry(ctx, c, data)
+	case "get_pantry":
+		s.handleGetPantry(ctx, c, data)
+	case "log_consumption":
+		s.handleLogConsumption(ctx, c, data)
+	case "get_pantry_report":
+		s.handleGetPantryReport(ctx, c, data)
+	case "get_accuracy_report":
+		s.handleGetAccuracyReport(ctx, c, data)
+	case "identify":
+		s.handleIdentify(ctx, c, data)
+	case "get_household":
+		s.handleGetHousehold(ctx, c, data)
+	case "add_user":
+		s.handleAddUser(ctx, c, data)
+	case "set_timezone":
+		s.handleSetTimezone(ctx, c, data)
+	case "get_goals":
+		s.handleGetGoals(ctx, c, data)
+	case "set_goals":
+		s.handleSetGoals(ctx, c, data)
+	case "clear_goals":
+		s.handleClearGoals(ctx, c, data)
+	case "get_body_profile":
+		s.handleGetBodyProfile(ctx, c, data)
+	case "set_body_profile":
+		s.handleSetBodyProfile(ctx, c, data)
+	case "log_body_metrics", "log_weight":
+		s.handleLogBodyMetrics(ctx, c, data)
+	case "get_body_metrics":
+		s.handleGetBodyMetrics(ctx, c, data)
+	case "delete_body_metric":
+		s.handleDeleteBodyMetric(ctx, c, data)
+	case "list_recipes":
+		s.handleListRecipes(ctx, c, data)
+	case "create_recipe":
+		s.handleCreateRecipe(ctx, c, data)
+	case "update_recipe":
+		s.handleUpdateRecipe(ctx, c, data)
+	case "delete_recipe":
+		s.handleDeleteRecipe(ctx, c, data)
+	case "log_recipe":
+		s.handleLogRecipe(ctx, c, data)
+	case "set_password":
+		s.handleSetPassword(ctx, c, data)
+	case "create_pairing_code":
+		s.handleCreatePairingCode(ctx, c, data)
+	case "create_api_key":
+		s.handleCreateAPIKey(ctx, c, data)
+	case "list_tokens":
+		s.handleListTokens(ctx, c, data)
+	case "revoke_token":
+		s.handleRevokeToken(ctx, c, data)
 	default:
-		s.sendError(conn, "Unknown message type")
+		s.sendError(c, "Unknown message type")
 	}
 }
 
-func (s *Server) handleScan(conn *websocket.Conn, data map[string]any) {
+func (s *Server) handleScan(ctx context.Context, c *client, data map[string]any) {
 	// Validate input data
 	imageStr, ok := data["image"].(string)
 	if !ok {
-		s.sendError(conn, "Invalid image data")
+		s.sendError(c, "Invalid image data")
 		return
 	}
 
 	totalWeight, ok := data["totalWeight"].(float64)
 	if !ok {
-		s.sendError(conn, "Invalid weight value")
+		s.sendError(c, "Invalid weight value")
 		return
 	}
 
@@ -145,15 +337,24 @@ func (s *Server) handleScan(conn *websocket.Conn, data map[string]any) {
 	imageData, err := base64.StdEncoding.DecodeString(imageStr)
 	if err != nil {
 		log.Printf("Error decoding image: %v", err)
-		s.sendError(conn, "Invalid image format")
+		s.sendError(c, "Invalid image format")
 		return
 	}
 
 	// Process image
-	nutritionInfo, err := s.model.ProcessImage(context.Background(), imageData)
+	nutritionInfo, err := s.model.ProcessImage(ctx, imageData)
+	if errors.Is(err, context.Canceled) {
+		log.Printf("Image processing cancelled for client %s", c.id)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Image processing timed out: %v", err)
+		s.sendError(c, "Timed out processing image")
+		return
+	}
 	if err != nil {
 		log.Printf("Error processing image: %v", err)
-		s.sendError(conn, "Failed to process image")
+		s.sendError(c, "Failed to process image")
 		return
 	}
 
@@ -165,78 +366,24 @@ func (s *Server) handleScan(conn *websocket.Conn, data map[string]any) {
 	nutritionInfo.ID = uuid.New().String()
 	nutritionInfo.CreatedAt = time.Now()
 	nutritionInfo.UpdatedAt = time.Now()
-
-	// Store the image data in the server's memory temporarily
-	// We'll use a map with the nutrition info ID as the key
-	s.tempImageData.Store(nutritionInfo.ID, imageData)
+	loggedAt := nutritionInfo.CreatedAt.In(c.currentUser().Location())
+	nutritionInfo.Meal = models.SuggestMeal(loggedAt)
+	nutritionInfo.MealDate = loggedAt.Format(models.MealDateLayout)
+
+	// Keep the photo and what the model read until the scan is confirmed
+	s.pendingScans.add(nutritionInfo.ID, &pendingScan{
+		householdID: c.currentUser().HouseholdID,
+		image:       imageData,
+		model:       s.model.Name(),
+		output:      models.OutputOf(nutritionInfo),
+		created:     nutritionInfo.CreatedAt,
+	})
 
 	// Send results back to client for confirmation
-	s.sendMessage(conn, "scan_result", nutritionInfo)
-}
-
-func (s *Server) handleGetHistory(conn *websocket.Conn) {
-	// Get recent nutritional info from database
-	ctx := context.Background()
-	nutritionInfos, err := s.db.GetRecentNutritionalInfo(ctx, 20) // Get last 20 entries
-	if err != nil {
-		log.Printf("Error retrieving history: %v", err)
-		s.sendError(conn, "Failed to retrieve history")
-		return
-	}
-
-	// Calculate session and weekly totals
-	now := time.Now()
-	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
-	startOfWeek := now.AddDate(0, 0, -int(now.Weekday()))
-	startOfWeek = time.Date(startOfWeek.Year(), startOfWeek.Month(), startOfWeek.Day(), 0, 0, 0, 0, startOfWeek.Location())
-
-	var dayTotal, weekTotal struct {
-		Calories float64
-		Protein  float64
-		Carbs    float64
-		Fat      float64
-	}
-
-	// Calculate totals
-	for _, info := range nutritionInfos {
-		// Add to weekly total if within this week
-		if info.CreatedAt.After(startOfWeek) {
-			weekTotal.Calories += info.Calories
-			weekTotal.Protein += info.Protein
-			weekTotal.Carbs += info.Carbs
-			weekTotal.Fat += info.Fat
-
-			// Add to day total if within today
-			if info.CreatedAt.After(startOfDay) {
-				dayTotal.Calories += info.Calories
-				dayTotal.Protein += info.Protein
-				dayTotal.Carbs += info.Carbs
-				dayTotal.Fat += info.Fat
-			}
-		}
-	}
-
-	// Prepare response
-	response := map[string]interface{}{
-		"items": nutritionInfos,
-		"day_total": map[string]float64{
-			"calories": dayTotal.Calories,
-			"protein":  dayTotal.Protein,
-			"carbs":    dayTotal.Carbs,
-			"fat":      dayTotal.Fat,
-		},
-		"week_total": map[string]float64{
-			"calories": weekTotal.Calories,
-			"protein":  weekTotal.Protein,
-			"carbs":    weekTotal.Carbs,
-			"fat":      weekTotal.Fat,
-		},
-	}
-
-	s.sendMessage(conn, "history", response)
+	s.sendMessage(c, "scan_result", nutritionInfo)
 }
 
-func (s *Server) handleConfirmScan(conn *websocket.Conn, data map[string]any) {
+func (s *Server) handleConfirmScan(ctx context.Context, c *client, data map[string]any) {
 	// Log the received data for debugging
 	log.Printf("Received confirm_scan data: %+v", data)
 
@@ -247,42 +394,26 @@ func (s *Server) handleConfirmScan(conn *websocket.Conn, data map[string]any) {
 			nutritionInfoID = strID
 		} else {
 			log.Printf("ID is not a string: %v (type: %T)", id, id)
-			s.sendError(conn, "Invalid nutrition info ID format")
+			s.sendError(c, "Invalid nutrition info ID format")
 			return
 		}
 	} else {
 		log.Printf("No ID field in data: %+v", data)
-		s.sendError(conn, "Missing nutrition info ID")
+		s.sendError(c, "Missing nutrition info ID")
 		return
 	}
 
-	log.Printf("Looking for image data with ID: %s", nutritionInfoID)
-
-	// Retrieve the image data from temporary storage
-	imageDataAny, ok := s.tempImageData.Load(nutritionInfoID)
-	if !ok {
-		// Dump all keys in tempImageData for debugging
-		var keys []string
-		s.tempImageData.Range(func(key, value interface{}) bool {
-			keys = append(keys, fmt.Sprintf("%v", key))
-			return true
-		})
-		log.Printf("Image data not found for ID: %s. Available keys: %v", nutritionInfoID, keys)
-		s.sendError(conn, "Image data not found")
-		return
-	}
+	log.Printf("Looking for pending scan with ID: %s", nutritionInfoID)
 
-	// Type assertion with safety check
-	imageData, ok := imageDataAny.([]byte)
+	// Retrieve the scan awaiting confirmation; scans of other households
+	// are not found
+	pending, ok := s.pendingScans.get(nutritionInfoID, c.currentUser().HouseholdID, time.Now())
 	if !ok {
-		log.Printf("Stored data is not []byte: %T", imageDataAny)
-		s.sendError(conn, "Invalid stored image data")
+		log.Printf("Image data not found for ID: %s", nutritionInfoID)
+		s.sendError(c, "Image data not found")
 		return
 	}
 
-	// Clean up the temporary storage
-	s.tempImageData.Delete(nutritionInfoID)
-
 	// Safely extract numeric values with defaults
 	var totalWeight, calories, protein, carbs, fat, fiber, sugar float64
 
@@ -332,6 +463,7 @@ func (s *Server) handleConfirmScan(conn *websocket.Conn, data map[string]any) {
 	// Convert the data back to NutritionalInfo
 	nutritionInfo := &models.NutritionalInfo{
 		ID:          nutritionInfoID,
+		UserID:      c.currentUser().ID,
 		TotalWeight: totalWeight,
 		Calories:    calories,
 		Protein:     protein,
@@ -343,53 +475,116 @@ func (s *Server) handleConfirmScan(conn *websocket.Conn, data map[string]any) {
 		UpdatedAt:   time.Now(),
 	}
 
-	// Save the nutritional info to the database
-	if err := s.db.SaveNutritionalInfo(context.Background(), nutritionInfo); err != nil {
-		log.Printf("Error saving nutritional info: %v", err)
-		s.sendError(conn, "Failed to save results")
+	// Confirmed scans count as eaten straight away unless "pantry" asks for
+	// them to be stocked. Pantry items start uneaten; consumption is then
+	// logged against the item.
+	addToPantry, _ := data["pantry"].(bool)
+	if addToPantry {
+		nothing := 0.0
+		nutritionInfo.ConsumedWeight = &nothing
+	}
+
+	// Record if only part of the package was eaten
+	if err := applyConsumption(nutritionInfo, data); err != nil {
+		s.sendError(c, "Invalid entry: "+err.Error())
+		return
+	}
+	if err := applyMeal(&nutritionInfo.Meal, &nutritionInfo.MealDate, data, nutritionInfo.CreatedAt.In(c.currentUser().Location())); err != nil {
+		s.sendError(c, "Invalid entry: "+err.Error())
 		return
 	}
+	// A pantry item is only a meal for what is eaten of it
+	if addToPantry && nutritionInfo.EatenWeight() == 0 {
+		nutritionInfo.Meal, nutritionInfo.MealDate = "", ""
+	}
+	if err := nutritionInfo.Validate(); err != nil {
+		s.sendError(c, "Invalid entry: "+err.Error())
+		return
+	}
+
+	// Food eaten straight away can be shared between household members
+	var shares []models.ConsumptionShare
+	if !addToPantry {
+		if shares, ok = s.parseShares(ctx, c, data); !ok {
+			return
+		}
+	}
 
-	// Create and save the scan record
+	// The entry and the scan refer to the photo by its key in the image store
+	imagePath, err := s.imageStore.Put(ctx, pending.image)
+	if err != nil {
+		log.Printf("Error storing image: %v", err)
+		s.sendError(c, "Failed to save image")
+		return
+	}
+	nutritionInfo.ImagePath = imagePath
+
+	// The scan record keeps what the model proposed and what the user
+	// confirmed, to measure how often the model is corrected
 	scan := &models.NutritionScan{
-		ID:        uuid.New().String(),
-		ImageData: imageData,
-		Status:    "completed",
-		Result:    nutritionInfo,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-	if err := s.db.SaveScan(context.Background(), scan); err != nil {
-		log.Printf("Error saving scan: %v", err)
-		s.sendError(conn, "Failed to save scan")
+		ID:              uuid.New().String(),
+		EntryID:         nutritionInfo.ID,
+		ImagePath:       imagePath,
+		Model:           pending.model,
+		ModelOutput:     pending.output,
+		ConfirmedOutput: models.OutputOf(nutritionInfo),
+		Status:          "completed",
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+
+	// Save the entry, its pantry item or shares and the scan at once, so that
+	// a confirmation that fails can be sent again
+	item, err := s.db.ConfirmScan(ctx, nutritionInfo, shares, addToPantry, scan)
+	if err != nil {
+		log.Printf("Error saving confirmed scan: %v", err)
+		s.sendError(c, "Failed to save results")
 		return
 	}
+	if item != nil {
+		s.publish(c, eventPantryUpdated, item)
+	}
+
+	// Clean up the temporary storage; done last so a rejected confirmation can be retried
+	s.pendingScans.remove(nutritionInfoID)
 
 	log.Printf("Successfully saved nutritional info and scan")
-	s.sendMessage(conn, "scan_saved", nil)
+	s.sendMessage(c, "scan_saved", nil)
+
+	// Let the other devices know about the new entry
+	s.publish(c, eventEntryCreated, nutritionInfo)
+	s.broadcastTotals(ctx, c.currentUser().HouseholdID)
 }
 
-func (s *Server) sendMessage(conn *websocket.Conn, messageType string, data any) {
+// secretMessages are message types whose data must not end up in the logs
+var secretMessages = map[string]bool{
+	"api_key_created": true,
+}
+
+func (s *Server) sendMessage(c *client, messageType string, data any) {
 	msg := map[string]any{
 		"type": messageType,
 		"data": data,
 	}
 
-	log.Printf("Sending message to client - Type: %s, Data: %+v", messageType, data)
-	if err := conn.WriteJSON(msg); err != nil {
-		log.Println("Error sending message:", err)
+	if secretMessages[messageType] {
+		log.Printf("Sending message to client - Type: %s", messageType)
+	} else {
+		log.Printf("Sending message to client - Type: %s, Data: %+v", messageType, data)
+	}
+	if !c.enqueue(msg) {
+		log.Printf("Client %s is gone, dropping %s message", c.id, messageType)
 	}
-	log.Printf("Message sent successfully")
 }
 
-func (s *Server) sendError(conn *websocket.Conn, message string) {
+func (s *Server) sendError(c *client, message string) {
 	msg := map[string]any{
 		"type":    "error",
 		"message": message,
 	}
 
-	if err := conn.WriteJSON(msg); err != nil {
-		log.Println("Error sending error message:", err)
+	if !c.enqueue(msg) {
+		log.Printf("Client %s is gone, dropping error message", c.id)
 	}
 }
 
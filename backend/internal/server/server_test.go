@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/database"
+	"github.com/franckalain/nutritionalvalue/internal/images"
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"github.com/google/uuid"
+)
+
+// testServer returns a server on an in-memory database, with a client of its
+// default user subscribed to broadcasts
+func testServer(t *testing.T) (*Server, *database.MemoryDB, *client) {
+	t.Helper()
+	store, err := images.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := database.NewMemoryDB()
+	s := New(db, nil, store, false)
+	t.Cleanup(s.cancelBase)
+
+	user, err := db.EnsureDefaultUser(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, db, testClient(s, user)
+}
+
+// testClient subscribes a client of user to broadcasts. It has no connection:
+// what is sent to it stays in its queue for received to read.
+func testClient(s *Server, user *models.User) *client {
+	c := newClient(s.baseCtx, uuid.New().String(), nil, user)
+	s.hub.subscribe(c)
+	return c
+}
+
+// send handles a message from the client, with data given as JSON the way a
+// browser sends it
+func send(t *testing.T, s *Server, c *client, messageType, data string) {
+	t.Helper()
+	var decoded map[string]any
+	if err := json.Unmarshal([]byte(data), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	s.handleWebSocketMessage(c, map[string]any{"type": messageType, "data": decoded})
+}
+
+// received empties the queue of a client and returns its messages as the
+// browser would decode them
+func received(t *testing.T, c *client) []map[string]any {
+	t.Helper()
+	var messages []map[string]any
+	for {
+		select {
+		case msg := <-c.send:
+			encoded, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded map[string]any
+			if err := json.Unmarshal(encoded, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			messages = append(messages, decoded)
+		default:
+			return messages
+		}
+	}
+}
+
+// find returns the data of the first message of a type, failing the test if
+// there is none or an error came first
+func find(t *testing.T, messages []map[string]any, messageType string) map[string]any {
+	t.Helper()
+	for _, msg := range messages {
+		if msg["type"] == "error" {
+			t.Fatalf("error before %s: %v", messageType, msg["message"])
+		}
+		if msg["type"] == messageType {
+			data, _ := msg["data"].(map[string]any)
+			return data
+		}
+	}
+	t.Fatalf("no %s message in %v", messageType, messages)
+	return nil
+}
+
+// has reports whether there is a message of a type
+func has(messages []map[string]any, messageType string) bool {
+	for _, msg := range messages {
+		if msg["type"] == messageType {
+			return true
+		}
+	}
+	return false
+}
+
+// pendScan makes a scan await confirmation as if the model had just read it
+// for the client
+func pendScan(s *Server, c *client) string {
+	id := uuid.New().String()
+	s.pendingScans.add(id, &pendingScan{
+		householdID: c.currentUser().HouseholdID,
+		image:       []byte("label photo"),
+		model:       "fake",
+		output:      &models.ModelOutput{Calories: 350, Protein: 10, Carbs: 60, Fat: 5, Fiber: 3, Sugar: 4},
+		created:     time.Now(),
+	})
+	return id
+}
+
+func TestConfirmScan(t *testing.T) {
+	ctx := context.Background()
+	s, db, c := testServer(t)
+	id := pendScan(s, c)
+
+	send(t, s, c, "confirm_scan", `{"id": "`+id+`", "total_weight": 200, "calories": 360,
+		"protein": 10, "carbs": 60, "fat": 5, "fiber": 3, "sugar": 4}`)
+	messages := received(t, c)
+	find(t, messages, "scan_saved")
+	created := find(t, messages, eventEntryCreated)
+	if created["image_path"] != images.Key([]byte("label photo")) {
+		t.Errorf("entry refers to photo %v", created["image_path"])
+	}
+	if has(messages, eventPantryUpdated) {
+		t.Error("entry eaten straight away was stocked in the pantry")
+	}
+	find(t, messages, eventTotalsChanged)
+
+	entry, err := db.GetNutritionalInfo(ctx, id)
+	if err != nil || entry == nil {
+		t.Fatalf("confirmed entry = %+v, %v", entry, err)
+	}
+	if entry.EatenWeight() != 200 {
+		t.Errorf("eaten weight = %v, want 200", entry.EatenWeight())
+	}
+	if item, _ := db.GetPantryItem(ctx, id); item != nil {
+		t.Errorf("pantry item %+v for an entry eaten straight away", item)
+	}
+	scans, err := db.GetScansByEntry(ctx, []string{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scan := scans[id]; scan == nil || scan.Model != "fake" || scan.ModelOutput.Calories != 350 || scan.ConfirmedOutput.Calories != 360 {
+		t.Errorf("scan = %+v", scan)
+	}
+
+	// Confirmed once; sending it again finds nothing to confirm
+	send(t, s, c, "confirm_scan", `{"id": "`+id+`", "total_weight": 200}`)
+	if messages := received(t, c); len(messages) != 1 || messages[0]["type"] != "error" {
+		t.Errorf("confirming twice: %v", messages)
+	}
+}
+
+func TestConfirmScanIntoPantry(t *testing.T) {
+	ctx := context.Background()
+	s, db, c := testServer(t)
+	id := pendScan(s, c)
+
+	send(t, s, c, "confirm_scan", `{"id": "`+id+`", "total_weight": 500, "calories": 360, "pantry": true}`)
+	messages := received(t, c)
+	find(t, messages, "scan_saved")
+	item := find(t, messages, eventPantryUpdated)
+	if item["remaining_weight"] != 500.0 || item["status"] != models.PantryInStock {
+		t.Errorf("pantry item = %v", item)
+	}
+
+	entry, err := db.GetNutritionalInfo(ctx, id)
+	if err != nil || entry == nil {
+		t.Fatalf("confirmed entry = %+v, %v", entry, err)
+	}
+	if entry.EatenWeight() != 0 || entry.Meal != "" {
+		t.Errorf("stocked entry counts as eaten: %+v", entry)
+	}
+	if stocked, err := db.GetPantryItem(ctx, id); err != nil || stocked == nil || stocked.RemainingWeight != 500 {
+		t.Errorf("pantry item = %+v, %v", stocked, err)
+	}
+}
+
+func TestConfirmScanRejected(t *testing.T) {
+	ctx := context.Background()
+	s, db, c := testServer(t)
+	id := pendScan(s, c)
+
+	// An invalid entry saves nothing and can be confirmed again
+	send(t, s, c, "confirm_scan", `{"id": "`+id+`", "total_weight": 100, "calories": -5}`)
+	if messages := received(t, c); len(messages) != 1 || messages[0]["type"] != "error" {
+		t.Fatalf("invalid confirmation: %v", messages)
+	}
+	if entry, _ := db.GetNutritionalInfo(ctx, id); entry != nil {
+		t.Fatalf("invalid confirmation saved %+v", entry)
+	}
+
+	send(t, s, c, "confirm_scan", `{"id": "`+id+`", "total_weight": 100, "calories": 5}`)
+	find(t, received(t, c), "scan_saved")
+}
+
+func TestConfirmScanOtherHousehold(t *testing.T) {
+	ctx := context.Background()
+	s, db, c := testServer(t)
+	id := pendScan(s, c)
+
+	stranger := testClient(s, &models.User{ID: uuid.New().String(), HouseholdID: "other-household", Name: "Stranger"})
+	send(t, s, stranger, "confirm_scan", `{"id": "`+id+`", "total_weight": 100, "calories": 5}`)
+	if messages := received(t, stranger); len(messages) != 1 || messages[0]["type"] != "error" {
+		t.Fatalf("confirming the scan of another household: %v", messages)
+	}
+	if entry, _ := db.GetNutritionalInfo(ctx, id); entry != nil {
+		t.Fatalf("another household saved %+v", entry)
+	}
+
+	send(t, s, c, "confirm_scan", `{"id": "`+id+`", "total_weight": 100, "calories": 5}`)
+	find(t, received(t, c), "scan_saved")
+}
+
+func TestPendingScansExpire(t *testing.T) {
+	scans := newPendingScans(time.Hour)
+	start := time.Now()
+	scans.add("old", &pendingScan{householdID: "home", created: start})
+	scans.add("new", &pendingScan{householdID: "home", created: start.Add(30 * time.Minute)})
+
+	if _, ok := scans.get("old", "home", start.Add(59*time.Minute)); !ok {
+		t.Error("scan expired early")
+	}
+	if _, ok := scans.get("old", "home", start.Add(time.Hour)); ok {
+		t.Error("scan awaited confirmation for longer than an hour")
+	}
+	if _, ok := scans.get("new", "home", start.Add(time.Hour)); !ok {
+		t.Error("newer scan expired with the old one")
+	}
+	if len(scans.scans) != 1 {
+		t.Errorf("%d scans kept, want 1", len(scans.scans))
+	}
+}
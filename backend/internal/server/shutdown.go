@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// jobTracker counts the messages being handled so that shutdown can wait for
+// them. Once draining starts, new jobs are refused.
+type jobTracker struct {
+	mu       sync.Mutex
+	running  int
+	draining bool
+	idle     chan struct{} // closed once draining and no job is left
+}
+
+// begin registers a new job. It returns false if the job must be refused.
+func (t *jobTracker) begin() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return false
+	}
+	t.running++
+	return true
+}
+
+// end marks a job started with begin as finished
+func (t *jobTracker) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.running--
+	if t.draining && t.running == 0 {
+		close(t.idle)
+	}
+}
+
+// startDraining refuses all further jobs
+func (t *jobTracker) startDraining() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return
+	}
+	t.draining = true
+	t.idle = make(chan struct{})
+	if t.running == 0 {
+		close(t.idle)
+	}
+}
+
+// wait blocks until draining has started and no job is running, or ctx
+// expires. It returns false if jobs were still running at the deadline.
+func (t *jobTracker) wait(ctx context.Context) bool {
+	t.mu.Lock()
+	idle := t.idle
+	t.mu.Unlock()
+	if idle == nil {
+		return false
+	}
+
+	select {
+	case <-idle:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// shutdown stops the HTTP server, lets running jobs finish within the
+// deadline and closes the websocket connections
+func (s *Server) shutdown(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Refuse new jobs right away
+	s.jobs.startDraining()
+
+	// Stop accepting connections; websockets are hijacked and not waited for here
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down HTTP server: %v", err)
+	}
+	if s.httpRedirect != nil {
+		if err := s.httpRedirect.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down HTTP redirect: %v", err)
+		}
+	}
+
+	if s.jobs.wait(ctx) {
+		log.Println("All running jobs finished")
+	} else {
+		// Cancel whatever is left so model calls and queries return
+		// promptly, and wait for them so that nothing uses the database
+		// once it is closed
+		log.Printf("Shutdown deadline of %s reached, cancelling running jobs", timeout)
+		s.cancelBase()
+		s.jobs.wait(context.Background())
+		log.Println("Cancelled jobs returned")
+	}
+	s.cancelBase()
+
+	s.hub.closeAll(websocket.CloseGoingAway, "Server shutting down")
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJobTracker(t *testing.T) {
+	var jobs jobTracker
+	if !jobs.begin() {
+		t.Fatal("job refused before draining")
+	}
+	jobs.startDraining()
+	if jobs.begin() {
+		t.Fatal("job accepted while draining")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if jobs.wait(ctx) {
+		t.Fatal("wait returned with a job still running")
+	}
+
+	go jobs.end()
+	if !jobs.wait(context.Background()) {
+		t.Fatal("wait did not return once the job ended")
+	}
+}
+
+func TestJobTrackerIdle(t *testing.T) {
+	var jobs jobTracker
+	jobs.startDraining()
+	if !jobs.wait(context.Background()) {
+		t.Fatal("wait blocked without running jobs")
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxAuthFailures is how many wrong passwords or pairing codes a client
+	// or login may send within authFailureWindow before it is refused
+	maxAuthFailures   = 10
+	authFailureWindow = 15 * time.Minute
+)
+
+// throttle counts failed attempts by key, such as the address of a client or
+// the login it signs in as, and refuses a key once it failed too often. A key
+// is let through again once window has passed since its last failure.
+type throttle struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	failures map[string]failures
+}
+
+type failures struct {
+	count int
+	last  time.Time
+}
+
+func newThrottle(max int, window time.Duration) *throttle {
+	return &throttle{max: max, window: window, failures: make(map[string]failures)}
+}
+
+// wait returns how long until any of keys is let through again, or zero if
+// none is refused
+func (t *throttle) wait(now time.Time, keys ...string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range keys {
+		f, ok := t.failures[key]
+		if !ok || f.count < t.max {
+			continue
+		}
+		wait = max(wait, f.last.Add(t.window).Sub(now))
+	}
+	return wait
+}
+
+// fail records a failed attempt for each of keys
+func (t *throttle) fail(now time.Time, keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, f := range t.failures {
+		if !now.Before(f.last.Add(t.window)) {
+			delete(t.failures, key)
+		}
+	}
+	for _, key := range keys {
+		f := t.failures[key]
+		t.failures[key] = failures{count: f.count + 1, last: now}
+	}
+}
+
+// reset forgets the failures of keys after a successful attempt
+func (t *throttle) reset(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range keys {
+		delete(t.failures, key)
+	}
+}
+
+// clientKey is the throttle key of the address a request comes from
+func clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "client:" + host
+}
+
+// loginKey is the throttle key of a login
+func loginKey(login string) string {
+	return "login:" + login
+}
+
+// refuseThrottled answers with 429 Too Many Requests and returns true when
+// there is a wait before trying again
+func refuseThrottled(w http.ResponseWriter, wait time.Duration) bool {
+	if wait <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+	writeJSONError(w, http.StatusTooManyRequests, "Too many failed attempts, try again later")
+	return true
+}
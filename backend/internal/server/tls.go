@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/franckalain/nutritionalvalue/internal/certs"
+	"github.com/franckalain/nutritionalvalue/internal/config"
+)
+
+// setupTLS returns the certificate and key to serve HTTPS with. Unless both are
+// configured, they are generated along with a certificate authority that is
+// then offered for download at /ca.crt.
+func (s *Server) setupTLS(cfg config.TLSConfig) (string, string, error) {
+	if cfg.CertFile != "" {
+		return cfg.CertFile, cfg.KeyFile, nil
+	}
+
+	hosts := append(certs.LocalHosts(), cfg.Hosts...)
+	files, err := certs.EnsureSelfSigned(cfg.Dir, hosts)
+	if err != nil {
+		return "", "", fmt.Errorf("error setting up certificates: %w", err)
+	}
+	s.caFile = files.CACert
+	return files.Cert, files.Key, nil
+}
+
+// handleCA serves the generated certificate authority so that phones can be
+// set up to trust the server
+func (s *Server) handleCA(w http.ResponseWriter, r *http.Request) {
+	if s.caFile == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	w.Header().Set("Content-Disposition", `attachment; filename="nutritionalvalue-ca.crt"`)
+	http.ServeFile(w, r, s.caFile)
+}
+
+// redirectHandler serves the plain HTTP port: the certificate authority, which
+// has to be installed before HTTPS is trusted, and a redirect for the rest
+func (s *Server) redirectHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ca.crt", s.handleCA)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		target := "https://" + net.JoinHostPort(host, s.port) + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusFound)
+	})
+	return mux
+}
+
+// logCAInstructions tells where phones can get the certificate authority
+func (s *Server) logCAInstructions(httpPort string) {
+	if s.caFile == "" {
+		return
+	}
+	base := "https://" + net.JoinHostPort(localAddress(), s.port)
+	if httpPort != "" {
+		base = "http://" + net.JoinHostPort(localAddress(), httpPort)
+	}
+	log.Printf("Install the certificate authority from %s/ca.crt on your devices to trust this server", base)
+}
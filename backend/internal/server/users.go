@@ -0,0 +1,265 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/franckalain/nutritionalvalue/internal/models"
+	"github.com/google/uuid"
+)
+
+// shareTolerance is how far the fractions of a split may be from adding up to one
+const shareTolerance = 1e-3
+
+// resolveUser picks the user a new connection acts for: the one it signed in
+// as. With authentication disabled, it is the one named by the user query
+// parameter, or the default user otherwise.
+func (s *Server) resolveUser(r *http.Request) (*models.User, error) {
+	if !s.auth.Disabled {
+		user, _, err := s.authenticate(r)
+		return user, err
+	}
+
+	id := r.URL.Query().Get("user")
+	if id == "" {
+		return s.db.EnsureDefaultUser(r.Context())
+	}
+
+	user, err := s.db.GetUser(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user %s not found", id)
+	}
+	return user, nil
+}
+
+// handleIdentify switches the connection to another member of its household.
+// Once users sign in, they have to sign in as the other member instead.
+func (s *Server) handleIdentify(ctx context.Context, c *client, data map[string]any) {
+	if !s.auth.Disabled {
+		s.sendError(c, "Sign in as the other user to act for them")
+		return
+	}
+
+	id, ok := data["user_id"].(string)
+	if !ok || id == "" {
+		s.sendError(c, "Missing user ID")
+		return
+	}
+
+	user, ok := s.householdMember(ctx, c, id)
+	if !ok {
+		return
+	}
+
+	c.user.Store(user)
+	log.Printf("Client %s is now user %s", c.id, user.ID)
+	s.sendMessage(c, "identity", user)
+}
+
+// handleGetHousehold describes the household of the connection and its members
+func (s *Server) handleGetHousehold(ctx context.Context, c *client, data map[string]any) {
+	me := c.currentUser()
+
+	household, err := s.db.GetHousehold(ctx, me.HouseholdID)
+	if err != nil || household == nil {
+		log.Printf("Error retrieving household %s: %v", me.HouseholdID, err)
+		s.sendError(c, "Failed to retrieve household")
+		return
+	}
+
+	users, err := s.db.ListUsers(ctx, me.HouseholdID)
+	if err != nil {
+		log.Printf("Error retrieving users of household %s: %v", me.HouseholdID, err)
+		s.sendError(c, "Failed to retrieve household")
+		return
+	}
+
+	s.sendMessage(c, "household", map[string]any{
+		"household": household,
+		"users":     users,
+		"me":        me,
+	})
+}
+
+// handleAddUser adds a member to the household of the connection
+func (s *Server) handleAddUser(ctx context.Context, c *client, data map[string]any) {
+	name, ok := data["name"].(string)
+	if !ok || name == "" {
+		s.sendError(c, "Missing user name")
+		return
+	}
+
+	user := &models.User{
+		ID:          uuid.New().String(),
+		HouseholdID: c.currentUser().HouseholdID,
+		Name:        name,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.db.CreateUser(ctx, user); err != nil {
+		log.Printf("Error creating user: %v", err)
+		s.sendError(c, "Failed to add user")
+		return
+	}
+
+	log.Printf("Added user %s to household %s", user.ID, user.HouseholdID)
+	s.sendMessage(c, "user_added", user)
+	s.publish(c, eventHousehold, map[string]any{
+		"user": user,
+	})
+}
+
+// handleSetTimezone sets the time zone in which a household member's days
+// start and end, by IANA name such as "Europe/Paris". An empty name goes back
+// to the server's time zone. Food the member gave a diary day stays on it, so
+// the totals of the household change.
+func (s *Server) handleSetTimezone(ctx context.Context, c *client, data map[string]any) {
+	user, ok := s.subjectUser(ctx, c, data)
+	if !ok {
+		return
+	}
+
+	timezone, ok := data["timezone"].(string)
+	if !ok {
+		s.sendError(c, "Missing time zone")
+		return
+	}
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+			s.sendError(c, "Unknown time zone "+timezone)
+			return
+		}
+	}
+
+	if err := s.db.SetTimezone(ctx, user.ID, timezone); err != nil {
+		log.Printf("Error setting time zone of user %s: %v", user.ID, err)
+		s.sendError(c, "Failed to set time zone")
+		return
+	}
+
+	updated := *user
+	updated.Timezone = timezone
+	s.hub.updateUser(&updated)
+
+	log.Printf("User %s now counts days in %q", user.ID, timezone)
+	s.sendMessage(c, "timezone_set", &updated)
+	s.publish(c, eventHousehold, map[string]any{
+		"user": &updated,
+	})
+	s.broadcastTotals(ctx, updated.HouseholdID)
+}
+
+// householdMember looks up a user of the connection's household. It reports
+// the error to the client and returns false if there is no such user.
+func (s *Server) householdMember(ctx context.Context, c *client, id string) (*models.User, bool) {
+	user, err := s.db.GetUser(ctx, id)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %v", id, err)
+		s.sendError(c, "Failed to retrieve user")
+		return nil, false
+	}
+	if user == nil || user.HouseholdID != c.currentUser().HouseholdID {
+		s.sendError(c, "Unknown user "+id)
+		return nil, false
+	}
+	return user, true
+}
+
+// ownsEntry reports whether the entry was logged by the connection's household
+func (s *Server) ownsEntry(ctx context.Context, c *client, info *models.NutritionalInfo) bool {
+	if info.UserID == "" {
+		return false
+	}
+	owner, err := s.db.GetUser(ctx, info.UserID)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %v", info.UserID, err)
+		return false
+	}
+	return owner != nil && owner.HouseholdID == c.currentUser().HouseholdID
+}
+
+// ownsEntryID is ownsEntry for an entry that has not been loaded yet
+func (s *Server) ownsEntryID(ctx context.Context, c *client, id string) bool {
+	info, err := s.db.GetNutritionalInfo(ctx, id)
+	if err != nil {
+		log.Printf("Error retrieving entry %s: %v", id, err)
+		return false
+	}
+	return info != nil && s.ownsEntry(ctx, c, info)
+}
+
+// hasShares reports whether data says who ate the food, with shares or users
+// that are not null
+func hasShares(data map[string]any) bool {
+	return data["shares"] != nil || data["users"] != nil
+}
+
+// parseShares reads who ate a portion of food from data, either as
+//   - shares: a list of {user_id, fraction} adding up to one, or
+//   - users: a list of user IDs splitting it equally.
+//
+// Without either, the connection's user ate all of it. It reports the error to
+// the client and returns false if the split is invalid.
+func (s *Server) parseShares(ctx context.Context, c *client, data map[string]any) ([]models.ConsumptionShare, bool) {
+	var shares []models.ConsumptionShare
+
+	switch {
+	case data["shares"] != nil:
+		list, ok := data["shares"].([]any)
+		if !ok || len(list) == 0 {
+			s.sendError(c, "Shares must be a non-empty list")
+			return nil, false
+		}
+		for _, v := range list {
+			share, _ := v.(map[string]any)
+			userID, okUser := share["user_id"].(string)
+			fraction, okFraction := share["fraction"].(float64)
+			if !okUser || !okFraction || fraction <= 0 {
+				s.sendError(c, "Each share needs a user_id and a positive fraction")
+				return nil, false
+			}
+			shares = append(shares, models.ConsumptionShare{UserID: userID, Fraction: fraction})
+		}
+	case data["users"] != nil:
+		list, ok := data["users"].([]any)
+		if !ok || len(list) == 0 {
+			s.sendError(c, "Users must be a non-empty list")
+			return nil, false
+		}
+		for _, v := range list {
+			userID, ok := v.(string)
+			if !ok {
+				s.sendError(c, "Users must be a list of user IDs")
+				return nil, false
+			}
+			shares = append(shares, models.ConsumptionShare{UserID: userID, Fraction: 1 / float64(len(list))})
+		}
+	default:
+		return []models.ConsumptionShare{{UserID: c.currentUser().ID, Fraction: 1}}, true
+	}
+
+	seen := make(map[string]bool, len(shares))
+	total := 0.0
+	for _, share := range shares {
+		if seen[share.UserID] {
+			s.sendError(c, "User "+share.UserID+" is listed twice")
+			return nil, false
+		}
+		seen[share.UserID] = true
+		if _, ok := s.householdMember(ctx, c, share.UserID); !ok {
+			return nil, false
+		}
+		total += share.Fraction
+	}
+	if math.Abs(total-1) > shareTolerance {
+		s.sendError(c, "Shares must add up to 1")
+		return nil, false
+	}
+	return shares, true
+}
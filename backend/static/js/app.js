@@ -16,6 +16,11 @@ document.addEventListener('DOMContentLoaded', () => {
     const nutritionResults = document.getElementById('nutritionResults');
     const confirmButton = document.getElementById('confirmButton');
     const cancelButton = document.getElementById('cancelButton');
+    const authSection = document.getElementById('authSection');
+    const appSection = document.getElementById('appSection');
+    const loginForm = document.getElementById('loginForm');
+    const pairForm = document.getElementById('pairForm');
+    const logoutButton = document.getElementById('logoutButton');
     
     // Store current image data and nutrition info
     let currentImageData = null;
@@ -52,11 +57,54 @@ document.addEventListener('DOMContentLoaded', () => {
         
         ws.onclose = () => {
             console.log('WebSocket connection closed');
-            // Try to reconnect after a delay
-            setTimeout(connectWebSocket, 3000);
+            // Try to reconnect after a delay, unless the session has ended
+            setTimeout(start, 3000);
         };
     }
     
+    // Check whether this device is signed in, then connect or ask to sign in
+    async function start() {
+        try {
+            const response = await fetch('/api/session', { credentials: 'same-origin' });
+            if (response.status === 401) {
+                showSignIn();
+                return;
+            }
+            const session = await response.json();
+            console.log('Signed in as', session.user);
+            authSection.classList.add('hidden');
+            appSection.classList.remove('hidden');
+            logoutButton.classList.toggle('hidden', !session.auth);
+            connectWebSocket();
+        } catch (error) {
+            console.error('Error checking session:', error);
+            setTimeout(start, 3000);
+        }
+    }
+    
+    function showSignIn() {
+        appSection.classList.add('hidden');
+        logoutButton.classList.add('hidden');
+        authSection.classList.remove('hidden');
+    }
+    
+    // Sign in through the given endpoint; the server sets a session cookie
+    async function signIn(url, body) {
+        body.device = navigator.userAgent;
+        const response = await fetch(url, {
+            method: 'POST',
+            credentials: 'same-origin',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body)
+        });
+        if (!response.ok) {
+            const result = await response.json().catch(() => ({}));
+            alert(`Error: ${result.error || 'Failed to sign in'}`);
+            return;
+        }
+        start();
+    }
+    
     // Handle WebSocket messages
     function handleWebSocketMessage(message) {
         console.log('Processing message type:', message.type);
@@ -307,8 +355,42 @@ document.addEventListener('DOMContentLoaded', () => {
         });
     }
     
-    // Connect to WebSocket when page loads
-    connectWebSocket();
+    if (loginForm) {
+        loginForm.addEventListener('submit', (e) => {
+            e.preventDefault();
+            signIn('/api/login', {
+                login: document.getElementById('loginInput').value,
+                password: document.getElementById('passwordInput').value
+            });
+        });
+    }
+    
+    if (pairForm) {
+        pairForm.addEventListener('submit', (e) => {
+            e.preventDefault();
+            signIn('/api/pair', { code: document.getElementById('pairingCodeInput').value });
+        });
+    }
+    
+    if (logoutButton) {
+        logoutButton.addEventListener('click', async () => {
+            await fetch('/api/logout', { method: 'POST', credentials: 'same-origin' });
+            if (ws) {
+                ws.onclose = null;
+                ws.close();
+            }
+            showSignIn();
+        });
+    }
+    
+    // Pairing links from the server console carry the code, e.g. /#pair=ABCD-EFGH
+    const pairMatch = window.location.hash.match(/^#pair=(.+)$/);
+    if (pairMatch) {
+        history.replaceState(null, '', window.location.pathname);
+        signIn('/api/pair', { code: decodeURIComponent(pairMatch[1]) });
+    } else {
+        start();
+    }
 });
 
 
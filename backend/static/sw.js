@@ -1,4 +1,4 @@
-const CACHE_NAME = 'nutrition-scanner-v1';
+const CACHE_NAME = 'nutrition-scanner-v2';
 const ASSETS_TO_CACHE = [
     '/',
     '/index.html',
@@ -41,8 +41,8 @@ self.addEventListener('activate', (event) => {
 
 // Fetch event handler
 self.addEventListener('fetch', (event) => {
-    // Don't cache WebSocket connections
-    if (event.request.url.includes('/ws')) {
+    // Don't cache WebSocket connections or API calls
+    if (event.request.url.includes('/ws') || event.request.url.includes('/api/')) {
         return;
     }
 